@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Alert states
+const (
+	AlertOK      = "ok"
+	AlertPending = "pending"
+	AlertFiring  = "firing"
+)
+
+// Fields of a polled varbind rules compare
+const (
+	AlertFieldValue = "value"
+	AlertFieldRate  = "rate"
+	AlertFieldDelta = "delta"
+)
+
+// alertOps - comparison of a sampled value with the value of a rule
+var alertOps = map[string]func(c int) bool{
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+}
+
+// AlertRule - condition on polled varbinds that fires after For consecutive samples
+//
+// Oid matches the varbind itself or every instance below it, each
+// alerting on its own. Field rate and delta compare the derived values of
+// polls with rates. Poll restricts the rule to one poll, otherwise it
+// applies to every poll reading the oid.
+type AlertRule struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Poll       string      `json:"poll,omitempty"`
+	Oid        string      `json:"oid"`
+	Field      string      `json:"field,omitempty"`
+	Op         string      `json:"op"`
+	Value      interface{} `json:"value"`
+	For        int         `json:"for"`
+	WebhookURL string      `json:"webhook_url,omitempty"`
+	CreatedBy  string      `json:"created_by,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Delivery   *Callback   `json:"last_delivery,omitempty"`
+}
+
+// Alert - state of a rule for one varbind of one poll
+type Alert struct {
+	Rule        string      `json:"rule"`
+	Name        string      `json:"name"`
+	Poll        string      `json:"poll"`
+	Target      string      `json:"target"`
+	Oid         string      `json:"oid"`
+	State       string      `json:"state"`
+	Value       interface{} `json:"value"`
+	Consecutive int         `json:"consecutive"`
+	Since       time.Time   `json:"since"`
+	Evaluated   time.Time   `json:"evaluated"`
+}
+
+// AlertTransition - change of state of an alert, as notified
+type AlertTransition struct {
+	Alert
+	From string `json:"from"`
+}
+
+// AlertRuleDetail - rule with the state of its alerts
+type AlertRuleDetail struct {
+	AlertRule
+	Alerts []Alert `json:"alerts"`
+}
+
+// AlertManager - rules evaluated against every poll sample
+type AlertManager struct {
+	mu     sync.RWMutex
+	rules  map[string]*AlertRule
+	states map[string]*Alert
+}
+
+// alerts - alert rules of the gateway
+var alerts = NewAlertManager()
+
+// NewAlertManager - manager without rules
+func NewAlertManager() *AlertManager {
+	return &AlertManager{rules: map[string]*AlertRule{}, states: map[string]*Alert{}}
+}
+
+// matches - whether rule applies to varbind oid of poll
+func (rule *AlertRule) matches(poll string, oid string) bool {
+	if rule.Poll != "" && rule.Poll != poll {
+		return false
+	}
+	return oid == rule.Oid || strings.HasPrefix(oid, rule.Oid+".")
+}
+
+// compare - whether sampled value satisfies the rule, false if they cannot be compared
+func (rule *AlertRule) compare(value interface{}) bool {
+	op := alertOps[rule.Op]
+	switch want := rule.Value.(type) {
+	case float64:
+		got, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case got < want:
+			return op(-1)
+		case got > want:
+			return op(1)
+		}
+		return op(0)
+	case string:
+		got, ok := value.(string)
+		if !ok || (rule.Op != "==" && rule.Op != "!=") {
+			return false
+		}
+		return op(strings.Compare(got, want))
+	}
+	return false
+}
+
+// sampledValue - value of pdu rules compare, numbers as float64
+func sampledValue(pdu gosnmp.SnmpPDU) (interface{}, bool) {
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Counter64, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(pdu.Value)).Float64()
+		return f, true
+	case gosnmp.OctetString:
+		return octetString(pdu.Value), true
+	case gosnmp.ObjectIdentifier, gosnmp.IPAddress:
+		return fmt.Sprint(pdu.Value), true
+	}
+	return nil, false
+}
+
+// Evaluate - apply every rule to sample of poll and notify state changes
+//
+// Failed polls and varbinds missing from the sample leave alerts as they
+// are. An alert is pending while its condition holds for fewer than For
+// samples, firing after, and back to ok once it no longer holds; firing
+// and the return to ok are published on the alerts channel and POSTed to
+// the webhook of the rule.
+func (m *AlertManager) Evaluate(poll *Poll, sample *PollSample) {
+	if sample.Error != "" {
+		return
+	}
+	values := map[string]map[string]interface{}{AlertFieldValue: {}, AlertFieldRate: {}, AlertFieldDelta: {}}
+	for _, pdu := range sample.pdus {
+		if v, ok := sampledValue(pdu); ok {
+			values[AlertFieldValue][normalizeOid(pdu.Name)] = v
+		}
+	}
+	for _, rate := range sample.Derived {
+		values[AlertFieldRate][normalizeOid(rate.Oid)] = rate.Rate
+		values[AlertFieldDelta][normalizeOid(rate.Oid)] = float64(rate.Delta)
+	}
+
+	var transitions []AlertTransition
+	var notify []*AlertRule
+	m.mu.Lock()
+	for _, rule := range m.rules {
+		for oid, value := range values[rule.Field] {
+			if !rule.matches(poll.ID, oid) {
+				continue
+			}
+			key := rule.ID + "|" + poll.ID + "|" + oid
+			alert, ok := m.states[key]
+			if !ok {
+				alert = &Alert{Rule: rule.ID, Name: rule.Name, Poll: poll.ID, Target: poll.Target, Oid: oid, State: AlertOK, Since: sample.Time}
+				m.states[key] = alert
+			}
+			from := alert.State
+			alert.Value = value
+			alert.Evaluated = sample.Time
+			if rule.compare(value) {
+				alert.Consecutive++
+				if alert.Consecutive >= rule.For {
+					alert.State = AlertFiring
+				} else {
+					alert.State = AlertPending
+				}
+			} else {
+				alert.Consecutive = 0
+				alert.State = AlertOK
+			}
+			if alert.State == from {
+				continue
+			}
+			alert.Since = sample.Time
+			if alert.State == AlertFiring || from == AlertFiring {
+				transitions = append(transitions, AlertTransition{Alert: *alert, From: from})
+				notify = append(notify, rule)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for i, transition := range transitions {
+		stats.Inc("alerts." + transition.State)
+		events.Publish(ChannelAlerts, transition.Target, transition)
+		rule := notify[i]
+		if rule.WebhookURL == "" {
+			continue
+		}
+		NewCallback(rule.WebhookURL).Deliver(CallbackEvent{Event: "alert." + transition.State, ID: rule.ID, Time: transition.Since, Data: transition}, func(cb Callback) {
+			m.mu.Lock()
+			rule.Delivery = &cb
+			m.mu.Unlock()
+		})
+	}
+}
+
+// ForgetPoll - drop the alerts of a deleted poll
+func (m *AlertManager) ForgetPoll(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, alert := range m.states {
+		if alert.Poll == id {
+			delete(m.states, key)
+		}
+	}
+}
+
+// alertsOf - alerts of rule id sorted by poll and oid, caller holds the lock
+func (m *AlertManager) alertsOf(id string) []Alert {
+	list := []Alert{}
+	for _, alert := range m.states {
+		if id == "" || alert.Rule == id {
+			list = append(list, *alert)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Poll != list[j].Poll {
+			return list[i].Poll < list[j].Poll
+		}
+		return list[i].Oid < list[j].Oid
+	})
+	return list
+}
+
+// ListRulesHandler - alert rules
+func (m *AlertManager) ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	rules := make([]AlertRule, 0, len(m.rules))
+	for _, rule := range m.rules {
+		rules = append(rules, *rule)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+	WriteJSON(w, http.StatusOK, rules)
+}
+
+// CreateRuleHandler - register an alert rule, evaluated from the next poll on
+func (m *AlertManager) CreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule := &AlertRule{}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid alert rule json")
+		return
+	}
+	if rule.Oid == "" {
+		WriteError(w, http.StatusBadRequest, "oid required")
+		return
+	}
+	oid, err := ResolveOid(rule.Oid)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rule.Oid = normalizeOid(oid)
+	if rule.Field == "" {
+		rule.Field = AlertFieldValue
+	}
+	if rule.Field != AlertFieldValue && rule.Field != AlertFieldRate && rule.Field != AlertFieldDelta {
+		WriteError(w, http.StatusBadRequest, "field must be value, rate or delta")
+		return
+	}
+	if _, ok := alertOps[rule.Op]; !ok {
+		WriteError(w, http.StatusBadRequest, "op must be one of >, >=, <, <=, == or !=")
+		return
+	}
+	switch rule.Value.(type) {
+	case float64:
+	case string:
+		if rule.Op != "==" && rule.Op != "!=" {
+			WriteError(w, http.StatusBadRequest, "string values can only be compared with == or !=")
+			return
+		}
+	default:
+		WriteError(w, http.StatusBadRequest, "value must be a number or a string")
+		return
+	}
+	if rule.For == 0 {
+		rule.For = 1
+	}
+	if rule.For < 1 {
+		WriteError(w, http.StatusBadRequest, "for must be a positive number of samples")
+		return
+	}
+	if rule.Poll != "" {
+		if _, ok := poller.poll(rule.Poll); !ok {
+			WriteError(w, http.StatusBadRequest, "poll "+rule.Poll+" not found")
+			return
+		}
+	}
+	if rule.WebhookURL != "" {
+		if err := ValidateCallbackURL(rule.WebhookURL); err != nil {
+			WriteError(w, http.StatusBadRequest, "webhook_url "+err.Error())
+			return
+		}
+	}
+	rule.ID = NewID()
+	rule.CreatedBy = RequestIdentity(r)
+	rule.CreatedAt = time.Now()
+	rule.Delivery = nil
+	if rule.Name == "" {
+		rule.Name = fmt.Sprintf("%s %s %s %v", rule.Oid, rule.Field, rule.Op, rule.Value)
+	}
+
+	m.mu.Lock()
+	m.rules[rule.ID] = rule
+	created := *rule
+	m.mu.Unlock()
+
+	w.Header().Set("Location", "/api/v1/alerts/"+rule.ID)
+	WriteJSON(w, http.StatusCreated, created)
+}
+
+// GetRuleHandler - alert rule with the state of its alerts
+func (m *AlertManager) GetRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	m.mu.RLock()
+	rule, ok := m.rules[id]
+	var detail AlertRuleDetail
+	if ok {
+		detail = AlertRuleDetail{AlertRule: *rule, Alerts: m.alertsOf(id)}
+	}
+	m.mu.RUnlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "alert rule not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, detail)
+}
+
+// DeleteRuleHandler - remove alert rule and its alerts
+func (m *AlertManager) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	m.mu.Lock()
+	_, ok := m.rules[id]
+	delete(m.rules, id)
+	for key, alert := range m.states {
+		if alert.Rule == id {
+			delete(m.states, key)
+		}
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "alert rule not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ActiveAlertsHandler - pending and firing alerts of every rule, ?state= narrows them
+func (m *AlertManager) ActiveAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && state != AlertPending && state != AlertFiring {
+		WriteError(w, http.StatusBadRequest, "state must be pending or firing")
+		return
+	}
+	principal := RequestPrincipal(r)
+
+	m.mu.RLock()
+	all := m.alertsOf("")
+	m.mu.RUnlock()
+
+	active := []Alert{}
+	for _, alert := range all {
+		if alert.State == AlertOK || (state != "" && alert.State != state) {
+			continue
+		}
+		if principal != nil && !principal.AllowsTarget(alert.Target) {
+			continue
+		}
+		active = append(active, alert)
+	}
+	WriteJSON(w, http.StatusOK, active)
+}
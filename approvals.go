@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Pending change states
+const (
+	ChangePending  = "pending"
+	ChangeExecuted = "executed"
+	ChangeRejected = "rejected"
+	ChangeExpired  = "expired"
+)
+
+// IdentityHeader - header naming the identity issuing a request
+const IdentityHeader = "X-User"
+
+// ApprovedKey - context key marking replay of an approved change
+type ApprovedKey string
+
+// ApprovedKeyName - keyname defined for context
+const ApprovedKeyName ApprovedKey = "APPROVED"
+
+// ApproverKey - context key holding the principal that approved a change
+type ApproverKey string
+
+// ApproverKeyName - keyname defined for context
+const ApproverKeyName ApproverKey = "APPROVER"
+
+// CapturedRequest - http request held back for later execution
+type CapturedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// authHeaders - headers authenticating the caller
+//
+// Held back requests run as the principal stored with them, so the
+// credentials are not kept in memory until then.
+var authHeaders = []string{"Authorization", "X-API-Key"}
+
+// RequestTargets - targets named by the path or the target and targets body fields
+func RequestTargets(r *http.Request, body []byte) []string {
+	var targets []string
+	if target := mux.Vars(r)["target"]; target != "" {
+		targets = append(targets, target)
+	}
+	fields := struct {
+		Target  string   `json:"target"`
+		Targets []string `json:"targets"`
+	}{}
+	// Malformed bodies are left for the handler to report
+	_ = json.Unmarshal(body, &fields)
+	if fields.Target != "" {
+		targets = append(targets, fields.Target)
+	}
+	return append(targets, fields.Targets...)
+}
+
+// CaptureRequest - copy of method, url, headers and body of r
+func CaptureRequest(r *http.Request) (CapturedRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return CapturedRequest{}, err
+	}
+	return NewCapturedRequest(r, body), nil
+}
+
+// NewCapturedRequest - copy of r with an already read body, without credentials
+func NewCapturedRequest(r *http.Request, body []byte) CapturedRequest {
+	header := make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	for _, h := range authHeaders {
+		header.Del(h)
+	}
+	return CapturedRequest{
+		Method: r.Method,
+		URL:    r.URL.RequestURI(),
+		Header: header,
+		Body:   body,
+	}
+}
+
+// Execute - serve captured request through handler, returning recorded response
+func (c CapturedRequest) Execute(ctx context.Context, handler http.Handler) *ChangeResult {
+	r, err := http.NewRequest(c.Method, c.URL, bytes.NewReader(c.Body))
+	if err != nil {
+		return &ChangeResult{Status: http.StatusInternalServerError, Body: err.Error()}
+	}
+	r.Header = c.Header
+	r = r.WithContext(ctx)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return &ChangeResult{
+		Status:      rec.Code,
+		ContentType: rec.Header().Get("Content-Type"),
+		Body:        rec.Body.String(),
+	}
+}
+
+// ChangeResult - response recorded when a held back request executed
+type ChangeResult struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+// PendingChange - destructive request awaiting approval
+type PendingChange struct {
+	ID          string        `json:"id"`
+	Status      string        `json:"status"`
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	Body        string        `json:"body,omitempty"`
+	RequestedBy string        `json:"requested_by"`
+	RequestedAt time.Time     `json:"requested_at"`
+	DecidedBy   string        `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time    `json:"decided_at,omitempty"`
+	Result      *ChangeResult `json:"result,omitempty"`
+	Callback    *Callback     `json:"callback,omitempty"`
+
+	request   CapturedRequest
+	principal *Principal
+	targets   []string
+}
+
+// Approvals - two-phase confirmation of destructive operations
+//
+// When enabled, wrapped handlers do not execute; the request is stored as
+// a pending change that a second identity approves before it is replayed
+// through Handler. Changes are only decided by authenticated principals,
+// an X-User header is not trusted to tell approver and requester apart,
+// and only approved by principals that may reach the targets of the
+// change. Pending changes expire after TTL, decided and expired ones are
+// dropped Retention after their decision or expiry.
+type Approvals struct {
+	Enabled   bool
+	TTL       time.Duration
+	Retention time.Duration
+	Handler   http.Handler
+
+	mu      sync.RWMutex
+	changes map[string]*PendingChange
+}
+
+// NewApprovals - disabled approval workflow
+func NewApprovals() *Approvals {
+	return &Approvals{TTL: 24 * time.Hour, Retention: 7 * 24 * time.Hour, changes: map[string]*PendingChange{}}
+}
+
+// RequestIdentity - identity issuing the request
+//
+// With authentication enabled it is the authenticated principal and the
+// header is ignored.
+func RequestIdentity(r *http.Request) string {
+	if p := RequestPrincipal(r); p != nil {
+		return p.Name
+	}
+	return r.Header.Get(IdentityHeader)
+}
+
+// Require - hold back requests to next until approved
+func (a *Approvals) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Enabled || r.Context().Value(ApprovedKeyName) != nil || IsDryRun(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := RequestIdentity(r)
+		if identity == "" {
+			WriteError(w, http.StatusUnauthorized, IdentityHeader+" required for operations needing approval")
+			return
+		}
+		captured, err := CaptureRequest(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "reading request body")
+			return
+		}
+		callbackURL, err := RequestCallbackURL(r, captured.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		change := &PendingChange{
+			ID:          NewID(),
+			Status:      ChangePending,
+			Method:      captured.Method,
+			URL:         captured.URL,
+			Body:        string(captured.Body),
+			RequestedBy: identity,
+			RequestedAt: time.Now(),
+			Callback:    NewCallback(callbackURL),
+			request:     captured,
+			principal:   RequestPrincipal(r),
+			targets:     RequestTargets(r, captured.Body),
+		}
+		a.mu.Lock()
+		a.changes[change.ID] = change
+		a.mu.Unlock()
+
+		w.Header().Set("Location", "/api/v1/changes/"+change.ID)
+		WriteJSON(w, http.StatusAccepted, change)
+	})
+}
+
+// expire - mark stale pending changes expired and drop old decided ones,
+// caller holds the lock
+func (a *Approvals) expire() {
+	now := time.Now()
+	for id, c := range a.changes {
+		if c.Status == ChangePending && now.Sub(c.RequestedAt) > a.TTL {
+			c.Status = ChangeExpired
+		}
+		if c.Status == ChangePending || a.Retention <= 0 {
+			continue
+		}
+		ended := c.RequestedAt.Add(a.TTL)
+		if c.DecidedAt != nil {
+			ended = *c.DecidedAt
+		}
+		if now.Sub(ended) > a.Retention {
+			delete(a.changes, id)
+		}
+	}
+}
+
+// Count - number of changes in status
+func (a *Approvals) Count(status string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	n := 0
+	for _, c := range a.changes {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *Approvals) list() []PendingChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	list := make([]PendingChange, 0, len(a.changes))
+	for _, c := range a.changes {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].RequestedAt.Before(list[j].RequestedAt) })
+	return list
+}
+
+// ListChangesHandler - pending and decided changes, ?status= filters
+func (a *Approvals) ListChangesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	changes := []PendingChange{}
+	for _, c := range a.list() {
+		if status == "" || c.Status == status {
+			changes = append(changes, c)
+		}
+	}
+	WriteJSON(w, http.StatusOK, changes)
+}
+
+// GetChangeHandler - single change
+func (a *Approvals) GetChangeHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.expire()
+	c, ok := a.changes[mux.Vars(r)["id"]]
+	var change PendingChange
+	if ok {
+		change = *c
+	}
+	a.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "change not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, change)
+}
+
+// decide - move pending change to a final state, returns copy and held request
+func (a *Approvals) decide(w http.ResponseWriter, r *http.Request, status string) (*PendingChange, bool) {
+	p := RequestPrincipal(r)
+	if p == nil {
+		WriteError(w, http.StatusUnauthorized, "deciding changes requires authentication")
+		return nil, false
+	}
+	identity := p.Name
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	c, ok := a.changes[mux.Vars(r)["id"]]
+	if !ok {
+		WriteError(w, http.StatusNotFound, "change not found")
+		return nil, false
+	}
+	if c.Status != ChangePending {
+		WriteError(w, http.StatusConflict, "change is "+c.Status)
+		return nil, false
+	}
+	if identity == c.RequestedBy {
+		WriteError(w, http.StatusForbidden, "change must be decided by a different identity")
+		return nil, false
+	}
+	for _, target := range c.targets {
+		if !p.AllowsTarget(target) {
+			WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+			return nil, false
+		}
+	}
+
+	now := time.Now()
+	c.Status = status
+	c.DecidedBy = identity
+	c.DecidedAt = &now
+	return c, true
+}
+
+// ApproveChangeHandler - approve and execute pending change
+//
+// The change runs as the principal that requested it, so the journal sees
+// the requester rather than the approver. Targets the change resolves
+// while running, e.g. those of a drift policy, are checked against the
+// scopes of both, see AuthorizeTargets.
+func (a *Approvals) ApproveChangeHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.decide(w, r, ChangeExecuted)
+	if !ok {
+		return
+	}
+
+	ctx := context.WithValue(context.Background(), ApprovedKeyName, c.DecidedBy)
+	ctx = context.WithValue(ctx, ApproverKeyName, RequestPrincipal(r))
+	if c.principal != nil {
+		ctx = context.WithValue(ctx, PrincipalKeyName, c.principal)
+	}
+	result := c.request.Execute(ctx, a.Handler)
+
+	a.mu.Lock()
+	c.Result = result
+	a.notify(c, "change.executed")
+	change := *c
+	a.mu.Unlock()
+
+	WriteJSON(w, http.StatusOK, change)
+}
+
+// RejectChangeHandler - reject pending change
+func (a *Approvals) RejectChangeHandler(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.decide(w, r, ChangeRejected)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	a.notify(c, "change.rejected")
+	change := *c
+	a.mu.Unlock()
+	WriteJSON(w, http.StatusOK, change)
+}
+
+// notify - deliver callback of change, caller holds the lock
+func (a *Approvals) notify(c *PendingChange, event string) {
+	if c.Callback == nil {
+		return
+	}
+	c.Callback.Deliver(CallbackEvent{Event: event, ID: c.ID, Time: time.Now(), Data: *c}, func(cb Callback) {
+		a.mu.Lock()
+		c.Callback = &cb
+		a.mu.Unlock()
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Audit results
+const (
+	AuditOK     = "ok"
+	AuditFailed = "failed"
+)
+
+// AuditRecord - write operation as reported to compliance
+//
+// OldValues are read right before the SET, in the [oid, type, value] form
+// of NewValues with a null value where the oid had no instance.
+type AuditRecord struct {
+	Time          time.Time       `json:"time"`
+	Identity      string          `json:"identity,omitempty"`
+	ApprovedBy    string          `json:"approved_by,omitempty"`
+	RemoteAddr    string          `json:"remote_addr,omitempty"`
+	RequestID     string          `json:"request_id,omitempty"`
+	Request       string          `json:"request,omitempty"`
+	Target        string          `json:"target"`
+	Version       string          `json:"snmp_version"`
+	Operation     string          `json:"operation"`
+	JournalID     string          `json:"journal_id,omitempty"`
+	OldValues     [][]interface{} `json:"old_values"`
+	OldValueError string          `json:"old_values_error,omitempty"`
+	NewValues     [][]interface{} `json:"new_values"`
+	Result        string          `json:"result"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// AuditLog - structured log of every SET, as json lines to a file and/or syslog
+type AuditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	syslog *syslog.Writer
+}
+
+// audit - audit log of all writes, disabled unless opened
+var audit = &AuditLog{}
+
+// OpenAuditLog - audit log appending to path and sending to syslog
+//
+// syslogAddr is "local" for the local syslog daemon or udp://host:port
+// or tcp://host:port for a remote one; either may be empty.
+func OpenAuditLog(path string, syslogAddr string) (*AuditLog, error) {
+	a := &AuditLog{}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	if syslogAddr != "" {
+		network, addr := "", ""
+		if syslogAddr != "local" {
+			parts := strings.SplitN(syslogAddr, "://", 2)
+			if len(parts) != 2 || (parts[0] != "udp" && parts[0] != "tcp") {
+				return nil, fmt.Errorf("audit syslog must be local, udp://host:port or tcp://host:port")
+			}
+			network, addr = parts[0], parts[1]
+		}
+		w, err := syslog.Dial(network, addr, syslog.LOG_NOTICE|syslog.LOG_AUTH, "rest-snmp")
+		if err != nil {
+			return nil, err
+		}
+		a.syslog = w
+	}
+	return a, nil
+}
+
+// Enabled - whether writes are audited
+func (a *AuditLog) Enabled() bool {
+	return a.file != nil || a.syslog != nil
+}
+
+// Record - write record to every configured destination
+//
+// Failures are logged; the SET has already been sent.
+func (a *AuditLog) Record(record AuditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Error("encoding audit record", Fields{"err": err})
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			logger.Error("writing audit log", Fields{"err": err})
+		}
+	}
+	if a.syslog != nil {
+		if err := a.syslog.Notice(string(line)); err != nil {
+			logger.Error("writing audit syslog", Fields{"err": err})
+		}
+	}
+	stats.Inc("audit.records")
+}
+
+// NewAuditRecord - record of a SET of pdus on g, identified by the request g is bound to
+func NewAuditRecord(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) AuditRecord {
+	record := AuditRecord{
+		Time:      time.Now(),
+		Target:    SessionTarget(g),
+		Version:   VersionLabel(g.Version),
+		Operation: operation,
+		NewValues: PDUsToValues(pdus),
+	}
+	if r := SessionRequest(g); r != nil {
+		record.Identity = RequestIdentity(r)
+		if record.Identity == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			record.Identity = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		record.ApprovedBy, _ = r.Context().Value(ApprovedKeyName).(string)
+		record.RemoteAddr = r.RemoteAddr
+		record.RequestID = RequestID(r)
+		record.Request = r.Method + " " + r.URL.RequestURI()
+	}
+	return record
+}
+
+// Before - read the old values of the record's oids
+func (record *AuditRecord) Before(g *gosnmp.GoSNMP, pdus []gosnmp.SnmpPDU) {
+	values, err := CurrentValues(g, pdus)
+	if err != nil {
+		record.OldValueError = err.Error()
+		return
+	}
+	record.OldValues = values
+}
+
+// After - set result of the record from the outcome of the SET
+func (record *AuditRecord) After(journalID string, err error) {
+	record.JournalID = journalID
+	record.Result = AuditOK
+	if err != nil {
+		record.Result = AuditFailed
+		record.Error = err.Error()
+	}
+}
+
+// CurrentValues - values the oids of pdus hold, as [oid, type, value]
+//
+// Oids without instance have a null type and value.
+func CurrentValues(g *gosnmp.GoSNMP, pdus []gosnmp.SnmpPDU) ([][]interface{}, error) {
+	oids := make([]string, len(pdus))
+	for i, pdu := range pdus {
+		oids[i] = pdu.Name
+	}
+	current, failed, err := GetPartial(g, oids)
+	if err != nil {
+		return nil, err
+	}
+	values := PDUsToValues(current)
+	for i, pdu := range current {
+		if _, ok := failed[i]; ok || !cacheable(pdu) {
+			values[i] = []interface{}{oids[i], nil, nil}
+		}
+	}
+	return values, nil
+}
+
+// AuditedSet - ObservedSet, audited with its old values if auditing is enabled
+func AuditedSet(g *gosnmp.GoSNMP, operation string, journalID string, pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	if !audit.Enabled() {
+		return ObservedSet(g, pdus)
+	}
+	record := NewAuditRecord(g, operation, pdus)
+	record.Before(g, pdus)
+	result, err := ObservedSet(g, pdus)
+	outcome := err
+	if perr := NewPacketError("Set", result); err == nil && perr != nil {
+		outcome = perr
+	}
+	record.After(journalID, outcome)
+	audit.Record(record)
+	return result, err
+}
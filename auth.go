@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Roles, each including the ones before it
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+	RoleAdmin = "admin"
+)
+
+var roleLevels = map[string]int{RoleRead: 1, RoleWrite: 2, RoleAdmin: 3}
+
+// PrincipalKey - key defining authenticated principal context key
+type PrincipalKey string
+
+// PrincipalKeyName - keyname defined for context
+const PrincipalKeyName PrincipalKey = "PRINCIPAL"
+
+// Principal - authenticated caller, its role and the targets it may reach
+//
+// No targets means every target.
+type Principal struct {
+	Name    string   `json:"name"`
+	Role    string   `json:"role"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// Can - whether principal has at least role
+func (p *Principal) Can(role string) bool {
+	return roleLevels[p.Role] >= roleLevels[role]
+}
+
+// AllowsTarget - whether principal may send requests to target
+func (p *Principal) AllowsTarget(target string) bool {
+	if len(p.Targets) == 0 {
+		return true
+	}
+	for _, pattern := range p.Targets {
+		if MatchTarget(pattern, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey - static key of a principal, plain or as hex sha256
+type APIKey struct {
+	Principal
+	Key       string `json:"key,omitempty"`
+	KeySHA256 string `json:"key_sha256,omitempty"`
+}
+
+// JWTConfig - accepted HS256 bearer tokens
+//
+// Tokens carry the principal in the sub, role and targets claims.
+type JWTConfig struct {
+	Secret   string `json:"secret"`
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+// Auth - authentication and role based authorization of API requests
+//
+// Disabled unless API keys or a JWT secret are configured.
+type Auth struct {
+	APIKeys []APIKey  `json:"api_keys"`
+	JWT     JWTConfig `json:"jwt"`
+}
+
+// auth - authentication settings loaded at startup
+var auth = &Auth{}
+
+// LoadAuth - auth settings from json file
+func LoadAuth(path string) (*Auth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	a := &Auth{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for i, k := range a.APIKeys {
+		if k.Name == "" || (k.Key == "") == (k.KeySHA256 == "") {
+			return nil, fmt.Errorf("%s: api key %d needs a name and one of key or key_sha256", path, i)
+		}
+		if _, ok := roleLevels[k.Role]; !ok {
+			return nil, fmt.Errorf("%s: api key %s: role must be read, write or admin", path, k.Name)
+		}
+	}
+	return a, nil
+}
+
+// Enabled - whether requests must authenticate
+func (a *Auth) Enabled() bool {
+	return len(a.APIKeys) > 0 || a.JWT.Secret != ""
+}
+
+// authenticate - principal of the api key or bearer token of request
+//
+// A bearer token that is not a JWT is taken as api key, so scrapers only
+// able to send bearer tokens can use keys.
+func (a *Auth) authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if bearer := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(bearer, "Bearer ") {
+		token := strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
+		if strings.Count(token, ".") == 2 {
+			return a.verifyJWT(token)
+		}
+		key = token
+	}
+	if key == "" {
+		return nil, fmt.Errorf("api key or bearer token required")
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	hashed := hex.EncodeToString(sum[:])
+	for _, k := range a.APIKeys {
+		if (k.Key != "" && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1) ||
+			(k.KeySHA256 != "" && subtle.ConstantTimeCompare([]byte(strings.ToLower(k.KeySHA256)), []byte(hashed)) == 1) {
+			p := k.Principal
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid api key")
+}
+
+// jwtClaims - claims of accepted tokens
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  interface{} `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+	Role      string      `json:"role"`
+	Targets   []string    `json:"targets"`
+}
+
+// hasAudience - aud claim, string or list, contains audience
+func (c jwtClaims) hasAudience(audience string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifyJWT - principal of a valid HS256 token
+func (a *Auth) verifyJWT(token string) (*Principal, error) {
+	if a.JWT.Secret == "" {
+		return nil, fmt.Errorf("bearer tokens not accepted")
+	}
+	parts := strings.Split(token, ".")
+
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil || header.Alg != "HS256" {
+		return nil, fmt.Errorf("invalid token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+	mac := hmac.New(sha256.New, []byte(a.JWT.Secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	claims := jwtClaims{}
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	now := time.Now().Unix()
+	switch {
+	case claims.ExpiresAt == 0 || now >= claims.ExpiresAt:
+		return nil, fmt.Errorf("token expired")
+	case claims.NotBefore != 0 && now < claims.NotBefore:
+		return nil, fmt.Errorf("token not yet valid")
+	case a.JWT.Issuer != "" && claims.Issuer != a.JWT.Issuer:
+		return nil, fmt.Errorf("invalid token issuer")
+	case a.JWT.Audience != "" && !claims.hasAudience(a.JWT.Audience):
+		return nil, fmt.Errorf("invalid token audience")
+	case claims.Subject == "":
+		return nil, fmt.Errorf("token subject missing")
+	}
+	if _, ok := roleLevels[claims.Role]; !ok {
+		return nil, fmt.Errorf("token role must be read, write or admin")
+	}
+	return &Principal{Name: claims.Subject, Role: claims.Role, Targets: claims.Targets}, nil
+}
+
+// readMethods - methods that never change device state
+var readMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"WALK":             true,
+	"BULKWALK":         true,
+	"GETBULK":          true,
+}
+
+// RequiredRole - role needed for request on route template
+//
+// Credential management, the admin endpoints and changes to profiles
+// and table definitions need admin, other reads need read and everything
+// else write. POSTs that only read, among them the submission of walk
+// jobs, are listed here; cancelling a job needs write.
+func RequiredRole(r *http.Request, route string) string {
+	switch {
+	case strings.HasPrefix(route, "/api/v1/credentials"),
+		strings.HasPrefix(route, "/api/v1/admin"),
+		strings.HasPrefix(route, "/api/v1/profiles") && !readMethods[r.Method],
+		strings.HasPrefix(route, "/api/v1/tables") && !readMethods[r.Method]:
+		return RoleAdmin
+	case readMethods[r.Method],
+		strings.HasSuffix(route, "/multi"),
+		strings.HasSuffix(route, "/get"),
+		strings.HasSuffix(route, "/getbulk"),
+		strings.HasSuffix(route, "/matrix"),
+		route == "/api/v1/jobs" && r.Method == http.MethodPost,
+		strings.HasSuffix(route, "/validate"):
+		return RoleRead
+	}
+	return RoleWrite
+}
+
+// publicPaths - probed by orchestrators and load balancers without credentials
+var publicPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// Middleware - authenticate requests and enforce role and target scope
+//
+// Used as mux middleware so it runs before AddSnmpContext. Requests
+// replayed after approval or at their scheduled time were authorized
+// when submitted and pass unchecked, carrying the principal stored with
+// them as their credentials are not kept.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !a.Enabled() || publicPaths[r.URL.Path] || ctx.Value(ApprovedKeyName) != nil || ctx.Value(ScheduledKeyName) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		p, status, err := a.Check(r, RequiredRole(r, route), mux.Vars(r)["target"])
+		if err != nil {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			WriteError(w, status, err.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, PrincipalKeyName, p)))
+	})
+}
+
+// Check - principal of r if it has role and may reach target
+//
+// An empty target is not checked. On failure the http status to answer
+// with is returned, 401 for missing or invalid credentials and 403 for
+// missing permissions.
+func (a *Auth) Check(r *http.Request, role string, target string) (*Principal, int, error) {
+	p, err := a.authenticate(r)
+	if err != nil {
+		stats.Inc("auth.rejected")
+		return nil, http.StatusUnauthorized, err
+	}
+	if !p.Can(role) {
+		stats.Inc("auth.forbidden")
+		return nil, http.StatusForbidden, fmt.Errorf("%s role required", role)
+	}
+	if target != "" && !p.AllowsTarget(target) {
+		stats.Inc("auth.forbidden")
+		return nil, http.StatusForbidden, fmt.Errorf("target not allowed for %s", p.Name)
+	}
+	return p, 0, nil
+}
+
+// RequestPrincipal - authenticated principal of request, nil if auth is disabled
+func RequestPrincipal(r *http.Request) *Principal {
+	p, _ := r.Context().Value(PrincipalKeyName).(*Principal)
+	return p
+}
+
+// AuthorizeTargets - check targets named in a request body against the principal
+//
+// Approved changes are also checked against the approver, see
+// ApproverKeyName. The first target not allowed is returned with false.
+func AuthorizeTargets(r *http.Request, targets []string) (string, bool) {
+	principals := []*Principal{RequestPrincipal(r)}
+	if approver, ok := r.Context().Value(ApproverKeyName).(*Principal); ok {
+		principals = append(principals, approver)
+	}
+	for _, p := range principals {
+		if p == nil {
+			continue
+		}
+		for _, target := range targets {
+			if !p.AllowsTarget(target) {
+				return target, false
+			}
+		}
+	}
+	return "", true
+}
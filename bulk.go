@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/soniah/gosnmp"
+)
+
+// BulkParams - max_repetitions and non_repeaters query parameters, 0 if unset
+func BulkParams(r *http.Request) (int, int, error) {
+	q := r.URL.Query()
+	maxReps, nonRepeaters := 0, 0
+	if v := q.Get("max_repetitions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 255 {
+			return 0, 0, fmt.Errorf("max_repetitions must be between 1 and 255")
+		}
+		maxReps = n
+	}
+	if v := q.Get("non_repeaters"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 255 {
+			return 0, 0, fmt.Errorf("non_repeaters must be between 0 and 255")
+		}
+		nonRepeaters = n
+	}
+	return maxReps, nonRepeaters, nil
+}
+
+// GetBulkHandler - single snmpgetbulk of the oids in the body
+//
+// The first non_repeaters oids are read once, the others max_repetitions
+// times, as with snmpbulkget.
+func GetBulkHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	if g.Version == gosnmp.Version1 {
+		WriteError(w, http.StatusBadRequest, "GETBULK requires v2c or later")
+		return
+	}
+	maxReps, nonRepeaters, err := BulkParams(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var oidlist OidList
+	if err := json.NewDecoder(r.Body).Decode(&oidlist); err != nil || len(oidlist.Oids) == 0 {
+		WriteError(w, http.StatusBadRequest, "oids missing")
+		return
+	}
+	oids, err := ResolveOids(oidlist.Oids)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if nonRepeaters > len(oids) {
+		WriteError(w, http.StatusBadRequest, "non_repeaters exceeds number of oids")
+		return
+	}
+
+	result, err := NegotiatedGetBulk(g, oids, uint8(nonRepeaters), maxReps)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if err := NewPacketError("GetBulk", result); err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	RenderVariables(w, r, result.Variables)
+}
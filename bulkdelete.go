@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Row outcomes of a bulk delete
+const (
+	RowDeleted  = "deleted"
+	RowNotFound = "not_found"
+	RowFailed   = "failed"
+)
+
+// BulkDeleteRequest - rows of a table to delete
+//
+// Either indexes lists the rows, or filter is a glob matched against the
+// index of every existing row, e.g. "1.*".
+type BulkDeleteRequest struct {
+	Indexes []string `json:"indexes"`
+	Filter  string   `json:"filter"`
+}
+
+// RowResult - outcome of deleting one row
+type RowResult struct {
+	Index  string `json:"index"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// deleteMode - RowStatus value and journal operation of ?mode=
+func deleteMode(r *http.Request) (int, string, error) {
+	switch r.URL.Query().Get("mode") {
+	case "", "destroy":
+		return RowStatusDestroy, "delete", nil
+	case "soft":
+		return RowStatusNotInService, "deactivate", nil
+	}
+	return 0, "", fmt.Errorf("mode must be destroy or soft")
+}
+
+// BulkDeleteHandler - delete many rows of a table in one call
+//
+// Existing rows are written in sets of up to MaxOids varbinds; when a set
+// fails its rows are retried one by one so every index gets its own
+// outcome. Responds 207 unless every row was deleted.
+func BulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	status, operation, err := deleteMode(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	column, err := rowStatusColumn(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	request := BulkDeleteRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid bulk delete json")
+		return
+	}
+	if (len(request.Indexes) == 0) == (request.Filter == "") {
+		WriteError(w, http.StatusBadRequest, "either indexes or filter is required")
+		return
+	}
+	if _, err := path.Match(request.Filter, ""); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid filter")
+		return
+	}
+
+	var results []RowResult
+	var existing []string
+	if request.Filter != "" {
+		rows, err := ObservedWalkAll(g, column, false)
+		if err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		for _, pdu := range rows {
+			index, ok := instanceSuffix(column, pdu.Name)
+			if ok && index != "" && pdu.Type == gosnmp.Integer {
+				if match, _ := path.Match(request.Filter, index); match {
+					existing = append(existing, index)
+				}
+			}
+		}
+	} else {
+		oids := make([]string, len(request.Indexes))
+		for i, index := range request.Indexes {
+			oids[i] = column + "." + strings.Trim(index, ".")
+		}
+		got, err := NegotiatedGet(g, oids)
+		if err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		for i, index := range request.Indexes {
+			if i < len(got.Variables) && got.Variables[i].Type == gosnmp.Integer {
+				existing = append(existing, strings.Trim(index, "."))
+			} else {
+				results = append(results, RowResult{Index: index, Status: RowNotFound})
+			}
+		}
+	}
+
+	chunk := LimitsForTarget(SessionTarget(g)).MaxOids
+	if chunk <= 0 {
+		chunk = gosnmp.MaxOids
+	}
+	for start := 0; start < len(existing); start += chunk {
+		end := start + chunk
+		if end > len(existing) {
+			end = len(existing)
+		}
+		results = append(results, deleteRows(g, column, existing[start:end], status, operation)...)
+	}
+
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Status != RowDeleted {
+			code = http.StatusMultiStatus
+		}
+	}
+	if results == nil {
+		results = []RowResult{}
+	}
+	WriteJSON(w, code, results)
+}
+
+// deleteRows - single set for rows, one set per row if that fails
+func deleteRows(g *gosnmp.GoSNMP, column string, indexes []string, status int, operation string) []RowResult {
+	pdus := make([]gosnmp.SnmpPDU, len(indexes))
+	for i, index := range indexes {
+		pdus[i] = gosnmp.SnmpPDU{Name: column + "." + index, Type: gosnmp.Integer, Value: status}
+	}
+
+	results := make([]RowResult, len(indexes))
+	result, err := JournaledSet(g, operation, pdus)
+	if err == nil && result.ErrorIndex == 0 {
+		for i, index := range indexes {
+			results[i] = RowResult{Index: index, Status: RowDeleted}
+		}
+		return results
+	}
+
+	for i, index := range indexes {
+		results[i] = RowResult{Index: index, Status: RowFailed}
+		if len(indexes) == 1 {
+			if err != nil {
+				results[i].Error = err.Error()
+			} else {
+				results[i].Error = fmt.Sprintf("Set error: %v", result.Error)
+			}
+			continue
+		}
+		results[i] = deleteRows(g, column, indexes[i:i+1], status, operation)[0]
+	}
+	return results
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// CacheRule - time varbinds under an oid prefix are cached
+type CacheRule struct {
+	Prefix string
+	TTL    time.Duration
+}
+
+// cacheEntry - cached varbind of a session
+type cacheEntry struct {
+	oid     string
+	pdu     gosnmp.SnmpPDU
+	expires time.Time
+}
+
+// ResponseCache - GET results cached per target, credentials and oid
+//
+// The TTL of an oid is that of the longest matching rule, DefaultTTL
+// otherwise; zero disables caching. Entries are scoped by the session
+// key, so agents exposing different views per community never share
+// results. Writes through the gateway drop the entries of their oids.
+type ResponseCache struct {
+	DefaultTTL time.Duration
+	MaxEntries int
+
+	mu       sync.Mutex
+	rules    []CacheRule
+	size     int
+	byTarget map[string]map[string]*cacheEntry
+}
+
+// cache - response cache of GET requests
+var cache = NewResponseCache()
+
+// NewResponseCache - disabled cache
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		MaxEntries: 10000,
+		byTarget:   map[string]map[string]*cacheEntry{},
+	}
+}
+
+// ParseCacheRules - rules of comma separated oid=ttl pairs, e.g. sysDescr=1h
+func ParseCacheRules(s string) ([]CacheRule, error) {
+	var rules []CacheRule
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("cache rule %s: expected oid=ttl", item)
+		}
+		oid, err := ResolveOid(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("cache rule %s: %v", item, err)
+		}
+		ttl, err := time.ParseDuration(parts[1])
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("cache rule %s: invalid ttl", item)
+		}
+		rules = append(rules, CacheRule{Prefix: "." + strings.Trim(oid, "."), TTL: ttl})
+	}
+	sort.Slice(rules, func(i, j int) bool { return len(rules[i].Prefix) > len(rules[j].Prefix) })
+	return rules, nil
+}
+
+// SetRules - replace the per oid rules
+func (c *ResponseCache) SetRules(rules []CacheRule) {
+	c.mu.Lock()
+	c.rules = rules
+	c.mu.Unlock()
+}
+
+// Enabled - whether any oid may be cached
+func (c *ResponseCache) Enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.DefaultTTL > 0 {
+		return true
+	}
+	for _, rule := range c.rules {
+		if rule.TTL > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ttl - cache time of oid, caller holds the lock
+func (c *ResponseCache) ttl(oid string) time.Duration {
+	for _, rule := range c.rules {
+		if oid == rule.Prefix || strings.HasPrefix(oid, rule.Prefix+".") {
+			return rule.TTL
+		}
+	}
+	return c.DefaultTTL
+}
+
+// normalizeOid - oid with a single leading dot, as returned by agents
+func normalizeOid(oid string) string {
+	return "." + strings.Trim(oid, ".")
+}
+
+func (c *ResponseCache) lookup(target string, key string, now time.Time) (gosnmp.SnmpPDU, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byTarget[target][key]
+	if !ok || now.After(e.expires) {
+		return gosnmp.SnmpPDU{}, false
+	}
+	return e.pdu, true
+}
+
+func (c *ResponseCache) store(target string, key string, pdu gosnmp.SnmpPDU, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oid := normalizeOid(pdu.Name)
+	ttl := c.ttl(oid)
+	if ttl <= 0 {
+		return
+	}
+	entries, ok := c.byTarget[target]
+	if !ok {
+		entries = map[string]*cacheEntry{}
+		c.byTarget[target] = entries
+	}
+	if _, ok := entries[key]; !ok {
+		if c.MaxEntries > 0 && c.size >= c.MaxEntries {
+			c.expire(now)
+			if c.size >= c.MaxEntries {
+				stats.Inc("cache.full")
+				return
+			}
+		}
+		c.size++
+	}
+	entries[key] = &cacheEntry{oid: oid, pdu: pdu, expires: now.Add(ttl)}
+}
+
+// expire - drop expired entries, caller holds the lock
+func (c *ResponseCache) expire(now time.Time) {
+	for target, entries := range c.byTarget {
+		for key, e := range entries {
+			if now.After(e.expires) {
+				delete(entries, key)
+				c.size--
+			}
+		}
+		if len(entries) == 0 {
+			delete(c.byTarget, target)
+		}
+	}
+}
+
+// Run - drop expired entries every minute until stop is closed
+func (c *ResponseCache) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.mu.Lock()
+			c.expire(now)
+			c.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Invalidate - drop cached varbinds of target written by pdus
+func (c *ResponseCache) Invalidate(target string, pdus []gosnmp.SnmpPDU) {
+	written := make(map[string]bool, len(pdus))
+	for _, pdu := range pdus {
+		written[normalizeOid(pdu.Name)] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.byTarget[target] {
+		if written[e.oid] {
+			delete(c.byTarget[target], key)
+			c.size--
+		}
+	}
+}
+
+// Len - number of cached varbinds
+func (c *ResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// cacheable - whether a varbind read from the agent may be cached
+func cacheable(pdu gosnmp.SnmpPDU) bool {
+	switch pdu.Type {
+	case gosnmp.NoSuchInstance, gosnmp.NoSuchObject, gosnmp.EndOfMibView:
+		return false
+	}
+	return true
+}
+
+// Get - snmpget of plain oids served from the cache where possible
+//
+// Unless bypass is set cached varbinds are returned and only the others
+// are read with GetPartial; fresh varbinds are stored either way. The
+// result has the shape of GetWithWildcards; hit reports whether no
+// request was sent.
+func (c *ResponseCache) Get(g *gosnmp.GoSNMP, oids []string, bypass bool) ([]gosnmp.SnmpPDU, []VarbindError, bool, error) {
+	now := time.Now()
+	session := sessions.Key(g)
+	pdus := make([]gosnmp.SnmpPDU, len(oids))
+	var missing []string
+	var positions []int
+	for i, oid := range oids {
+		if !bypass {
+			if pdu, ok := c.lookup(SessionTarget(g), session+"|"+normalizeOid(oid), now); ok {
+				pdus[i] = pdu
+				continue
+			}
+		}
+		missing = append(missing, oid)
+		positions = append(positions, i)
+	}
+
+	hits := len(oids) - len(missing)
+	stats.Add("cache.hits", int64(hits))
+	stats.Add("cache.misses", int64(len(missing)))
+	metrics.Add("cache_lookups_total", uint64(hits), "hit")
+	metrics.Add("cache_lookups_total", uint64(len(missing)), "miss")
+
+	failed := map[int]string{}
+	if len(missing) > 0 {
+		got, gotFailed, err := GetPartial(g, missing)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		for j, i := range positions {
+			if msg, ok := gotFailed[j]; ok {
+				failed[i] = msg
+				continue
+			}
+			pdus[i] = got[j]
+			if cacheable(got[j]) {
+				c.store(SessionTarget(g), session+"|"+normalizeOid(missing[j]), got[j], now)
+			}
+		}
+	}
+
+	result := make([]gosnmp.SnmpPDU, 0, len(oids))
+	var errs []VarbindError
+	for i, oid := range oids {
+		if msg, ok := failed[i]; ok {
+			errs = append(errs, VarbindError{Oid: oid, Error: msg})
+			continue
+		}
+		result = append(result, pdus[i])
+	}
+	return result, errs, len(missing) == 0, nil
+}
+
+// CacheBypass - whether request asks for fresh values with Cache-Control: no-cache
+func CacheBypass(r *http.Request) bool {
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.TrimSpace(directive) == "no-cache" {
+			return true
+		}
+	}
+	return r.Header.Get("Pragma") == "no-cache"
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/soniah/gosnmp"
+)
+
+// ValueMismatch - varbind whose current value differs from the expected one
+type ValueMismatch struct {
+	Oid      string      `json:"oid"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+// SetConflict - body of a 409 answer to a compare-and-set
+//
+// Actual holds the current varbinds formatted per request options.
+type SetConflict struct {
+	Error      string          `json:"error"`
+	Mismatches []ValueMismatch `json:"mismatches"`
+	Actual     interface{}     `json:"actual"`
+}
+
+// ExpectedValues - expectations of [oid, type, value, expected] entries
+//
+// pdus are the varbinds built from values, in the same order; entries
+// without a fourth element are written unconditionally.
+func ExpectedValues(values [][]interface{}, pdus []gosnmp.SnmpPDU) ([]gosnmp.SnmpPDU, error) {
+	var expected []gosnmp.SnmpPDU
+	for i, val := range values {
+		if len(val) < 4 || i >= len(pdus) {
+			continue
+		}
+		pdu, err := ToSnmpPDU(pdus[i].Name, val[1], val[3])
+		if err != nil {
+			return nil, fmt.Errorf("expected value of %s: %v", pdus[i].Name, err)
+		}
+		expected = append(expected, pdu)
+	}
+	return expected, nil
+}
+
+// CompareValues - GET expected oids and list those not holding their value
+//
+// The check and the following SET are not atomic on the agent; it only
+// narrows the window in which concurrent writers clobber each other.
+func CompareValues(g *gosnmp.GoSNMP, expected []gosnmp.SnmpPDU) ([]ValueMismatch, []gosnmp.SnmpPDU, error) {
+	oids := make([]string, len(expected))
+	for i, pdu := range expected {
+		oids[i] = pdu.Name
+	}
+	result, err := NegotiatedGet(g, oids)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.Error != gosnmp.NoError {
+		return nil, nil, NewPacketError("Get", result)
+	}
+
+	actual := SanitizeResultVariables(&result.Variables)
+	var mismatches []ValueMismatch
+	for i, pdu := range expected {
+		if i < len(actual) && PDUValueEqual(actual[i], pdu) {
+			continue
+		}
+		mismatch := ValueMismatch{Oid: pdu.Name, Expected: pdu.Value}
+		if i < len(actual) {
+			mismatch.Actual = actual[i].Value
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, actual, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Circuit states
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+)
+
+// circuitWindow - number of recent operations the failure rate is taken over
+const circuitWindow = 20
+
+// CircuitOpenError - operation refused because the circuit of target is open
+type CircuitOpenError struct {
+	Target     string
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s not responding, circuit open, retry after %v", e.Target, e.RetryAfter)
+}
+
+// circuit - recent operations of one target
+//
+// outcomes is a ring of the last circuitWindow results, true for
+// timeouts. version and community are those of the last timed out
+// session, used to probe the target while open.
+type circuit struct {
+	open      bool
+	timeouts  int
+	outcomes  []bool
+	next      int
+	openedAt  time.Time
+	nextProbe time.Time
+	lastSeen  time.Time
+	lastError string
+	probing   bool
+	version   gosnmp.SnmpVersion
+	community string
+}
+
+// failureRate - share of timeouts among the recent operations
+func (c *circuit) failureRate() float64 {
+	if len(c.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, timeout := range c.outcomes {
+		if timeout {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(c.outcomes))
+}
+
+// add - record outcome in the ring
+func (c *circuit) add(timeout bool) {
+	if len(c.outcomes) < circuitWindow {
+		c.outcomes = append(c.outcomes, timeout)
+		return
+	}
+	c.outcomes[c.next] = timeout
+	c.next = (c.next + 1) % circuitWindow
+}
+
+// CircuitStatus - health of a target as reported by the targets api
+type CircuitStatus struct {
+	Target              string     `json:"target"`
+	State               string     `json:"state"`
+	ConsecutiveTimeouts int        `json:"consecutive_timeouts"`
+	FailureRate         float64    `json:"failure_rate"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	NextProbe           *time.Time `json:"next_probe,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// CircuitBreaker - per target circuits opened by consecutive timeouts
+//
+// After Threshold timeouts in a row every operation on the target fails
+// at once with CircuitOpenError instead of waiting for its own timeout.
+// While open the target is probed every ProbeInterval with a single GET
+// without retries; any answer closes the circuit. Threshold 0 disables
+// the breaker.
+type CircuitBreaker struct {
+	Threshold     int
+	ProbeInterval time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// breaker - circuits of every target the gateway talks to
+var breaker = NewCircuitBreaker()
+
+// NewCircuitBreaker - breaker opening after 5 timeouts, probing every 30s
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{Threshold: 5, ProbeInterval: 30 * time.Second, circuits: map[string]*circuit{}}
+}
+
+// Allow - CircuitOpenError if the circuit of target is open
+func (b *CircuitBreaker) Allow(target string) error {
+	if b.Threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[target]
+	if !ok || !c.open {
+		return nil
+	}
+	stats.Inc("circuit.rejected")
+	wait := time.Until(c.nextProbe)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return &CircuitOpenError{Target: target, RetryAfter: wait}
+}
+
+// Record - count the result of an operation of session g, see ObserveSnmp
+//
+// Timeouts count towards opening the circuit; any answer of the agent,
+// error statuses included, resets the count and closes an open circuit.
+// Other errors, e.g. unresolvable hosts, are not counted.
+func (b *CircuitBreaker) Record(g *gosnmp.GoSNMP, result string, err error) {
+	if b.Threshold <= 0 || (result != "timeout" && result != "ok" && result != "agent_error") {
+		return
+	}
+	target := SessionTarget(g)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[target]
+	if !ok {
+		if result != "timeout" {
+			return
+		}
+		c = &circuit{}
+		b.circuits[target] = c
+	}
+	c.lastSeen = now
+	if result != "timeout" {
+		c.add(false)
+		c.timeouts = 0
+		if c.open {
+			b.close(target, c)
+		}
+		return
+	}
+
+	c.add(true)
+	c.timeouts++
+	c.lastError = err.Error()
+	c.version, c.community = g.Version, g.Community
+	if !c.open && c.timeouts >= b.Threshold {
+		c.open = true
+		c.openedAt = now
+		c.nextProbe = now.Add(b.ProbeInterval)
+		metrics.Inc("circuit_transitions_total", target, CircuitOpen)
+		logger.Warn("circuit opened", Fields{"target": target, "timeouts": c.timeouts, "retry_in": b.ProbeInterval.String()})
+	}
+}
+
+// close - close the circuit of target, caller holds the lock
+func (b *CircuitBreaker) close(target string, c *circuit) {
+	c.open = false
+	c.timeouts = 0
+	c.lastError = ""
+	metrics.Inc("circuit_transitions_total", target, CircuitClosed)
+	logger.Info("circuit closed", Fields{"target": target, "open_for": time.Since(c.openedAt).String()})
+}
+
+// status - CircuitStatus of c, caller holds the lock
+func (b *CircuitBreaker) status(target string, c *circuit) CircuitStatus {
+	s := CircuitStatus{
+		Target:              target,
+		State:               CircuitClosed,
+		ConsecutiveTimeouts: c.timeouts,
+		FailureRate:         c.failureRate(),
+		LastError:           c.lastError,
+	}
+	if c.open {
+		openedAt, nextProbe := c.openedAt, c.nextProbe
+		s.State, s.OpenedAt, s.NextProbe = CircuitOpen, &openedAt, &nextProbe
+	}
+	return s
+}
+
+// Status - circuit of target, nil if none of its operations timed out recently
+func (b *CircuitBreaker) Status(target string) *CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[target]
+	if !ok {
+		return nil
+	}
+	s := b.status(target, c)
+	return &s
+}
+
+// Statuses - circuits of every target with recent timeouts, open ones first
+func (b *CircuitBreaker) Statuses() []CircuitStatus {
+	b.mu.Lock()
+	list := make([]CircuitStatus, 0, len(b.circuits))
+	for target, c := range b.circuits {
+		list = append(list, b.status(target, c))
+	}
+	b.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].State != list[j].State {
+			return list[i].State == CircuitOpen
+		}
+		return list[i].Target < list[j].Target
+	})
+	return list
+}
+
+// OpenCount - number of open circuits
+func (b *CircuitBreaker) OpenCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, c := range b.circuits {
+		if c.open {
+			n++
+		}
+	}
+	return n
+}
+
+// Run - probe open circuits and drop healthy idle ones until stop is closed
+func (b *CircuitBreaker) Run(stop <-chan struct{}) {
+	if b.Threshold <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			b.mu.Lock()
+			for target, c := range b.circuits {
+				switch {
+				case c.open && !c.probing && !now.Before(c.nextProbe):
+					c.probing = true
+					go b.probe(target, c.version, c.community)
+				case !c.open && c.timeouts == 0 && now.Sub(c.lastSeen) > 10*time.Minute:
+					delete(b.circuits, target)
+				}
+			}
+			b.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// probe - GET sysObjectID.0 of target once, closing its circuit if answered
+func (b *CircuitBreaker) probe(target string, version gosnmp.SnmpVersion, community string) {
+	err := probeTarget(target, version, community)
+	stats.Inc("circuit.probes")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[target]
+	if !ok {
+		return
+	}
+	c.probing = false
+	if !c.open {
+		return
+	}
+	if err != nil {
+		c.lastError = err.Error()
+		c.nextProbe = time.Now().Add(b.ProbeInterval)
+		logger.Debug("circuit probe failed", Fields{"target": target, "err": err})
+		return
+	}
+	b.close(target, c)
+}
+
+// probeTarget - single GET of sysObjectID.0 without retries, past the breaker
+//
+// v3 targets are probed by engine discovery, which agents answer without
+// credentials.
+func probeTarget(target string, version gosnmp.SnmpVersion, community string) error {
+	addr, err := ParseTarget(target)
+	if err != nil {
+		return err
+	}
+	g := &gosnmp.GoSNMP{
+		Target:    addr.Host,
+		Port:      addr.Port,
+		Transport: addr.Transport,
+		Version:   version,
+		Community: community,
+		Timeout:   gosnmp.Default.Timeout,
+	}
+	var usm *gosnmp.UsmSecurityParameters
+	if version == gosnmp.Version3 {
+		usm = &gosnmp.UsmSecurityParameters{UserName: probeUser}
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.MsgFlags = gosnmp.NoAuthNoPriv
+		g.SecurityParameters = usm
+	}
+	if err := g.Connect(); err != nil {
+		return err
+	}
+	defer g.Conn.Close()
+
+	_, err = g.Get([]string{probeOid})
+	if usm != nil && usm.AuthoritativeEngineID != "" {
+		return nil
+	}
+	return err
+}
+
+// ListCircuitsHandler - circuits of targets with recent timeouts
+func (b *CircuitBreaker) ListCircuitsHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, b.Statuses())
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEnvPrefix - prefix of environment variables overriding settings
+//
+// The variable of a setting is its flag name upper-cased with dashes
+// replaced, e.g. REST_SNMP_TRAP_LISTEN for -trap-listen.
+const configEnvPrefix = "REST_SNMP_"
+
+// ServerConfig - http listener settings
+type ServerConfig struct {
+	Listen       string
+	TLSCert      string
+	TLSKey       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// serverConfig - listener settings from flags, config file and environment
+var serverConfig ServerConfig
+
+// configEnvName - environment variable of flag name
+func configEnvName(name string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// configValue - flag value of a json config value
+//
+// Lists become comma separated values, as accepted by list flags.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// LoadConfig - apply config file and environment to flags of fs
+//
+// The config file is a json object keyed by flag name. Precedence is
+// command line flags, then environment variables, then the file, then
+// flag defaults. Unknown settings in the file are an error.
+func LoadConfig(fs *flag.FlagSet, path string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	settings := map[string]string{}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		values := map[string]interface{}{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("parsing %s: %v", path, err)
+		}
+		for name, v := range values {
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown setting %s", path, name)
+			}
+			s, err := configValue(v)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+			settings[name] = s
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(configEnvName(f.Name)); ok {
+			settings[f.Name] = v
+		}
+	})
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, settings[name]); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate - error describing the first invalid listener setting
+func (c ServerConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls-cert and tls-key must be set together")
+	}
+	for _, path := range []string{c.TLSCert, c.TLSKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return err
+		}
+	}
+	if c.ReadTimeout <= 0 || c.WriteTimeout <= 0 || c.IdleTimeout <= 0 {
+		return fmt.Errorf("http timeouts must be positive")
+	}
+	return nil
+}
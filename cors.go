@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMethods - methods of the API, the custom snmp verbs included
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	"WALK", "BULKWALK", "GETBULK", "SET",
+}
+
+// corsHeaders - request headers the API reads
+var corsHeaders = []string{
+	"Accept", "Authorization", "Content-Type", "Last-Event-ID", "X-API-Key", "X-User", RequestIDHeader,
+	"X-SNMP-COMM", "X-SNMP-Credential", "X-SNMP-Timeout", "X-SNMP-Retries", "X-SNMP-Max-Oids",
+	"X-SNMP-Port", "X-SNMP-Transport",
+}
+
+// corsExposedHeaders - response headers scripts may read
+var corsExposedHeaders = []string{
+	"Location", "Retry-After", "Deprecation", "Link", RequestIDHeader, "X-Cache",
+	"X-SNMP-Credential-Index", "X-SNMP-Credential-Source", "X-Walk-Next", "X-Walk-Truncated",
+}
+
+// CORS - cross origin access of browser clients
+//
+// Disabled without AllowedOrigins. An origin is allowed when listed,
+// when it matches a pattern such as https://*.example.com, or by "*".
+// Listed origins are echoed with Access-Control-Allow-Credentials;
+// "*" allows any origin without credentials.
+type CORS struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         time.Duration
+}
+
+// cors - cross origin settings of the gateway
+var cors = &CORS{AllowedMethods: corsMethods, AllowedHeaders: corsHeaders, MaxAge: 10 * time.Minute}
+
+// splitList - trimmed non-empty items of a comma separated flag
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Enabled - whether any origin is allowed
+func (c *CORS) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
+}
+
+// allowOrigin - Access-Control-Allow-Origin for origin, empty if not allowed
+func (c *CORS) allowOrigin(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range c.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			wildcard = true
+		case strings.EqualFold(allowed, origin):
+			return origin, true
+		case strings.Contains(allowed, "://*."):
+			i := strings.Index(allowed, "*")
+			if strings.HasPrefix(origin, allowed[:i]) && strings.HasSuffix(origin, allowed[i+1:]) &&
+				len(origin) > len(allowed)-1 {
+				return origin, true
+			}
+		}
+	}
+	if wildcard {
+		return "*", false
+	}
+	return "", false
+}
+
+// allowsMethod - whether method is among the allowed methods
+func (c *CORS) allowsMethod(method string) bool {
+	for _, m := range c.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware - answer preflights and add CORS headers to responses of allowed origins
+//
+// Used as negroni middleware so preflights of every route, the custom
+// snmp methods included, are answered before routing and authentication.
+// Preflights of origins or methods not allowed get 403.
+func (c *CORS) Middleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	origin := r.Header.Get("Origin")
+	if !c.Enabled() || origin == "" {
+		next(w, r)
+		return
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	allowed, credentials := c.allowOrigin(origin)
+	if allowed == "" {
+		if preflight {
+			stats.Inc("cors.rejected")
+			WriteError(w, http.StatusForbidden, "origin not allowed: "+origin)
+			return
+		}
+		next(w, r)
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		next(w, r)
+		return
+	}
+	if method := r.Header.Get("Access-Control-Request-Method"); !c.allowsMethod(method) {
+		stats.Inc("cors.rejected")
+		WriteError(w, http.StatusForbidden, "method not allowed: "+method)
+		return
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// CredentialFromStore - X-SNMP-Credential-Source of stored credentials
+const CredentialFromStore = "store"
+
+// errCredentialNotAllowed - stored credential does not cover the target
+var errCredentialNotAllowed = errors.New("credential not allowed for target")
+
+// V3Credential - SNMPv3 USM user
+//
+// auth_protocol is MD5 or SHA, priv_protocol DES or AES; privacy
+// requires authentication.
+type V3Credential struct {
+	Username       string `json:"username"`
+	AuthProtocol   string `json:"auth_protocol,omitempty"`
+	AuthPassphrase string `json:"auth_passphrase,omitempty"`
+	PrivProtocol   string `json:"priv_protocol,omitempty"`
+	PrivPassphrase string `json:"priv_passphrase,omitempty"`
+}
+
+// Credential - stored snmp secrets for matching targets
+//
+// Targets are host names, globs or CIDRs as in profiles. A credential
+// holds a community for v1/v2c, a v3 user, or both.
+type Credential struct {
+	Name      string        `json:"name"`
+	Targets   []string      `json:"targets"`
+	Community string        `json:"community,omitempty"`
+	V3        *V3Credential `json:"v3,omitempty"`
+}
+
+// redacted - copy of credential without secrets
+func (c *Credential) redacted() Credential {
+	r := *c
+	r.Community = ""
+	if c.V3 != nil {
+		v3 := *c.V3
+		v3.AuthPassphrase = ""
+		v3.PrivPassphrase = ""
+		r.V3 = &v3
+	}
+	return r
+}
+
+// Allows - whether credential may be used for target
+func (c *Credential) Allows(target string) bool {
+	for _, pattern := range c.Targets {
+		if MatchTarget(pattern, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Credential) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("targets missing")
+	}
+	if c.Community == "" && c.V3 == nil {
+		return fmt.Errorf("community or v3 required")
+	}
+	if v3 := c.V3; v3 != nil {
+		if v3.Username == "" {
+			return fmt.Errorf("v3 username missing")
+		}
+		if _, err := authProtocol(v3.AuthProtocol); err != nil {
+			return err
+		}
+		if _, err := privProtocol(v3.PrivProtocol); err != nil {
+			return err
+		}
+		if v3.AuthProtocol == "" && v3.PrivProtocol != "" {
+			return fmt.Errorf("v3 privacy requires authentication")
+		}
+		if (v3.AuthProtocol != "" && len(v3.AuthPassphrase) < 8) || (v3.PrivProtocol != "" && len(v3.PrivPassphrase) < 8) {
+			return fmt.Errorf("v3 passphrases must have at least 8 characters")
+		}
+	}
+	return nil
+}
+
+func authProtocol(name string) (gosnmp.SnmpV3AuthProtocol, error) {
+	switch name {
+	case "":
+		return gosnmp.NoAuth, nil
+	case "MD5":
+		return gosnmp.MD5, nil
+	case "SHA":
+		return gosnmp.SHA, nil
+	}
+	return 0, fmt.Errorf("auth_protocol must be MD5 or SHA")
+}
+
+func privProtocol(name string) (gosnmp.SnmpV3PrivProtocol, error) {
+	switch name {
+	case "":
+		return gosnmp.NoPriv, nil
+	case "DES":
+		return gosnmp.DES, nil
+	case "AES":
+		return gosnmp.AES, nil
+	}
+	return 0, fmt.Errorf("priv_protocol must be DES or AES")
+}
+
+// ApplyV3 - configure session g for the v3 user of credential
+func (c *Credential) ApplyV3(g *gosnmp.GoSNMP) error {
+	if c.V3 == nil {
+		return fmt.Errorf("credential %s has no v3 user", c.Name)
+	}
+	auth, _ := authProtocol(c.V3.AuthProtocol)
+	priv, _ := privProtocol(c.V3.PrivProtocol)
+
+	g.SecurityModel = gosnmp.UserSecurityModel
+	switch {
+	case priv != gosnmp.NoPriv:
+		g.MsgFlags = gosnmp.AuthPriv
+	case auth != gosnmp.NoAuth:
+		g.MsgFlags = gosnmp.AuthNoPriv
+	default:
+		g.MsgFlags = gosnmp.NoAuthNoPriv
+	}
+	g.SecurityParameters = &gosnmp.UsmSecurityParameters{
+		UserName:                 c.V3.Username,
+		AuthenticationProtocol:   auth,
+		AuthenticationPassphrase: c.V3.AuthPassphrase,
+		PrivacyProtocol:          priv,
+		PrivacyPassphrase:        c.V3.PrivPassphrase,
+	}
+	return nil
+}
+
+// CredentialStore - named credentials, optionally in an encrypted file
+type CredentialStore struct {
+	mu          sync.RWMutex
+	path        string
+	key         []byte
+	credentials map[string]*Credential
+}
+
+// encryptedCredentials - file content of an encrypted store
+type encryptedCredentials struct {
+	Encrypted []byte `json:"encrypted"`
+}
+
+// credentials - credential store loaded at startup
+var credentials = NewCredentialStore("", "")
+
+// NewCredentialStore - empty store persisted to path, encrypted if passphrase is set
+func NewCredentialStore(path string, passphrase string) *CredentialStore {
+	s := &CredentialStore{path: path, credentials: map[string]*Credential{}}
+	if passphrase != "" {
+		key := sha256.Sum256([]byte(passphrase))
+		s.key = key[:]
+	}
+	return s
+}
+
+// LoadCredentials - credential store from file, missing file is empty store
+func LoadCredentials(path string, passphrase string) (*CredentialStore, error) {
+	s := NewCredentialStore(path, passphrase)
+	list, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range list {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("%s: credential %s: %v", path, c.Name, err)
+		}
+		s.credentials[c.Name] = c
+	}
+	return s, nil
+}
+
+// read - credentials of the store file, none if it does not exist
+func (s *CredentialStore) read() ([]*Credential, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := encryptedCredentials{}
+	if json.Unmarshal(data, &encrypted) == nil && encrypted.Encrypted != nil {
+		if s.key == nil {
+			return nil, fmt.Errorf("%s is encrypted, credentials key required", s.path)
+		}
+		if data, err = s.decrypt(encrypted.Encrypted); err != nil {
+			return nil, fmt.Errorf("decrypting %s: %v", s.path, err)
+		}
+	}
+
+	var list []*Credential
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", s.path, err)
+	}
+	return list, nil
+}
+
+func (s *CredentialStore) encrypt(plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (s *CredentialStore) decrypt(sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// Persistent - whether the store is backed by a file
+func (s *CredentialStore) Persistent() bool {
+	return s.path != ""
+}
+
+// Check - error if the store file cannot be read back, e.g. after the
+// volume holding it went away
+func (s *CredentialStore) Check() error {
+	_, err := s.read()
+	return err
+}
+
+// save - persist credentials, caller holds the lock
+func (s *CredentialStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.key != nil {
+		sealed, err := s.encrypt(data)
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(encryptedCredentials{Encrypted: sealed}); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+func (s *CredentialStore) list() []*Credential {
+	list := make([]*Credential, 0, len(s.credentials))
+	for _, c := range s.credentials {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Lookup - credential by name, nil if unknown
+func (s *CredentialStore) Lookup(name string) *Credential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.credentials[name]
+}
+
+// ForTarget - credentials (by name) allowed for target
+func (s *CredentialStore) ForTarget(target string) []*Credential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matching []*Credential
+	for _, c := range s.list() {
+		if c.Allows(target) {
+			matching = append(matching, c)
+		}
+	}
+	return matching
+}
+
+// Reference - candidate for a request naming a stored credential
+//
+// v3 sessions are keyed by credential name, v1/v2c ones by community.
+func (s *CredentialStore) Reference(name string, target string, version gosnmp.SnmpVersion) (CandidateCommunity, error) {
+	c := s.Lookup(name)
+	if c == nil {
+		return CandidateCommunity{}, fmt.Errorf("unknown credential %s", name)
+	}
+	if !c.Allows(target) {
+		return CandidateCommunity{}, errCredentialNotAllowed
+	}
+	if version == gosnmp.Version3 {
+		if c.V3 == nil {
+			return CandidateCommunity{}, fmt.Errorf("credential %s has no v3 user", name)
+		}
+		return CandidateCommunity{c.Name, CredentialFromStore}, nil
+	}
+	if c.Community == "" {
+		return CandidateCommunity{}, fmt.Errorf("credential %s has no community", name)
+	}
+	return CandidateCommunity{c.Community, CredentialFromStore}, nil
+}
+
+// RequestCredential - stored credential named by X-SNMP-Credential or ?credential
+func RequestCredential(r *http.Request) string {
+	if name := r.URL.Query().Get("credential"); name != "" {
+		return name
+	}
+	return r.Header.Get("X-SNMP-Credential")
+}
+
+// ListCredentialsHandler - stored credentials without secrets
+func (s *CredentialStore) ListCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := []Credential{}
+	for _, c := range s.list() {
+		list = append(list, c.redacted())
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// GetCredentialHandler - single stored credential without secrets
+func (s *CredentialStore) GetCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	c := s.Lookup(mux.Vars(r)["name"])
+	if c == nil {
+		WriteError(w, http.StatusNotFound, "credential not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, c.redacted())
+}
+
+// PutCredentialHandler - create or replace stored credential
+func (s *CredentialStore) PutCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	c := &Credential{}
+	if err := json.NewDecoder(r.Body).Decode(c); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid credential json")
+		return
+	}
+	c.Name = mux.Vars(r)["name"]
+	if err := c.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.credentials[c.Name] = c
+	if err := s.save(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, c.redacted())
+}
+
+// DeleteCredentialHandler - remove stored credential
+func (s *CredentialStore) DeleteCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.credentials[name]; !ok {
+		WriteError(w, http.StatusNotFound, "credential not found")
+		return
+	}
+	delete(s.credentials, name)
+	if err := s.save(); err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
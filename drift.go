@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// DesiredValue - value an oid is expected to hold
+//
+// MIB names are resolved to numeric oids when the policy is registered,
+// reports and remediation refer to the resolved oid.
+type DesiredValue struct {
+	Oid   string      `json:"oid"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// DriftPolicy - desired values declared for a group of targets
+type DriftPolicy struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Version   string         `json:"snmp_version"`
+	Community string         `json:"community,omitempty"`
+	Targets   []string       `json:"targets"`
+	Values    []DesiredValue `json:"values"`
+}
+
+// DriftEntry - oid whose actual value differs from the desired one
+type DriftEntry struct {
+	Oid     string      `json:"oid"`
+	Desired interface{} `json:"desired"`
+	Actual  interface{} `json:"actual"`
+}
+
+// DriftReport - outcome of comparing one target against a policy
+type DriftReport struct {
+	PolicyID  string       `json:"policy_id"`
+	Target    string       `json:"target"`
+	CheckedAt time.Time    `json:"checked_at"`
+	InSync    bool         `json:"in_sync"`
+	Drift     []DriftEntry `json:"drift"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// DriftDetector - drift policies and latest reports
+type DriftDetector struct {
+	mu       sync.RWMutex
+	policies map[string]*DriftPolicy
+	reports  map[string]map[string]DriftReport
+}
+
+// NewDriftDetector - empty drift detector
+func NewDriftDetector() *DriftDetector {
+	return &DriftDetector{
+		policies: map[string]*DriftPolicy{},
+		reports:  map[string]map[string]DriftReport{},
+	}
+}
+
+// Run - periodically check every policy until stop is closed
+func (d *DriftDetector) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.CheckAll()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// CheckAll - check every policy against all its targets
+func (d *DriftDetector) CheckAll() {
+	d.mu.RLock()
+	policies := make([]*DriftPolicy, 0, len(d.policies))
+	for _, p := range d.policies {
+		policies = append(policies, p)
+	}
+	d.mu.RUnlock()
+
+	for _, p := range policies {
+		d.Check(p)
+	}
+}
+
+// Check - compare actual and desired values on all targets of a policy
+func (d *DriftDetector) Check(p *DriftPolicy) []DriftReport {
+	reports := make([]DriftReport, len(p.Targets))
+	for i, target := range p.Targets {
+		reports[i] = checkDrift(p, target)
+		if !reports[i].InSync {
+			logger.Warn("drift detected", Fields{"target": target, "policy": p.ID})
+		}
+	}
+
+	d.mu.Lock()
+	byTarget := map[string]DriftReport{}
+	for _, report := range reports {
+		byTarget[report.Target] = report
+	}
+	d.reports[p.ID] = byTarget
+	d.mu.Unlock()
+
+	return reports
+}
+
+func checkDrift(p *DriftPolicy, target string) DriftReport {
+	report := DriftReport{
+		PolicyID:  p.ID,
+		Target:    target,
+		CheckedAt: time.Now(),
+		Drift:     []DriftEntry{},
+	}
+
+	version, _ := ParseSnmpVersion(p.Version)
+	g, err := sessions.Get(target, version, p.Community)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer sessions.Put(g)
+
+	oids := make([]string, len(p.Values))
+	for i, v := range p.Values {
+		oids[i] = v.Oid
+	}
+	result, err := NegotiatedGet(g, oids)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if err := NewPacketError("Get", result); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	actual := SanitizeResultVariables(&result.Variables)
+	for i, v := range p.Values {
+		desired, _ := ToSnmpPDU(v.Oid, v.Type, v.Value)
+		if i >= len(actual) || !PDUValueEqual(actual[i], desired) {
+			entry := DriftEntry{Oid: v.Oid, Desired: v.Value}
+			if i < len(actual) {
+				entry.Actual = actual[i].Value
+			}
+			report.Drift = append(report.Drift, entry)
+		}
+	}
+	report.InSync = len(report.Drift) == 0
+	return report
+}
+
+// Remediate - SET desired values on drifted targets of a policy
+//
+// Each session is bound to r so that journal and audit records carry the
+// identity of the requester.
+func (d *DriftDetector) Remediate(r *http.Request, p *DriftPolicy, targets []string) map[string]string {
+	d.mu.RLock()
+	reports := d.reports[p.ID]
+	d.mu.RUnlock()
+
+	if len(targets) == 0 {
+		for target, report := range reports {
+			if !report.InSync && report.Error == "" {
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	version, _ := ParseSnmpVersion(p.Version)
+	outcome := map[string]string{}
+	for _, target := range targets {
+		report, ok := reports[target]
+		if !ok {
+			outcome[target] = "not checked"
+			continue
+		}
+		if len(report.Drift) == 0 {
+			outcome[target] = "in sync"
+			continue
+		}
+
+		drifted := map[string]bool{}
+		for _, entry := range report.Drift {
+			drifted[entry.Oid] = true
+		}
+		var pdus []gosnmp.SnmpPDU
+		for _, v := range p.Values {
+			if drifted[v.Oid] {
+				pdu, _ := ToSnmpPDU(v.Oid, v.Type, v.Value)
+				pdus = append(pdus, pdu)
+			}
+		}
+
+		g, err := sessions.Get(target, version, p.Community)
+		if err != nil {
+			outcome[target] = err.Error()
+			continue
+		}
+		release := BindSession(g, r)
+		result, err := JournaledSet(g, "remediate", pdus)
+		release()
+		sessions.Put(g)
+		if err == nil {
+			if perr := NewPacketError("Set", result); perr != nil {
+				err = perr
+			}
+		}
+		if err != nil {
+			outcome[target] = err.Error()
+			continue
+		}
+		outcome[target] = "remediated"
+	}
+
+	d.Check(p)
+	return outcome
+}
+
+func (d *DriftDetector) policy(id string) (*DriftPolicy, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	p, ok := d.policies[id]
+	return p, ok
+}
+
+// redacted - copy of policy safe to return to clients
+func (p *DriftPolicy) redacted() DriftPolicy {
+	c := *p
+	c.Community = ""
+	return c
+}
+
+// ListPoliciesHandler - list drift policies
+func (d *DriftDetector) ListPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	d.mu.RLock()
+	policies := make([]DriftPolicy, 0, len(d.policies))
+	for _, p := range d.policies {
+		policies = append(policies, p.redacted())
+	}
+	d.mu.RUnlock()
+
+	WriteJSON(w, http.StatusOK, policies)
+}
+
+// CreatePolicyHandler - declare desired values for targets
+func (d *DriftDetector) CreatePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	p := &DriftPolicy{}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid policy json")
+		return
+	}
+	if _, err := ParseSnmpVersion(p.Version); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if p.Community == "" || len(p.Targets) == 0 || len(p.Values) == 0 {
+		WriteError(w, http.StatusBadRequest, "community, targets and values are required")
+		return
+	}
+	if target, ok := AuthorizeTargets(r, p.Targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	for i, v := range p.Values {
+		oid, err := ResolveOid(v.Oid)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := ToSnmpPDU(oid, v.Type, v.Value); err != nil {
+			WriteError(w, http.StatusBadRequest, v.Oid+": "+err.Error())
+			return
+		}
+		p.Values[i].Oid = oid
+	}
+
+	p.ID = NewID()
+	d.mu.Lock()
+	d.policies[p.ID] = p
+	d.mu.Unlock()
+
+	WriteJSON(w, http.StatusCreated, p.redacted())
+}
+
+// GetPolicyHandler - single drift policy
+func (d *DriftDetector) GetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := d.policy(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "policy not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, p.redacted())
+}
+
+// DeletePolicyHandler - remove drift policy and its reports
+func (d *DriftDetector) DeletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	d.mu.Lock()
+	_, ok := d.policies[id]
+	delete(d.policies, id)
+	delete(d.reports, id)
+	d.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "policy not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportHandler - latest drift report, ?refresh=true checks immediately
+func (d *DriftDetector) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := d.policy(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "policy not found")
+		return
+	}
+
+	if r.URL.Query().Get("refresh") == "true" {
+		WriteJSON(w, http.StatusOK, d.Check(p))
+		return
+	}
+
+	d.mu.RLock()
+	reports := make([]DriftReport, 0, len(d.reports[p.ID]))
+	for _, report := range d.reports[p.ID] {
+		reports = append(reports, report)
+	}
+	d.mu.RUnlock()
+
+	WriteJSON(w, http.StatusOK, reports)
+}
+
+// RemediateHandler - push desired values to drifted targets
+//
+// Without targets every target of the policy must be allowed for the
+// principal, not only those drifted.
+func (d *DriftDetector) RemediateHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := d.policy(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "policy not found")
+		return
+	}
+
+	request := struct {
+		Targets []string `json:"targets"`
+	}{}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid remediation json")
+			return
+		}
+	}
+	targets := request.Targets
+	if len(targets) == 0 {
+		targets = p.Targets
+	}
+	if target, ok := AuthorizeTargets(r, targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, d.Remediate(r, p, request.Targets))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// DryRunKey - key defining dry run context key
+type DryRunKey string
+
+// DryRunKeyName - keyname defined for context
+const DryRunKeyName DryRunKey = "DRYRUN"
+
+// DryRunWrite - SET a dry run would have sent
+//
+// Current holds the values read from the target in the same
+// [oid, type, value] form, with a null value where the oid has no
+// instance.
+type DryRunWrite struct {
+	Operation string          `json:"operation"`
+	Values    [][]interface{} `json:"values"`
+	Current   [][]interface{} `json:"current"`
+}
+
+// DryRun - writes recorded in place of being sent
+type DryRun struct {
+	DryRun bool          `json:"dry_run"`
+	Target string        `json:"target"`
+	Writes []DryRunWrite `json:"writes"`
+
+	mu sync.Mutex
+}
+
+// IsDryRun - request asks for ?dry_run=true
+func IsDryRun(r *http.Request) bool {
+	return r.Context().Value(DryRunKeyName) != nil
+}
+
+// dryRunOf - dry run of the request session g serves, nil if none
+func dryRunOf(g *gosnmp.GoSNMP) *DryRun {
+	if r := SessionRequest(g); r != nil {
+		d, _ := r.Context().Value(DryRunKeyName).(*DryRun)
+		return d
+	}
+	return nil
+}
+
+// Set - record pdus with the current values of their oids
+//
+// The GET confirms the target is reachable; its errors are returned as
+// those of the SET would be. The SET is reported as successful.
+func (d *DryRun) Set(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	values, err := CurrentValues(g, pdus)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.Target = SessionTarget(g)
+	d.Writes = append(d.Writes, DryRunWrite{Operation: operation, Values: PDUsToValues(pdus), Current: values})
+	d.mu.Unlock()
+	stats.Inc("snmp.set.dry_run")
+	return &gosnmp.SnmpPacket{Version: g.Version, Error: gosnmp.NoError, Variables: dryRunEcho(pdus)}, nil
+}
+
+// dryRunEcho - pdus as an agent would echo them, octet strings as bytes
+func dryRunEcho(pdus []gosnmp.SnmpPDU) []gosnmp.SnmpPDU {
+	echo := make([]gosnmp.SnmpPDU, len(pdus))
+	copy(echo, pdus)
+	for i := range echo {
+		if s, ok := echo[i].Value.(string); ok && echo[i].Type == gosnmp.OctetString {
+			echo[i].Value = []byte(s)
+		}
+	}
+	return echo
+}
+
+// DryRunMiddleware - serve writes with ?dry_run=true without sending SETs
+//
+// The request is validated and handled as usual, but every SET is
+// replaced by a GET of its oids. The response lists the SETs that would
+// have been sent; if none was reached, e.g. because the request is
+// invalid, the response of the handler is returned unchanged. Dry runs
+// bypass approval and scheduling.
+func DryRunMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readMethods[r.Method] || r.URL.Query().Get("dry_run") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := mux.Vars(r)["target"]; !ok {
+			WriteError(w, http.StatusBadRequest, "dry_run is only supported on writes to a single target")
+			return
+		}
+
+		d := &DryRun{DryRun: true}
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), DryRunKeyName, d)))
+
+		if len(d.Writes) == 0 {
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		}
+		WriteJSON(w, http.StatusOK, d)
+	})
+}
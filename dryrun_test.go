@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestDryRunStringSet - dry run of an OctetString SET renders the echoed value
+func TestDryRunStringSet(t *testing.T) {
+	pdus, err := parseSnmprec(strings.NewReader("1.3.6.1.2.1.1.4.0|4|noc\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSimulator(pdus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := s.Start("127.0.0.1:0", stop); err != nil {
+		t.Fatal(err)
+	}
+	simulator = s
+	defer func() { simulator = nil }()
+
+	r := mux.NewRouter()
+	snmprouter := r.PathPrefix("/api/v1/snmp/{snmp_version}/{target}").Subrouter()
+	snmprouter.Handle("/{base_oid}", AddSnmpContext(SetHandler)).Methods(http.MethodPut)
+	r.Use(DryRunMiddleware)
+
+	body := `{"values":[["4.0","s","ops"]]}`
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/snmp/v2c/"+SimulatorTarget+"/.1.3.6.1.2.1.1?dry_run=true", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("response %s: %v", rec.Body.String(), err)
+	}
+	if !strings.Contains(rec.Body.String(), `"ops"`) || !strings.Contains(rec.Body.String(), `"noc"`) {
+		t.Errorf("dry run should list the new and the current value: %s", rec.Body.String())
+	}
+}
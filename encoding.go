@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Response encodings of result variables besides json
+const (
+	EncodingJSON = "json"
+	EncodingCSV  = "csv"
+	EncodingXML  = "xml"
+	EncodingText = "text"
+)
+
+// encodingTypes - content type of each encoding
+var encodingTypes = map[string]string{
+	EncodingCSV:  "text/csv",
+	EncodingXML:  "application/xml",
+	EncodingText: "text/plain",
+}
+
+// acceptEncodings - encodings of the media types clients may accept
+var acceptEncodings = map[string]string{
+	"application/json": EncodingJSON,
+	"text/csv":         EncodingCSV,
+	"application/xml":  EncodingXML,
+	"text/xml":         EncodingXML,
+	"text/plain":       EncodingText,
+}
+
+// ResponseEncoding - encoding of result variables asked for by ?format= or Accept
+//
+// ?format=csv|xml|text wins over the Accept header, whose first known
+// media type is used otherwise; json is the default.
+func ResponseEncoding(r *http.Request) string {
+	if v := r.URL.Query().Get("format"); encodingTypes[v] != "" {
+		return v
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if encoding, ok := acceptEncodings[mediaType]; ok {
+			return encoding
+		}
+	}
+	return EncodingJSON
+}
+
+// xmlResult - result variables of the xml encoding
+type xmlResult struct {
+	XMLName  xml.Name     `xml:"result"`
+	Varbinds []xmlVarbind `xml:"varbind"`
+	Errors   []xmlError   `xml:"error"`
+}
+
+type xmlVarbind struct {
+	Oid     string `xml:"oid,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Symbol  string `xml:"symbol,attr,omitempty"`
+	Display string `xml:"display,attr,omitempty"`
+	Error   string `xml:"error,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type xmlError struct {
+	Oid   string `xml:"oid,attr"`
+	Error string `xml:",chardata"`
+}
+
+// varbindValue - rendered varbind value as text, empty for null
+func varbindValue(v Varbind) string {
+	if v.Value == nil {
+		return ""
+	}
+	return fmt.Sprint(v.Value)
+}
+
+// RenderEncoded - write pdus and per oid errors as csv, xml or text
+//
+// Values are rendered as in the structured json format, see
+// FormatOptions; text is in the style of snmpwalk output.
+func RenderEncoded(w http.ResponseWriter, r *http.Request, status int, encoding string, pdus []gosnmp.SnmpPDU, errs []VarbindError) {
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	o.Output = FormatStructured
+
+	var lines []string
+	if encoding == EncodingText {
+		// before Varbinds sanitizes octet strings
+		lines = make([]string, len(pdus))
+		for i, pdu := range pdus {
+			lines[i] = netSnmpLine(o, pdu)
+		}
+	}
+	varbinds := o.Varbinds(pdus)
+
+	w.Header().Set("Content-Type", encodingTypes[encoding]+"; charset=utf-8")
+	w.WriteHeader(status)
+	switch encoding {
+	case EncodingCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"oid", "type", "value"})
+		for _, v := range varbinds {
+			cw.Write([]string{v.Oid, v.Type, varbindValue(v)})
+		}
+		for _, e := range errs {
+			cw.Write([]string{e.Oid, "error", e.Error})
+		}
+		cw.Flush()
+		err = cw.Error()
+	case EncodingXML:
+		result := xmlResult{Varbinds: make([]xmlVarbind, len(varbinds))}
+		for i, v := range varbinds {
+			result.Varbinds[i] = xmlVarbind{Oid: v.Oid, Type: v.Type, Symbol: v.Symbol, Display: v.Display, Error: v.Error, Value: varbindValue(v)}
+		}
+		for _, e := range errs {
+			result.Errors = append(result.Errors, xmlError{Oid: e.Oid, Error: e.Error})
+		}
+		if _, err = fmt.Fprint(w, xml.Header); err == nil {
+			encoder := xml.NewEncoder(w)
+			encoder.Indent("", "  ")
+			err = encoder.Encode(result)
+		}
+	case EncodingText:
+		for _, e := range errs {
+			lines = append(lines, fmt.Sprintf("%s = ERROR: %s", e.Oid, e.Error))
+		}
+		if len(lines) > 0 {
+			_, err = fmt.Fprintln(w, strings.Join(lines, "\n"))
+		}
+	}
+	if err != nil {
+		RequestLogger(r).Error("encoding response", Fields{"encoding": encoding, "err": err})
+	}
+}
+
+// netSnmpLine - pdu as a line of snmpwalk output
+func netSnmpLine(o FormatOptions, pdu gosnmp.SnmpPDU) string {
+	name := pdu.Name
+	var object *MibObject
+	if o.Mib {
+		if symbol, translated := TranslateOid(pdu.Name); symbol != "" {
+			name, object = symbol, translated
+		}
+	}
+	return name + " = " + netSnmpValue(pdu, object)
+}
+
+// netSnmpValue - pdu value as printed by the net-snmp tools
+func netSnmpValue(pdu gosnmp.SnmpPDU, object *MibObject) string {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		raw := []byte(octetString(pdu.Value))
+		if printable(raw) {
+			return fmt.Sprintf("STRING: %q", raw)
+		}
+		return "Hex-STRING: " + strings.ToUpper(strings.Replace(colonHex(raw), ":", " ", -1))
+	case gosnmp.Integer:
+		if label := object.EnumLabel(pdu.Value); label != "" {
+			return "INTEGER: " + label
+		}
+		return fmt.Sprintf("INTEGER: %v", pdu.Value)
+	case gosnmp.ObjectIdentifier:
+		return fmt.Sprintf("OID: %v", pdu.Value)
+	case gosnmp.IPAddress:
+		return fmt.Sprintf("IpAddress: %v", pdu.Value)
+	case gosnmp.Counter32:
+		return "Counter32: " + gosnmp.ToBigInt(pdu.Value).String()
+	case gosnmp.Counter64:
+		return "Counter64: " + gosnmp.ToBigInt(pdu.Value).String()
+	case gosnmp.Gauge32:
+		return "Gauge32: " + gosnmp.ToBigInt(pdu.Value).String()
+	case gosnmp.Uinteger32:
+		return "UInteger32: " + gosnmp.ToBigInt(pdu.Value).String()
+	case gosnmp.TimeTicks:
+		return "Timeticks: " + netSnmpTicks(gosnmp.ToBigInt(pdu.Value).Uint64())
+	case gosnmp.Opaque, gosnmp.BitString, gosnmp.NsapAddress:
+		if raw, ok := pdu.Value.([]byte); ok {
+			return pdu.Type.String() + ": " + strings.ToUpper(strings.Replace(colonHex(raw), ":", " ", -1))
+		}
+	case gosnmp.Null:
+		return "NULL"
+	case gosnmp.NoSuchObject:
+		return "No Such Object available on this agent at this OID"
+	case gosnmp.NoSuchInstance:
+		return "No Such Instance currently exists at this OID"
+	case gosnmp.EndOfMibView:
+		return "No more variables left in this MIB View (It is past the end of the MIB tree)"
+	}
+	return fmt.Sprintf("%s: %v", pdu.Type, pdu.Value)
+}
+
+// netSnmpTicks - timeticks as "(ticks) 1 day, 2:03:04.05"
+func netSnmpTicks(ticks uint64) string {
+	days := ticks / 8640000
+	rest := ticks % 8640000
+	clock := fmt.Sprintf("%d:%02d:%02d.%02d", rest/360000, rest/6000%60, rest/100%60, rest%100)
+	switch days {
+	case 0:
+		return fmt.Sprintf("(%d) %s", ticks, clock)
+	case 1:
+		return fmt.Sprintf("(%d) 1 day, %s", ticks, clock)
+	}
+	return fmt.Sprintf("(%d) %d days, %s", ticks, days, clock)
+}
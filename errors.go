@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+)
+
+// APIError - body of every error response
+//
+// snmp_error and error_index are set when the agent answered with an
+// error status.
+type APIError struct {
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	SnmpError  string `json:"snmp_error,omitempty"`
+	ErrorIndex int    `json:"error_index,omitempty"`
+}
+
+// ErrorResponse - error envelope, {"error": {...}}
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// PacketError - error status of an agent response
+type PacketError struct {
+	Operation string
+	Status    gosnmp.SNMPError
+	Index     uint8
+}
+
+// NewPacketError - PacketError of result, nil if the agent reported none
+func NewPacketError(operation string, result *gosnmp.SnmpPacket) *PacketError {
+	if result == nil || (result.Error == gosnmp.NoError && result.ErrorIndex == 0) {
+		return nil
+	}
+	return &PacketError{Operation: operation, Status: result.Error, Index: result.ErrorIndex}
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("%s error: %v, Index: %v", e.Operation, e.Status, e.Index)
+}
+
+// packetErrorStatus - http status of an agent error status
+func packetErrorStatus(status gosnmp.SNMPError) int {
+	switch status {
+	case gosnmp.NoSuchName:
+		return http.StatusNotFound
+	case gosnmp.ReadOnly, gosnmp.NoAccess, gosnmp.NotWritable, gosnmp.NoCreation, gosnmp.AuthorizationError:
+		return http.StatusForbidden
+	case gosnmp.BadValue, gosnmp.WrongType, gosnmp.WrongLength, gosnmp.WrongEncoding, gosnmp.WrongValue,
+		gosnmp.InconsistentValue, gosnmp.InconsistentName:
+		return http.StatusBadRequest
+	case gosnmp.TooBig:
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadGateway
+}
+
+// isTimeout - whether err is an snmp request that got no response
+func isTimeout(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "timeout")
+}
+
+// WriteAPIError - write error envelope
+func WriteAPIError(w http.ResponseWriter, e APIError) {
+	WriteJSON(w, e.Code, ErrorResponse{Error: e})
+}
+
+// WriteSnmpError - write error of an snmp operation
+//
+// Agent error statuses map to the closest http status, writes denied by
+// the write policy to 403, rate limited operations to 429 with
+// Retry-After, timeouts to 504 and other failures to 500.
+func WriteSnmpError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*PacketError); ok {
+		WriteAPIError(w, APIError{
+			Code:       packetErrorStatus(e.Status),
+			Message:    e.Error(),
+			SnmpError:  e.Status.String(),
+			ErrorIndex: int(e.Index),
+		})
+		return
+	}
+	if e, ok := err.(*PolicyError); ok {
+		WriteError(w, http.StatusForbidden, e.Error())
+		return
+	}
+	if e, ok := err.(*RateLimitError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+		WriteError(w, http.StatusTooManyRequests, e.Error())
+		return
+	}
+	if e, ok := err.(*CircuitOpenError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+		WriteError(w, http.StatusServiceUnavailable, e.Error())
+		return
+	}
+	status := http.StatusInternalServerError
+	if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+	ResponseLogger(w).Error("snmp request failed", Fields{"err": err})
+	WriteError(w, status, err.Error())
+}
+
+// WriteNoSuchError - 404 for a varbind without instance or object
+func WriteNoSuchError(w http.ResponseWriter, pdu gosnmp.SnmpPDU) {
+	WriteAPIError(w, APIError{
+		Code:      http.StatusNotFound,
+		Message:   fmt.Sprintf("%s: %v", pdu.Name, pdu.Type),
+		SnmpError: pdu.Type.String(),
+	})
+}
+
+// varbindExceptions - error of varbinds answered with an exception instead of a value
+var varbindExceptions = map[gosnmp.Asn1BER]string{
+	gosnmp.NoSuchObject:   "noSuchObject",
+	gosnmp.NoSuchInstance: "noSuchInstance",
+	gosnmp.EndOfMibView:   "endOfMibView",
+}
+
+// missingVarbinds - errors of the varbinds answered with an exception
+func missingVarbinds(pdus []gosnmp.SnmpPDU) []VarbindError {
+	var errs []VarbindError
+	for _, pdu := range pdus {
+		if exception, ok := varbindExceptions[pdu.Type]; ok {
+			errs = append(errs, VarbindError{Oid: pdu.Name, Error: exception})
+		}
+	}
+	return errs
+}
+
+// WriteStrictError - error of a ?strict=true GET, for the first oid read without value
+//
+// 404 if the oid is missing on the agent, 502 if reading it failed.
+func WriteStrictError(w http.ResponseWriter, errs []VarbindError) {
+	e := APIError{
+		Code:    http.StatusBadGateway,
+		Message: fmt.Sprintf("%s: %s, %d of the requested oids without value", errs[0].Oid, errs[0].Error, len(errs)),
+	}
+	for _, exception := range varbindExceptions {
+		if errs[0].Error == exception {
+			e.Code, e.SnmpError = http.StatusNotFound, exception
+		}
+	}
+	if errs[0].Error == gosnmp.NoSuchName.String() {
+		e.Code, e.SnmpError = http.StatusNotFound, errs[0].Error
+	}
+	WriteAPIError(w, e)
+}
+
+// allMissing - first varbind if every varbind lacks an instance or object
+func allMissing(pdus []gosnmp.SnmpPDU) (gosnmp.SnmpPDU, bool) {
+	if len(pdus) == 0 {
+		return gosnmp.SnmpPDU{}, false
+	}
+	for _, pdu := range pdus {
+		if pdu.Type != gosnmp.NoSuchInstance && pdu.Type != gosnmp.NoSuchObject {
+			return gosnmp.SnmpPDU{}, false
+		}
+	}
+	return pdus[0], true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event channels clients can subscribe to
+const (
+	ChannelTraps  = "traps"
+	ChannelPolls  = "polls"
+	ChannelAlerts = "alerts"
+)
+
+var eventChannels = map[string]bool{ChannelTraps: true, ChannelPolls: true, ChannelAlerts: true}
+
+// eventKeepAlive - interval of comments keeping idle streams open
+const eventKeepAlive = 15 * time.Second
+
+// Event - something that happened on a channel
+type Event struct {
+	Seq     uint64      `json:"seq"`
+	Channel string      `json:"channel"`
+	Time    time.Time   `json:"time"`
+	Target  string      `json:"target,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// PollEvent - sample taken by a scheduled poll
+type PollEvent struct {
+	Poll   string     `json:"poll"`
+	Name   string     `json:"name,omitempty"`
+	Sample PollSample `json:"sample"`
+}
+
+// eventSubscriber - stream receiving events of its channels
+type eventSubscriber struct {
+	channels map[string]bool
+	events   chan Event
+}
+
+// EventHub - fan out of events to streaming clients
+//
+// The last Buffer events are kept so that reconnecting clients receive
+// what they missed. Subscribers that do not keep up lose events rather
+// than slowing down publishers.
+type EventHub struct {
+	Buffer      int
+	MaxDuration time.Duration
+
+	mu          sync.Mutex
+	seq         uint64
+	recent      []Event
+	subscribers map[*eventSubscriber]bool
+}
+
+// events - events streamed by /api/v1/stream
+var events = NewEventHub(1000)
+
+// NewEventHub - hub keeping the last buffer events
+func NewEventHub(buffer int) *EventHub {
+	return &EventHub{Buffer: buffer, subscribers: map[*eventSubscriber]bool{}}
+}
+
+// Publish - send data to the subscribers of channel
+func (h *EventHub) Publish(channel string, target string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event := Event{Seq: h.seq, Channel: channel, Time: time.Now(), Target: target, Data: data}
+	h.recent = append(h.recent, event)
+	if len(h.recent) > h.Buffer {
+		h.recent = h.recent[len(h.recent)-h.Buffer:]
+	}
+	stats.Inc("events.published")
+	for s := range h.subscribers {
+		if !s.channels[channel] {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			stats.Inc("events.dropped")
+		}
+	}
+}
+
+// subscribe - new subscriber, with the buffered events after seq
+func (h *EventHub) subscribe(channels map[string]bool, after uint64) (*eventSubscriber, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &eventSubscriber{channels: channels, events: make(chan Event, 64)}
+	h.subscribers[s] = true
+	var missed []Event
+	for _, event := range h.recent {
+		if event.Seq > after && channels[event.Channel] {
+			missed = append(missed, event)
+		}
+	}
+	return s, missed
+}
+
+func (h *EventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+}
+
+// StreamHandler - server-sent events of the channels in ?channels=
+//
+// Each event is sent with its seq as id and its channel as event name.
+// Streams end before the server write timeout; clients reconnect with
+// Last-Event-ID, as EventSource does, and receive the buffered events
+// they missed. Events of targets the principal may not reach are
+// skipped, ?target= narrows them to a target pattern.
+func (h *EventHub) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	channels := map[string]bool{}
+	for _, name := range strings.Split(r.URL.Query().Get("channels"), ",") {
+		if name == "" {
+			continue
+		}
+		if !eventChannels[name] {
+			WriteError(w, http.StatusBadRequest, "unknown channel "+name)
+			return
+		}
+		channels[name] = true
+	}
+	if len(channels) == 0 {
+		WriteError(w, http.StatusBadRequest, "channels missing")
+		return
+	}
+	// New clients start with the next event
+	after := ^uint64(0)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		var err error
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	principal := RequestPrincipal(r)
+	pattern := r.URL.Query().Get("target")
+	visible := func(event Event) bool {
+		if event.Target == "" {
+			return true
+		}
+		if principal != nil && !principal.AllowsTarget(event.Target) {
+			return false
+		}
+		return pattern == "" || MatchTarget(pattern, event.Target)
+	}
+
+	s, missed := h.subscribe(channels, after)
+	defer h.unsubscribe(s)
+	stats.Add("events.streams", 1)
+	defer stats.Add("events.streams", -1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 1000\n\n")
+	write := func(event Event) error {
+		if !visible(event) {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Channel, data)
+		return err
+	}
+	for _, event := range missed {
+		if err := write(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var deadline <-chan time.Time
+	if h.MaxDuration > 0 {
+		timer := time.NewTimer(h.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-s.events:
+			if err := write(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-deadline:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
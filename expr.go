@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprNode - compiled expression evaluated against the variables of a varbind
+type exprNode func(vars map[string]interface{}) (interface{}, error)
+
+// exprFuncs - functions callable from transform expressions
+var exprFuncs = map[string]func(args []interface{}) (interface{}, error){
+	"round": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("round takes a number and optional digits")
+		}
+		x, err := exprNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		digits := 0.0
+		if len(args) == 2 {
+			if digits, err = exprNumber(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		scale := math.Pow(10, digits)
+		return math.Round(x*scale) / scale, nil
+	},
+	"floor": exprMath(math.Floor),
+	"ceil":  exprMath(math.Ceil),
+	"abs":   exprMath(math.Abs),
+	"number": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("number takes one argument")
+		}
+		if s, ok := args[0].(string); ok {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+		return exprNumber(args[0])
+	},
+	"string": exprString(func(s string) string { return s }),
+	"lower":  exprString(strings.ToLower),
+	"upper":  exprString(strings.ToUpper),
+	"trim":   exprString(strings.TrimSpace),
+	"len": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len takes one argument")
+		}
+		return float64(len(exprText(args[0]))), nil
+	},
+	"contains": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("contains takes two arguments")
+		}
+		return strings.Contains(exprText(args[0]), exprText(args[1])), nil
+	},
+	"replace": func(args []interface{}) (interface{}, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("replace takes three arguments")
+		}
+		return strings.Replace(exprText(args[0]), exprText(args[1]), exprText(args[2]), -1), nil
+	},
+	"match": func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("match takes a string and a regular expression")
+		}
+		re, err := regexp.Compile(exprText(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		m := re.FindStringSubmatch(exprText(args[0]))
+		switch {
+		case m == nil:
+			return nil, nil
+		case len(m) > 1:
+			return m[1], nil
+		}
+		return m[0], nil
+	},
+}
+
+// exprMath - function of one number
+func exprMath(f func(float64) float64) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected one argument")
+		}
+		x, err := exprNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(x), nil
+	}
+}
+
+// exprString - function of one string
+func exprString(f func(string) string) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("expected one argument")
+		}
+		return f(exprText(args[0])), nil
+	}
+}
+
+// exprNumber - v as float64, error for values that are not numbers
+func exprNumber(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// exprText - v as string, numbers without trailing zeros
+func exprText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// exprTruth - v as condition, error for values that are not booleans
+func exprTruth(v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not a boolean", v)
+	}
+	return b, nil
+}
+
+// exprCompare - -1, 0 or 1 comparing numbers numerically and anything else as text
+func exprCompare(a interface{}, b interface{}) int {
+	x, errA := exprNumber(a)
+	y, errB := exprNumber(b)
+	if errA != nil || errB != nil {
+		return strings.Compare(exprText(a), exprText(b))
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// exprParser - recursive descent parser of transform expressions
+//
+// The grammar is a small subset of CEL: literals (numbers, quoted
+// strings, true, false, null), variables, calls of exprFuncs, unary ! and
+// -, * / %, + -, comparisons, && || and the conditional a ? b : c, from
+// the tightest binding to the loosest.
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+// CompileExpr - expression of a transform, see exprParser
+func CompileExpr(src string) (exprNode, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// exprOperators - operators of two characters
+var exprOperators = map[string]bool{"==": true, "!=": true, "<=": true, ">=": true, "&&": true, "||": true}
+
+// tokenizeExpr - numbers, quoted strings, identifiers and operators of src
+func tokenizeExpr(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && rune(src[j]) != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, src[i:j+1])
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				((src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		case i+1 < len(src) && exprOperators[src[i:i+2]]:
+			tokens = append(tokens, src[i:i+2])
+			i += 2
+		case strings.ContainsRune("+-*/%()!<>?:,", c):
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// exprUnescape - content of a quoted string with \n, \t, \\ and escaped quotes resolved
+//
+// Other escapes are kept, so regular expressions like \d need no doubling.
+func exprUnescape(quoted string) string {
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		c := quoted[i]
+		if c == '\\' && i+1 < len(quoted) {
+			switch quoted[i+1] {
+			case 'n':
+				c, i = '\n', i+1
+			case 't':
+				c, i = '\t', i+1
+			case '\\', '"', '\'':
+				c, i = quoted[i+1], i+1
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// peek - current token, empty at the end
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// expect - consume token, error if it is not next
+func (p *exprParser) expect(token string) error {
+	if p.peek() != token {
+		if p.peek() == "" {
+			return fmt.Errorf("expected %s at end", token)
+		}
+		return fmt.Errorf("expected %s, got %s", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) conditional() (exprNode, error) {
+	cond, err := p.or()
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.pos++
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return func(vars map[string]interface{}) (interface{}, error) {
+		v, err := cond(vars)
+		if err != nil {
+			return nil, err
+		}
+		b, err := exprTruth(v)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return then(vars)
+		}
+		return otherwise(vars)
+	}, nil
+}
+
+// logical - left associative && or ||, short-circuited
+func (p *exprParser) logical(op string, next func() (exprNode, error)) (exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == op {
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(vars map[string]interface{}) (interface{}, error) {
+			v, err := l(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := exprTruth(v)
+			if err != nil {
+				return nil, err
+			}
+			if b == (op == "||") {
+				return b, nil
+			}
+			if v, err = right(vars); err != nil {
+				return nil, err
+			}
+			return exprTruth(v)
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	return p.logical("||", p.and)
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	return p.logical("&&", p.comparison)
+}
+
+func (p *exprParser) comparison() (exprNode, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	var test func(c int) bool
+	switch op {
+	case "==":
+		test = func(c int) bool { return c == 0 }
+	case "!=":
+		test = func(c int) bool { return c != 0 }
+	case "<":
+		test = func(c int) bool { return c < 0 }
+	case "<=":
+		test = func(c int) bool { return c <= 0 }
+	case ">":
+		test = func(c int) bool { return c > 0 }
+	case ">=":
+		test = func(c int) bool { return c >= 0 }
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	return func(vars map[string]interface{}) (interface{}, error) {
+		a, err := left(vars)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(vars)
+		if err != nil {
+			return nil, err
+		}
+		if op == "==" || op == "!=" {
+			if a == nil || b == nil {
+				return (a == nil && b == nil) == (op == "=="), nil
+			}
+			if x, ok := a.(bool); ok {
+				y, ok := b.(bool)
+				return (ok && x == y) == (op == "=="), nil
+			}
+		}
+		return test(exprCompare(a, b)), nil
+	}, nil
+}
+
+// arithmetic - left associative binary operators of ops, + also joins strings
+func (p *exprParser) arithmetic(ops string, next func() (exprNode, error)) (exprNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for len(p.peek()) == 1 && strings.Contains(ops, p.peek()) {
+		op := p.peek()
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(vars map[string]interface{}) (interface{}, error) {
+			a, err := l(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := right(vars)
+			if err != nil {
+				return nil, err
+			}
+			_, textA := a.(string)
+			_, textB := b.(string)
+			if op == "+" && (textA || textB) {
+				return exprText(a) + exprText(b), nil
+			}
+			x, err := exprNumber(a)
+			if err != nil {
+				return nil, err
+			}
+			y, err := exprNumber(b)
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case "+":
+				return x + y, nil
+			case "-":
+				return x - y, nil
+			case "*":
+				return x * y, nil
+			}
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "%" {
+				return math.Mod(x, y), nil
+			}
+			return x / y, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) additive() (exprNode, error) {
+	return p.arithmetic("+-", p.multiplicative)
+}
+
+func (p *exprParser) multiplicative() (exprNode, error) {
+	return p.arithmetic("*/%", p.unary)
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			v, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := exprTruth(v)
+			return !b, err
+		}, nil
+	case "-":
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			v, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			x, err := exprNumber(v)
+			return -x, err
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	switch {
+	case token == "(":
+		node, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token[0] == '"' || token[0] == '\'':
+		s := exprUnescape(token[1 : len(token)-1])
+		return func(map[string]interface{}) (interface{}, error) { return s, nil }, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		x, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", token)
+		}
+		return func(map[string]interface{}) (interface{}, error) { return x, nil }, nil
+	case token == "true" || token == "false":
+		b := token == "true"
+		return func(map[string]interface{}) (interface{}, error) { return b, nil }, nil
+	case token == "null":
+		return func(map[string]interface{}) (interface{}, error) { return nil, nil }, nil
+	case !unicode.IsLetter(rune(token[0])) && token[0] != '_':
+		return nil, fmt.Errorf("unexpected %s", token)
+	case p.peek() != "(":
+		return func(vars map[string]interface{}) (interface{}, error) {
+			v, ok := vars[token]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %s", token)
+			}
+			return v, nil
+		}, nil
+	}
+
+	f, ok := exprFuncs[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", token)
+	}
+	p.pos++
+	var args []exprNode
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+	return func(vars map[string]interface{}) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(vars)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return f(values)
+	}, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/soniah/gosnmp"
+)
+
+// probeOid - sysObjectID.0, answered by every agent
+const probeOid = ".1.3.6.1.2.1.1.2.0"
+
+// Credential sources reported in X-SNMP-Credential-Source
+const (
+	CredentialFromRequest = "request"
+	CredentialFromProfile = "profile"
+)
+
+// CandidateCommunity - community to try and where it came from
+type CandidateCommunity struct {
+	Community string
+	Source    string
+}
+
+// CandidateCommunities - request communities followed by profile and stored ones
+//
+// v3 has no communities; its candidates are the names of the stored
+// credentials with a v3 user allowed for target.
+func CandidateCommunities(requested []string, target string, version gosnmp.SnmpVersion) []CandidateCommunity {
+	var candidates []CandidateCommunity
+	seen := map[string]bool{}
+	add := func(community string, source string) {
+		if community == "" || seen[community] {
+			return
+		}
+		seen[community] = true
+		candidates = append(candidates, CandidateCommunity{community, source})
+	}
+
+	if version == gosnmp.Version3 {
+		for _, c := range credentials.ForTarget(target) {
+			if c.V3 != nil {
+				add(c.Name, CredentialFromStore)
+			}
+		}
+		return candidates
+	}
+
+	for _, community := range requested {
+		add(community, CredentialFromRequest)
+	}
+	if p := profiles.ForTarget(target); p != nil {
+		for _, community := range p.Communities {
+			add(community, CredentialFromProfile)
+		}
+	}
+	for _, c := range credentials.ForTarget(target) {
+		add(c.Community, CredentialFromStore)
+	}
+	if simulator != nil && target == simulator.Address() {
+		add(simulator.WriteCommunity, CredentialFromSimulator)
+	}
+	return candidates
+}
+
+// BodyCandidates - candidates of a target named in a request body
+//
+// A credential names a stored credential used alone; a community replaces
+// the requested ones. Otherwise candidates are as for CandidateCommunities.
+func BodyCandidates(requested []string, community string, credential string, target string, version gosnmp.SnmpVersion) ([]CandidateCommunity, error) {
+	if credential != "" {
+		candidate, err := credentials.Reference(credential, target, version)
+		if err != nil {
+			return nil, err
+		}
+		return []CandidateCommunity{candidate}, nil
+	}
+	if community != "" {
+		requested = []string{community}
+	}
+	return CandidateCommunities(requested, target, version), nil
+}
+
+// ConnectWithFallback - session with the first community the agent accepts
+//
+// With a single candidate no probe is sent, preserving the cost of a
+// plain request. Otherwise each candidate is probed with one GET of
+// sysObjectID.0 without retries; the index of the working one is returned.
+func ConnectWithFallback(target string, version gosnmp.SnmpVersion, candidates []CandidateCommunity) (*gosnmp.GoSNMP, int, error) {
+	if len(candidates) == 0 {
+		return nil, -1, fmt.Errorf("SNMP Community undefined")
+	}
+	if len(candidates) == 1 {
+		g, err := sessions.Get(target, version, candidates[0].Community)
+		return g, 0, err
+	}
+
+	var lastErr error
+	for i, candidate := range candidates {
+		g, err := sessions.Get(target, version, candidate.Community)
+		if err != nil {
+			return nil, -1, err
+		}
+		stats.Inc("credentials.probes")
+		if _, lastErr = ProbeSession(g); lastErr == nil {
+			return g, i, nil
+		}
+		if refusedLocally(lastErr) {
+			sessions.Put(g)
+			return nil, -1, lastErr
+		}
+		stats.Inc("credentials.rejected")
+		sessions.Put(g)
+		logger.Warn("credential rejected", Fields{"target": target, "candidate": i, "err": lastErr})
+	}
+	return nil, -1, fmt.Errorf("no working credential for %s: %v", target, lastErr)
+}
+
+// ProbeSession - single GET of sysObjectID.0 without retries
+func ProbeSession(g *gosnmp.GoSNMP) (gosnmp.SnmpPDU, error) {
+	retries := g.Retries
+	g.Retries = 0
+	defer func() { g.Retries = retries }()
+
+	result, err := ObservedGet(g, []string{probeOid})
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+	if result.Error != gosnmp.NoError {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("%v", result.Error)
+	}
+	if len(result.Variables) == 0 {
+		return gosnmp.SnmpPDU{}, fmt.Errorf("empty response")
+	}
+	return result.Variables[0], nil
+}
+
+// PromoteCommunity - move working community to the front of target profile
+func (s *ProfileStore) PromoteCommunity(target string, community string) error {
+	p := s.ForTarget(target)
+	if p == nil {
+		return fmt.Errorf("no profile for %s", target)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	communities := []string{community}
+	for _, c := range p.Communities {
+		if c != community {
+			communities = append(communities, c)
+		}
+	}
+	// Profiles handed out by ForTarget are read without the lock, so
+	// replace rather than modify
+	promoted := *p
+	promoted.Communities = communities
+	s.profiles[p.Name] = &promoted
+	return s.save()
+}
+
+// credentialHeaders - response metadata identifying working credential
+func credentialHeaders(candidates []CandidateCommunity, index int) map[string]string {
+	return map[string]string{
+		"X-SNMP-Credential-Index":  strconv.Itoa(index),
+		"X-SNMP-Credential-Source": candidates[index].Source,
+	}
+}
+
+// refusedLocally - whether err refused the operation before it was sent,
+// by the rate limiter or an open circuit, so trying other credentials is pointless
+func refusedLocally(err error) bool {
+	switch err.(type) {
+	case *RateLimitError, *CircuitOpenError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Response formats
+const (
+	FormatStructured = "structured"
+	FormatLegacy     = "legacy"
+)
+
+// FormatOptions - per request rendering of result variables
+//
+// Query parameters:
+//
+//	format=structured|legacy      Varbind objects or gosnmp PDUs;
+//	       csv|xml|text            structured values in another encoding,
+//	                               see ResponseEncoding
+//	counters=number|string        Counter32/Counter64/Gauge32 rendering
+//	timeticks=raw|seconds|duration
+//	octet_format=auto|string|hex|base64
+//	                               OctetString rendering, also accepted as
+//	                               octets; auto formats MAC addresses,
+//	                               DateAndTime and InetAddress values, see
+//	                               autoOctets, and is text if printable and
+//	                               hex otherwise
+//	types=true|false               include Type of every varbind
+//	decode_index=true              decode table indexes, see DecodeIndexes
+//	mib=true                       add symbolic names and enum labels, see TranslateOid
+//	transform=name,...             transforms applied after the route
+//	                               defaults, see TransformStore.Pipeline
+type FormatOptions struct {
+	Output      string
+	Counters    string
+	TimeTicks   string
+	Octets      string
+	TypeInfo    bool
+	DecodeIndex bool
+	Mib         bool
+	Transforms  TransformPipeline
+
+	// custom is set when any option differs from the legacy output
+	custom bool
+}
+
+// FormattedPDU - varbind rendered according to FormatOptions
+type FormattedPDU struct {
+	Name    string                 `json:"Name"`
+	Type    *gosnmp.Asn1BER        `json:"Type,omitempty"`
+	Value   interface{}            `json:"Value"`
+	Table   string                 `json:"table,omitempty"`
+	Column  int                    `json:"column,omitempty"`
+	Index   map[string]interface{} `json:"index,omitempty"`
+	Symbol  string                 `json:"symbol,omitempty"`
+	Display string                 `json:"display,omitempty"`
+	Unit    string                 `json:"unit,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Varbind - result variable of the structured response format
+//
+// Value is rendered according to FormatOptions, raw holds the undecoded
+// wire value where it differs: hex for byte strings and ticks for
+// formatted TimeTicks. Unit is set by transforms, see Transform. Error
+// names the exception of varbinds without value: noSuchObject,
+// noSuchInstance or endOfMibView.
+type Varbind struct {
+	Oid     string                 `json:"oid"`
+	Type    string                 `json:"type,omitempty"`
+	Value   interface{}            `json:"value"`
+	Raw     interface{}            `json:"raw,omitempty"`
+	Table   string                 `json:"table,omitempty"`
+	Column  int                    `json:"column,omitempty"`
+	Index   map[string]interface{} `json:"index,omitempty"`
+	Symbol  string                 `json:"symbol,omitempty"`
+	Display string                 `json:"display,omitempty"`
+	Unit    string                 `json:"unit,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// ParseFormatOptions - format options from request query
+func ParseFormatOptions(r *http.Request) (FormatOptions, error) {
+	q := r.URL.Query()
+	o := FormatOptions{
+		Output:      FormatStructured,
+		Counters:    "number",
+		TimeTicks:   "raw",
+		Octets:      "auto",
+		TypeInfo:    true,
+		DecodeIndex: q.Get("decode_index") == "true",
+		Mib:         q.Get("mib") == "true",
+	}
+
+	if v := q.Get("format"); v != "" && encodingTypes[v] == "" {
+		if v != FormatStructured && v != FormatLegacy {
+			return o, fmt.Errorf("format must be structured, legacy, csv, xml or text")
+		}
+		o.Output = v
+	}
+	if o.Output == FormatLegacy {
+		o.Octets = "string"
+	}
+	if v := q.Get("counters"); v != "" {
+		if v != "number" && v != "string" {
+			return o, fmt.Errorf("counters must be number or string")
+		}
+		o.Counters = v
+	}
+	if v := q.Get("timeticks"); v != "" {
+		if v != "raw" && v != "seconds" && v != "duration" {
+			return o, fmt.Errorf("timeticks must be raw, seconds or duration")
+		}
+		o.TimeTicks = v
+	}
+	v := q.Get("octet_format")
+	if v == "" {
+		v = q.Get("octets")
+	}
+	if v != "" {
+		if v != "auto" && v != "string" && v != "hex" && v != "base64" {
+			return o, fmt.Errorf("octet_format must be auto, string, hex or base64")
+		}
+		o.Octets = v
+	}
+	if v := q.Get("types"); v != "" {
+		if v != "true" && v != "false" {
+			return o, fmt.Errorf("types must be true or false")
+		}
+		o.TypeInfo = v == "true"
+	}
+
+	pipeline, err := transforms.Pipeline(r)
+	if err != nil {
+		return o, err
+	}
+	o.Transforms = pipeline
+
+	o.custom = o.Counters != "number" || o.TimeTicks != "raw" ||
+		o.Octets != "string" || !o.TypeInfo || o.DecodeIndex || o.Mib || len(o.Transforms) > 0
+	return o, nil
+}
+
+// FormatValue - value of sanitized pdu rendered according to options and transforms
+func (o FormatOptions) FormatValue(pdu gosnmp.SnmpPDU) interface{} {
+	return o.Transforms.Apply(pdu, o.formatValue(pdu))
+}
+
+// formatValue - FormatValue before transforms
+func (o FormatOptions) formatValue(pdu gosnmp.SnmpPDU) interface{} {
+	switch pdu.Type {
+	case gosnmp.Counter32, gosnmp.Counter64, gosnmp.Gauge32:
+		if o.Counters == "string" {
+			return gosnmp.ToBigInt(pdu.Value).String()
+		}
+	case gosnmp.TimeTicks:
+		ticks := gosnmp.ToBigInt(pdu.Value).Int64()
+		switch o.TimeTicks {
+		case "seconds":
+			return float64(ticks) / 100
+		case "duration":
+			return (time.Duration(ticks) * 10 * time.Millisecond).String()
+		}
+	case gosnmp.OctetString:
+		raw := []byte(octetString(pdu.Value))
+		switch o.Octets {
+		case "auto":
+			return autoOctets(pdu.Name, raw)
+		case "hex":
+			return hex.EncodeToString(raw)
+		case "base64":
+			return base64.StdEncoding.EncodeToString(raw)
+		}
+	case gosnmp.Opaque, gosnmp.BitString, gosnmp.NsapAddress:
+		if raw, ok := pdu.Value.([]byte); ok && o.Output == FormatStructured {
+			return colonHex(raw)
+		}
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		if o.Output == FormatStructured {
+			return nil
+		}
+	}
+	return pdu.Value
+}
+
+// rawValue - undecoded value of pdu where it differs from the rendered one
+func (o FormatOptions) rawValue(pdu gosnmp.SnmpPDU) interface{} {
+	switch pdu.Type {
+	case gosnmp.OctetString, gosnmp.Opaque, gosnmp.BitString, gosnmp.NsapAddress:
+		if raw, ok := pdu.Value.([]byte); ok {
+			return hex.EncodeToString(raw)
+		}
+		if pdu.Type == gosnmp.OctetString {
+			return hex.EncodeToString([]byte(octetString(pdu.Value)))
+		}
+	case gosnmp.TimeTicks:
+		if o.TimeTicks != "raw" {
+			return gosnmp.ToBigInt(pdu.Value).Uint64()
+		}
+	}
+	return nil
+}
+
+// printable - whether octets are text worth showing as a string
+func printable(octets []byte) bool {
+	if !utf8.Valid(octets) {
+		return false
+	}
+	for _, r := range string(octets) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// colonHex - octets as colon separated hex pairs, e.g. 00:1a:2b
+func colonHex(octets []byte) string {
+	pairs := make([]string, len(octets))
+	for i, b := range octets {
+		pairs[i] = hex.EncodeToString([]byte{b})
+	}
+	return strings.Join(pairs, ":")
+}
+
+// Format - sanitized pdus rendered according to options
+func (o FormatOptions) Format(pdus []gosnmp.SnmpPDU) []FormattedPDU {
+	formatted := make([]FormattedPDU, len(pdus))
+	for i, pdu := range pdus {
+		formatted[i] = FormattedPDU{Name: pdu.Name, Value: o.FormatValue(pdu), Unit: o.Transforms.Unit(pdu), Error: varbindExceptions[pdu.Type]}
+		if o.TypeInfo {
+			t := pdu.Type
+			formatted[i].Type = &t
+		}
+		if o.Mib {
+			symbol, object := TranslateOid(pdu.Name)
+			formatted[i].Symbol = symbol
+			formatted[i].Display = object.EnumLabel(pdu.Value)
+		}
+	}
+
+	if o.DecodeIndex {
+		for i, indexed := range DecodeIndexes(pdus) {
+			formatted[i].Table = indexed.Table
+			formatted[i].Column = indexed.Column
+			formatted[i].Index = indexed.Index
+		}
+	}
+	return formatted
+}
+
+// Varbinds - pdus in the structured response format
+//
+// pdus must not be sanitized, so the raw value of octet strings is kept.
+func (o FormatOptions) Varbinds(pdus []gosnmp.SnmpPDU) []Varbind {
+	raw := make([]interface{}, len(pdus))
+	for i, pdu := range pdus {
+		raw[i] = o.rawValue(pdu)
+	}
+
+	// the Type of FormattedPDU is filled in below as a name
+	typeInfo := o.TypeInfo
+	o.TypeInfo = false
+	formatted := o.Format(SanitizeResultVariables(&pdus))
+
+	varbinds := make([]Varbind, len(formatted))
+	for i, f := range formatted {
+		varbinds[i] = Varbind{
+			Oid:     f.Name,
+			Value:   f.Value,
+			Raw:     raw[i],
+			Table:   f.Table,
+			Column:  f.Column,
+			Index:   f.Index,
+			Symbol:  f.Symbol,
+			Display: f.Display,
+			Unit:    f.Unit,
+			Error:   f.Error,
+		}
+		if typeInfo {
+			varbinds[i].Type = pdus[i].Type.String()
+		}
+	}
+	return varbinds
+}
+
+// formatVariables - result variables as encoded per request options
+//
+// With ?format=legacy and no other formatting option the gosnmp PDU
+// encoding of earlier releases is kept.
+func formatVariables(r *http.Request, pdus []gosnmp.SnmpPDU) (interface{}, error) {
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Output == FormatStructured {
+		return o.Varbinds(pdus), nil
+	}
+	sanitized := SanitizeResultVariables(&pdus)
+	if !o.custom {
+		return sanitized, nil
+	}
+	return o.Format(sanitized), nil
+}
+
+// RenderVariables - write result variables formatted per request options
+func RenderVariables(w http.ResponseWriter, r *http.Request, pdus []gosnmp.SnmpPDU) {
+	if encoding := ResponseEncoding(r); encoding != EncodingJSON {
+		RenderEncoded(w, r, http.StatusOK, encoding, pdus, nil)
+		return
+	}
+	variables, err := formatVariables(r, pdus)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, variables)
+}
+
+// PartialResult - varbinds read and oids that failed in the same request
+type PartialResult struct {
+	Variables interface{}    `json:"variables"`
+	Errors    []VarbindError `json:"errors"`
+}
+
+// RenderPartial - write 207 with the formatted variables and per oid errors
+func RenderPartial(w http.ResponseWriter, r *http.Request, pdus []gosnmp.SnmpPDU, errs []VarbindError) {
+	if encoding := ResponseEncoding(r); encoding != EncodingJSON {
+		RenderEncoded(w, r, http.StatusMultiStatus, encoding, pdus, errs)
+		return
+	}
+	variables, err := formatVariables(r, pdus)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusMultiStatus, PartialResult{Variables: variables, Errors: errs})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Group write outcomes
+const (
+	GroupCompleted = "completed"
+	GroupAborted   = "aborted"
+)
+
+// groupWorkers - concurrent targets during the rollout phase
+const groupWorkers = 8
+
+// Canary verification modes
+const (
+	VerifyReadBack = "readback"
+	VerifyQuery    = "query"
+)
+
+// CanaryOptions - devices written first and how they are verified
+//
+// With verify "readback" the written values are read again and compared,
+// with "query" the expected values of the validation query are compared.
+type CanaryOptions struct {
+	Count  int            `json:"count"`
+	Verify string         `json:"verify"`
+	Query  []DesiredValue `json:"query"`
+}
+
+// GroupSetRequest - same SET applied to a group of targets
+type GroupSetRequest struct {
+	Targets  []string        `json:"targets"`
+	Values   [][]interface{} `json:"values,omitempty"`
+	Varbinds []SetVarbind    `json:"varbinds,omitempty"`
+	Canary   *CanaryOptions  `json:"canary"`
+}
+
+// TargetResult - outcome of a write on one target
+type TargetResult struct {
+	Target      string `json:"target"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	VerifyError string `json:"verify_error,omitempty"`
+}
+
+// GroupSetResult - outcome of a group write
+type GroupSetResult struct {
+	Status  string         `json:"status"`
+	Canary  []TargetResult `json:"canary,omitempty"`
+	Rollout []TargetResult `json:"rollout"`
+}
+
+// GroupSetHandler - snmpset on a group of targets with optional canary phase
+//
+// Canary targets are written and verified one by one; the remaining
+// targets are only written if every canary succeeded.
+func GroupSetHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := ParseSnmpVersion(mux.Vars(r)["snmp_version"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	community := r.Header.Get("X-SNMP-COMM")
+	if community == "" {
+		WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+		return
+	}
+
+	request := GroupSetRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid group set json")
+		return
+	}
+	if len(request.Targets) == 0 {
+		WriteError(w, http.StatusBadRequest, "targets missing")
+		return
+	}
+	request.Targets = inventory.ResolveAll(request.Targets)
+	if target, ok := AuthorizeTargets(r, request.Targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	pdus, err := ValuesToPDUs(append(request.Values, VarbindValues(request.Varbinds)...))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c := request.Canary; c != nil {
+		if c.Count < 1 || c.Count > len(request.Targets) {
+			WriteError(w, http.StatusBadRequest, "canary count must be between 1 and the number of targets")
+			return
+		}
+		switch c.Verify {
+		case "", VerifyReadBack:
+			c.Verify = VerifyReadBack
+		case VerifyQuery:
+			if len(c.Query) == 0 {
+				WriteError(w, http.StatusBadRequest, "canary query missing")
+				return
+			}
+			for _, v := range c.Query {
+				if _, err := ToSnmpPDU(v.Oid, v.Type, v.Value); err != nil {
+					WriteError(w, http.StatusBadRequest, v.Oid+": "+err.Error())
+					return
+				}
+			}
+		default:
+			WriteError(w, http.StatusBadRequest, "canary verify must be readback or query")
+			return
+		}
+	}
+
+	WriteJSON(w, http.StatusOK, RunGroupSet(r, version, community, pdus, request.Targets, request.Canary))
+}
+
+// RunGroupSet - write pdus to targets on behalf of r, canary phase first if requested
+func RunGroupSet(r *http.Request, version gosnmp.SnmpVersion, community string, pdus []gosnmp.SnmpPDU, targets []string, canary *CanaryOptions) GroupSetResult {
+	result := GroupSetResult{Status: GroupCompleted}
+	rollout := targets
+
+	if canary != nil {
+		rollout = targets[canary.Count:]
+		for _, target := range targets[:canary.Count] {
+			tr := writeTarget(r, version, community, target, pdus, canary)
+			result.Canary = append(result.Canary, tr)
+			if tr.Status != StepOK {
+				result.Status = GroupAborted
+			}
+		}
+	}
+
+	result.Rollout = make([]TargetResult, len(rollout))
+	if result.Status == GroupAborted {
+		for i, target := range rollout {
+			result.Rollout[i] = TargetResult{Target: target, Status: StepSkipped}
+		}
+		return result
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, groupWorkers)
+	for i, target := range rollout {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target string) {
+			defer wg.Done()
+			stats.Add("group.workers.busy", 1)
+			defer stats.Add("group.workers.busy", -1)
+			result.Rollout[i] = writeTarget(r, version, community, target, pdus, nil)
+			<-sem
+		}(i, target)
+	}
+	wg.Wait()
+	return result
+}
+
+// writeTarget - set pdus on one target, verifying as canary if given
+func writeTarget(r *http.Request, version gosnmp.SnmpVersion, community string, target string, pdus []gosnmp.SnmpPDU, canary *CanaryOptions) TargetResult {
+	tr := TargetResult{Target: target, Status: StepFailed}
+
+	g, err := sessions.Get(target, version, community)
+	if err != nil {
+		tr.Error = err.Error()
+		return tr
+	}
+	defer sessions.Put(g)
+	defer BindSession(g, r)()
+
+	setResult, err := JournaledSet(g, "group-set", pdus)
+	if err != nil {
+		tr.Error = err.Error()
+		return tr
+	}
+	if err := NewPacketError("Set", setResult); err != nil {
+		tr.Error = err.Error()
+		return tr
+	}
+
+	if canary != nil {
+		expected := pdus
+		if canary.Verify == VerifyQuery {
+			expected = make([]gosnmp.SnmpPDU, len(canary.Query))
+			for i, v := range canary.Query {
+				expected[i], _ = ToSnmpPDU(v.Oid, v.Type, v.Value)
+			}
+		}
+		if err := VerifyValues(g, expected); err != nil {
+			tr.VerifyError = err.Error()
+			return tr
+		}
+	}
+
+	tr.Status = StepOK
+	return tr
+}
+
+// VerifyValues - GET expected oids and compare with expected values
+func VerifyValues(g *gosnmp.GoSNMP, expected []gosnmp.SnmpPDU) error {
+	oids := make([]string, len(expected))
+	for i, pdu := range expected {
+		oids[i] = pdu.Name
+	}
+	result, err := NegotiatedGet(g, oids)
+	if err != nil {
+		return err
+	}
+	if result.Error != gosnmp.NoError {
+		return fmt.Errorf("Get error: %v, Index: %v", result.Error, result.ErrorIndex)
+	}
+
+	actual := SanitizeResultVariables(&result.Variables)
+	for i, pdu := range expected {
+		if i >= len(actual) || !PDUValueEqual(actual[i], pdu) {
+			return fmt.Errorf("%s does not hold expected value %v", pdu.Name, pdu.Value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+	restsnmp "github.com/thebinary/rest-snmp/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService - full name of the Snmp service
+const grpcService = "restsnmp.v1.Snmp"
+
+// GrpcServer - Snmp gRPC service, see proto/restsnmp.proto
+//
+// Every call is turned into the http request its REST route would get,
+// with the metadata as headers, so auth, the credential store and the
+// session pool apply as for REST: sessions are opened by AddSnmpContext
+// and error responses written on the way are returned as grpc status.
+type GrpcServer struct {
+	Traps *TrapReceiver
+}
+
+// NewGrpcServer - grpc server of s, using the REST TLS certificate if set
+func NewGrpcServer(s *GrpcServer, certFile string, keyFile string) (*grpc.Server, error) {
+	var options []grpc.ServerOption
+	if certFile != "" {
+		creds, err := grpccredentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(options...)
+	restsnmp.RegisterSnmpServer(server, s)
+	return server, nil
+}
+
+// grpcCode - grpc code of an http status
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+// grpcRequest - http request of a call to method on target, authorized for role
+//
+// Metadata becomes headers, the peer the remote address. A nil target is
+// not checked against the principal.
+func grpcRequest(ctx context.Context, method string, target *restsnmp.Target, role string) (*http.Request, error) {
+	r, err := http.NewRequest(http.MethodPost, "/"+grpcService+"/"+method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		if strings.HasPrefix(k, ":") || strings.HasSuffix(k, "-bin") {
+			continue
+		}
+		r.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = NewID()
+	}
+
+	vars := map[string]string{}
+	if target != nil {
+		vars["snmp_version"] = target.SnmpVersion
+		vars["target"] = target.Target
+	}
+	r = mux.SetURLVars(r.WithContext(context.WithValue(ctx, RequestIDKeyName, id)), vars)
+	if auth.Enabled() {
+		p, code, err := auth.Check(r, role, vars["target"])
+		if err != nil {
+			return nil, status.Error(grpcCode(code), err.Error())
+		}
+		r = r.WithContext(context.WithValue(r.Context(), PrincipalKeyName, p))
+	}
+	return r, nil
+}
+
+// grpcError - grpc status of the response recorded by rec, nil on success
+func grpcError(rec *httptest.ResponseRecorder) error {
+	if rec.Code < http.StatusBadRequest {
+		return nil
+	}
+	message := strings.TrimSpace(rec.Body.String())
+	var response ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err == nil && response.Error.Message != "" {
+		message = response.Error.Message
+	}
+	return status.Error(grpcCode(rec.Code), message)
+}
+
+// withSession - run fn with a session of the target of r, see AddSnmpContext
+//
+// fn reports failures with WriteError or WriteSnmpError on w.
+func withSession(r *http.Request, fn func(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP)) error {
+	rec := httptest.NewRecorder()
+	AddSnmpContext(func(w http.ResponseWriter, r *http.Request) {
+		fn(w, r, r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP))
+	}).ServeHTTP(rec, r)
+	return grpcError(rec)
+}
+
+// grpcVarbinds - pdus as structured varbinds, see FormatOptions.Varbinds
+func grpcVarbinds(o FormatOptions, pdus []gosnmp.SnmpPDU) []*restsnmp.Varbind {
+	varbinds := o.Varbinds(pdus)
+	list := make([]*restsnmp.Varbind, len(varbinds))
+	for i, v := range varbinds {
+		list[i] = &restsnmp.Varbind{Oid: v.Oid, Type: v.Type, Display: v.Display, Unit: v.Unit, Error: v.Error}
+		if v.Value != nil {
+			value, err := json.Marshal(v.Value)
+			if err != nil {
+				value = []byte(fmt.Sprintf("%q", fmt.Sprint(v.Value)))
+			}
+			list[i].Value = string(value)
+		}
+	}
+	return list
+}
+
+// Get - GET of oids, names resolved and wildcards expanded as for REST
+func (s *GrpcServer) Get(ctx context.Context, request *restsnmp.GetRequest) (*restsnmp.GetResponse, error) {
+	r, err := grpcRequest(ctx, "Get", request.Target, RoleRead)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Oids) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "oids missing")
+	}
+
+	response := &restsnmp.GetResponse{}
+	err = withSession(r, func(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP) {
+		o, err := ParseFormatOptions(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		oids, err := ResolveOids(request.Oids)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := LimitsForTarget(SessionTarget(g)).CheckGet(g, oids); err != nil {
+			WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		variables, failed, err := GetWithWildcards(g, oids)
+		if err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		response.Variables = grpcVarbinds(o, variables)
+		for _, e := range failed {
+			response.Variables = append(response.Variables, &restsnmp.Varbind{Oid: e.Oid, Error: e.Error})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Walk - GETNEXT walk below base_oid, each varbind sent when received
+func (s *GrpcServer) Walk(request *restsnmp.WalkRequest, stream restsnmp.Snmp_WalkServer) error {
+	return s.walk(stream.Context(), "Walk", request, false, stream.Send)
+}
+
+// BulkWalk - GETBULK walk below base_oid, each varbind sent when received
+func (s *GrpcServer) BulkWalk(request *restsnmp.WalkRequest, stream restsnmp.Snmp_BulkWalkServer) error {
+	return s.walk(stream.Context(), "BulkWalk", request, true, stream.Send)
+}
+
+// walk - stream the walk of request to send, see StreamWalk
+func (s *GrpcServer) walk(ctx context.Context, method string, request *restsnmp.WalkRequest, bulk bool, send func(*restsnmp.Varbind) error) error {
+	r, err := grpcRequest(ctx, method, request.Target, RoleRead)
+	if err != nil {
+		return err
+	}
+	if request.MaxRepetitions > 255 {
+		return status.Error(codes.InvalidArgument, "max_repetitions cannot exceed 255")
+	}
+
+	return withSession(r, func(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP) {
+		if bulk && g.Version == gosnmp.Version1 {
+			WriteError(w, http.StatusBadRequest, "GETBULK requires v2c or later")
+			return
+		}
+		rootOid, err := ResolveOid(request.BaseOid)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		o, err := ParseFormatOptions(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if bulk && request.MaxRepetitions > 0 {
+			g.MaxRepetitions = uint8(request.MaxRepetitions)
+		}
+
+		stats.Inc("walk.streams")
+		err = ObservedWalk(g, rootOid, bulk, func(pdu gosnmp.SnmpPDU) error {
+			if err := r.Context().Err(); err != nil {
+				return err
+			}
+			return send(grpcVarbinds(o, []gosnmp.SnmpPDU{pdu})[0])
+		})
+		if err != nil && r.Context().Err() == nil {
+			WriteSnmpError(w, err)
+		}
+	})
+}
+
+// Set - SET of varbinds, compare-and-set and dry run as for REST
+func (s *GrpcServer) Set(ctx context.Context, request *restsnmp.SetRequest) (*restsnmp.SetResponse, error) {
+	r, err := grpcRequest(ctx, "Set", request.Target, RoleWrite)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Varbinds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "varbinds missing")
+	}
+	if request.DryRun {
+		r = r.WithContext(context.WithValue(r.Context(), DryRunKeyName, &DryRun{DryRun: true}))
+	}
+
+	values := make([][]interface{}, len(request.Varbinds))
+	for i, v := range request.Varbinds {
+		values[i] = []interface{}{v.Oid, v.Type, v.Value}
+		if v.Expected != "" {
+			values[i] = append(values[i], v.Expected)
+		}
+	}
+	response := &restsnmp.SetResponse{DryRun: request.DryRun}
+	err = withSession(r, func(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP) {
+		pdus, err := ValuesToPDUs(values)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := LimitsForTarget(SessionTarget(g)).Check(g, gosnmp.SetRequest, pdus); err != nil {
+			WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		expected, err := ExpectedValues(values, pdus)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(expected) > 0 {
+			mismatches, _, err := CompareValues(g, expected)
+			if err != nil {
+				WriteSnmpError(w, err)
+				return
+			}
+			if len(mismatches) > 0 {
+				oids := make([]string, len(mismatches))
+				for i, m := range mismatches {
+					oids[i] = m.Oid
+				}
+				WriteError(w, http.StatusConflict, "current values do not match expected values: "+strings.Join(oids, ", "))
+				return
+			}
+		}
+
+		result, err := JournaledSet(g, "set", pdus)
+		if err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		if err := NewPacketError("Set", result); err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		o, err := ParseFormatOptions(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Variables = grpcVarbinds(o, result.Variables)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Subscribe - traps as received, after the buffered ones past since_seq
+//
+// Traps from sources the principal may not reach are skipped, as on the
+// event stream.
+func (s *GrpcServer) Subscribe(request *restsnmp.SubscribeRequest, stream restsnmp.Snmp_SubscribeServer) error {
+	r, err := grpcRequest(stream.Context(), "Subscribe", nil, RoleRead)
+	if err != nil {
+		return err
+	}
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	principal := RequestPrincipal(r)
+	last := request.SinceSeq
+	send := func(trap Trap) error {
+		if trap.Seq <= last ||
+			(principal != nil && !principal.AllowsTarget(trap.Source)) ||
+			(request.Source != "" && request.Source != trap.Source) ||
+			!strings.HasPrefix(trap.TrapOid, request.TrapOid) {
+			return nil
+		}
+		last = trap.Seq
+		return stream.Send(&restsnmp.Trap{
+			Id:           trap.ID,
+			Seq:          trap.Seq,
+			Received:     trap.Received.Format(time.RFC3339Nano),
+			Source:       trap.Source,
+			SnmpVersion:  trap.Version,
+			Kind:         trap.Kind,
+			TrapOid:      trap.TrapOid,
+			Enterprise:   trap.Enterprise,
+			AgentAddress: trap.AgentAddress,
+			GenericTrap:  int32(trap.GenericTrap),
+			SpecificTrap: int32(trap.SpecificTrap),
+			Uptime:       uint32(trap.Uptime),
+			Variables:    grpcVarbinds(o, trap.Variables),
+		})
+	}
+
+	// Subscribe first so no trap falls between the buffer and the stream
+	sub, _ := events.subscribe(map[string]bool{ChannelTraps: true}, ^uint64(0))
+	defer events.unsubscribe(sub)
+	stats.Add("events.streams", 1)
+	defer stats.Add("events.streams", -1)
+
+	if request.SinceSeq > 0 && s.Traps != nil {
+		for _, trap := range s.Traps.Traps(func(Trap) bool { return true }) {
+			if err := send(trap); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case event := <-sub.events:
+			if trap, ok := event.Data.(Trap); ok {
+				if err := send(trap); err != nil {
+					return err
+				}
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health check outcomes
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// started - process start, reported by liveness
+var started = time.Now()
+
+// HealthCheck - outcome of one readiness check
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport - overall status and the checks it is made of
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Uptime float64                `json:"uptime_seconds"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// Readiness - checks deciding whether the gateway should receive traffic
+//
+// The trap listener is checked when traps are received, the credential
+// store when it is backed by a file, and with CanaryTarget set a GET of
+// CanaryOid must succeed. Canary results are reused for CanaryInterval
+// so probes do not load the reference device.
+type Readiness struct {
+	Traps          *TrapReceiver
+	CanaryTarget   string
+	CanaryOid      string
+	CanaryVersion  string
+	CanaryInterval time.Duration
+
+	mu           sync.Mutex
+	canaryAt     time.Time
+	canaryErr    error
+	shuttingDown bool
+}
+
+// readiness - readiness checks of the gateway
+var readiness = &Readiness{
+	CanaryOid:      "1.3.6.1.2.1.1.3.0",
+	CanaryVersion:  "v2c",
+	CanaryInterval: 30 * time.Second,
+}
+
+// ShutDown - report not ready from now on, so load balancers drain the gateway
+func (h *Readiness) ShutDown() {
+	h.mu.Lock()
+	h.shuttingDown = true
+	h.mu.Unlock()
+}
+
+// canary - GET of the canary oid, reusing a recent result
+func (h *Readiness) canary() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.canaryAt.IsZero() && time.Since(h.canaryAt) < h.CanaryInterval {
+		return h.canaryErr
+	}
+	h.canaryErr = h.probe()
+	h.canaryAt = time.Now()
+	if h.canaryErr != nil {
+		stats.Inc("health.canary.failed")
+	}
+	return h.canaryErr
+}
+
+func (h *Readiness) probe() error {
+	version, err := ParseSnmpVersion(h.CanaryVersion)
+	if err != nil {
+		return err
+	}
+	oid, err := ResolveOid(h.CanaryOid)
+	if err != nil {
+		return err
+	}
+	g, _, err := ConnectWithFallback(h.CanaryTarget, version, CandidateCommunities(nil, h.CanaryTarget, version))
+	if err != nil {
+		return err
+	}
+	defer sessions.Put(g)
+
+	result, err := ObservedGet(g, []string{oid})
+	if err != nil {
+		return err
+	}
+	if err := NewPacketError("Get", result); err != nil {
+		return err
+	}
+	if missing, ok := allMissing(result.Variables); ok {
+		return fmt.Errorf("%s: %v", missing.Name, missing.Type)
+	}
+	return nil
+}
+
+// Check - outcome of every configured check
+func (h *Readiness) Check() map[string]HealthCheck {
+	checks := map[string]HealthCheck{}
+	record := func(name string, err error) {
+		if err != nil {
+			checks[name] = HealthCheck{Status: HealthFail, Error: err.Error()}
+			return
+		}
+		checks[name] = HealthCheck{Status: HealthOK}
+	}
+
+	h.mu.Lock()
+	shuttingDown := h.shuttingDown
+	h.mu.Unlock()
+	if shuttingDown {
+		record("shutdown", fmt.Errorf("shutting down"))
+	}
+	if h.Traps != nil {
+		var err error
+		if !h.Traps.Listening() {
+			err = fmt.Errorf("trap listener not bound")
+		}
+		record("trap_listener", err)
+	}
+	if credentials.Persistent() {
+		record("credentials", credentials.Check())
+	}
+	if h.CanaryTarget != "" {
+		record("canary", h.canary())
+	}
+	return checks
+}
+
+// HealthzHandler - liveness, 200 while the process serves requests
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, HealthReport{Status: HealthOK, Uptime: time.Since(started).Seconds()})
+}
+
+// ReadyzHandler - readiness, 503 if any check fails
+func (h *Readiness) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{Status: HealthOK, Uptime: time.Since(started).Seconds(), Checks: h.Check()}
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != HealthOK {
+			report.Status = HealthFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	WriteJSON(w, status, report)
+}
@@ -2,111 +2,486 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/soniah/gosnmp"
 )
 
-// ToSnmpPDU - convert to SnmpPDU
-func ToSnmpPDU(oid string, typeString interface{}, value interface{}) gosnmp.SnmpPDU {
-	var pduType gosnmp.Asn1BER
-	var pduValue interface{}
+// pduTypes - BER types of the type codes of SET and expected values
+//
+// The letters follow net-snmp's snmpset; long names are accepted too.
+// base64, dateandtime and inetaddress are octet strings given as rendered
+// by octet_format=base64 and auto. C, b and q are only valid as expected
+// values, see WritablePDU.
+var pduTypes = map[string]gosnmp.Asn1BER{
+	"i": gosnmp.Integer, "integer": gosnmp.Integer,
+	"u": gosnmp.Uinteger32, "unsigned32": gosnmp.Uinteger32,
+	"g": gosnmp.Gauge32, "gauge32": gosnmp.Gauge32,
+	"c": gosnmp.Counter32, "counter32": gosnmp.Counter32,
+	"C": gosnmp.Counter64, "counter64": gosnmp.Counter64,
+	"t": gosnmp.TimeTicks, "timeticks": gosnmp.TimeTicks,
+	"a": gosnmp.IPAddress, "ipaddress": gosnmp.IPAddress,
+	"o": gosnmp.ObjectIdentifier, "oid": gosnmp.ObjectIdentifier,
+	"s": gosnmp.OctetString, "string": gosnmp.OctetString,
+	"x": gosnmp.OctetString, "hex": gosnmp.OctetString,
+	"base64": gosnmp.OctetString, "dateandtime": gosnmp.OctetString, "inetaddress": gosnmp.OctetString,
+	"b": gosnmp.BitString, "bits": gosnmp.BitString,
+	"q": gosnmp.Opaque, "opaque": gosnmp.Opaque,
+	"n": gosnmp.Null, "null": gosnmp.Null,
+}
 
-	// TODO : Test all the types
-	switch typeString.(string) {
-	case "i":
-		pduType = gosnmp.Integer
-		pduValue = int(value.(float64))
-	case "u":
-		pduType = gosnmp.Uinteger32
-		pduValue = int(value.(float64))
-	case "t":
-		pduType = gosnmp.TimeTicks
-		pduValue = int(value.(float64))
-	case "a":
-		pduType = gosnmp.IPAddress
-		pduValue = value.([]byte)
-	case "o":
-		pduType = gosnmp.ObjectIdentifier
-		pduValue = value.([]byte)
-	case "s", "x":
-		pduType = gosnmp.OctetString
-		pduValue = value.(string)
-	case "b":
-		pduType = gosnmp.BitString
-		pduValue = value.(string)
-	default:
-		return gosnmp.SnmpPDU{}
+// WritablePDU - error if gosnmp cannot encode the type of pdu in a SET
+func WritablePDU(pdu gosnmp.SnmpPDU) error {
+	switch pdu.Type {
+	case gosnmp.Counter64, gosnmp.BitString, gosnmp.Opaque:
+		return fmt.Errorf("type %s cannot be written", pdu.Type)
+	}
+	return nil
+}
+
+// ParsePDUType - BER type of a type code, see pduTypes
+func ParsePDUType(typeString interface{}) (gosnmp.Asn1BER, error) {
+	code, ok := typeString.(string)
+	if !ok {
+		return 0, fmt.Errorf("type must be a string")
+	}
+	pduType, ok := pduTypes[code]
+	if !ok {
+		return 0, fmt.Errorf("unknown type %s", code)
+	}
+	return pduType, nil
+}
+
+// ToSnmpPDU - convert json type code and value to SnmpPDU
+//
+// Numbers may be given as json numbers or decimal strings and must fit
+// the type; IP addresses and oids are strings, x and opaque values hex
+// strings, plain or separated by colons, spaces or dashes. base64 values
+// are decoded, dateandtime ones are RFC 3339 dates and inetaddress ones
+// IPv4 or IPv6 addresses. Null ignores the value. Counter64, BitString
+// and Opaque values are converted for comparison with values read from
+// agents; callers building SET requests reject them with WritablePDU.
+func ToSnmpPDU(oid string, typeString interface{}, value interface{}) (gosnmp.SnmpPDU, error) {
+	pduType, err := ParsePDUType(typeString)
+	if err != nil {
+		return gosnmp.SnmpPDU{}, err
+	}
+	code := typeString.(string)
+
+	var pduValue interface{}
+	switch pduType {
+	case gosnmp.Integer:
+		n, err := integerValue(value, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, err
+		}
+		pduValue = int(n)
+	case gosnmp.Uinteger32, gosnmp.Gauge32, gosnmp.Counter32, gosnmp.TimeTicks:
+		n, err := unsignedValue(value, math.MaxUint32)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, err
+		}
+		pduValue = uint32(n)
+	case gosnmp.Counter64:
+		n, err := unsignedValue(value, math.MaxUint64)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, err
+		}
+		pduValue = n
+	case gosnmp.IPAddress:
+		s, ok := value.(string)
+		if ip := net.ParseIP(s); !ok || ip == nil || ip.To4() == nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value %v for type %s: expected IPv4 address", value, code)
+		}
+		pduValue = s
+	case gosnmp.ObjectIdentifier:
+		s, ok := value.(string)
+		if !ok {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value %v for type %s: expected oid string", value, code)
+		}
+		resolved, err := ResolveOid(s)
+		if err != nil {
+			return gosnmp.SnmpPDU{}, err
+		}
+		pduValue = "." + strings.Trim(resolved, ".")
+	case gosnmp.OctetString, gosnmp.Opaque:
+		s, ok := value.(string)
+		if !ok {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value %v for type %s: expected string", value, code)
+		}
+		if code == "s" || code == "string" {
+			pduValue = s
+			break
+		}
+		var b []byte
+		var err error
+		switch code {
+		case "base64":
+			b, err = base64Value(s)
+		case "dateandtime":
+			b, err = dateAndTimeValue(s)
+		case "inetaddress":
+			b, err = inetAddressValue(s)
+		default:
+			b, err = hexValue(s)
+		}
+		if err != nil {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value %v for type %s: %v", value, code, err)
+		}
+		pduValue = b
+	case gosnmp.BitString:
+		s, ok := value.(string)
+		if !ok {
+			return gosnmp.SnmpPDU{}, fmt.Errorf("invalid value %v for type %s: expected string", value, code)
+		}
+		pduValue = s
+	case gosnmp.Null:
+		pduValue = nil
 	}
 
 	return gosnmp.SnmpPDU{
 		Name:  oid,
 		Type:  pduType,
 		Value: pduValue,
+	}, nil
+}
+
+// integerValue - whole json number or decimal string within min and max
+func integerValue(value interface{}, min int64, max int64) (int64, error) {
+	var n int64
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || v < float64(min) || v > float64(max) {
+			return 0, fmt.Errorf("invalid value %v: expected integer between %d and %d", value, min, max)
+		}
+		n = int64(v)
+	case int:
+		n = int64(v)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid value %v: expected integer", value)
+		}
+	default:
+		return 0, fmt.Errorf("invalid value %v: expected integer", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("invalid value %v: expected integer between %d and %d", value, min, max)
+	}
+	return n, nil
+}
+
+// unsignedValue - whole non-negative json number or decimal string up to max
+//
+// Counter64 values above 2^53 must be strings to keep their precision.
+func unsignedValue(value interface{}, max uint64) (uint64, error) {
+	var n uint64
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v > float64(max) {
+			return 0, fmt.Errorf("invalid value %v: expected unsigned integer up to %d", value, max)
+		}
+		n = uint64(v)
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("invalid value %v: expected unsigned integer", value)
+		}
+		n = uint64(v)
+	case string:
+		var err error
+		if n, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("invalid value %v: expected unsigned integer", value)
+		}
+	default:
+		return 0, fmt.Errorf("invalid value %v: expected unsigned integer", value)
+	}
+	if n > max {
+		return 0, fmt.Errorf("invalid value %v: expected unsigned integer up to %d", value, max)
+	}
+	return n, nil
+}
+
+// hexValue - bytes of a hex string, optionally 0x prefixed and separated by spaces, colons or dashes
+func hexValue(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	s = strings.NewReplacer(" ", "", ":", "", "-", "").Replace(s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected hex string")
+	}
+	return b, nil
+}
+
+// ParseSnmpVersion - map version label used in routes to gosnmp version
+func ParseSnmpVersion(label string) (gosnmp.SnmpVersion, error) {
+	switch label {
+	case "v1":
+		return gosnmp.Version1, nil
+	case "v2", "v2c":
+		return gosnmp.Version2c, nil
+	case "v3":
+		return gosnmp.Version3, nil
 	}
+	return 0, fmt.Errorf("Unknown SNMP version")
+}
+
+// NewSnmpSession - connected snmp session for target
+//
+// A fresh GoSNMP is built from gosnmp.Default for every session so that
+// concurrent requests and background jobs never share state. For v3
+// community names the stored credential holding the USM user.
+func NewSnmpSession(target string, version gosnmp.SnmpVersion, community string) (*gosnmp.GoSNMP, error) {
+	addr, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	g := &gosnmp.GoSNMP{
+		Port:               addr.Port,
+		Transport:          addr.Transport,
+		Community:          community,
+		Version:            version,
+		Timeout:            gosnmp.Default.Timeout,
+		Retries:            gosnmp.Default.Retries,
+		ExponentialTimeout: gosnmp.Default.ExponentialTimeout,
+		MaxOids:            LimitsForTarget(target).MaxOids,
+		MaxRepetitions:     uint8(sizes.For(target).MaxRepetitions),
+		Target:             addr.Host,
+	}
+	if version == gosnmp.Version3 {
+		c := credentials.Lookup(community)
+		if c == nil {
+			return nil, fmt.Errorf("unknown credential %s", community)
+		}
+		if err := c.ApplyV3(g); err != nil {
+			return nil, err
+		}
+		g.Community = ""
+	}
+	if err := g.Connect(); err != nil {
+		stats.Inc("snmp.sessions.failed")
+		return nil, err
+	}
+	stats.Inc("snmp.sessions.opened")
+	return g, nil
 }
 
 // AddSnmpContext - snmp connection wrapper handler
 func AddSnmpContext(next http.HandlerFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		sversionLabel := vars["snmp_version"]
-		starget := vars["target"]
-		scommunity := r.Header.Get("X-SNMP-COMM")
-		var sversion gosnmp.SnmpVersion
-
-		switch sversionLabel {
-		case "v1":
-			sversion = gosnmp.Version1
-		case "v2", "v2c":
-			sversion = gosnmp.Version2c
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			_, err := w.Write([]byte("Unknown SNMP version"))
-			if err != nil {
-				log.Printf("[ERR] http write error")
-			}
+		starget, err := RequestTarget(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		if scommunity == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			_, err := w.Write([]byte("SNMP Community undefined"))
-			if err != nil {
-				log.Printf("[ERR] http write error")
-			}
+		sversion, err := ParseSnmpVersion(vars["snmp_version"])
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		g := gosnmp.Default
-		g.Target = starget
-		g.Community = scommunity
-		g.Version = sversion
-
-		err := g.Connect()
+		options, err := ParseRequestOptions(r, starget)
 		if err != nil {
-			w.WriteHeader(http.StatusBadGateway)
-			_, err := w.Write([]byte(err.Error()))
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// A referenced stored credential is used alone; otherwise every
+		// X-SNMP-COMM header is a candidate, tried in order before the
+		// communities of the target profile and the credential store
+		var candidates []CandidateCommunity
+		if name := RequestCredential(r); name != "" {
+			candidate, err := credentials.Reference(name, starget, sversion)
+			if err == errCredentialNotAllowed {
+				WriteError(w, http.StatusForbidden, err.Error())
+				return
+			}
 			if err != nil {
-				log.Printf("[ERR] http write error")
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
 			}
+			candidates = []CandidateCommunity{candidate}
+		} else {
+			candidates = CandidateCommunities(r.Header["X-Snmp-Comm"], starget, sversion)
+		}
+		if len(candidates) == 0 {
+			WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+			return
+		}
+
+		g, index, err := ConnectWithFallback(starget, sversion, candidates)
+		if refusedLocally(err) {
+			WriteSnmpError(w, err)
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadGateway, err.Error())
 			return
 		}
+		for k, v := range credentialHeaders(candidates, index) {
+			w.Header().Set(k, v)
+		}
+		if len(candidates) > 1 && candidates[index].Source != CredentialFromStore && r.URL.Query().Get("persist_credential") == "true" {
+			if err := profiles.PromoteCommunity(starget, candidates[index].Community); err != nil {
+				RequestLogger(r).Error("persisting credential", Fields{"target": starget, "err": err})
+			}
+		}
+
+		defer sessions.Put(g)
+		options.Apply(g)
 
-		ctx := context.WithValue(r.Context(), SNMPKeyName, g)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		r = r.WithContext(context.WithValue(r.Context(), SNMPKeyName, g))
+		defer BindSession(g, r)()
+		next.ServeHTTP(w, r)
 	})
 }
 
+// boundRequests - request each checked out session serves, see BindSession
+var boundRequests sync.Map
+
+// BindSession - associate session g with request r until the returned func is called
+//
+// Writes only get the session; they look up its request for the dry run
+// and audit identity.
+func BindSession(g *gosnmp.GoSNMP, r *http.Request) func() {
+	boundRequests.Store(g, r)
+	return func() { boundRequests.Delete(g) }
+}
+
+// SessionRequest - request session g is bound to, nil if none
+func SessionRequest(g *gosnmp.GoSNMP) *http.Request {
+	if r, ok := boundRequests.Load(g); ok {
+		return r.(*http.Request)
+	}
+	return nil
+}
+
+// WriteError - write json error envelope, see APIError
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteAPIError(w, APIError{Code: status, Message: message})
+}
+
+// WriteJSON - write json response
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		ResponseLogger(w).Error("encoding json", Fields{"err": err})
+	}
+}
+
+// millis - duration in milliseconds, to the microsecond
+func millis(d time.Duration) float64 {
+	return math.Round(d.Seconds()*1e6) / 1e3
+}
+
+// NewID - random identifier for stored resources
+func NewID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		logger.Error("generating id", Fields{"err": err})
+	}
+	return hex.EncodeToString(b)
+}
+
 // SanitizeResultVariables - refactor gosnmp result variables
 func SanitizeResultVariables(pdus *[]gosnmp.SnmpPDU) []gosnmp.SnmpPDU {
 	pdusNew := *pdus
 	for i, p := range pdusNew {
-		if pdusNew[i].Type == gosnmp.OctetString {
-			pdusNew[i].Value = string(p.Value.([]byte))
+		if v, ok := p.Value.([]byte); ok && p.Type == gosnmp.OctetString {
+			pdusNew[i].Value = string(v)
 		}
 	}
 	return pdusNew
 }
+
+// PDUValueEqual - compare values of two pdus independent of go representation
+func PDUValueEqual(a gosnmp.SnmpPDU, b gosnmp.SnmpPDU) bool {
+	switch a.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks,
+		gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(a.Value).Cmp(gosnmp.ToBigInt(b.Value)) == 0
+	case gosnmp.OctetString:
+		return octetString(a.Value) == octetString(b.Value)
+	}
+	return fmt.Sprint(a.Value) == fmt.Sprint(b.Value)
+}
+
+func octetString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// ParseOid - split dotted oid into sub-identifiers
+func ParseOid(oid string) ([]int, error) {
+	oid = strings.Trim(oid, ".")
+	if oid == "" {
+		return []int{}, nil
+	}
+	parts := strings.Split(oid, ".")
+	subids := make([]int, len(parts))
+	for i, part := range parts {
+		subid, err := strconv.Atoi(part)
+		if err != nil || subid < 0 {
+			return nil, fmt.Errorf("invalid oid %s", oid)
+		}
+		subids[i] = subid
+	}
+	return subids, nil
+}
+
+// ApplyDefaults - replace missing instances with client supplied defaults
+//
+// defaults is keyed by oid; NoSuchObject and NoSuchInstance varbinds with
+// a default get its value and a type inferred from the json value.
+func ApplyDefaults(pdus []gosnmp.SnmpPDU, defaults map[string]interface{}) {
+	if len(defaults) == 0 {
+		return
+	}
+	normalized := make(map[string]interface{}, len(defaults))
+	for oid, value := range defaults {
+		if numeric, err := ResolveOid(oid); err == nil {
+			oid = numeric
+		}
+		normalized["."+strings.Trim(oid, ".")] = value
+	}
+
+	for i, pdu := range pdus {
+		if pdu.Type != gosnmp.NoSuchInstance && pdu.Type != gosnmp.NoSuchObject {
+			continue
+		}
+		value, ok := normalized["."+strings.Trim(pdu.Name, ".")]
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			pdus[i].Type = gosnmp.OctetString
+			pdus[i].Value = []byte(v)
+		case float64:
+			pdus[i].Type = gosnmp.Integer
+			pdus[i].Value = int(v)
+		case nil:
+			pdus[i].Type = gosnmp.Null
+			pdus[i].Value = nil
+		default:
+			pdus[i].Type = gosnmp.Opaque
+			pdus[i].Value = v
+		}
+	}
+}
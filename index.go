@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Index component encodings, as derived from the syntax of INDEX objects
+const (
+	IndexInteger       = "integer"
+	IndexIPAddress     = "ipaddress"
+	IndexString        = "string"
+	IndexImpliedString = "implied-string"
+	IndexMacAddress    = "macaddress"
+	IndexOid           = "oid"
+	IndexImpliedOid    = "implied-oid"
+)
+
+// indexSyntaxKinds - index encoding of base syntaxes and common textual
+// conventions, by the first token of the syntax
+var indexSyntaxKinds = map[string]string{
+	"INTEGER":              IndexInteger,
+	"Integer32":            IndexInteger,
+	"Unsigned32":           IndexInteger,
+	"Gauge32":              IndexInteger,
+	"Counter32":            IndexInteger,
+	"TimeTicks":            IndexInteger,
+	"InterfaceIndex":       IndexInteger,
+	"InterfaceIndexOrZero": IndexInteger,
+	"InetAddressType":      IndexInteger,
+	"InetPortNumber":       IndexInteger,
+	"TruthValue":           IndexInteger,
+	"RowStatus":            IndexInteger,
+	"StorageType":          IndexInteger,
+	"TimeStamp":            IndexInteger,
+	"IpAddress":            IndexIPAddress,
+	"MacAddress":           IndexMacAddress,
+	"OCTET":                IndexString,
+	"DisplayString":        IndexString,
+	"SnmpAdminString":      IndexString,
+	"PhysAddress":          IndexString,
+	"InetAddress":          IndexString,
+	"TAddress":             IndexString,
+	"OBJECT":               IndexOid,
+	"AutonomousType":       IndexOid,
+	"TDomain":              IndexOid,
+	"RowPointer":           IndexOid,
+}
+
+// IndexPart - one object of a table INDEX clause
+type IndexPart struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// TableIndex - INDEX clause of a conceptual table row
+type TableIndex struct {
+	Entry string      `json:"entry"`
+	Oid   string      `json:"oid"`
+	Parts []IndexPart `json:"parts"`
+}
+
+// IndexedPDU - pdu with its index portion decoded into named fields
+type IndexedPDU struct {
+	gosnmp.SnmpPDU
+	Table  string                 `json:"table,omitempty"`
+	Column int                    `json:"column,omitempty"`
+	Index  map[string]interface{} `json:"index,omitempty"`
+}
+
+var (
+	tableIndexesMu sync.RWMutex
+	tableIndexes   = map[string]TableIndex{}
+)
+
+// Built-in indexes of common tables, used until MIBs defining them are
+// loaded, see LoadMibDir
+func init() {
+	for _, t := range []TableIndex{
+		{"ifEntry", ".1.3.6.1.2.1.2.2.1", []IndexPart{{"ifIndex", IndexInteger}}},
+		{"ifXEntry", ".1.3.6.1.2.1.31.1.1.1", []IndexPart{{"ifIndex", IndexInteger}}},
+		{"ipAddrEntry", ".1.3.6.1.2.1.4.20.1", []IndexPart{{"ipAdEntAddr", IndexIPAddress}}},
+		{"ipRouteEntry", ".1.3.6.1.2.1.4.21.1", []IndexPart{{"ipRouteDest", IndexIPAddress}}},
+		{"ipNetToMediaEntry", ".1.3.6.1.2.1.4.22.1", []IndexPart{
+			{"ipNetToMediaIfIndex", IndexInteger},
+			{"ipNetToMediaNetAddress", IndexIPAddress},
+		}},
+		{"tcpConnEntry", ".1.3.6.1.2.1.6.13.1", []IndexPart{
+			{"tcpConnLocalAddress", IndexIPAddress},
+			{"tcpConnLocalPort", IndexInteger},
+			{"tcpConnRemAddress", IndexIPAddress},
+			{"tcpConnRemPort", IndexInteger},
+		}},
+		{"udpEntry", ".1.3.6.1.2.1.7.5.1", []IndexPart{
+			{"udpLocalAddress", IndexIPAddress},
+			{"udpLocalPort", IndexInteger},
+		}},
+		{"dot1dTpFdbEntry", ".1.3.6.1.2.1.17.4.3.1", []IndexPart{{"dot1dTpFdbAddress", IndexMacAddress}}},
+		{"hrStorageEntry", ".1.3.6.1.2.1.25.2.3.1", []IndexPart{{"hrStorageIndex", IndexInteger}}},
+		{"hrDeviceEntry", ".1.3.6.1.2.1.25.3.2.1", []IndexPart{{"hrDeviceIndex", IndexInteger}}},
+		{"hrSWRunEntry", ".1.3.6.1.2.1.25.4.2.1", []IndexPart{{"hrSWRunIndex", IndexInteger}}},
+		{"entPhysicalEntry", ".1.3.6.1.2.1.47.1.1.1.1", []IndexPart{{"entPhysicalIndex", IndexInteger}}},
+		{"vacmAccessEntry", ".1.3.6.1.6.3.16.1.4.1", []IndexPart{
+			{"vacmGroupName", IndexString},
+			{"vacmAccessContextPrefix", IndexString},
+			{"vacmAccessSecurityModel", IndexInteger},
+			{"vacmAccessSecurityLevel", IndexInteger},
+		}},
+		{"snmpTargetAddrEntry", ".1.3.6.1.6.3.12.1.2.1", []IndexPart{{"snmpTargetAddrName", IndexImpliedString}}},
+	} {
+		RegisterTableIndex(t)
+	}
+}
+
+// RegisterTableIndex - make INDEX clause of a table known to the decoder
+func RegisterTableIndex(t TableIndex) {
+	t.Oid = "." + strings.Trim(t.Oid, ".")
+	tableIndexesMu.Lock()
+	tableIndexes[t.Oid] = t
+	tableIndexesMu.Unlock()
+}
+
+// IndexPartKind - encoding of index object name in any registered table
+func IndexPartKind(name string) (string, bool) {
+	tableIndexesMu.RLock()
+	defer tableIndexesMu.RUnlock()
+	for _, t := range tableIndexes {
+		for _, part := range t.Parts {
+			if part.Name == name {
+				return part.Kind, true
+			}
+		}
+	}
+	return "", false
+}
+
+// LookupTableIndex - INDEX clause of the table containing oid
+func LookupTableIndex(oid string) (TableIndex, bool) {
+	oid = "." + strings.Trim(oid, ".")
+
+	tableIndexesMu.RLock()
+	defer tableIndexesMu.RUnlock()
+	for prefix := oid; prefix != ""; prefix = prefix[:strings.LastIndex(prefix, ".")] {
+		if t, ok := tableIndexes[prefix]; ok {
+			return t, true
+		}
+	}
+	return TableIndex{}, false
+}
+
+// DecodeIndexes - decode index portion of every pdu of a known table
+func DecodeIndexes(pdus []gosnmp.SnmpPDU) []IndexedPDU {
+	indexed := make([]IndexedPDU, len(pdus))
+	for i, pdu := range pdus {
+		indexed[i].SnmpPDU = pdu
+
+		t, ok := LookupTableIndex(pdu.Name)
+		if !ok {
+			continue
+		}
+		suffix, err := ParseOid(strings.TrimPrefix("."+strings.Trim(pdu.Name, "."), t.Oid))
+		if err != nil || len(suffix) < 2 {
+			continue
+		}
+		index, err := t.Decode(suffix[1:])
+		if err != nil {
+			continue
+		}
+		indexed[i].Table = t.Entry
+		indexed[i].Column = suffix[0]
+		indexed[i].Index = index
+	}
+	return indexed
+}
+
+// Decode - map index sub-identifiers to INDEX object values
+func (t TableIndex) Decode(subids []int) (map[string]interface{}, error) {
+	index := map[string]interface{}{}
+	for n, part := range t.Parts {
+		var value interface{}
+		var used int
+
+		switch part.Kind {
+		case IndexInteger:
+			if len(subids) < 1 {
+				return nil, fmt.Errorf("index too short for %s", part.Name)
+			}
+			value, used = subids[0], 1
+		case IndexIPAddress:
+			if len(subids) < 4 {
+				return nil, fmt.Errorf("index too short for %s", part.Name)
+			}
+			ip := net.IPv4(byte(subids[0]), byte(subids[1]), byte(subids[2]), byte(subids[3]))
+			value, used = ip.String(), 4
+		case IndexMacAddress:
+			if len(subids) < 6 {
+				return nil, fmt.Errorf("index too short for %s", part.Name)
+			}
+			octets := make([]string, 6)
+			for i, b := range subids[:6] {
+				octets[i] = fmt.Sprintf("%02x", b)
+			}
+			value, used = strings.Join(octets, ":"), 6
+		case IndexString, IndexOid:
+			if len(subids) < 1 || len(subids) < subids[0]+1 {
+				return nil, fmt.Errorf("index too short for %s", part.Name)
+			}
+			value, used = decodeIndexValue(part.Kind, subids[1:subids[0]+1]), subids[0]+1
+		case IndexImpliedString, IndexImpliedOid:
+			if n != len(t.Parts)-1 {
+				return nil, fmt.Errorf("implied index %s is not last", part.Name)
+			}
+			value, used = decodeIndexValue(part.Kind, subids), len(subids)
+		default:
+			return nil, fmt.Errorf("unknown index kind %s", part.Kind)
+		}
+
+		index[part.Name] = value
+		subids = subids[used:]
+	}
+	if len(subids) != 0 {
+		return nil, fmt.Errorf("trailing index sub-identifiers")
+	}
+	return index, nil
+}
+
+func decodeIndexValue(kind string, subids []int) interface{} {
+	if kind == IndexOid || kind == IndexImpliedOid {
+		parts := make([]string, len(subids))
+		for i, s := range subids {
+			parts[i] = strconv.Itoa(s)
+		}
+		return "." + strings.Join(parts, ".")
+	}
+
+	b := make([]byte, len(subids))
+	printable := true
+	for i, s := range subids {
+		b[i] = byte(s)
+		if s > unicode.MaxASCII || !unicode.IsPrint(rune(s)) {
+			printable = false
+		}
+	}
+	if printable {
+		return string(b)
+	}
+	return fmt.Sprintf("% x", b)
+}
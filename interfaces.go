@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Entries of the IF-MIB interface tables
+const (
+	ifEntryOid  = ".1.3.6.1.2.1.2.2.1"
+	ifXEntryOid = ".1.3.6.1.2.1.31.1.1.1"
+)
+
+// Columns written by UpdateInterfaceHandler
+const (
+	ifAdminStatusColumn = 7
+	ifAliasColumn       = 18
+)
+
+// ifStatusNames - ifAdminStatus and ifOperStatus values
+var ifStatusNames = map[int64]string{
+	1: "up", 2: "down", 3: "testing", 4: "unknown", 5: "dormant", 6: "notPresent", 7: "lowerLayerDown",
+}
+
+// Interface - row of ifTable merged with the same row of ifXTable
+//
+// Octet counters are the 64 bit ones of ifXTable where the agent has
+// them, as hc_counters tells. Speed is taken from ifHighSpeed when
+// ifSpeed is saturated.
+type Interface struct {
+	Index       int    `json:"if_index"`
+	Name        string `json:"name"`
+	Descr       string `json:"descr,omitempty"`
+	Alias       string `json:"alias"`
+	Type        int64  `json:"type,omitempty"`
+	MTU         int64  `json:"mtu,omitempty"`
+	PhysAddress string `json:"phys_address,omitempty"`
+	AdminStatus string `json:"admin_status"`
+	OperStatus  string `json:"oper_status"`
+	LastChange  uint64 `json:"last_change,omitempty"`
+	Speed       uint64 `json:"speed_bps"`
+	InOctets    uint64 `json:"in_octets"`
+	OutOctets   uint64 `json:"out_octets"`
+	HCCounters  bool   `json:"hc_counters"`
+	InErrors    uint64 `json:"in_errors"`
+	OutErrors   uint64 `json:"out_errors"`
+	InDiscards  uint64 `json:"in_discards"`
+	OutDiscards uint64 `json:"out_discards"`
+
+	highSpeed uint64
+}
+
+// InterfaceChange - writable fields of an interface, omitted ones are kept
+type InterfaceChange struct {
+	AdminStatus string  `json:"admin_status,omitempty"`
+	Alias       *string `json:"alias,omitempty"`
+}
+
+// pduUint, pduInt - numeric value of pdu
+func pduUint(pdu gosnmp.SnmpPDU) uint64 { return gosnmp.ToBigInt(pdu.Value).Uint64() }
+func pduInt(pdu gosnmp.SnmpPDU) int64   { return gosnmp.ToBigInt(pdu.Value).Int64() }
+
+// ifStatus - name of an interface status value
+func ifStatus(pdu gosnmp.SnmpPDU) string {
+	if name, ok := ifStatusNames[pduInt(pdu)]; ok {
+		return name
+	}
+	return strconv.FormatInt(pduInt(pdu), 10)
+}
+
+// ifFields - setters of the columns read, by entry and column number
+var ifFields = map[string]map[int]func(*Interface, gosnmp.SnmpPDU){
+	ifEntryOid: {
+		2:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.Descr = octetString(pdu.Value) },
+		3:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.Type = pduInt(pdu) },
+		4:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.MTU = pduInt(pdu) },
+		5:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.Speed = pduUint(pdu) },
+		6:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.PhysAddress = physAddress(pdu.Value) },
+		7:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.AdminStatus = ifStatus(pdu) },
+		8:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.OperStatus = ifStatus(pdu) },
+		9:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.LastChange = pduUint(pdu) },
+		10: func(i *Interface, pdu gosnmp.SnmpPDU) { i.InOctets = pduUint(pdu) },
+		13: func(i *Interface, pdu gosnmp.SnmpPDU) { i.InDiscards = pduUint(pdu) },
+		14: func(i *Interface, pdu gosnmp.SnmpPDU) { i.InErrors = pduUint(pdu) },
+		16: func(i *Interface, pdu gosnmp.SnmpPDU) { i.OutOctets = pduUint(pdu) },
+		19: func(i *Interface, pdu gosnmp.SnmpPDU) { i.OutDiscards = pduUint(pdu) },
+		20: func(i *Interface, pdu gosnmp.SnmpPDU) { i.OutErrors = pduUint(pdu) },
+	},
+	ifXEntryOid: {
+		1:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.Name = octetString(pdu.Value) },
+		6:  func(i *Interface, pdu gosnmp.SnmpPDU) { i.InOctets, i.HCCounters = pduUint(pdu), true },
+		10: func(i *Interface, pdu gosnmp.SnmpPDU) { i.OutOctets, i.HCCounters = pduUint(pdu), true },
+		15: func(i *Interface, pdu gosnmp.SnmpPDU) { i.highSpeed = pduUint(pdu) },
+		18: func(i *Interface, pdu gosnmp.SnmpPDU) { i.Alias = octetString(pdu.Value) },
+	},
+}
+
+// physAddress - octets of ifPhysAddress as colon separated hex
+func physAddress(v interface{}) string {
+	b, ok := v.([]byte)
+	if !ok || len(b) == 0 {
+		return ""
+	}
+	return net.HardwareAddr(b).String()
+}
+
+// ifColumnOids - instances of the columns read for interface index
+//
+// ifTable columns come first so the ifXTable counters replace theirs.
+func ifColumnOids(index int) []string {
+	var oids []string
+	for _, entry := range []string{ifEntryOid, ifXEntryOid} {
+		columns := make([]int, 0, len(ifFields[entry]))
+		for column := range ifFields[entry] {
+			columns = append(columns, column)
+		}
+		sort.Ints(columns)
+		for _, column := range columns {
+			oids = append(oids, entry+"."+strconv.Itoa(column)+"."+strconv.Itoa(index))
+		}
+	}
+	return oids
+}
+
+// MergeInterfaces - interfaces of ifTable and ifXTable varbinds, by index
+func MergeInterfaces(pdus []gosnmp.SnmpPDU) []*Interface {
+	byIndex := map[int]*Interface{}
+	for _, pdu := range pdus {
+		if !cacheable(pdu) {
+			continue
+		}
+		name := normalizeOid(pdu.Name)
+		i := strings.LastIndex(name, ".")
+		j := strings.LastIndex(name[:i], ".")
+		index, err := strconv.Atoi(name[i+1:])
+		if j < 0 || err != nil {
+			continue
+		}
+		column, err := strconv.Atoi(name[j+1 : i])
+		if err != nil {
+			continue
+		}
+		set, ok := ifFields[name[:j]][column]
+		if !ok {
+			continue
+		}
+		// Rows missing from ifTable are not reported
+		if _, ok := byIndex[index]; !ok && name[:j] == ifXEntryOid {
+			continue
+		}
+		if _, ok := byIndex[index]; !ok {
+			byIndex[index] = &Interface{Index: index}
+		}
+		set(byIndex[index], pdu)
+	}
+
+	interfaces := make([]*Interface, 0, len(byIndex))
+	for _, iface := range byIndex {
+		if iface.highSpeed > 0 && (iface.Speed == math.MaxUint32 || iface.highSpeed*1000000 > iface.Speed) {
+			iface.Speed = iface.highSpeed * 1000000
+		}
+		if iface.Name == "" {
+			iface.Name = iface.Descr
+		}
+		interfaces = append(interfaces, iface)
+	}
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].Index < interfaces[j].Index })
+	return interfaces
+}
+
+// readInterface - interface index, nil if the agent has no such interface
+func readInterface(g *gosnmp.GoSNMP, index int) (*Interface, error) {
+	pdus, failed, err := GetPartial(g, ifColumnOids(index))
+	if err != nil {
+		return nil, err
+	}
+	var found []gosnmp.SnmpPDU
+	for i, pdu := range pdus {
+		if _, ok := failed[i]; !ok {
+			found = append(found, pdu)
+		}
+	}
+	interfaces := MergeInterfaces(found)
+	if len(interfaces) == 0 {
+		return nil, nil
+	}
+	return interfaces[0], nil
+}
+
+// ListInterfacesHandler - interfaces of ifTable merged with ifXTable
+func ListInterfacesHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	bulk := g.Version != gosnmp.Version1
+	var pdus []gosnmp.SnmpPDU
+	for _, entry := range []string{ifEntryOid, ifXEntryOid} {
+		result, err := walkAll(g, entry, bulk, 0)
+		if err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		pdus = append(pdus, result...)
+	}
+	WriteJSON(w, http.StatusOK, MergeInterfaces(pdus))
+}
+
+// GetInterfaceHandler - single interface by ifIndex
+func GetInterfaceHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	index, err := strconv.Atoi(mux.Vars(r)["if_index"])
+	if err != nil || index < 1 {
+		WriteError(w, http.StatusBadRequest, "invalid ifIndex")
+		return
+	}
+	iface, err := readInterface(g, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if iface == nil {
+		WriteError(w, http.StatusNotFound, "interface does not exist")
+		return
+	}
+	WriteJSON(w, http.StatusOK, iface)
+}
+
+// UpdateInterfaceHandler - set ifAdminStatus and ifAlias of an interface and read it back
+func UpdateInterfaceHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	index, err := strconv.Atoi(mux.Vars(r)["if_index"])
+	if err != nil || index < 1 {
+		WriteError(w, http.StatusBadRequest, "invalid ifIndex")
+		return
+	}
+	change := InterfaceChange{}
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid interface json: "+err.Error())
+		return
+	}
+
+	var pdus []gosnmp.SnmpPDU
+	if change.AdminStatus != "" {
+		status := 0
+		for value, name := range ifStatusNames {
+			if name == change.AdminStatus && value <= 3 {
+				status = int(value)
+			}
+		}
+		if status == 0 {
+			WriteError(w, http.StatusBadRequest, "admin_status must be up, down or testing")
+			return
+		}
+		pdus = append(pdus, gosnmp.SnmpPDU{Name: ifEntryOid + "." + strconv.Itoa(ifAdminStatusColumn) + "." + strconv.Itoa(index), Type: gosnmp.Integer, Value: status})
+	}
+	if change.Alias != nil {
+		if len(*change.Alias) > 64 {
+			WriteError(w, http.StatusBadRequest, "alias cannot exceed 64 characters")
+			return
+		}
+		pdus = append(pdus, gosnmp.SnmpPDU{Name: ifXEntryOid + "." + strconv.Itoa(ifAliasColumn) + "." + strconv.Itoa(index), Type: gosnmp.OctetString, Value: *change.Alias})
+	}
+	if len(pdus) == 0 {
+		WriteError(w, http.StatusBadRequest, "Nothing to set")
+		return
+	}
+
+	existing, err := readInterface(g, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if existing == nil {
+		WriteError(w, http.StatusNotFound, "interface does not exist")
+		return
+	}
+	if err := setRow(g, "interface", pdus); err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+
+	iface, err := readInterface(g, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if iface == nil {
+		WriteError(w, http.StatusBadGateway, "interface missing after update")
+		return
+	}
+	WriteJSON(w, http.StatusOK, iface)
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Discovery states
+const (
+	DiscoveryRunning = "running"
+	DiscoveryDone    = "done"
+)
+
+// Bounds of a discovery sweep
+const (
+	maxDiscoveryHosts       = 4096
+	discoveryWorkers        = 32
+	defaultDiscoveryTimeout = time.Second
+)
+
+// sysNameOid - sysName.0, read next to sysObjectID.0 to name discovered agents
+const sysNameOid = ".1.3.6.1.2.1.1.5.0"
+
+// RegisteredTarget - device known to the gateway by name
+//
+// Address is a target as accepted in routes. Profile names the target
+// profile applied to it whatever the patterns of the profiles; ObjectID
+// and Discovered are set by discovery.
+type RegisteredTarget struct {
+	Name       string     `json:"name"`
+	Address    string     `json:"address"`
+	Profile    string     `json:"profile,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	ObjectID   string     `json:"object_id,omitempty"`
+	Discovered *time.Time `json:"discovered,omitempty"`
+}
+
+// HasTags - whether target carries every tag of tags
+func (t *RegisteredTarget) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, own := range t.Tags {
+			if own == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DiscoveryRequest - sweep of a CIDR registering every agent that answers
+//
+// Credentials are resolved as for multi-target requests. Registered
+// targets get profile and tags; timeout bounds the GET of each address.
+type DiscoveryRequest struct {
+	CIDR       string   `json:"cidr"`
+	Version    string   `json:"snmp_version"`
+	Community  string   `json:"community,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+	Profile    string   `json:"profile,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Timeout    string   `json:"timeout,omitempty"`
+}
+
+// Discovery - progress and outcome of a sweep
+type Discovery struct {
+	ID          string           `json:"id"`
+	Status      string           `json:"status"`
+	Request     DiscoveryRequest `json:"request"`
+	RequestedBy string           `json:"requested_by,omitempty"`
+	StartedAt   time.Time        `json:"started_at"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+	Hosts       int              `json:"hosts"`
+	Scanned     int              `json:"scanned"`
+	Responded   int              `json:"responded"`
+	Registered  []string         `json:"registered"`
+}
+
+// Inventory - registered targets, optionally backed by a json file
+type Inventory struct {
+	mu          sync.RWMutex
+	path        string
+	targets     map[string]*RegisteredTarget
+	discoveries map[string]*Discovery
+}
+
+// inventory - targets registered under /api/v1/targets
+var inventory = NewInventory("")
+
+// NewInventory - empty inventory persisted to path if not empty
+func NewInventory(path string) *Inventory {
+	return &Inventory{path: path, targets: map[string]*RegisteredTarget{}, discoveries: map[string]*Discovery{}}
+}
+
+// LoadInventory - inventory from json file, missing file is empty inventory
+func LoadInventory(path string) (*Inventory, error) {
+	inv := NewInventory(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return inv, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*RegisteredTarget
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, t := range list {
+		inv.targets[t.Name] = t
+	}
+	return inv, nil
+}
+
+// save - persist targets, caller holds the lock
+func (inv *Inventory) save() error {
+	if inv.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(inv.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(inv.path, data, 0600)
+}
+
+func (inv *Inventory) list() []*RegisteredTarget {
+	list := make([]*RegisteredTarget, 0, len(inv.targets))
+	for _, t := range inv.targets {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Resolve - address of registered target name, target itself if not registered
+func (inv *Inventory) Resolve(target string) string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	if t, ok := inv.targets[target]; ok {
+		return t.Address
+	}
+	return resolveSimulator(target)
+}
+
+// ResolveAll - Resolve of every target
+func (inv *Inventory) ResolveAll(targets []string) []string {
+	resolved := make([]string, len(targets))
+	for i, target := range targets {
+		resolved[i] = inv.Resolve(target)
+	}
+	return resolved
+}
+
+// WithTags - registered targets carrying every tag of tags
+func (inv *Inventory) WithTags(tags []string) []RegisteredTarget {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var matched []RegisteredTarget
+	for _, t := range inv.list() {
+		if t.HasTags(tags) {
+			matched = append(matched, *t)
+		}
+	}
+	return matched
+}
+
+// ProfileOf - profile name of the registered target at address target, empty if none
+func (inv *Inventory) ProfileOf(target string) string {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	for _, t := range inv.targets {
+		if t.Address == target {
+			return t.Profile
+		}
+	}
+	return ""
+}
+
+// Middleware - replace registered target names in routes by their address
+//
+// Runs before auth so that target restrictions apply to the address.
+func (inv *Inventory) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if target, ok := vars["target"]; ok {
+			if address := inv.Resolve(target); address != target {
+				resolved := make(map[string]string, len(vars))
+				for k, v := range vars {
+					resolved[k] = v
+				}
+				resolved["target"] = address
+				r = mux.SetURLVars(r, resolved)
+				stats.Inc("inventory.resolved")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// register - add or update target found by discovery, returning its name
+//
+// A target already registered at the address keeps its name, profile and
+// tags. New targets are named after sysName unless that name is taken.
+func (inv *Inventory) register(address string, sysName string, objectID string, profile string, tags []string) string {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range inv.targets {
+		if t.Address == address {
+			t.ObjectID = objectID
+			t.Discovered = &now
+			if err := inv.save(); err != nil {
+				logger.Error("saving inventory", Fields{"err": err})
+			}
+			return t.Name
+		}
+	}
+	name := address
+	if _, taken := inv.targets[sysName]; sysName != "" && !taken && validTargetName(sysName, address) == nil {
+		name = sysName
+	}
+	inv.targets[name] = &RegisteredTarget{
+		Name:       name,
+		Address:    address,
+		Profile:    profile,
+		Tags:       tags,
+		ObjectID:   objectID,
+		Discovered: &now,
+	}
+	if err := inv.save(); err != nil {
+		logger.Error("saving inventory", Fields{"err": err})
+	}
+	stats.Inc("inventory.discovered")
+	return name
+}
+
+// validTargetName - names may appear in routes and must not shadow other addresses
+func validTargetName(name string, address string) error {
+	if name == "" || strings.ContainsAny(name, "/?#% ") {
+		return fmt.Errorf("invalid target name %s", name)
+	}
+	if name == SimulatorTarget {
+		return fmt.Errorf("target name %s is reserved for the simulator", name)
+	}
+	if net.ParseIP(strings.Trim(name, "[]")) != nil && name != address {
+		return fmt.Errorf("target named by an ip address must have that address")
+	}
+	return nil
+}
+
+// targetView - registered target with the circuit state of its address, not persisted
+type targetView struct {
+	RegisteredTarget
+	Circuit *CircuitStatus `json:"circuit,omitempty"`
+}
+
+// viewTarget - t with its circuit, omitted while none of its operations timed out recently
+func viewTarget(t RegisteredTarget) targetView {
+	view := targetView{RegisteredTarget: t}
+	if addr, err := ParseTarget(t.Address); err == nil {
+		view.Circuit = breaker.Status(addr.String())
+	}
+	return view
+}
+
+// ListTargetsHandler - registered targets, ?tag= narrows them to those with every tag
+func (inv *Inventory) ListTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	list := inv.WithTags(r.URL.Query()["tag"])
+	views := make([]targetView, len(list))
+	for i, t := range list {
+		views[i] = viewTarget(t)
+	}
+	WriteJSON(w, http.StatusOK, views)
+}
+
+// GetTargetHandler - single registered target
+func (inv *Inventory) GetTargetHandler(w http.ResponseWriter, r *http.Request) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	t, ok := inv.targets[mux.Vars(r)["name"]]
+	if !ok {
+		WriteError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, viewTarget(*t))
+}
+
+// PutTargetHandler - register or replace target
+func (inv *Inventory) PutTargetHandler(w http.ResponseWriter, r *http.Request) {
+	t := &RegisteredTarget{}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid target json")
+		return
+	}
+	t.Name = mux.Vars(r)["name"]
+	addr, err := ParseTarget(t.Address)
+	if err != nil || t.Address == "" {
+		WriteError(w, http.StatusBadRequest, "invalid address")
+		return
+	}
+	t.Address = addr.String()
+	if err := validTargetName(t.Name, t.Address); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if t.Profile != "" {
+		profiles.mu.RLock()
+		_, ok := profiles.profiles[t.Profile]
+		profiles.mu.RUnlock()
+		if !ok {
+			WriteError(w, http.StatusBadRequest, "profile "+t.Profile+" not found")
+			return
+		}
+	}
+	if target, ok := AuthorizeTargets(r, []string{t.Address}); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, other := range inv.targets {
+		if other.Address == t.Address && other.Name != t.Name {
+			WriteError(w, http.StatusConflict, "address already registered as "+other.Name)
+			return
+		}
+	}
+	inv.targets[t.Name] = t
+	if err := inv.save(); err != nil {
+		RequestLogger(r).Error("saving inventory", Fields{"err": err})
+	}
+	WriteJSON(w, http.StatusOK, t)
+}
+
+// DeleteTargetHandler - remove registered target
+func (inv *Inventory) DeleteTargetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if _, ok := inv.targets[name]; !ok {
+		WriteError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	delete(inv.targets, name)
+	if err := inv.save(); err != nil {
+		RequestLogger(r).Error("saving inventory", Fields{"err": err})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cidrHosts - addresses of cidr, without network and broadcast address of IPv4 subnets
+func cidrHosts(cidr string) ([]string, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %s", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 31 || 1<<uint(bits-ones) > maxDiscoveryHosts {
+		return nil, fmt.Errorf("cidr cannot cover more than %d addresses", maxDiscoveryHosts)
+	}
+
+	v4 := ip.To4() != nil
+	count := 1 << uint(bits-ones)
+	hosts := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if v4 && count > 2 && (i == 0 || i == count-1) {
+			continue
+		}
+		addr := make(net.IP, len(network.IP))
+		copy(addr, network.IP)
+		last := addr[len(addr)-4:]
+		binary.BigEndian.PutUint32(last, binary.BigEndian.Uint32(last)+uint32(i))
+		hosts = append(hosts, addr.String())
+	}
+	return hosts, nil
+}
+
+// probeAgent - sysObjectID and sysName of target with the first candidate answering
+func probeAgent(target string, version gosnmp.SnmpVersion, candidates []CandidateCommunity, timeout time.Duration) (string, string, error) {
+	var lastErr error
+	for _, candidate := range candidates {
+		g, err := NewSnmpSession(target, version, candidate.Community)
+		if err != nil {
+			return "", "", err
+		}
+		g.Timeout = timeout
+		g.Retries = 0
+		result, err := ObservedGet(g, []string{probeOid, sysNameOid})
+		g.Conn.Close()
+		if err == nil && result.Error != gosnmp.NoError {
+			err = fmt.Errorf("%v", result.Error)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		objectID, sysName := "", ""
+		for _, pdu := range result.Variables {
+			switch normalizeOid(pdu.Name) {
+			case probeOid:
+				objectID, _ = pdu.Value.(string)
+			case sysNameOid:
+				if pdu.Type == gosnmp.OctetString {
+					sysName = octetString(pdu.Value)
+				}
+			}
+		}
+		return objectID, sysName, nil
+	}
+	return "", "", lastErr
+}
+
+// DiscoverHandler - start a sweep of a CIDR, answered with 202 and the discovery
+//
+// Every address gets one GET of sysObjectID.0 and sysName.0 without
+// retries, at most discoveryWorkers at a time. Agents that answer are
+// registered, see register; progress is read from
+// /api/v1/targets/discoveries/{id}.
+func (inv *Inventory) DiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	request := DiscoveryRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid discovery json")
+		return
+	}
+	if request.Version == "" {
+		request.Version = "v2c"
+	}
+	version, err := ParseSnmpVersion(request.Version)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hosts, err := cidrHosts(request.CIDR)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeout := defaultDiscoveryTimeout
+	if request.Timeout != "" {
+		if timeout, err = time.ParseDuration(request.Timeout); err != nil || timeout <= 0 || timeout > maxRequestTimeout {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("timeout must be a positive duration up to %v", maxRequestTimeout))
+			return
+		}
+	}
+	if request.Profile != "" {
+		profiles.mu.RLock()
+		_, ok := profiles.profiles[request.Profile]
+		profiles.mu.RUnlock()
+		if !ok {
+			WriteError(w, http.StatusBadRequest, "profile "+request.Profile+" not found")
+			return
+		}
+	}
+	if target, ok := AuthorizeTargets(r, hosts); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	// Candidates are resolved up front, the request is gone once the sweep runs
+	candidates := make([][]CandidateCommunity, len(hosts))
+	for i, host := range hosts {
+		if candidates[i], err = BodyCandidates(r.Header["X-Snmp-Comm"], request.Community, request.Credential, host, version); err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(candidates[i]) == 0 {
+			WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+			return
+		}
+	}
+
+	request.Community = ""
+	d := &Discovery{
+		ID:          NewID(),
+		Status:      DiscoveryRunning,
+		Request:     request,
+		RequestedBy: RequestIdentity(r),
+		StartedAt:   time.Now(),
+		Hosts:       len(hosts),
+		Registered:  []string{},
+	}
+	inv.mu.Lock()
+	inv.discoveries[d.ID] = d
+	created := *d
+	inv.mu.Unlock()
+
+	go inv.discover(d, hosts, version, candidates, timeout)
+	stats.Inc("inventory.discoveries")
+
+	w.Header().Set("Location", "/api/v1/targets/discoveries/"+d.ID)
+	WriteJSON(w, http.StatusAccepted, created)
+}
+
+// discover - sweep hosts, recording progress in d
+func (inv *Inventory) discover(d *Discovery, hosts []string, version gosnmp.SnmpVersion, candidates [][]CandidateCommunity, timeout time.Duration) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, discoveryWorkers)
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(host string, candidates []CandidateCommunity) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			objectID, sysName, err := probeAgent(host, version, candidates, timeout)
+			name := ""
+			if err == nil {
+				name = inv.register(host, sysName, objectID, d.Request.Profile, d.Request.Tags)
+			}
+
+			inv.mu.Lock()
+			d.Scanned++
+			if err == nil {
+				d.Responded++
+				d.Registered = append(d.Registered, name)
+			}
+			inv.mu.Unlock()
+		}(host, candidates[i])
+	}
+	wg.Wait()
+
+	inv.mu.Lock()
+	now := time.Now()
+	d.Status = DiscoveryDone
+	d.FinishedAt = &now
+	sort.Strings(d.Registered)
+	inv.mu.Unlock()
+	logger.Info("discovery done", Fields{"discovery": d.ID, "cidr": d.Request.CIDR, "hosts": d.Hosts, "responded": d.Responded})
+}
+
+// GetDiscoveryHandler - progress of a discovery
+func (inv *Inventory) GetDiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+	inv.mu.RLock()
+	d, ok := inv.discoveries[mux.Vars(r)["id"]]
+	var c Discovery
+	if ok {
+		c = *d
+		c.Registered = append([]string(nil), d.Registered...)
+	}
+	inv.mu.RUnlock()
+	if !ok {
+		WriteError(w, http.StatusNotFound, "discovery not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, c)
+}
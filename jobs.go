@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job operations
+const (
+	JobWalk     = "walk"
+	JobBulkWalk = "bulkwalk"
+)
+
+// Largest and default page of job results
+const (
+	maxJobPage     = 10000
+	defaultJobPage = 1000
+)
+
+// errJobCancelled - stops the walk of a cancelled job
+var errJobCancelled = errors.New("job cancelled")
+
+// JobRequest - walk queued with POST /api/v1/jobs
+//
+// Credentials are resolved as for multi-target requests: community or
+// credential of the body, then the X-SNMP-COMM headers, the target
+// profile and the credential store.
+type JobRequest struct {
+	Version        string   `json:"snmp_version"`
+	Target         string   `json:"target"`
+	Community      string   `json:"community,omitempty"`
+	Credential     string   `json:"credential,omitempty"`
+	Operation      string   `json:"operation"`
+	Oids           []string `json:"oids"`
+	MaxRepetitions int      `json:"max_repetitions,omitempty"`
+}
+
+// Job - walk run in the background, results kept until expiry
+type Job struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Request     JobRequest `json:"request"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Count       int        `json:"count"`
+	Error       string     `json:"error,omitempty"`
+
+	version    gosnmp.SnmpVersion
+	oids       []string
+	candidates []CandidateCommunity
+	options    RequestOptions
+	pdus       []gosnmp.SnmpPDU
+	cancelled  bool
+}
+
+// JobPage - job with one page of its results
+type JobPage struct {
+	Job
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	NextOffset *int        `json:"next_offset,omitempty"`
+	Variables  interface{} `json:"variables"`
+}
+
+// JobQueue - walks executed by a fixed pool of workers
+//
+// At most MaxQueued jobs wait for a worker; finished jobs and their
+// results are dropped TTL after they finish.
+type JobQueue struct {
+	Workers   int
+	MaxQueued int
+	TTL       time.Duration
+
+	mu    sync.RWMutex
+	jobs  map[string]*Job
+	queue chan *Job
+}
+
+// jobs - queue of background walks
+var jobs = NewJobQueue()
+
+// NewJobQueue - empty queue
+func NewJobQueue() *JobQueue {
+	return &JobQueue{
+		Workers:   4,
+		MaxQueued: 100,
+		TTL:       time.Hour,
+		jobs:      map[string]*Job{},
+	}
+}
+
+// Run - start workers and expire finished jobs until stop is closed
+func (q *JobQueue) Run(stop <-chan struct{}) {
+	q.mu.Lock()
+	q.queue = make(chan *Job, q.MaxQueued)
+	q.mu.Unlock()
+
+	for i := 0; i < q.Workers; i++ {
+		go q.work(stop)
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			q.expire(now)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (q *JobQueue) work(stop <-chan struct{}) {
+	for {
+		select {
+		case job := <-q.queue:
+			q.execute(job)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// expire - drop jobs finished more than TTL ago
+func (q *JobQueue) expire(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, job := range q.jobs {
+		if job.ExpiresAt != nil && now.After(*job.ExpiresAt) {
+			delete(q.jobs, id)
+			stats.Inc("jobs.expired")
+		}
+	}
+}
+
+// finish - set final status of job, caller holds the lock
+func (q *JobQueue) finish(job *Job, status string, err error) {
+	now := time.Now()
+	expires := now.Add(q.TTL)
+	job.Status = status
+	job.FinishedAt = &now
+	job.ExpiresAt = &expires
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+func (q *JobQueue) execute(job *Job) {
+	q.mu.Lock()
+	if job.Status != JobQueued {
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	q.mu.Unlock()
+
+	err := q.walk(job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case job.cancelled:
+		q.finish(job, JobCancelled, nil)
+	case err != nil:
+		stats.Inc("jobs.failed")
+		q.finish(job, JobFailed, err)
+	default:
+		q.finish(job, JobDone, nil)
+	}
+}
+
+// walk - collect the varbinds under every oid of job
+func (q *JobQueue) walk(job *Job) error {
+	g, _, err := ConnectWithFallback(job.Request.Target, job.version, job.candidates)
+	if err != nil {
+		return err
+	}
+	defer sessions.Put(g)
+	job.options.Apply(g)
+
+	bulk := job.Request.Operation == JobBulkWalk
+	if bulk && job.Request.MaxRepetitions > 0 {
+		g.MaxRepetitions = uint8(job.Request.MaxRepetitions)
+	}
+	for _, oid := range job.oids {
+		err := ObservedWalk(g, oid, bulk, func(pdu gosnmp.SnmpPDU) error {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if job.cancelled {
+				return errJobCancelled
+			}
+			job.pdus = append(job.pdus, pdu)
+			job.Count++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count - number of jobs in status
+func (q *JobQueue) Count(status string) int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	n := 0
+	for _, job := range q.jobs {
+		if job.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// SubmitJobHandler - queue a walk and return its id
+//
+// Timeout and retries are taken from the query or headers as for
+// synchronous requests.
+func (q *JobQueue) SubmitJobHandler(w http.ResponseWriter, r *http.Request) {
+	request := JobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid job json")
+		return
+	}
+	if request.Version == "" {
+		request.Version = "v2c"
+	}
+	version, err := ParseSnmpVersion(request.Version)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.Target == "" || len(request.Oids) == 0 {
+		WriteError(w, http.StatusBadRequest, "target and oids required")
+		return
+	}
+	switch request.Operation {
+	case "":
+		request.Operation = JobWalk
+	case JobWalk, JobBulkWalk:
+	default:
+		WriteError(w, http.StatusBadRequest, "operation must be walk or bulkwalk")
+		return
+	}
+	if request.Operation == JobBulkWalk && version == gosnmp.Version1 {
+		WriteError(w, http.StatusBadRequest, "bulkwalk requires v2c or later")
+		return
+	}
+	if request.MaxRepetitions < 0 || request.MaxRepetitions > 255 {
+		WriteError(w, http.StatusBadRequest, "max_repetitions must be between 1 and 255")
+		return
+	}
+	request.Target = inventory.Resolve(request.Target)
+	if target, ok := AuthorizeTargets(r, []string{request.Target}); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	oids, err := ResolveOids(request.Oids)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	options, err := ParseRequestOptions(r, request.Target)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	candidates, err := BodyCandidates(r.Header["X-Snmp-Comm"], request.Community, request.Credential, request.Target, version)
+	if err == errCredentialNotAllowed {
+		WriteError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(candidates) == 0 {
+		WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+		return
+	}
+	request.Community = ""
+
+	job := &Job{
+		ID:          NewID(),
+		Status:      JobQueued,
+		Request:     request,
+		RequestedBy: RequestIdentity(r),
+		CreatedAt:   time.Now(),
+		version:     version,
+		oids:        oids,
+		candidates:  candidates,
+		options:     options,
+	}
+
+	q.mu.Lock()
+	select {
+	case q.queue <- job:
+		q.jobs[job.ID] = job
+	default:
+		q.mu.Unlock()
+		WriteError(w, http.StatusServiceUnavailable, "job queue full")
+		return
+	}
+	snapshot := *job
+	q.mu.Unlock()
+	stats.Inc("jobs.submitted")
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	WriteJSON(w, http.StatusAccepted, snapshot)
+}
+
+// ListJobsHandler - jobs without results, ?status= filters
+func (q *JobQueue) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	q.mu.RLock()
+	list := []Job{}
+	for _, job := range q.jobs {
+		if status == "" || job.Status == status {
+			list = append(list, *job)
+		}
+	}
+	q.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// GetJobHandler - job status and a page of the results read so far
+//
+// Pages are selected with ?offset= and ?limit=; next_offset is set while
+// more results are available. Results are rendered per format options.
+func (q *JobQueue) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, defaultJobPage
+	if v := r.URL.Query().Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			WriteError(w, http.StatusBadRequest, "offset must not be negative")
+			return
+		}
+		offset = n
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxJobPage {
+			WriteError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxJobPage))
+			return
+		}
+		limit = n
+	}
+
+	q.mu.RLock()
+	job, ok := q.jobs[mux.Vars(r)["id"]]
+	var page JobPage
+	var pdus []gosnmp.SnmpPDU
+	if ok {
+		page = JobPage{Job: *job, Offset: offset, Limit: limit}
+		if offset < len(job.pdus) {
+			end := offset + limit
+			if end > len(job.pdus) {
+				end = len(job.pdus)
+			}
+			// Copied as legacy output sanitizes in place
+			pdus = append(pdus, job.pdus[offset:end]...)
+		}
+	}
+	q.mu.RUnlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	if next := offset + len(pdus); next < page.Count {
+		page.NextOffset = &next
+	}
+	variables, err := formatVariables(r, pdus)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page.Variables = variables
+	WriteJSON(w, http.StatusOK, page)
+}
+
+// DeleteJobHandler - cancel a queued or running job, remove a finished one
+func (q *JobQueue) DeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := mux.Vars(r)["id"]
+	job, ok := q.jobs[id]
+	if !ok {
+		WriteError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	switch job.Status {
+	case JobQueued:
+		q.finish(job, JobCancelled, nil)
+		WriteJSON(w, http.StatusOK, job)
+	case JobRunning:
+		job.cancelled = true
+		WriteJSON(w, http.StatusAccepted, job)
+	default:
+		delete(q.jobs, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Journal entry states
+const (
+	JournalPending = "pending"
+	JournalOK      = "ok"
+	JournalFailed  = "failed"
+)
+
+// JournalEntry - accepted write operation and its outcome
+type JournalEntry struct {
+	ID        string          `json:"id"`
+	Seq       uint64          `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Target    string          `json:"target"`
+	Version   string          `json:"snmp_version"`
+	Operation string          `json:"operation"`
+	Values    [][]interface{} `json:"values"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// journalCompactMin - superseded records kept in the file before compacting
+const journalCompactMin = 1024
+
+// Journal - ordered write-ahead journal of SET operations
+//
+// Every write is appended as pending before it is sent and appended again
+// with its outcome afterwards; when loading, the last record of an id wins.
+// Entries beyond MaxEntries or older than MaxAge, if set, are pruned when
+// a write is recorded. Once the file holds more superseded records than
+// live entries it is rewritten with the live ones.
+type Journal struct {
+	MaxEntries int
+	MaxAge     time.Duration
+
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	seq     uint64
+	dead    int
+	entries map[string]*JournalEntry
+}
+
+// journal - write journal used by all write handlers
+var journal = NewJournal()
+
+// NewJournal - in-memory journal
+func NewJournal() *Journal {
+	return &Journal{entries: map[string]*JournalEntry{}}
+}
+
+// OpenJournal - journal persisted to path, existing records are loaded
+func OpenJournal(path string) (*Journal, error) {
+	j := NewJournal()
+	j.path = path
+
+	if f, err := os.Open(path); err == nil {
+		records := 0
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			entry := &JournalEntry{}
+			if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("parsing %s: %v", path, err)
+			}
+			j.entries[entry.ID] = entry
+			if entry.Seq > j.seq {
+				j.seq = entry.Seq
+			}
+			records++
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		j.dead = records - len(j.entries)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.file = f
+	return j, nil
+}
+
+// Prune - drop entries beyond MaxEntries or older than MaxAge
+func (j *Journal) Prune() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.prune()
+}
+
+// prune - see Prune, caller holds the lock
+func (j *Journal) prune() {
+	if j.MaxEntries <= 0 && j.MaxAge <= 0 {
+		return
+	}
+	excess := 0
+	if j.MaxEntries > 0 && len(j.entries) > j.MaxEntries {
+		excess = len(j.entries) - j.MaxEntries
+	}
+	var cutoff time.Time
+	if j.MaxAge > 0 {
+		cutoff = time.Now().Add(-j.MaxAge)
+	}
+
+	var pruned []*JournalEntry
+	for _, entry := range j.entries {
+		if excess > 0 || entry.Time.Before(cutoff) {
+			pruned = append(pruned, entry)
+		}
+	}
+	if len(pruned) == 0 {
+		return
+	}
+	sort.Slice(pruned, func(a, b int) bool { return pruned[a].Seq < pruned[b].Seq })
+	for i, entry := range pruned {
+		if i >= excess && !entry.Time.Before(cutoff) {
+			break
+		}
+		delete(j.entries, entry.ID)
+		j.dead++
+		stats.Inc("journal.pruned")
+	}
+	if j.dead >= journalCompactMin && j.dead > len(j.entries) {
+		j.compact()
+	}
+}
+
+// compact - rewrite the file with the live entries, caller holds the lock
+func (j *Journal) compact() {
+	if j.file == nil {
+		return
+	}
+	entries := make([]*JournalEntry, 0, len(j.entries))
+	for _, entry := range j.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+
+	tmp := j.path + ".tmp"
+	err := func() error {
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		w := bufio.NewWriter(f)
+		for _, entry := range entries {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				f.Close()
+				return err
+			}
+			w.Write(append(line, '\n'))
+		}
+		if err := w.Flush(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp, j.path)
+	}()
+	if err != nil {
+		os.Remove(tmp)
+		logger.Error("compacting journal", Fields{"err": err})
+		return
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.Error("reopening journal", Fields{"err": err})
+		return
+	}
+	j.file.Close()
+	j.file = f
+	j.dead = 0
+}
+
+// append - persist entry, caller holds the lock
+func (j *Journal) append(entry *JournalEntry) {
+	if j.file == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = j.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		logger.Error("writing journal", Fields{"err": err})
+	}
+}
+
+// Record - append pending write before it is sent, returns entry id
+func (j *Journal) Record(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	entry := &JournalEntry{
+		ID:        NewID(),
+		Seq:       j.seq,
+		Time:      time.Now(),
+		Target:    SessionTarget(g),
+		Version:   VersionLabel(g.Version),
+		Operation: operation,
+		Values:    PDUsToValues(pdus),
+		Status:    JournalPending,
+	}
+	j.entries[entry.ID] = entry
+	j.append(entry)
+	j.prune()
+	return entry.ID
+}
+
+// Complete - append outcome of a recorded write
+func (j *Journal) Complete(id string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.entries[id]
+	if !ok {
+		return
+	}
+	completed := *entry
+	completed.Status = JournalOK
+	if err != nil {
+		completed.Status = JournalFailed
+		completed.Error = err.Error()
+	}
+	j.entries[id] = &completed
+	j.append(&completed)
+	j.dead++
+}
+
+// Get - single journal entry
+func (j *Journal) Get(id string) (JournalEntry, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	entry, ok := j.entries[id]
+	if !ok {
+		return JournalEntry{}, false
+	}
+	return *entry, true
+}
+
+// Entries - entries in journal order matching filter
+func (j *Journal) Entries(match func(JournalEntry) bool) []JournalEntry {
+	j.mu.RLock()
+	entries := []JournalEntry{}
+	for _, entry := range j.entries {
+		if match(*entry) {
+			entries = append(entries, *entry)
+		}
+	}
+	j.mu.RUnlock()
+
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
+
+// Len - number of journal entries
+func (j *Journal) Len() int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return len(j.entries)
+}
+
+// JournaledSet - snmpset recorded in the write journal
+//
+// An error status in the response is recorded as failure, the result is
+// returned unchanged for the caller to report. In a dry run nothing is
+// sent or journaled, see DryRun. Writes the write policy denies fail
+// with a PolicyError, dry runs included.
+func JournaledSet(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	if err := policies.CheckedSet(g, operation, pdus); err != nil {
+		return nil, err
+	}
+	if d := dryRunOf(g); d != nil {
+		return d.Set(g, operation, pdus)
+	}
+	_, result, err, _ := journal.set(g, operation, pdus)
+	return result, err
+}
+
+// set - snmpset recorded as entry id, completed with its outcome
+//
+// failed is the error the entry is completed with: err of the exchange
+// or the error status of result, see NewPacketError.
+func (j *Journal) set(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) (id string, result *gosnmp.SnmpPacket, err, failed error) {
+	id = j.Record(g, operation, pdus)
+	result, err = AuditedSet(g, operation, id, pdus)
+	cache.Invalidate(SessionTarget(g), pdus)
+	failed = err
+	if perr := NewPacketError("Set", result); err == nil && perr != nil {
+		failed = perr
+	}
+	if failed != nil {
+		stats.Inc("snmp.set.failed")
+	} else {
+		stats.Inc("snmp.set.ok")
+	}
+	j.Complete(id, failed)
+	return id, result, err, failed
+}
+
+// VersionLabel - route label of gosnmp version
+func VersionLabel(v gosnmp.SnmpVersion) string {
+	switch v {
+	case gosnmp.Version1:
+		return "v1"
+	case gosnmp.Version3:
+		return "v3"
+	}
+	return "v2c"
+}
+
+// PDUsToValues - pdus as [oid, type, value] triplets accepted by ToSnmpPDU
+func PDUsToValues(pdus []gosnmp.SnmpPDU) [][]interface{} {
+	values := make([][]interface{}, len(pdus))
+	for i, pdu := range pdus {
+		var typeString string
+		value := pdu.Value
+		switch pdu.Type {
+		case gosnmp.Integer:
+			typeString = "i"
+		case gosnmp.Uinteger32:
+			typeString = "u"
+		case gosnmp.Gauge32:
+			typeString = "g"
+		case gosnmp.Counter32:
+			typeString = "c"
+		case gosnmp.Counter64:
+			typeString = "C"
+			value = gosnmp.ToBigInt(value).String()
+		case gosnmp.TimeTicks:
+			typeString = "t"
+		case gosnmp.IPAddress:
+			typeString = "a"
+		case gosnmp.ObjectIdentifier:
+			typeString = "o"
+		case gosnmp.BitString:
+			typeString = "b"
+		case gosnmp.Opaque:
+			typeString = "q"
+			value = hex.EncodeToString([]byte(octetString(value)))
+		case gosnmp.Null:
+			typeString = "n"
+		default:
+			typeString = "s"
+			value = octetString(value)
+			if !utf8.ValidString(value.(string)) {
+				typeString = "x"
+				value = hex.EncodeToString([]byte(value.(string)))
+			}
+		}
+		switch typeString {
+		case "i", "u", "g", "c", "t":
+			f, _ := new(big.Float).SetInt(gosnmp.ToBigInt(value)).Float64()
+			value = f
+		}
+		values[i] = []interface{}{pdu.Name, typeString, value}
+	}
+	return values
+}
+
+// ReplayRequest - journal entries to reapply
+type ReplayRequest struct {
+	IDs     []string `json:"ids"`
+	Target  string   `json:"target"`
+	Version string   `json:"snmp_version"`
+}
+
+// ReplayResult - outcome of reapplying one journal entry
+type ReplayResult struct {
+	ID        string `json:"id"`
+	JournalID string `json:"journal_id,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ListJournalHandler - journal entries, filtered by target, status, operation and since
+func (j *Journal) ListJournalHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			WriteError(w, http.StatusBadRequest, "since must be RFC3339")
+			return
+		}
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	entries := j.Entries(func(e JournalEntry) bool {
+		return (q.Get("target") == "" || q.Get("target") == e.Target) &&
+			(q.Get("status") == "" || q.Get("status") == e.Status) &&
+			(q.Get("operation") == "" || q.Get("operation") == e.Operation) &&
+			!e.Time.Before(since)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	WriteJSON(w, http.StatusOK, entries)
+}
+
+// GetJournalHandler - single journal entry
+func (j *Journal) GetJournalHandler(w http.ResponseWriter, r *http.Request) {
+	entry, ok := j.Get(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "journal entry not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, entry)
+}
+
+// ReplayJournalHandler - reapply selected entries in journal order
+//
+// Entries are sent to their original target unless target is given; the
+// community is taken from X-SNMP-COMM as secrets are never journaled.
+func (j *Journal) ReplayJournalHandler(w http.ResponseWriter, r *http.Request) {
+	request := ReplayRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.IDs) == 0 {
+		WriteError(w, http.StatusBadRequest, "ids missing")
+		return
+	}
+	community := r.Header.Get("X-SNMP-COMM")
+	if community == "" {
+		WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+		return
+	}
+
+	selected := map[string]bool{}
+	for _, id := range request.IDs {
+		if _, ok := j.Get(id); !ok {
+			WriteError(w, http.StatusNotFound, "journal entry "+id+" not found")
+			return
+		}
+		selected[id] = true
+	}
+	entries := j.Entries(func(e JournalEntry) bool { return selected[e.ID] })
+	targets := []string{request.Target}
+	if request.Target == "" {
+		targets = targets[:0]
+		for _, entry := range entries {
+			targets = append(targets, entry.Target)
+		}
+	}
+	if target, ok := AuthorizeTargets(r, targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+
+	status := http.StatusOK
+	results := make([]ReplayResult, len(entries))
+	for i, entry := range entries {
+		results[i] = j.replay(r, entry, request, community)
+		if results[i].Status != JournalOK {
+			status = http.StatusMultiStatus
+		}
+	}
+	WriteJSON(w, status, results)
+}
+
+func (j *Journal) replay(r *http.Request, entry JournalEntry, request ReplayRequest, community string) ReplayResult {
+	result := ReplayResult{ID: entry.ID, Status: JournalFailed}
+
+	target, versionLabel := entry.Target, entry.Version
+	if request.Target != "" {
+		target = request.Target
+	}
+	if request.Version != "" {
+		versionLabel = request.Version
+	}
+	version, err := ParseSnmpVersion(versionLabel)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	pdus, err := ValuesToPDUs(entry.Values)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	g, err := sessions.Get(target, version, community)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer sessions.Put(g)
+
+	defer BindSession(g, r)()
+
+	if err := policies.CheckedSet(g, "replay:"+entry.Operation, pdus); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	var failed error
+	result.JournalID, _, _, failed = j.set(g, "replay:"+entry.Operation, pdus)
+	if failed != nil {
+		result.Error = failed.Error()
+		return result
+	}
+	result.Status = JournalOK
+	return result
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/soniah/gosnmp"
+	"github.com/urfave/negroni"
+)
+
+// Log levels, each including the ones after it
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+var logLevels = map[string]int32{LevelDebug: 0, LevelInfo: 1, LevelWarn: 2, LevelError: 3}
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Fields - key values of a log entry
+type Fields map[string]interface{}
+
+// logOutput - destination, format and level shared by a logger and the loggers derived from it
+type logOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	json  bool
+	level int32
+}
+
+// Logger - leveled logger writing one key=value line or json object per entry
+//
+// Entries carry the fields of the logger, e.g. the request_id of
+// RequestLogger, followed by their own.
+type Logger struct {
+	out    *logOutput
+	fields Fields
+}
+
+// logger - logger of the gateway, configured by -log-level and -log-format
+var logger = NewLogger(os.Stderr)
+
+// NewLogger - text logger of info and above writing to w
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{out: &logOutput{w: w, level: logLevels[LevelInfo]}}
+}
+
+// With - logger adding fields to every entry, sharing level and output with l
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{out: l.out, fields: merged}
+}
+
+// SetLevel - log entries of level and above only
+func (l *Logger) SetLevel(level string) error {
+	n, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("log level must be debug, info, warn or error")
+	}
+	atomic.StoreInt32(&l.out.level, n)
+	return nil
+}
+
+// Level - lowest level logged
+func (l *Logger) Level() string {
+	n := atomic.LoadInt32(&l.out.level)
+	for level, m := range logLevels {
+		if m == n {
+			return level
+		}
+	}
+	return LevelInfo
+}
+
+// SetFormat - write entries as text or json
+func (l *Logger) SetFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("log format must be text or json")
+	}
+	l.out.mu.Lock()
+	l.out.json = format == LogFormatJSON
+	l.out.mu.Unlock()
+	return nil
+}
+
+// Enabled - whether entries of level are logged
+func (l *Logger) Enabled(level string) bool {
+	return logLevels[level] >= atomic.LoadInt32(&l.out.level)
+}
+
+// Debug - log debug entry
+func (l *Logger) Debug(msg string, fields Fields) { l.log(LevelDebug, msg, fields) }
+
+// Info - log info entry
+func (l *Logger) Info(msg string, fields Fields) { l.log(LevelInfo, msg, fields) }
+
+// Warn - log warning entry
+func (l *Logger) Warn(msg string, fields Fields) { l.log(LevelWarn, msg, fields) }
+
+// Error - log error entry
+func (l *Logger) Error(msg string, fields Fields) { l.log(LevelError, msg, fields) }
+
+// Fatal - log error entry and exit
+func (l *Logger) Fatal(msg string, fields Fields) {
+	l.log(LevelError, msg, fields)
+	os.Exit(1)
+}
+
+// log - write entry of level unless below the level of l
+//
+// Errors are logged by their message.
+func (l *Logger) log(level string, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	entry := Fields{}
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	if l.out.json {
+		entry["time"] = now
+		entry["level"] = level
+		entry["msg"] = msg
+		line, err := json.Marshal(entry)
+		if err != nil {
+			line, _ = json.Marshal(Fields{"time": now, "level": LevelError, "msg": "encoding log entry", "err": err.Error()})
+		}
+		l.out.w.Write(append(line, '\n'))
+		return
+	}
+
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString("time=" + now + " level=" + level + " msg=" + logfmtValue(msg))
+	for _, k := range keys {
+		b.WriteString(" " + k + "=" + logfmtValue(entry[k]))
+	}
+	b.WriteByte('\n')
+	io.WriteString(l.out.w, b.String())
+}
+
+// logfmtValue - value quoted when it has spaces, quotes or equal signs
+func logfmtValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// RequestIDKey - type of context key defined for request ids
+type RequestIDKey string
+
+// RequestIDKeyName - keyname defined for context
+const RequestIDKeyName RequestIDKey = "REQUEST_ID"
+
+// RequestIDHeader - header carrying the request id, accepted from callers and set on every response
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern - request ids accepted from callers
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID - id of request, empty for requests not received over http
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDKeyName).(string)
+	return id
+}
+
+// RequestLogger - logger adding the request id of r to every entry
+func RequestLogger(r *http.Request) *Logger {
+	if r == nil {
+		return logger
+	}
+	if id := RequestID(r); id != "" {
+		return logger.With(Fields{"request_id": id})
+	}
+	return logger
+}
+
+// ResponseLogger - logger adding the request id set on w by RequestLogMiddleware
+//
+// For helpers writing responses without the request at hand.
+func ResponseLogger(w http.ResponseWriter) *Logger {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return logger.With(Fields{"request_id": id})
+	}
+	return logger
+}
+
+// SessionLogger - logger of the request session g is bound to, see BindSession
+func SessionLogger(g *gosnmp.GoSNMP) *Logger {
+	return RequestLogger(SessionRequest(g)).With(Fields{"target": SessionTarget(g)})
+}
+
+// RequestLogMiddleware - assign a request id and log every request once served
+//
+// The X-Request-ID of the caller is kept when it is a plain token of at
+// most 64 characters, otherwise a new id is generated. The id is set on
+// the response before any handler runs, so error responses carry it too.
+func RequestLogMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = NewID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	r = r.WithContext(context.WithValue(r.Context(), RequestIDKeyName, id))
+
+	start := time.Now()
+	next(w, r)
+
+	status := http.StatusOK
+	if rw, ok := w.(negroni.ResponseWriter); ok && rw.Status() != 0 {
+		status = rw.Status()
+	}
+	level := LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = LevelWarn
+	}
+	RequestLogger(r).log(level, "request", Fields{
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"status":      status,
+		"duration_ms": millis(time.Since(start)),
+		"remote":      r.RemoteAddr,
+	})
+}
+
+// LogLevel - body of the log level endpoint
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+// GetLogLevelHandler - current log level
+func GetLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, LogLevel{Level: logger.Level()})
+}
+
+// PutLogLevelHandler - change the log level until the next restart
+func PutLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var body LogLevel
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid log level json")
+		return
+	}
+	from := logger.Level()
+	if err := logger.SetLevel(body.Level); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RequestLogger(r).Info("log level changed", Fields{"from": from, "to": body.Level, "by": RequestIdentity(r)})
+	WriteJSON(w, http.StatusOK, LogLevel{Level: body.Level})
+}
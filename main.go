@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,22 +16,52 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/soniah/gosnmp"
 	"github.com/urfave/negroni"
+	"google.golang.org/grpc"
 )
 
 // OidList - oids
 type OidList struct {
-	Oids []string `json:"oids"`
+	Oids     []string               `json:"oids"`
+	Defaults map[string]interface{} `json:"defaults"`
 }
 
-// GetFieldsRequest - set value maps
+// GetFieldsRequest - fields read at every index, see MatrixHandler
 type GetFieldsRequest struct {
-	Indexes []string `json:"indexes"`
-	Fields  []string `json:"fields"`
+	Indexes  []string               `json:"indexes"`
+	Fields   []string               `json:"fields"`
+	Defaults map[string]interface{} `json:"defaults"`
 }
 
 // SetEntryRequest - set value maps
+//
+// values holds positional [oid, type, value, expected] arrays and is
+// deprecated in favour of varbinds; both may be given.
 type SetEntryRequest struct {
-	Values [][]interface{} `json:"values"`
+	Values   [][]interface{} `json:"values,omitempty"`
+	Varbinds []SetVarbind    `json:"varbinds,omitempty"`
+}
+
+// SetVarbind - value to write, oid relative to the route as for values
+//
+// With expected set the write only happens if the current value matches,
+// see ExpectedValues.
+type SetVarbind struct {
+	Oid      string      `json:"oid"`
+	Type     string      `json:"type"`
+	Value    interface{} `json:"value"`
+	Expected interface{} `json:"expected,omitempty"`
+}
+
+// VarbindValues - varbinds as positional value arrays
+func VarbindValues(varbinds []SetVarbind) [][]interface{} {
+	values := make([][]interface{}, len(varbinds))
+	for i, v := range varbinds {
+		values[i] = []interface{}{v.Oid, v.Type, v.Value}
+		if v.Expected != nil {
+			values[i] = append(values[i], v.Expected)
+		}
+	}
+	return values
 }
 
 // SNMPKey - key defining SNMP context key
@@ -39,14 +70,18 @@ type SNMPKey string
 // SNMPKeyName - keyname defined for context
 const SNMPKeyName SNMPKey = "SNMP"
 
-// GetHandler - snmpget
+// GetHandler - snmpget, oids ending in ".*" are expanded by a walk
+//
+// Output is rendered according to FormatOptions. Oids without value,
+// failed or answered with an exception, make it a 207 partial result;
+// with ?strict=true they fail the request, see WriteStrictError.
 func GetHandler(w http.ResponseWriter, r *http.Request) {
 	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
-	defer g.Conn.Close()
 
 	vars := mux.Vars(r)
 
 	var oids []string
+	var defaults map[string]interface{}
 	var oidlist OidList
 	if oid, ok := vars["oid"]; ok {
 
@@ -56,29 +91,22 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 		} else {
 			// Request for combination of fields and indexes
 			fieldsRequest := GetFieldsRequest{}
-			err := json.NewDecoder(r.Body).Decode(&fieldsRequest)
-			if err != nil {
-				log.Printf("[ERR] decoding request json")
-			}
-			fields := fieldsRequest.Fields
-			indexes := fieldsRequest.Indexes
-			numIndexes := len(indexes)
-
-			oids = make([]string, len(fields)*len(indexes))
-			for i, index := range indexes {
-				for j, foid := range fields {
-					oids[i*(numIndexes+1)+j] = oid + "." + foid + "." + index
-				}
+			if err := json.NewDecoder(r.Body).Decode(&fieldsRequest); err != nil {
+				WriteError(w, http.StatusBadRequest, "invalid request json: "+err.Error())
+				return
 			}
+			getMatrix(w, r, g, oid, fieldsRequest)
+			return
 		}
 	} else if baseOid, ok := vars["base_oid"]; ok {
 		index := vars["index"]
 		fieldsRequest := GetFieldsRequest{}
-		err := json.NewDecoder(r.Body).Decode(&fieldsRequest)
-		if err != nil {
-			log.Printf("[ERR] decoding request json")
+		if err := json.NewDecoder(r.Body).Decode(&fieldsRequest); err != nil && err != io.EOF {
+			WriteError(w, http.StatusBadRequest, "invalid request json: "+err.Error())
+			return
 		}
 		fields := fieldsRequest.Fields
+		defaults = fieldsRequest.Defaults
 
 		oids = make([]string, len(fields))
 		for i, foid := range fields {
@@ -86,258 +114,900 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		if err := json.NewDecoder(r.Body).Decode(&oidlist); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_, err := w.Write([]byte("oids missing"))
-			if err != nil {
-				log.Printf("[ERR] http write error")
+			if err == io.EOF {
+				WriteError(w, http.StatusBadRequest, "oids missing")
+			} else {
+				WriteError(w, http.StatusBadRequest, "invalid request json: "+err.Error())
 			}
 			return
 		}
 		oids = oidlist.Oids
+		defaults = oidlist.Defaults
 	}
 
 	if len(oids) <= 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_, err := w.Write([]byte("Nothing to get"))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
+		WriteError(w, http.StatusBadRequest, "Nothing to get")
 		return
 	}
 
-	result, err := g.Get(oids)
+	oids, err := ResolveOids(oids)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := LimitsForTarget(SessionTarget(g)).CheckGet(g, PlainOids(oids)); err != nil {
+		WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(SanitizeResultVariables(&result.Variables))
+	var variables []gosnmp.SnmpPDU
+	var failed []VarbindError
+	if cache.Enabled() && len(PlainOids(oids)) == len(oids) {
+		var hit bool
+		variables, failed, hit, err = cache.Get(g, oids, CacheBypass(r))
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	} else {
+		variables, failed, err = GetWithWildcards(g, oids)
+	}
 	if err != nil {
-		log.Printf("[ERR] encoding json")
+		WriteSnmpError(w, err)
+		return
+	}
+
+	ApplyDefaults(variables, defaults)
+	if missing, ok := allMissing(variables); ok && len(failed) == 0 {
+		WriteNoSuchError(w, missing)
+		return
+	}
+	failed = append(failed, missingVarbinds(variables)...)
+	if len(failed) > 0 && r.URL.Query().Get("strict") == "true" {
+		WriteStrictError(w, failed)
+		return
 	}
+	if len(failed) > 0 {
+		RenderPartial(w, r, variables, failed)
+		return
+	}
+	RenderVariables(w, r, variables)
 }
 
-// WalkHandler - snmpwalk
+// WalkHandler - snmpwalk, output rendered according to FormatOptions
+//
+// v2c sessions walk with GETBULK unless ?bulk=false; max_repetitions
+// overrides the negotiated value. ?stream=true writes varbinds as they
+// arrive, see StreamWalk. max_results, max_depth, time_budget and next
+// bound the walk, see ScopedWalk; where it stopped early is returned in
+// the X-Walk-Next and X-Walk-Truncated headers.
 func WalkHandler(w http.ResponseWriter, r *http.Request) {
 	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
-	defer g.Conn.Close()
 
+	walk(w, r, g, g.Version != gosnmp.Version1 && r.URL.Query().Get("bulk") != "false")
+}
+
+// BulkWalkHandler - snmpbulkwalk, output rendered according to FormatOptions
+func BulkWalkHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	if g.Version == gosnmp.Version1 {
+		WriteError(w, http.StatusBadRequest, "GETBULK requires v2c or later")
+		return
+	}
+	walk(w, r, g, true)
+}
+
+func walk(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP, bulk bool) {
 	vars := mux.Vars(r)
-	rootOid := vars["base_oid"]
+	rootOid, err := ResolveOid(vars["base_oid"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	result, err := g.WalkAll(rootOid)
+	maxReps, _, err := BulkParams(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Printf("[ERR] http write error")
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scope, err := ParseWalkScope(r, rootOid)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("stream") == "true" {
+		if r.URL.Query().Get("as") == "table" {
+			WriteError(w, http.StatusBadRequest, "tables cannot be streamed")
+			return
+		}
+		if bulk && maxReps > 0 {
+			g.MaxRepetitions = uint8(maxReps)
 		}
+		StreamWalk(w, r, g, rootOid, bulk, scope)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(SanitizeResultVariables(&result))
+	var result []gosnmp.SnmpPDU
+	if scope.Scoped() {
+		var cursor WalkCursor
+		cursor, err = ScopedWalk(g, rootOid, bulk, maxReps, scope, func(pdu gosnmp.SnmpPDU) error {
+			result = append(result, pdu)
+			return nil
+		})
+		if cursor.Truncated != "" {
+			w.Header().Set("X-Walk-Truncated", cursor.Truncated)
+		}
+		if cursor.Next != "" {
+			w.Header().Set("X-Walk-Next", cursor.Next)
+		}
+	} else {
+		result, err = walkAll(g, rootOid, bulk, maxReps)
+	}
 	if err != nil {
-		log.Printf("[ERR] encoding json")
+		WriteSnmpError(w, err)
+		return
 	}
+
+	if r.URL.Query().Get("as") == "table" {
+		RenderTable(w, r, rootOid, result)
+		return
+	}
+	RenderVariables(w, r, result)
+}
+
+// walkAll - every varbind under rootOid, with GETBULK of maxReps if bulk is set
+func walkAll(g *gosnmp.GoSNMP, rootOid string, bulk bool, maxReps int) ([]gosnmp.SnmpPDU, error) {
+	if !bulk {
+		return ObservedWalkAll(g, rootOid, false)
+	}
+	if maxReps > 0 {
+		g.MaxRepetitions = uint8(maxReps)
+	}
+	return ObservedWalkAll(g, rootOid, true)
 }
 
 // SetHandler - snmpset
 func SetHandler(w http.ResponseWriter, r *http.Request) {
 	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
-	defer g.Conn.Close()
 
 	vars := mux.Vars(r)
 	request := SetEntryRequest{}
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		log.Printf("[ERR] request body json decode")
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid request json: "+err.Error())
+		return
 	}
+	request.Values = append(request.Values, VarbindValues(request.Varbinds)...)
 	baseOid := vars["base_oid"]
 	index := vars["index"]
 
-	var pdus []gosnmp.SnmpPDU
-
-	// Adding Entry
-	if r.Method == http.MethodPost {
-		pdus = make([]gosnmp.SnmpPDU, len(request.Values)+1)
-		rowOid := vars["row_oid"]
-		rowOidArr := strings.Split(rowOid, ".")
-		rowFieldOid := rowOidArr[len(rowOidArr)-1]
-		baseOid = strings.Join(rowOidArr[:len(rowOidArr)-1], ".")
+	// Adding Entry, see rowStatusColumn; tables defined under
+	// /api/v1/tables are better created with CreateRowHandler
+	var rowStatus []gosnmp.SnmpPDU
+	if _, ok := vars["row_oid"]; ok && r.Method == http.MethodPost {
+		column, err := rowStatusColumn(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		baseOid = column[:strings.LastIndex(column, ".")]
+		rowStatus = []gosnmp.SnmpPDU{{Name: column + "." + index, Type: gosnmp.Integer, Value: RowStatusCreateAndGo}}
+	}
 
-		pdus[0] = ToSnmpPDU(
-			baseOid+"."+rowFieldOid+"."+index, "i", 4.0)
+	// Oids of the values are relative to the base oid and index of the route
+	values := make([][]interface{}, len(request.Values))
+	for i, val := range request.Values {
+		values[i] = append([]interface{}(nil), val...)
+		if len(val) == 0 || baseOid == "" {
+			continue
+		}
+		if oid, ok := val[0].(string); ok && index != "" {
+			values[i][0] = baseOid + "." + oid + "." + index
+		} else if ok {
+			values[i][0] = baseOid + "." + oid
+		}
+	}
+	pdus, err := ValuesToPDUs(values)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pdus = append(rowStatus, pdus...)
 
-		for i, val := range request.Values {
-			fieldOid := val[0].(string)
-			fieldType := val[1]
-			fieldValue := val[2]
+	if err := LimitsForTarget(SessionTarget(g)).Check(g, gosnmp.SetRequest, pdus); err != nil {
+		WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
 
-			pdus[i+1] = ToSnmpPDU(baseOid+"."+fieldOid+"."+index, fieldType, fieldValue)
+	// Compare-and-set: values carrying a fourth element are only written
+	// if every expected current value matches
+	if r.Method != http.MethodPost {
+		expected, err := ExpectedValues(request.Values, pdus)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
 		}
-	} else {
-		pdus = make([]gosnmp.SnmpPDU, len(request.Values))
-		if baseOid == "" {
-			for i, val := range request.Values {
-				oid := val[0].(string)
-				fieldType := val[1]
-				fieldValue := val[2]
-
-				pdus[i] = ToSnmpPDU(oid, fieldType, fieldValue)
-			}
-		} else if index == "" {
-			for i, val := range request.Values {
-				oidSuffix := val[0]
-				fieldType := val[1]
-				fieldValue := val[2]
-
-				pdus[i] = ToSnmpPDU(
-					baseOid+"."+oidSuffix.(string), fieldType, fieldValue)
+		if len(expected) > 0 {
+			mismatches, actual, err := CompareValues(g, expected)
+			if err != nil {
+				WriteSnmpError(w, err)
+				return
 			}
-		} else {
-			for i, val := range request.Values {
-				fieldOid := val[0].(string)
-				fieldType := val[1]
-				fieldValue := val[2]
-
-				pdus[i] = ToSnmpPDU(
-					baseOid+"."+fieldOid+"."+index,
-					fieldType, fieldValue)
+			if len(mismatches) > 0 {
+				variables, err := formatVariables(r, actual)
+				if err != nil {
+					WriteError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				WriteJSON(w, http.StatusConflict, SetConflict{
+					Error:      "current values do not match expected values",
+					Mismatches: mismatches,
+					Actual:     variables,
+				})
+				return
 			}
 		}
 	}
 
-	result, err := g.Set(pdus)
+	operation := "set"
+	if rowStatus != nil {
+		operation = "create"
+	}
+	result, err := JournaledSet(g, operation, pdus)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
+		WriteSnmpError(w, err)
 		return
 	}
-	if result.ErrorIndex != 0 {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Set error: %v, Index: %v", result.Error, result.ErrorIndex)
+	if err := NewPacketError("Set", result); err != nil {
+		WriteSnmpError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(SanitizeResultVariables(&result.Variables))
+	RenderVariables(w, r, result.Variables)
+}
+
+// ExistsHandler - 204 if the oid instance exists, 404 otherwise
+//
+// No value is returned; noSuchInstance, noSuchObject and the v1
+// noSuchName error all mean the instance does not exist.
+func ExistsHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	oid, err := ResolveOid(mux.Vars(r)["oid"])
 	if err != nil {
-		log.Printf("[ERR] encoding json")
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := ObservedGet(g, []string{oid})
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if result.Error == gosnmp.NoSuchName || len(result.Variables) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := NewPacketError("Get", result); err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	switch result.Variables[0].Type {
+	case gosnmp.NoSuchInstance, gosnmp.NoSuchObject, gosnmp.EndOfMibView:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// DeleteHandler - snmpset with row delete
-func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+// RowStatus values written by the row handlers
+const (
+	RowStatusActive       = 1
+	RowStatusNotInService = 2
+	RowStatusDestroy      = 6
+)
+
+// rowStatusColumn - RowStatus column of a table
+//
+// Without ?rowstatus_column= the row oid is taken to be the RowStatus
+// column itself, otherwise it is the table entry and the given column
+// holds the RowStatus.
+func rowStatusColumn(r *http.Request) (string, error) {
+	rowOid, err := ResolveOid(mux.Vars(r)["row_oid"])
+	if err != nil {
+		return "", err
+	}
+	if column := r.URL.Query().Get("rowstatus_column"); column != "" {
+		if _, err := ParseOid(column); err != nil {
+			return "", fmt.Errorf("invalid rowstatus_column %s", column)
+		}
+		rowOid += "." + column
+	}
+	return rowOid, nil
+}
+
+// rowStatusOid - RowStatus instance of a row
+func rowStatusOid(r *http.Request) (string, error) {
+	column, err := rowStatusColumn(r)
+	if err != nil {
+		return "", err
+	}
+	return column + "." + mux.Vars(r)["index"], nil
+}
+
+// setRowStatus - write RowStatus of an existing row
+func setRowStatus(w http.ResponseWriter, r *http.Request, status int, operation string) bool {
 	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
-	defer g.Conn.Close()
 
-	vars := mux.Vars(r)
-	rowOid := vars["row_oid"]
-	index := vars["index"]
-	oid := rowOid + "." + index
-	log.Println(oid)
+	oid, err := rowStatusOid(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	RequestLogger(r).Debug("row status", Fields{"oid": oid, "status": status})
 
 	pdus := []gosnmp.SnmpPDU{
 		gosnmp.SnmpPDU{
 			Name:  oid,
 			Type:  gosnmp.Integer,
-			Value: 6,
+			Value: status,
 		},
 	}
 
-	getr, err := g.Get([]string{oid})
+	getr, err := ObservedGet(g, []string{oid})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
-		return
+		WriteSnmpError(w, err)
+		return false
 	}
 	gpdus := getr.Variables
-	log.Println(gpdus)
 	// Does not exist
-	if gpdus[0].Type != gosnmp.Integer {
-		w.WriteHeader(http.StatusNotFound)
-		_, err := w.Write([]byte("Entry does not exist"))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
-		return
+	if len(gpdus) == 0 || gpdus[0].Type != gosnmp.Integer {
+		WriteError(w, http.StatusNotFound, "Entry does not exist")
+		return false
 	}
 
-	result, err := g.Set(pdus)
+	result, err := JournaledSet(g, operation, pdus)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, err := w.Write([]byte(err.Error()))
-		if err != nil {
-			log.Printf("[ERR] http write error")
-		}
-		return
+		WriteSnmpError(w, err)
+		return false
 	}
-	if result.ErrorIndex != 0 {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "Set error: %v, Index: %v", result.Error, result.ErrorIndex)
+	if err := NewPacketError("Set", result); err != nil {
+		WriteSnmpError(w, err)
+		return false
 	}
+	return true
+}
 
+// DeleteHandler - snmpset with row delete
+//
+// ?mode=soft sets the row notInService instead of destroying it.
+func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	status, operation, err := deleteMode(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !setRowStatus(w, r, status, operation) {
+		return
+	}
+	if status == RowStatusNotInService {
+		fmt.Fprint(w, "Entry set notInService")
+		return
+	}
 	fmt.Fprint(w, "Entry deleted successfully")
 }
 
-const (
-	addr = "0.0.0.0:8161"
-)
+// ActivateHandler - snmpset of RowStatus active, e.g. after a soft delete
+func ActivateHandler(w http.ResponseWriter, r *http.Request) {
+	if setRowStatus(w, r, RowStatusActive, "activate") {
+		fmt.Fprint(w, "Entry activated successfully")
+	}
+}
 
 func main() {
 	var wait time.Duration
+	var driftInterval time.Duration
+	var profilesPath string
+	var inventoryPath string
+	var journalPath string
+	var journalMaxEntries int
+	var journalMaxAge time.Duration
+	var auditPath, auditSyslog string
+	var trapListen string
+	var grpcListen string
+	var trapBuffer int
+	var trapCommunities string
+	var mibDir string
+	var cacheRules string
+	var tablesPath string
+	var maxInFlight int
+	var configPath string
+	var credentialsPath string
+	var credentialsKey string
+	var authPath string
+	var logLevel, logFormat string
+	var corsOrigins, corsMethodList, corsHeaderList string
+	var policyPath string
+	var transformsPath string
+	var readOnly bool
+	var simulatePath, simulateListen, simulateCommunity, simulateWriteCommunity string
+	approvals := NewApprovals()
+	scheduler := NewScheduler()
+	flag.StringVar(&configPath, "config", os.Getenv(configEnvName("config")), "json file with settings keyed by flag name, overridden by REST_SNMP_* environment variables and flags")
+	flag.StringVar(&serverConfig.Listen, "listen", "0.0.0.0:8161", "address the http server listens on")
+	flag.StringVar(&serverConfig.TLSCert, "tls-cert", "", "certificate file, serves https together with -tls-key")
+	flag.StringVar(&serverConfig.TLSKey, "tls-key", "", "private key file of -tls-cert")
+	flag.DurationVar(&serverConfig.ReadTimeout, "read-timeout", time.Second*15, "maximum duration for reading an http request")
+	flag.DurationVar(&serverConfig.WriteTimeout, "write-timeout", time.Second*15, "maximum duration for writing an http response")
+	flag.DurationVar(&serverConfig.IdleTimeout, "idle-timeout", time.Second*60, "time idle keep-alive connections are kept open")
+	flag.DurationVar(&gosnmp.Default.Timeout, "snmp-timeout", gosnmp.Default.Timeout, "default timeout of snmp requests")
+	flag.IntVar(&gosnmp.Default.Retries, "snmp-retries", gosnmp.Default.Retries, "default number of snmp retries")
 	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	flag.DurationVar(&driftInterval, "drift-interval", time.Minute*5, "interval between configuration drift checks, 0 disables background checks")
+	flag.IntVar(&serverLimits.MaxOids, "max-oids", gosnmp.MaxOids, "maximum number of varbinds in a single snmp request")
+	flag.IntVar(&serverLimits.MaxMsgSize, "max-msg-size", 0, "maximum encoded snmp request size in bytes, 0 for no limit")
+	flag.StringVar(&profilesPath, "profiles", "", "json file with per target profiles")
+	flag.StringVar(&inventoryPath, "inventory", "", "json file with registered targets")
+	flag.StringVar(&tablesPath, "tables", "", "json file with table definitions used by the row endpoints")
+	flag.StringVar(&credentialsPath, "credentials", "", "json file of the credential store, in memory only if empty")
+	flag.StringVar(&credentialsKey, "credentials-key", "", "passphrase the credential store file is encrypted with, plain json if empty")
+	flag.StringVar(&authPath, "auth-file", "", "json file with api keys and jwt settings, authentication is disabled without keys or jwt secret")
+	flag.StringVar(&auth.JWT.Secret, "jwt-secret", "", "HS256 secret of accepted bearer tokens")
+	flag.StringVar(&auth.JWT.Issuer, "jwt-issuer", "", "required iss claim of bearer tokens")
+	flag.StringVar(&auth.JWT.Audience, "jwt-audience", "", "required aud claim of bearer tokens")
+	flag.StringVar(&journalPath, "journal", "", "file the write journal is persisted to, in memory only if empty")
+	flag.IntVar(&journalMaxEntries, "journal-max-entries", 10000, "journal entries kept, oldest pruned first, 0 for no limit")
+	flag.DurationVar(&journalMaxAge, "journal-max-age", 0, "age after which journal entries are pruned, 0 to keep them")
+	flag.StringVar(&auditPath, "audit-log", "", "file every write is audited to as json lines")
+	flag.StringVar(&auditSyslog, "audit-syslog", "", "syslog every write is audited to: local, udp://host:port or tcp://host:port")
+	flag.BoolVar(&approvals.Enabled, "require-approval", false, "hold DELETE and multi-device writes until approved by a second identity")
+	flag.DurationVar(&approvals.TTL, "approval-ttl", time.Hour*24, "time after which unapproved changes expire")
+	flag.DurationVar(&approvals.Retention, "approval-retention", time.Hour*24*7, "time decided and expired changes are kept, 0 to keep them")
+	flag.StringVar(&grpcListen, "grpc-listen", "", "address the grpc server listens on, e.g. :9161, disabled if empty")
+	flag.StringVar(&trapListen, "trap-listen", "", "udp address to receive traps and informs on, e.g. :162, disabled if empty")
+	flag.IntVar(&trapBuffer, "trap-buffer", 1000, "number of received traps kept for /api/v1/traps")
+	flag.StringVar(&trapCommunities, "trap-communities", "", "comma separated communities traps are accepted with, any if empty")
+	flag.IntVar(&sessions.MaxSessions, "max-sessions", 256, "maximum number of open snmp sessions, 0 for no limit")
+	flag.DurationVar(&sessions.IdleTimeout, "session-idle-timeout", time.Minute*2, "time after which idle snmp sessions are closed")
+	flag.DurationVar(&maxRequestTimeout, "max-request-timeout", time.Second*30, "largest snmp timeout a request may ask for with ?timeout")
+	flag.IntVar(&maxRequestRetries, "max-request-retries", 5, "largest number of snmp retries a request may ask for with ?retries")
+	flag.IntVar(&jobs.Workers, "job-workers", 4, "number of background walk jobs run concurrently")
+	flag.IntVar(&jobs.MaxQueued, "job-queue", 100, "maximum number of walk jobs waiting for a worker")
+	flag.DurationVar(&jobs.TTL, "job-ttl", time.Hour, "time finished walk jobs and their results are kept")
+	flag.IntVar(&poller.Workers, "poll-workers", 8, "number of scheduled polls run concurrently")
+	flag.IntVar(&poller.History, "poll-history", 100, "default number of samples kept per poll")
+	flag.DurationVar(&cache.DefaultTTL, "cache-ttl", 0, "time GET results are cached, 0 caches only oids of -cache-rules")
+	flag.StringVar(&cacheRules, "cache-rules", "", "comma separated oid=ttl cache times of oid subtrees, e.g. sysDescr=1h,ifName=10m")
+	flag.IntVar(&cache.MaxEntries, "cache-size", 10000, "maximum number of cached varbinds")
+	flag.Float64Var(&serverLimits.RateLimit, "rate-limit", 0, "snmp operations per second allowed per target, 0 for no limit, overridden by profiles")
+	flag.IntVar(&serverLimits.RateBurst, "rate-burst", 0, "snmp operations a target may receive at once, -rate-limit rounded up if 0")
+	flag.IntVar(&breaker.Threshold, "circuit-threshold", breaker.Threshold, "consecutive timeouts after which operations on a target fail at once until it answers a probe again, 0 disables")
+	flag.DurationVar(&breaker.ProbeInterval, "circuit-probe-interval", breaker.ProbeInterval, "time between probes of targets with an open circuit")
+	flag.IntVar(&maxInFlight, "max-inflight", 0, "maximum number of snmp operations running at once, 0 for no limit")
+	flag.StringVar(&readiness.CanaryTarget, "ready-canary", "", "target a GET must succeed on for /readyz, credentials from profiles or the credential store, disabled if empty")
+	flag.StringVar(&readiness.CanaryOid, "ready-canary-oid", readiness.CanaryOid, "oid read from -ready-canary")
+	flag.StringVar(&readiness.CanaryVersion, "ready-canary-version", readiness.CanaryVersion, "snmp version used with -ready-canary")
+	flag.DurationVar(&readiness.CanaryInterval, "ready-canary-interval", readiness.CanaryInterval, "time a -ready-canary result is reused")
+	flag.IntVar(&multiWorkers, "multi-workers", 32, "maximum number of targets a multi-target request queries concurrently")
+	flag.StringVar(&logLevel, "log-level", LevelInfo, "lowest level logged: debug, info, warn or error, changed at runtime with /api/v1/admin/log-level")
+	flag.StringVar(&logFormat, "log-format", LogFormatText, "log entries as key=value text or json lines")
+	flag.StringVar(&corsOrigins, "cors-origins", "", "comma separated origins browsers may call the api from, e.g. https://dash.example.com, https://*.example.com or *, cors disabled if empty")
+	flag.StringVar(&corsMethodList, "cors-methods", strings.Join(corsMethods, ","), "comma separated methods allowed in cors preflights")
+	flag.StringVar(&corsHeaderList, "cors-headers", strings.Join(corsHeaders, ","), "comma separated request headers allowed in cors preflights")
+	flag.DurationVar(&cors.MaxAge, "cors-max-age", cors.MaxAge, "time browsers may cache cors preflight results")
+	flag.StringVar(&policyPath, "write-policy", "", "json file with allow and deny rules of oid prefixes and targets every SET is checked against, all writes allowed if empty")
+	flag.StringVar(&transformsPath, "transforms", "", "json file with user defined result transforms and the transforms routes apply by default, builtins only if empty")
+	flag.BoolVar(&readOnly, "read-only", false, "deny every write, changed at runtime with /api/v1/admin/write-policy")
+	flag.StringVar(&simulatePath, "simulate", "", "snmprec or .json fixture served by an embedded v1/v2c agent reached as target "+SimulatorTarget+", disabled if empty")
+	flag.StringVar(&simulateListen, "simulate-listen", "127.0.0.1:0", "udp address of the -simulate agent, a free port if 0")
+	flag.StringVar(&simulateCommunity, "simulate-community", "public", "read community of the -simulate agent")
+	flag.StringVar(&simulateWriteCommunity, "simulate-write-community", "private", "read-write community of the -simulate agent, used for "+SimulatorTarget+" without X-SNMP-COMM")
+	flag.StringVar(&mibDir, "mib-dir", "", "comma separated directories of MIB files whose names are accepted in oids and shown with ?mib=true")
 	flag.Parse()
 
+	if err := LoadConfig(flag.CommandLine, configPath); err != nil {
+		logger.Fatal("cannot load configuration", Fields{"err": err})
+	}
+	if err := logger.SetLevel(logLevel); err != nil {
+		logger.Fatal("invalid configuration", Fields{"err": err})
+	}
+	if err := logger.SetFormat(logFormat); err != nil {
+		logger.Fatal("invalid configuration", Fields{"err": err})
+	}
+	cors.AllowedOrigins = splitList(corsOrigins)
+	cors.AllowedMethods = splitList(corsMethodList)
+	cors.AllowedHeaders = splitList(corsHeaderList)
+	if err := serverConfig.Validate(); err != nil {
+		logger.Fatal("invalid configuration", Fields{"err": err})
+	}
+	if gosnmp.Default.Timeout <= 0 || gosnmp.Default.Retries < 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "snmp-timeout must be positive and snmp-retries not negative"})
+	}
+	if trapBuffer < 1 || multiWorkers < 1 || sessions.MaxSessions < 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "trap-buffer and multi-workers must be positive, max-sessions not negative"})
+	}
+	if serverLimits.RateLimit < 0 || serverLimits.RateBurst < 0 || maxInFlight < 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "rate-limit, rate-burst and max-inflight must not be negative"})
+	}
+	limiter = NewTargetLimiter(maxInFlight)
+	if breaker.Threshold < 0 || breaker.ProbeInterval <= 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "circuit-threshold must not be negative and circuit-probe-interval positive"})
+	}
+	if jobs.Workers < 1 || jobs.MaxQueued < 1 || jobs.TTL <= 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "job-workers, job-queue and job-ttl must be positive"})
+	}
+	if poller.Workers < 1 || poller.History < 1 || poller.History > maxPollHistory {
+		logger.Fatal("invalid configuration", Fields{"err": fmt.Sprintf("poll-workers must be positive and poll-history between 1 and %d", maxPollHistory)})
+	}
+	if _, err := ParseSnmpVersion(readiness.CanaryVersion); err != nil || readiness.CanaryInterval < 0 {
+		logger.Fatal("invalid configuration", Fields{"err": "ready-canary-version must be v1, v2c or v3 and ready-canary-interval not negative"})
+	}
+
+	if profilesPath != "" {
+		var err error
+		if profiles, err = LoadProfiles(profilesPath); err != nil {
+			logger.Fatal("cannot load profiles", Fields{"err": err})
+		}
+	}
+	if inventoryPath != "" {
+		var err error
+		if inventory, err = LoadInventory(inventoryPath); err != nil {
+			logger.Fatal("cannot load inventory", Fields{"err": err})
+		}
+	}
+	if tablesPath != "" {
+		var err error
+		if tables, err = LoadTables(tablesPath); err != nil {
+			logger.Fatal("cannot load tables", Fields{"err": err})
+		}
+	}
+	if authPath != "" {
+		loaded, err := LoadAuth(authPath)
+		if err != nil {
+			logger.Fatal("cannot load auth settings", Fields{"err": err})
+		}
+		auth.APIKeys = loaded.APIKeys
+		if auth.JWT.Secret == "" {
+			auth.JWT = loaded.JWT
+		}
+	}
+	if !auth.Enabled() {
+		logger.Warn("authentication disabled, configure -auth-file or -jwt-secret", nil)
+		if approvals.Enabled {
+			logger.Warn("changes held by -require-approval cannot be decided without authentication", nil)
+		}
+	}
+	if credentialsPath != "" {
+		var err error
+		if credentials, err = LoadCredentials(credentialsPath, credentialsKey); err != nil {
+			logger.Fatal("cannot load credentials", Fields{"err": err})
+		}
+	}
+	if journalPath != "" {
+		var err error
+		if journal, err = OpenJournal(journalPath); err != nil {
+			logger.Fatal("cannot open journal", Fields{"err": err})
+		}
+	}
+	journal.MaxEntries = journalMaxEntries
+	journal.MaxAge = journalMaxAge
+	journal.Prune()
+	if auditPath != "" || auditSyslog != "" {
+		var err error
+		if audit, err = OpenAuditLog(auditPath, auditSyslog); err != nil {
+			logger.Fatal("cannot open audit log", Fields{"err": err})
+		}
+	}
+
+	for _, dir := range strings.Split(mibDir, ",") {
+		if dir == "" {
+			continue
+		}
+		n, err := LoadMibDir(dir)
+		if err != nil {
+			logger.Fatal("cannot load mibs", Fields{"err": err})
+		}
+		logger.Info("loaded mib objects", Fields{"objects": n, "dir": dir})
+	}
+	if policyPath != "" {
+		var err error
+		if policies, err = LoadPolicies(policyPath); err != nil {
+			logger.Fatal("cannot load write policy", Fields{"err": err})
+		}
+	}
+	if transformsPath != "" {
+		var err error
+		if transforms, err = LoadTransforms(transformsPath); err != nil {
+			logger.Fatal("cannot load transforms", Fields{"err": err})
+		}
+	}
+	if readOnly {
+		policies.SetReadOnly(true)
+	}
+	if policies.ReadOnly() {
+		logger.Warn("gateway is read-only, every write is denied", nil)
+	}
+	if rules, err := ParseCacheRules(cacheRules); err != nil {
+		logger.Fatal("invalid configuration", Fields{"err": err})
+	} else {
+		cache.SetRules(rules)
+	}
+	if cache.DefaultTTL < 0 || cache.MaxEntries < 1 {
+		logger.Fatal("invalid configuration", Fields{"err": "cache-ttl must not be negative and cache-size must be positive"})
+	}
+
+	stop := make(chan struct{})
+
+	if simulatePath != "" {
+		pdus, err := LoadFixture(simulatePath)
+		if err != nil {
+			logger.Fatal("cannot load simulator fixture", Fields{"err": err})
+		}
+		if simulator, err = NewSimulator(pdus); err != nil {
+			logger.Fatal("cannot load simulator fixture", Fields{"err": err})
+		}
+		simulator.ReadCommunity, simulator.WriteCommunity = simulateCommunity, simulateWriteCommunity
+		if err := simulator.Start(simulateListen, stop); err != nil {
+			logger.Fatal("cannot start simulator", Fields{"listen": simulateListen, "err": err})
+		}
+		logger.Info("simulating agent", Fields{"target": SimulatorTarget, "address": simulator.Address(), "objects": simulator.Len(), "fixture": simulatePath})
+	}
+
 	r := mux.NewRouter()
 
+	r.HandleFunc("/api/v1/snmp/{snmp_version}/multi", MultiHandler).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/v1/snmp/probe/{target}", ProbeHandler).Methods(http.MethodGet)
 	snmprouter := r.PathPrefix("/api/v1/snmp/{snmp_version}/{target}").Subrouter()
 
+	snmprouter.Handle("/tables/{table}/rows", AddSnmpContext(ListRowsHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/tables/{table}/rows/{index}", AddSnmpContext(GetRowHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/tables/{table}/rows/{index}", scheduler.Schedule(AddSnmpContext(CreateRowHandler))).Methods(http.MethodPost)
+	snmprouter.Handle("/tables/{table}/rows/{index}", scheduler.Schedule(AddSnmpContext(UpdateRowHandler))).Methods(http.MethodPatch)
+	snmprouter.Handle("/tables/{table}/rows/{index}", approvals.Require(AddSnmpContext(DeleteRowHandler))).Methods(http.MethodDelete)
+	snmprouter.Handle("/tables/{table}/rows/{index}/activate", AddSnmpContext(ActivateRowHandler)).Methods(http.MethodPost)
+
+	snmprouter.Handle("/system", AddSnmpContext(SystemHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/interfaces", AddSnmpContext(ListInterfacesHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/interfaces/{if_index}", AddSnmpContext(GetInterfaceHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/interfaces/{if_index}", scheduler.Schedule(AddSnmpContext(UpdateInterfaceHandler))).Methods(http.MethodPut)
+
+	snmprouter.Handle("/exists/{oid}", AddSnmpContext(ExistsHandler)).Methods(http.MethodGet, http.MethodHead)
+	snmprouter.Handle("/get", AddSnmpContext(GetHandler)).Methods(http.MethodPost)
+	snmprouter.Handle("/getbulk", AddSnmpContext(GetBulkHandler)).Methods(http.MethodPost)
+	snmprouter.Handle("/set", scheduler.Schedule(AddSnmpContext(SetHandler))).Methods(http.MethodPost)
+	snmprouter.Handle("/{base_oid}/walk", AddSnmpContext(WalkHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/{base_oid}/bulkwalk", AddSnmpContext(BulkWalkHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/walk/{base_oid}", AddSnmpContext(WalkHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/bulkwalk/{base_oid}", AddSnmpContext(BulkWalkHandler)).Methods(http.MethodGet)
 	snmprouter.Handle("", AddSnmpContext(GetHandler)).Methods(http.MethodGet)
 	snmprouter.Handle("/{oid}", AddSnmpContext(GetHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/{base_oid}/table", AddSnmpContext(TableHandler)).Methods(http.MethodGet)
+	snmprouter.Handle("/{base_oid}/matrix", AddSnmpContext(MatrixHandler)).Methods(http.MethodPost)
 	snmprouter.Handle("/{base_oid}/{index}", AddSnmpContext(GetHandler)).Methods(http.MethodGet)
 
-	snmprouter.Handle("/{base_oid}", AddSnmpContext(WalkHandler)).Methods("WALK")
+	// Custom methods predate the routes above and are kept for a
+	// deprecation window
+	snmprouter.Handle("/{base_oid}", Deprecated("/walk", AddSnmpContext(WalkHandler))).Methods("WALK")
+	snmprouter.Handle("/{base_oid}", Deprecated("/bulkwalk", AddSnmpContext(BulkWalkHandler))).Methods("BULKWALK")
+	snmprouter.Handle("", Deprecated("/getbulk", AddSnmpContext(GetBulkHandler))).Methods("GETBULK")
+
+	snmprouter.Handle("", Deprecated("/set", scheduler.Schedule(AddSnmpContext(SetHandler)))).Methods("SET")
+	snmprouter.Handle("/sequence", scheduler.Schedule(AddSnmpContext(SequenceHandler))).Methods(http.MethodPost)
+	snmprouter.Handle("/transaction", approvals.Require(scheduler.Schedule(AddSnmpContext(TransactionHandler)))).Methods(http.MethodPost)
+	snmprouter.Handle("/{base_oid}", scheduler.Schedule(AddSnmpContext(SetHandler))).Methods(http.MethodPut)
+	snmprouter.Handle("/{base_oid}/{index}", scheduler.Schedule(AddSnmpContext(SetHandler))).Methods(http.MethodPut)
+	snmprouter.Handle("/{row_oid}/{index}", scheduler.Schedule(AddSnmpContext(SetHandler))).Methods(http.MethodPost)
+
+	snmprouter.Handle("/{row_oid}/{index}", approvals.Require(AddSnmpContext(DeleteHandler))).Methods(http.MethodDelete)
+	snmprouter.Handle("/{row_oid}", approvals.Require(AddSnmpContext(BulkDeleteHandler))).Methods(http.MethodDelete)
+	snmprouter.Handle("/{row_oid}/{index}/activate", AddSnmpContext(ActivateHandler)).Methods(http.MethodPost)
 
-	snmprouter.Handle("", AddSnmpContext(SetHandler)).Methods("SET")
-	snmprouter.Handle("/{base_oid}", AddSnmpContext(SetHandler)).Methods(http.MethodPut)
-	snmprouter.Handle("/{base_oid}/{index}", AddSnmpContext(SetHandler)).Methods(http.MethodPut)
-	snmprouter.Handle("/{row_oid}/{index}", AddSnmpContext(SetHandler)).Methods(http.MethodPost)
+	r.Handle("/api/v1/groups/{snmp_version}/set", approvals.Require(scheduler.Schedule(http.HandlerFunc(GroupSetHandler)))).Methods(http.MethodPost)
 
-	snmprouter.Handle("/{row_oid}/{index}", AddSnmpContext(DeleteHandler)).Methods(http.MethodDelete)
+	approvals.Handler = r
+	changerouter := r.PathPrefix("/api/v1/changes").Subrouter()
+	changerouter.HandleFunc("", approvals.ListChangesHandler).Methods(http.MethodGet)
+	changerouter.HandleFunc("/{id}", approvals.GetChangeHandler).Methods(http.MethodGet)
+	changerouter.HandleFunc("/{id}/approve", approvals.ApproveChangeHandler).Methods(http.MethodPost)
+	changerouter.HandleFunc("/{id}/reject", approvals.RejectChangeHandler).Methods(http.MethodPost)
 
-	nr := negroni.Classic()
+	scheduler.Handler = r
+	schedulerouter := r.PathPrefix("/api/v1/scheduled").Subrouter()
+	schedulerouter.HandleFunc("", scheduler.ListScheduledHandler).Methods(http.MethodGet)
+	schedulerouter.HandleFunc("/{id}", scheduler.GetScheduledHandler).Methods(http.MethodGet)
+	schedulerouter.HandleFunc("/{id}", scheduler.CancelScheduledHandler).Methods(http.MethodDelete)
+
+	windowrouter := r.PathPrefix("/api/v1/maintenance-windows").Subrouter()
+	windowrouter.HandleFunc("", scheduler.ListWindowsHandler).Methods(http.MethodGet)
+	windowrouter.HandleFunc("/{name}", scheduler.PutWindowHandler).Methods(http.MethodPut)
+	windowrouter.HandleFunc("/{name}", scheduler.DeleteWindowHandler).Methods(http.MethodDelete)
+	go scheduler.Run(stop)
+
+	jobrouter := r.PathPrefix("/api/v1/jobs").Subrouter()
+	jobrouter.HandleFunc("", jobs.ListJobsHandler).Methods(http.MethodGet)
+	jobrouter.HandleFunc("", jobs.SubmitJobHandler).Methods(http.MethodPost)
+	jobrouter.HandleFunc("/{id}", jobs.GetJobHandler).Methods(http.MethodGet)
+	jobrouter.HandleFunc("/{id}", jobs.DeleteJobHandler).Methods(http.MethodDelete)
+	go jobs.Run(stop)
+	go cache.Run(stop)
+	go limiter.Run(stop)
+	go breaker.Run(stop)
+
+	pollrouter := r.PathPrefix("/api/v1/polls").Subrouter()
+	pollrouter.HandleFunc("", poller.ListPollsHandler).Methods(http.MethodGet)
+	pollrouter.HandleFunc("", poller.CreatePollHandler).Methods(http.MethodPost)
+	pollrouter.HandleFunc("/{id}", poller.GetPollHandler).Methods(http.MethodGet)
+	pollrouter.HandleFunc("/{id}", poller.DeletePollHandler).Methods(http.MethodDelete)
+	pollrouter.HandleFunc("/{id}/latest", poller.LatestHandler).Methods(http.MethodGet)
+	pollrouter.HandleFunc("/{id}/history", poller.HistoryHandler).Methods(http.MethodGet)
+	go poller.Run(stop)
+
+	alertrouter := r.PathPrefix("/api/v1/alerts").Subrouter()
+	alertrouter.HandleFunc("", alerts.ListRulesHandler).Methods(http.MethodGet)
+	alertrouter.HandleFunc("", alerts.CreateRuleHandler).Methods(http.MethodPost)
+	alertrouter.HandleFunc("/active", alerts.ActiveAlertsHandler).Methods(http.MethodGet)
+	alertrouter.HandleFunc("/{id}", alerts.GetRuleHandler).Methods(http.MethodGet)
+	alertrouter.HandleFunc("/{id}", alerts.DeleteRuleHandler).Methods(http.MethodDelete)
+
+	go sessions.Run(stop)
+	stats.Gauge("pool.open", func() float64 { open, _, _ := sessions.Counts(); return float64(open) })
+	stats.Gauge("pool.in_use", func() float64 { _, inUse, _ := sessions.Counts(); return float64(inUse) })
+	stats.Gauge("pool.idle", func() float64 { _, _, idle := sessions.Counts(); return float64(idle) })
+	stats.Ratio("pool.hit_ratio", "pool.hits", "pool.misses")
+	stats.Gauge("scheduler.queue_depth", func() float64 { return float64(scheduler.Count(ScheduleQueued)) })
+	stats.Gauge("approvals.pending", func() float64 { return float64(approvals.Count(ChangePending)) })
+	stats.Gauge("journal.entries", func() float64 { return float64(journal.Len()) })
+	stats.Gauge("jobs.queued", func() float64 { return float64(jobs.Count(JobQueued)) })
+	stats.Gauge("jobs.running", func() float64 { return float64(jobs.Count(JobRunning)) })
+	stats.Gauge("polls.registered", func() float64 { return float64(poller.Count()) })
+	stats.Gauge("snmp.inflight", func() float64 { return float64(limiter.InFlight()) })
+	stats.Gauge("circuit.open", func() float64 { return float64(breaker.OpenCount()) })
+	stats.Gauge("cache.entries", func() float64 { return float64(cache.Len()) })
+	stats.Ratio("cache.hit_ratio", "cache.hits", "cache.misses")
+	stats.Gauge("group.workers.capacity", func() float64 { return groupWorkers })
+	r.HandleFunc("/api/v1/stats", stats.StatsHandler).Methods(http.MethodGet)
+	metrics.GaugeFunc("snmp_sessions_open", "Open snmp sessions.", func() float64 { open, _, _ := sessions.Counts(); return float64(open) })
+	metrics.GaugeFunc("snmp_sessions_in_use", "Snmp sessions checked out by requests.", func() float64 { _, inUse, _ := sessions.Counts(); return float64(inUse) })
+	metrics.GaugeFunc("snmp_circuits_open", "Targets whose circuit is open after consecutive timeouts.", func() float64 { return float64(breaker.OpenCount()) })
+	r.HandleFunc("/metrics", metrics.MetricsHandler).Methods(http.MethodGet)
+	r.Use(metrics.Middleware)
+	r.Use(inventory.Middleware)
+	r.Use(auth.Middleware)
+	r.Use(policies.Middleware)
+	r.Use(DryRunMiddleware)
+
+	tablerouter := r.PathPrefix("/api/v1/tables").Subrouter()
+	tablerouter.HandleFunc("", tables.ListTablesHandler).Methods(http.MethodGet)
+	tablerouter.HandleFunc("/{name}", tables.GetTableHandler).Methods(http.MethodGet)
+	tablerouter.HandleFunc("/{name}", tables.PutTableHandler).Methods(http.MethodPut)
+	tablerouter.HandleFunc("/{name}", tables.DeleteTableHandler).Methods(http.MethodDelete)
+
+	profilerouter := r.PathPrefix("/api/v1/profiles").Subrouter()
+	profilerouter.HandleFunc("", profiles.ListProfilesHandler).Methods(http.MethodGet)
+	profilerouter.HandleFunc("/{name}", profiles.GetProfileHandler).Methods(http.MethodGet)
+	profilerouter.HandleFunc("/{name}", profiles.PutProfileHandler).Methods(http.MethodPut)
+	profilerouter.HandleFunc("/{name}", profiles.DeleteProfileHandler).Methods(http.MethodDelete)
+
+	traps := NewTrapReceiver(trapBuffer)
+	if trapCommunities != "" {
+		traps.Communities = strings.Split(trapCommunities, ",")
+	}
+	traprouter := r.PathPrefix("/api/v1/traps").Subrouter()
+	traprouter.HandleFunc("", traps.ListTrapsHandler).Methods(http.MethodGet)
+	traprouter.HandleFunc("/webhooks", traps.ListTrapWebhooksHandler).Methods(http.MethodGet)
+	traprouter.HandleFunc("/webhooks", traps.CreateTrapWebhookHandler).Methods(http.MethodPost)
+	traprouter.HandleFunc("/webhooks/{id}", traps.DeleteTrapWebhookHandler).Methods(http.MethodDelete)
+
+	// Streams end before the write timeout would cut them off
+	events.MaxDuration = serverConfig.WriteTimeout * 9 / 10
+	r.HandleFunc("/api/v1/stream", events.StreamHandler).Methods(http.MethodGet)
+	if trapListen != "" {
+		readiness.Traps = traps
+		go func() {
+			if err := traps.Listen(trapListen, stop); err != nil {
+				logger.Fatal("cannot listen for traps", Fields{"listen": trapListen, "err": err})
+			}
+		}()
+		logger.Info("receiving traps", Fields{"listen": trapListen})
+	}
+
+	metricRules := NewMetricRules()
+	rulerouter := r.PathPrefix("/api/v1/metric-rules").Subrouter()
+	rulerouter.HandleFunc("", metricRules.ListRulesHandler).Methods(http.MethodGet)
+	rulerouter.HandleFunc("/{name}", metricRules.GetRuleHandler).Methods(http.MethodGet)
+	rulerouter.HandleFunc("/{name}", metricRules.PutRuleHandler).Methods(http.MethodPut)
+	rulerouter.HandleFunc("/{name}", metricRules.DeleteRuleHandler).Methods(http.MethodDelete)
+	r.Handle("/api/v1/scrape/{snmp_version}/{target}", AddSnmpContext(metricRules.ScrapeHandler)).Methods(http.MethodGet)
+
+	r.HandleFunc("/api/v1/admin/log-level", GetLogLevelHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/admin/log-level", PutLogLevelHandler).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/admin/write-policy", policies.GetPolicyHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/admin/write-policy", policies.PutPolicyHandler).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/admin/transforms", transforms.GetTransformsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/admin/transforms", transforms.PutTransformsHandler).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/mibs/translate", TranslateHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/openapi.json", OpenAPIHandler(r)).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", HealthzHandler).Methods(http.MethodGet, http.MethodHead)
+	r.HandleFunc("/readyz", readiness.ReadyzHandler).Methods(http.MethodGet, http.MethodHead)
+
+	credentialrouter := r.PathPrefix("/api/v1/credentials").Subrouter()
+	credentialrouter.HandleFunc("", credentials.ListCredentialsHandler).Methods(http.MethodGet)
+	credentialrouter.HandleFunc("/{name}", credentials.GetCredentialHandler).Methods(http.MethodGet)
+	credentialrouter.HandleFunc("/{name}", credentials.PutCredentialHandler).Methods(http.MethodPut)
+	credentialrouter.HandleFunc("/{name}", credentials.DeleteCredentialHandler).Methods(http.MethodDelete)
+
+	targetrouter := r.PathPrefix("/api/v1/targets").Subrouter()
+	targetrouter.HandleFunc("", inventory.ListTargetsHandler).Methods(http.MethodGet)
+	targetrouter.HandleFunc("/discover", inventory.DiscoverHandler).Methods(http.MethodPost)
+	targetrouter.HandleFunc("/discoveries/{id}", inventory.GetDiscoveryHandler).Methods(http.MethodGet)
+	targetrouter.HandleFunc("/circuits", breaker.ListCircuitsHandler).Methods(http.MethodGet)
+	targetrouter.HandleFunc("/{name}", inventory.GetTargetHandler).Methods(http.MethodGet)
+	targetrouter.HandleFunc("/{name}", inventory.PutTargetHandler).Methods(http.MethodPut)
+	targetrouter.HandleFunc("/{name}", inventory.DeleteTargetHandler).Methods(http.MethodDelete)
+	targetrouter.HandleFunc("/{name}/validate", ValidateTargetHandler).Methods(http.MethodPost)
+
+	journalrouter := r.PathPrefix("/api/v1/journal").Subrouter()
+	journalrouter.HandleFunc("", journal.ListJournalHandler).Methods(http.MethodGet)
+	journalrouter.Handle("/replay", approvals.Require(http.HandlerFunc(journal.ReplayJournalHandler))).Methods(http.MethodPost)
+	journalrouter.HandleFunc("/{id}", journal.GetJournalHandler).Methods(http.MethodGet)
+
+	drift := NewDriftDetector()
+	driftrouter := r.PathPrefix("/api/v1/drift/policies").Subrouter()
+	driftrouter.HandleFunc("", drift.ListPoliciesHandler).Methods(http.MethodGet)
+	driftrouter.HandleFunc("", drift.CreatePolicyHandler).Methods(http.MethodPost)
+	driftrouter.HandleFunc("/{id}", drift.GetPolicyHandler).Methods(http.MethodGet)
+	driftrouter.HandleFunc("/{id}", drift.DeletePolicyHandler).Methods(http.MethodDelete)
+	driftrouter.HandleFunc("/{id}/report", drift.ReportHandler).Methods(http.MethodGet)
+	driftrouter.Handle("/{id}/remediate", approvals.Require(http.HandlerFunc(drift.RemediateHandler))).Methods(http.MethodPost)
+	if driftInterval > 0 {
+		go drift.Run(driftInterval, stop)
+	}
+
+	nr := negroni.New(negroni.HandlerFunc(RequestLogMiddleware), negroni.HandlerFunc(cors.Middleware), negroni.NewRecovery(), negroni.NewStatic(http.Dir("public")))
 	nr.UseHandler(r)
 
 	srv := &http.Server{
-		Addr: addr,
+		Addr: serverConfig.Listen,
 		// Good practice to set timeouts to avoid Slowloris attacks.
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		WriteTimeout: serverConfig.WriteTimeout,
+		ReadTimeout:  serverConfig.ReadTimeout,
+		IdleTimeout:  serverConfig.IdleTimeout,
 		Handler:      nr, // Pass our instance of gorilla/mux in.
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Fatal("Cannot listen on ", addr)
+		var err error
+		if serverConfig.TLSCert != "" {
+			err = srv.ListenAndServeTLS(serverConfig.TLSCert, serverConfig.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("cannot listen", Fields{"listen": serverConfig.Listen, "err": err})
 		}
 	}()
 
-	log.Println("Listening on ", addr)
+	logger.Info("listening", Fields{"listen": serverConfig.Listen})
+
+	var grpcServer *grpc.Server
+	if grpcListen != "" {
+		var err error
+		if grpcServer, err = NewGrpcServer(&GrpcServer{Traps: traps}, serverConfig.TLSCert, serverConfig.TLSKey); err != nil {
+			logger.Fatal("cannot start grpc server", Fields{"err": err})
+		}
+		lis, err := net.Listen("tcp", grpcListen)
+		if err != nil {
+			logger.Fatal("cannot listen", Fields{"listen": grpcListen, "err": err})
+		}
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				logger.Fatal("cannot serve grpc", Fields{"listen": grpcListen, "err": err})
+			}
+		}()
+		logger.Info("listening for grpc", Fields{"listen": grpcListen})
+	}
 
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
@@ -345,6 +1015,8 @@ func main() {
 	signal.Notify(c, os.Interrupt)
 
 	<-c
+	readiness.ShutDown()
+	close(stop)
 
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
@@ -353,11 +1025,15 @@ func main() {
 	// until the timeout deadline.
 	err := srv.Shutdown(ctx)
 	if err != nil {
-		log.Println("[ERR] shutting down server")
+		logger.Error("shutting down server", Fields{"err": err})
+	}
+	// Subscriptions never end on their own, so grpc calls are not drained
+	if grpcServer != nil {
+		grpcServer.Stop()
 	}
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
-	log.Println("shutting down")
+	logger.Info("shutting down", nil)
 	os.Exit(0)
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// MatrixRow - fields read at one index, keyed by field as requested
+//
+// Fields without value are left out of Values and listed in Errors with
+// their exception, e.g. noSuchInstance for rows that do not exist, or the
+// error of the GET that failed to read them.
+type MatrixRow struct {
+	Index  string                 `json:"index"`
+	Values map[string]interface{} `json:"values"`
+	Errors map[string]string      `json:"errors,omitempty"`
+}
+
+// MatrixResult - fields × indexes GET grouped by index, rows in request order
+type MatrixResult struct {
+	Base   string      `json:"base"`
+	Fields []string    `json:"fields"`
+	Rows   []MatrixRow `json:"rows"`
+}
+
+// matrixCell - row and field an expanded oid was built from
+type matrixCell struct {
+	row   int
+	field int
+}
+
+// MatrixOids - oids of fields at every index below base, row by row
+//
+// Numeric fields are columns relative to base, MIB names stand for their
+// own oid. oids[i*len(fields)+j] is field j at index i; cells maps each
+// normalized oid back to its row and field.
+func MatrixOids(base string, fields []string, indexes []string) ([]string, map[string]matrixCell, error) {
+	base, err := ResolveOid(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, len(fields))
+	for j, field := range fields {
+		if _, err := ParseOid(field); err == nil {
+			columns[j] = normalizeOid(base) + "." + strings.Trim(field, ".")
+			continue
+		}
+		resolved, err := ResolveOid(field)
+		if err != nil {
+			return nil, nil, err
+		}
+		columns[j] = normalizeOid(resolved)
+	}
+	for _, index := range indexes {
+		if _, err := ParseOid(index); err != nil || strings.Trim(index, ".") == "" {
+			return nil, nil, fmt.Errorf("invalid index %s", index)
+		}
+	}
+
+	oids := make([]string, 0, len(fields)*len(indexes))
+	cells := make(map[string]matrixCell, len(fields)*len(indexes))
+	for i, index := range indexes {
+		for j, column := range columns {
+			oid := column + "." + strings.Trim(index, ".")
+			if _, ok := cells[oid]; !ok {
+				cells[oid] = matrixCell{row: i, field: j}
+			}
+			oids = append(oids, oid)
+		}
+	}
+	return oids, cells, nil
+}
+
+// MatrixHandler - GET fields at every index of the body below base_oid
+//
+// Also reached by a GET of a single oid with a GetFieldsRequest body.
+// More oids than fit one GET of the target are read in chunks, see
+// GetPartial. Rows are answered with 200 if every field was read, 207
+// if some were not and 404 if none exist; ?strict=true fails the request
+// on the first field without value, see WriteStrictError.
+func MatrixHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	var request GetFieldsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if err == io.EOF {
+			WriteError(w, http.StatusBadRequest, "fields and indexes missing")
+		} else {
+			WriteError(w, http.StatusBadRequest, "invalid request json: "+err.Error())
+		}
+		return
+	}
+	base := mux.Vars(r)["base_oid"]
+	if base == "" {
+		base = mux.Vars(r)["oid"]
+	}
+	getMatrix(w, r, g, base, request)
+}
+
+// getMatrix - MatrixHandler of a decoded request
+func getMatrix(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP, base string, request GetFieldsRequest) {
+	if len(request.Fields) == 0 || len(request.Indexes) == 0 {
+		WriteError(w, http.StatusBadRequest, "Nothing to get")
+		return
+	}
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	oids, cells, err := MatrixOids(base, request.Fields, request.Indexes)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := LimitsForTarget(SessionTarget(g)).CheckGet(g, oids); err != nil {
+		WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	var variables []gosnmp.SnmpPDU
+	var failed []VarbindError
+	if cache.Enabled() {
+		var hit bool
+		variables, failed, hit, err = cache.Get(g, oids, CacheBypass(r))
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+	} else {
+		variables, failed, err = GetWithWildcards(g, oids)
+	}
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+
+	ApplyDefaults(variables, request.Defaults)
+	if missing, ok := allMissing(variables); ok && len(failed) == 0 {
+		WriteNoSuchError(w, missing)
+		return
+	}
+	failed = append(failed, missingVarbinds(variables)...)
+	if len(failed) > 0 && r.URL.Query().Get("strict") == "true" {
+		WriteStrictError(w, failed)
+		return
+	}
+	if encoding := ResponseEncoding(r); encoding != EncodingJSON {
+		status := http.StatusOK
+		if len(failed) > 0 {
+			status = http.StatusMultiStatus
+		}
+		RenderEncoded(w, r, status, encoding, variables, failed)
+		return
+	}
+
+	result := MatrixResult{Base: base, Fields: request.Fields, Rows: make([]MatrixRow, len(request.Indexes))}
+	for i, index := range request.Indexes {
+		result.Rows[i] = MatrixRow{Index: index, Values: map[string]interface{}{}}
+	}
+	for _, pdu := range variables {
+		cell, ok := cells[normalizeOid(pdu.Name)]
+		if !ok {
+			continue
+		}
+		if _, missing := varbindExceptions[pdu.Type]; missing {
+			continue
+		}
+		result.Rows[cell.row].Values[request.Fields[cell.field]] = o.FormatValue(pdu)
+	}
+	for _, e := range failed {
+		cell, ok := cells[normalizeOid(e.Oid)]
+		if !ok {
+			continue
+		}
+		row := &result.Rows[cell.row]
+		if row.Errors == nil {
+			row.Errors = map[string]string{}
+		}
+		row.Errors[request.Fields[cell.field]] = e.Error
+	}
+
+	status := http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusMultiStatus
+	}
+	WriteJSON(w, status, result)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+	"github.com/urfave/negroni"
+)
+
+// Prefix of every metric exported at /metrics
+const metricsPrefix = "restsnmp_"
+
+// latencyBuckets - histogram buckets in seconds for snmp and http latency
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// metricFamily - labelled counter or histogram
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// metricSeries - samples of one label combination
+type metricSeries struct {
+	values []string
+	count  uint64
+	sum    float64
+	counts []uint64
+}
+
+// Metrics - labelled counters and histograms in Prometheus text format
+//
+// Families are registered once at startup; Inc and Observe take label
+// values in the order the labels were registered. The Stats counters and
+// gauges are exported alongside.
+type Metrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+	gauges   map[string]func() float64
+	help     map[string]string
+}
+
+// metrics - registry exported at /metrics
+var metrics = NewMetrics()
+
+func init() {
+	metrics.Counter("snmp_requests_total", "SNMP operations by target, operation and result.", "target", "operation", "result")
+	metrics.Histogram("snmp_request_duration_seconds", "SNMP operation round-trip time including retries.", latencyBuckets, "target", "operation")
+	metrics.Counter("http_requests_total", "HTTP requests by method, route and status code.", "method", "route", "code")
+	metrics.Histogram("http_request_duration_seconds", "HTTP handler latency.", latencyBuckets, "method", "route")
+	metrics.Counter("cache_lookups_total", "Response cache lookups of single varbinds by result.", "result")
+	metrics.Counter("traps_total", "Notifications received by source and kind.", "source", "kind")
+	metrics.Counter("circuit_transitions_total", "Circuit breaker state changes by target and new state.", "target", "state")
+}
+
+// NewMetrics - empty registry
+func NewMetrics() *Metrics {
+	return &Metrics{
+		families: map[string]*metricFamily{},
+		gauges:   map[string]func() float64{},
+		help:     map[string]string{},
+	}
+}
+
+// Counter - register counter family
+func (m *Metrics) Counter(name string, help string, labels ...string) {
+	m.mu.Lock()
+	m.families[name] = &metricFamily{name: name, help: help, kind: "counter", labels: labels, series: map[string]*metricSeries{}}
+	m.mu.Unlock()
+}
+
+// Histogram - register histogram family with upper bucket bounds
+func (m *Metrics) Histogram(name string, help string, buckets []float64, labels ...string) {
+	m.mu.Lock()
+	m.families[name] = &metricFamily{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets, series: map[string]*metricSeries{}}
+	m.mu.Unlock()
+}
+
+// GaugeFunc - register gauge sampled from f on every scrape
+func (m *Metrics) GaugeFunc(name string, help string, f func() float64) {
+	m.mu.Lock()
+	m.gauges[name] = f
+	m.help[name] = help
+	m.mu.Unlock()
+}
+
+// seriesFor - samples of label values, caller holds the lock
+func (f *metricFamily) seriesFor(values []string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Inc - increment counter for label values
+func (m *Metrics) Inc(name string, values ...string) {
+	m.Add(name, 1, values...)
+}
+
+// Add - add n to counter for label values
+func (m *Metrics) Add(name string, n uint64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.families[name]; ok {
+		f.seriesFor(values).count += n
+	}
+}
+
+// Observe - add sample to histogram for label values
+func (m *Metrics) Observe(name string, v float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.families[name]
+	if !ok {
+		return
+	}
+	s := f.seriesFor(values)
+	s.count++
+	s.sum += v
+	for i, bound := range f.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+}
+
+// labelPairs - {a="x",b="y"} of names and values plus extra pairs
+func labelPairs(names []string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// statsMetricName - Prometheus name of a Stats entry, e.g. pool.in_use
+func statsMetricName(name string) string {
+	return metricsPrefix + strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// Write - every metric in the Prometheus text exposition format
+func (m *Metrics) Write() []byte {
+	var buf bytes.Buffer
+
+	m.mu.Lock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := m.families[name]
+		full := metricsPrefix + f.name
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", full, f.help, full, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind == "counter" {
+				fmt.Fprintf(&buf, "%s%s %d\n", full, labelPairs(f.labels, s.values), s.count)
+				continue
+			}
+			for i, bound := range f.buckets {
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", full, labelPairs(f.labels, s.values, "le", fmt.Sprint(bound)), s.counts[i])
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", full, labelPairs(f.labels, s.values, "le", "+Inf"), s.count)
+			fmt.Fprintf(&buf, "%s_sum%s %g\n", full, labelPairs(f.labels, s.values), s.sum)
+			fmt.Fprintf(&buf, "%s_count%s %d\n", full, labelPairs(f.labels, s.values), s.count)
+		}
+	}
+
+	gauges := make([]string, 0, len(m.gauges))
+	for name := range m.gauges {
+		gauges = append(gauges, name)
+	}
+	sort.Strings(gauges)
+	for _, name := range gauges {
+		full := metricsPrefix + name
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", full, m.help[name], full, full, m.gauges[name]())
+	}
+	m.mu.Unlock()
+
+	snapshot := stats.Snapshot()
+	counters := make([]string, 0, len(snapshot.Counters))
+	for name := range snapshot.Counters {
+		counters = append(counters, name)
+	}
+	sort.Strings(counters)
+	for _, name := range counters {
+		fmt.Fprintf(&buf, "# TYPE %s untyped\n%s %d\n", statsMetricName(name), statsMetricName(name), snapshot.Counters[name])
+	}
+	for _, values := range []map[string]float64{snapshot.Gauges, snapshot.Ratios} {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "# TYPE %s gauge\n%s %g\n", statsMetricName(name), statsMetricName(name), values[name])
+		}
+	}
+	return buf.Bytes()
+}
+
+// MetricsHandler - Prometheus scrape endpoint
+func (m *Metrics) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write(m.Write()); err != nil {
+		RequestLogger(r).Error("writing response", Fields{"err": err})
+	}
+}
+
+// Middleware - count requests and observe handler latency per route
+//
+// Used as mux middleware so the route template, not the raw path with
+// targets and oids, becomes the label.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		rw := negroni.NewResponseWriter(w)
+		next.ServeHTTP(rw, r)
+		m.Inc("http_requests_total", r.Method, route, fmt.Sprint(rw.Status()))
+		m.Observe("http_request_duration_seconds", time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// ObserveSnmp - count snmp operation of session g and observe its duration
+//
+// The operation is logged with the request id of the session, at debug
+// level unless it timed out or failed.
+func ObserveSnmp(g *gosnmp.GoSNMP, operation string, start time.Time, status gosnmp.SNMPError, err error) {
+	target := SessionTarget(g)
+	result := "ok"
+	switch {
+	case isTimeout(err):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	case status != gosnmp.NoError:
+		result = "agent_error"
+	}
+	metrics.Inc("snmp_requests_total", target, operation, result)
+	breaker.Record(g, result, err)
+	metrics.Observe("snmp_request_duration_seconds", time.Since(start).Seconds(), target, operation)
+
+	level := LevelDebug
+	if result == "timeout" || result == "error" {
+		level = LevelWarn
+	}
+	if !logger.Enabled(level) {
+		return
+	}
+	fields := Fields{"operation": operation, "result": result, "duration_ms": millis(time.Since(start))}
+	if err != nil {
+		fields["err"] = err
+	}
+	if status != gosnmp.NoError {
+		fields["snmp_error"] = status.String()
+	}
+	SessionLogger(g).log(level, "snmp request", fields)
+}
+
+// ObservedGet - g.Get recorded in snmp metrics
+func ObservedGet(g *gosnmp.GoSNMP, oids []string) (*gosnmp.SnmpPacket, error) {
+	release, err := beginSnmp(g, "get")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	result, err := g.Get(oids)
+	ObserveSnmp(g, "get", start, packetError(result), err)
+	return result, err
+}
+
+// ObservedSet - g.Set recorded in snmp metrics
+func ObservedSet(g *gosnmp.GoSNMP, pdus []gosnmp.SnmpPDU) (*gosnmp.SnmpPacket, error) {
+	release, err := beginSnmp(g, "set")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	result, err := g.Set(pdus)
+	ObserveSnmp(g, "set", start, packetError(result), err)
+	return result, err
+}
+
+// ObservedGetNext - g.GetNext recorded in snmp metrics
+func ObservedGetNext(g *gosnmp.GoSNMP, oids []string) (*gosnmp.SnmpPacket, error) {
+	release, err := beginSnmp(g, "getnext")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	result, err := g.GetNext(oids)
+	ObserveSnmp(g, "getnext", start, packetError(result), err)
+	return result, err
+}
+
+// ObservedGetBulk - g.GetBulk recorded in snmp metrics
+func ObservedGetBulk(g *gosnmp.GoSNMP, oids []string, nonRepeaters uint8, maxReps uint8) (*gosnmp.SnmpPacket, error) {
+	release, err := beginSnmp(g, "getbulk")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	result, err := g.GetBulk(oids, nonRepeaters, maxReps)
+	ObserveSnmp(g, "getbulk", start, packetError(result), err)
+	return result, err
+}
+
+// ObservedWalkAll - g.WalkAll, or g.BulkWalkAll if bulk, recorded in snmp metrics
+func ObservedWalkAll(g *gosnmp.GoSNMP, rootOid string, bulk bool) ([]gosnmp.SnmpPDU, error) {
+	release, err := beginSnmp(g, walkOperation(bulk))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	if bulk {
+		pdus, err := g.BulkWalkAll(rootOid)
+		ObserveSnmp(g, "bulkwalk", start, gosnmp.NoError, err)
+		return pdus, err
+	}
+	pdus, err := g.WalkAll(rootOid)
+	ObserveSnmp(g, "walk", start, gosnmp.NoError, err)
+	return pdus, err
+}
+
+// ObservedWalk - g.Walk, or g.BulkWalk if bulk, recorded in snmp metrics
+//
+// walkFn is called with every varbind as it arrives.
+func ObservedWalk(g *gosnmp.GoSNMP, rootOid string, bulk bool, walkFn gosnmp.WalkFunc) error {
+	release, err := beginSnmp(g, walkOperation(bulk))
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	if bulk {
+		err := g.BulkWalk(rootOid, walkFn)
+		ObserveSnmp(g, "bulkwalk", start, gosnmp.NoError, err)
+		return err
+	}
+	err = g.Walk(rootOid, walkFn)
+	ObserveSnmp(g, "walk", start, gosnmp.NoError, err)
+	return err
+}
+
+// beginSnmp - admit operation on session g through the limiter
+//
+// Every Observed wrapper starts with it, so rate limits apply to all snmp
+// traffic of the gateway. Refused operations are counted with result
+// rate_limited.
+func beginSnmp(g *gosnmp.GoSNMP, operation string) (func(), error) {
+	if err := breaker.Allow(SessionTarget(g)); err != nil {
+		metrics.Inc("snmp_requests_total", SessionTarget(g), operation, "circuit_open")
+		return nil, err
+	}
+	release, err := limiter.Begin(SessionTarget(g))
+	if err != nil {
+		metrics.Inc("snmp_requests_total", SessionTarget(g), operation, "rate_limited")
+	}
+	return release, err
+}
+
+func walkOperation(bulk bool) string {
+	if bulk {
+		return "bulkwalk"
+	}
+	return "walk"
+}
+
+func packetError(p *gosnmp.SnmpPacket) gosnmp.SNMPError {
+	if p == nil {
+		return gosnmp.NoError
+	}
+	return p.Error
+}
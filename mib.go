@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MibObject - named node of a loaded MIB module
+type MibObject struct {
+	Module string         `json:"module"`
+	Name   string         `json:"name"`
+	Oid    string         `json:"oid"`
+	Syntax string         `json:"syntax,omitempty"`
+	Enums  map[int]string `json:"enums,omitempty"`
+}
+
+// mibDefinition - object as parsed, before its parent is resolved
+type mibDefinition struct {
+	module string
+	name   string
+	parent string
+	subids []int
+	syntax string
+	enums  map[int]string
+
+	index    []mibIndexPart
+	augments string
+}
+
+// mibIndexPart - object named in an INDEX clause
+type mibIndexPart struct {
+	name    string
+	implied bool
+}
+
+var (
+	mibMu      sync.RWMutex
+	mibObjects = map[string]*MibObject{}
+	oidSymbols = map[string]string{}
+	// mibTypes - base syntax of textual conventions and type assignments
+	mibTypes = map[string]string{}
+)
+
+func init() {
+	for name, oid := range oidNames {
+		oidSymbols[oid] = name
+	}
+}
+
+// smiMacros - macros whose invocation assigns an oid
+var smiMacros = map[string]bool{
+	"OBJECT-TYPE":        true,
+	"MODULE-IDENTITY":    true,
+	"OBJECT-IDENTITY":    true,
+	"NOTIFICATION-TYPE":  true,
+	"OBJECT-GROUP":       true,
+	"NOTIFICATION-GROUP": true,
+	"MODULE-COMPLIANCE":  true,
+	"AGENT-CAPABILITIES": true,
+}
+
+// tokenizeMib - identifiers, numbers and punctuation of a MIB file
+//
+// Comments and quoted strings (descriptions) are dropped.
+func tokenizeMib(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '-' && i+1 < len(src) && src[i+1] == '-':
+			// comment runs to end of line or the next "--"
+			end := strings.IndexAny(src[i+2:], "\n")
+			if dash := strings.Index(src[i+2:], "--"); dash >= 0 && (end < 0 || dash < end) {
+				i += 2 + dash + 2
+				continue
+			}
+			if end < 0 {
+				return tokens
+			}
+			i += 2 + end
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 2
+		case c == ':' && strings.HasPrefix(src[i:], "::="):
+			tokens = append(tokens, "::=")
+			i += 3
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case isMibIdentChar(c):
+			j := i
+			for j < len(src) && isMibIdentChar(src[j]) {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+func isMibIdentChar(c byte) bool {
+	return c == '-' || c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parseEnums - "{ up(1), down(2) }" starting at the opening brace
+func parseEnums(tokens []string, i int) (map[int]string, int) {
+	enums := map[int]string{}
+	for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+		if i+3 < len(tokens) && tokens[i+1] == "(" && tokens[i+3] == ")" {
+			if n, err := strconv.Atoi(tokens[i+2]); err == nil {
+				enums[n] = tokens[i]
+			}
+			i += 3
+		}
+	}
+	return enums, i
+}
+
+// parseIndex - "{ IMPLIED a, b }" starting at the opening brace
+func parseIndex(tokens []string, i int) ([]mibIndexPart, int) {
+	var parts []mibIndexPart
+	implied := false
+	for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+		switch tokens[i] {
+		case ",":
+		case "IMPLIED":
+			implied = true
+		default:
+			parts = append(parts, mibIndexPart{name: tokens[i], implied: implied})
+			implied = false
+		}
+	}
+	return parts, i
+}
+
+// parseMib - oid assignments and type assignments of a file
+//
+// Enumerated textual conventions are returned with their labels, every
+// type assignment with the first token of its base syntax.
+func parseMib(src string) ([]mibDefinition, map[string]map[int]string, map[string]string) {
+	tokens := tokenizeMib(src)
+	var defs []mibDefinition
+	conventions := map[string]map[int]string{}
+	types := map[string]string{}
+	module := ""
+
+	for i := 0; i < len(tokens); i++ {
+		switch {
+		case i+2 < len(tokens) && tokens[i+1] == "DEFINITIONS":
+			module = tokens[i]
+			continue
+		case tokens[i] == "IMPORTS" || tokens[i] == "EXPORTS":
+			for i < len(tokens) && tokens[i] != ";" {
+				i++
+			}
+			continue
+		case i+1 < len(tokens) && tokens[i+1] == "MACRO":
+			for i < len(tokens) && tokens[i] != "END" {
+				i++
+			}
+			continue
+		case i+1 >= len(tokens):
+			continue
+		}
+
+		name := tokens[i]
+		var def *mibDefinition
+		switch {
+		case i+3 < len(tokens) && tokens[i+1] == "OBJECT" && tokens[i+2] == "IDENTIFIER" && tokens[i+3] == "::=":
+			def = &mibDefinition{module: module, name: name}
+			i += 4
+		case smiMacros[tokens[i+1]]:
+			def = &mibDefinition{module: module, name: name}
+			for i += 2; i < len(tokens) && tokens[i] != "::="; i++ {
+				switch {
+				case tokens[i] == "SYNTAX" && i+1 < len(tokens):
+					def.syntax = tokens[i+1]
+					if i+2 < len(tokens) && tokens[i+2] == "{" {
+						def.enums, i = parseEnums(tokens, i+2)
+					}
+				case tokens[i] == "INDEX" && i+1 < len(tokens) && tokens[i+1] == "{":
+					def.index, i = parseIndex(tokens, i+1)
+				case tokens[i] == "AUGMENTS" && i+2 < len(tokens) && tokens[i+1] == "{":
+					def.augments = tokens[i+2]
+				}
+			}
+			i++
+		case tokens[i+1] == "::=" && i+2 < len(tokens):
+			// type assignment, enumerated ones are kept as conventions
+			j := i + 2
+			if tokens[j] == "TEXTUAL-CONVENTION" {
+				for j < len(tokens) && tokens[j] != "SYNTAX" {
+					j++
+				}
+				j++
+			}
+			if j < len(tokens) {
+				types[name] = tokens[j]
+			}
+			if j+1 < len(tokens) && (tokens[j] == "INTEGER" || tokens[j] == "BITS") && tokens[j+1] == "{" {
+				enums, end := parseEnums(tokens, j+1)
+				conventions[name] = enums
+				i = end
+			}
+			continue
+		default:
+			continue
+		}
+
+		if i >= len(tokens) || tokens[i] != "{" {
+			continue
+		}
+		for i++; i < len(tokens) && tokens[i] != "}"; i++ {
+			token := tokens[i]
+			if n, err := strconv.Atoi(token); err == nil {
+				def.subids = append(def.subids, n)
+				continue
+			}
+			// name(number) defines an intermediate node
+			if i+3 < len(tokens) && tokens[i+1] == "(" && tokens[i+3] == ")" {
+				n, err := strconv.Atoi(tokens[i+2])
+				if err == nil {
+					if def.parent == "" && len(def.subids) == 0 {
+						def.parent = token
+					} else {
+						intermediate := mibDefinition{module: module, name: token, parent: def.parent, subids: append(append([]int{}, def.subids...), n)}
+						defs = append(defs, intermediate)
+						def.subids = append(def.subids, n)
+					}
+				}
+				i += 3
+				continue
+			}
+			def.parent = token
+		}
+		defs = append(defs, *def)
+	}
+	return defs, conventions, types
+}
+
+// LoadMibDir - parse every MIB file of dir and register its names
+//
+// Objects are resolved against the built-in names, so mib-2 based
+// modules load without SNMPv2-SMI; unresolvable objects are reported.
+// The INDEX and AUGMENTS clauses of table entries are registered for
+// index decoding, replacing the built-in ones of the same table.
+func LoadMibDir(dir string) (int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var defs []mibDefinition
+	conventions := map[string]map[int]string{}
+	types := map[string]string{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return 0, err
+		}
+		fileDefs, fileConventions, fileTypes := parseMib(string(src))
+		defs = append(defs, fileDefs...)
+		for name, enums := range fileConventions {
+			conventions[name] = enums
+		}
+		for name, base := range fileTypes {
+			types[name] = base
+		}
+	}
+
+	known := map[string]string{"ccitt": ".0", "iso": ".1", "joint-iso-ccitt": ".2"}
+	oidNamesMu.RLock()
+	for name, oid := range oidNames {
+		known[name] = oid
+	}
+	oidNamesMu.RUnlock()
+
+	// Parents may be defined later or in other modules, resolve until
+	// no more progress is made
+	resolved := map[int]string{}
+	for progress := true; progress; {
+		progress = false
+		for i, def := range defs {
+			if _, ok := resolved[i]; ok {
+				continue
+			}
+			parent, ok := known[def.parent]
+			if !ok {
+				continue
+			}
+			oid := parent
+			for _, n := range def.subids {
+				oid += "." + strconv.Itoa(n)
+			}
+			known[def.name] = oid
+			resolved[i] = oid
+			progress = true
+		}
+	}
+
+	mibMu.Lock()
+	defer mibMu.Unlock()
+	for i, def := range defs {
+		oid, ok := resolved[i]
+		if !ok {
+			logger.Warn("cannot resolve mib object", Fields{"module": def.module, "name": def.name, "parent": def.parent})
+			continue
+		}
+		enums := def.enums
+		if enums == nil {
+			enums = conventions[def.syntax]
+		}
+		mibObjects[oid] = &MibObject{Module: def.module, Name: def.name, Oid: oid, Syntax: def.syntax, Enums: enums}
+		oidSymbols[oid] = def.module + "::" + def.name
+		RegisterOidName(def.name, oid)
+	}
+	for name, base := range types {
+		mibTypes[name] = base
+	}
+
+	// Augmented entries may be defined in the same directory, so entries
+	// with an INDEX clause are registered first
+	for _, augments := range []bool{false, true} {
+		for i, def := range defs {
+			oid, ok := resolved[i]
+			if !ok || (augments && def.augments == "") || (!augments && len(def.index) == 0) {
+				continue
+			}
+			t, err := mibTableIndex(def, oid, known)
+			if err != nil {
+				logger.Warn("cannot register table index", Fields{"module": def.module, "name": def.name, "err": err})
+				continue
+			}
+			RegisterTableIndex(t)
+		}
+	}
+	return len(resolved), nil
+}
+
+// mibTableIndex - TableIndex of entry def at oid, caller holds mibMu
+//
+// Index objects are looked up among the loaded MIB objects, then among
+// the parts of registered tables, for objects of modules not loaded.
+func mibTableIndex(def mibDefinition, oid string, known map[string]string) (TableIndex, error) {
+	if def.augments != "" {
+		base, ok := LookupTableIndex(known[def.augments])
+		if !ok || base.Oid != "."+strings.Trim(known[def.augments], ".") {
+			return TableIndex{}, fmt.Errorf("index of augmented %s unknown", def.augments)
+		}
+		return TableIndex{Entry: def.name, Oid: oid, Parts: base.Parts}, nil
+	}
+
+	t := TableIndex{Entry: def.name, Oid: oid}
+	for _, part := range def.index {
+		kind := ""
+		if object, ok := mibObjects[known[part.name]]; ok {
+			kind = mibIndexKind(object.Syntax, part.implied)
+		} else {
+			kind, _ = IndexPartKind(part.name)
+		}
+		if kind == "" {
+			return TableIndex{}, fmt.Errorf("syntax of index %s unknown", part.name)
+		}
+		t.Parts = append(t.Parts, IndexPart{Name: part.name, Kind: kind})
+	}
+	return t, nil
+}
+
+// mibIndexKind - index encoding of objects of syntax, empty if unknown
+//
+// Textual conventions are followed to their base syntax; caller holds mibMu.
+func mibIndexKind(syntax string, implied bool) string {
+	for depth := 0; depth < 8; depth++ {
+		if kind, ok := indexSyntaxKinds[syntax]; ok {
+			switch {
+			case implied && kind == IndexString:
+				return IndexImpliedString
+			case implied && kind == IndexOid:
+				return IndexImpliedOid
+			}
+			return kind
+		}
+		base, ok := mibTypes[syntax]
+		if !ok || base == syntax {
+			return ""
+		}
+		syntax = base
+	}
+	return ""
+}
+
+// TranslateOid - symbolic form of numeric oid and its MIB object if loaded
+//
+// The longest known prefix is used, e.g. IF-MIB::ifDescr.3.
+func TranslateOid(oid string) (string, *MibObject) {
+	oid = "." + strings.Trim(oid, ".")
+	mibMu.RLock()
+	defer mibMu.RUnlock()
+
+	for prefix := oid; prefix != ""; {
+		if symbol, ok := oidSymbols[prefix]; ok {
+			return symbol + oid[len(prefix):], mibObjects[prefix]
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i <= 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return oid, nil
+}
+
+// EnumLabel - "label(n)" for enumerated objects, empty otherwise
+func (o *MibObject) EnumLabel(value interface{}) string {
+	if o == nil || len(o.Enums) == 0 {
+		return ""
+	}
+	n, ok := value.(int)
+	if !ok {
+		return ""
+	}
+	label, ok := o.Enums[n]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s(%d)", label, n)
+}
+
+// TranslateHandler - numeric and symbolic form of ?oid=
+func TranslateHandler(w http.ResponseWriter, r *http.Request) {
+	oid, err := ResolveOid(r.URL.Query().Get("oid"))
+	if err != nil || oid == "" {
+		WriteError(w, http.StatusBadRequest, "invalid oid")
+		return
+	}
+	symbol, object := TranslateOid(oid)
+	WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"oid":    "." + strings.Trim(oid, "."),
+		"symbol": symbol,
+		"object": object,
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// defaultMaxRepetitions - GETBULK max-repetitions without profile setting
+const defaultMaxRepetitions = 50
+
+// TargetSize - request sizing known to work with a target
+//
+// MaxOids is the number of varbinds per GET and MaxRepetitions the
+// GETBULK max-repetitions; both shrink when the agent answers tooBig.
+type TargetSize struct {
+	MaxOids        int       `json:"max_oids"`
+	MaxRepetitions int       `json:"max_repetitions"`
+	Downshifts     int       `json:"downshifts"`
+	Updated        time.Time `json:"updated,omitempty"`
+}
+
+// SizeMemory - working request sizes remembered per target
+//
+// Sessions created for a target start with the remembered size, so after
+// one tooBig later requests are sized correctly the first time.
+type SizeMemory struct {
+	mu    sync.RWMutex
+	sizes map[string]TargetSize
+}
+
+// sizes - request sizes negotiated with agents
+var sizes = NewSizeMemory()
+
+// NewSizeMemory - empty size memory
+func NewSizeMemory() *SizeMemory {
+	return &SizeMemory{sizes: map[string]TargetSize{}}
+}
+
+// For - remembered size of target, profile limits if nothing was negotiated
+func (m *SizeMemory) For(target string) TargetSize {
+	m.mu.RLock()
+	size, ok := m.sizes[target]
+	m.mu.RUnlock()
+	if ok {
+		return size
+	}
+
+	limits := LimitsForTarget(target)
+	size = TargetSize{MaxOids: limits.MaxOids, MaxRepetitions: limits.MaxRepetitions}
+	if size.MaxRepetitions <= 0 {
+		size.MaxRepetitions = defaultMaxRepetitions
+	}
+	return size
+}
+
+// downshift - remember smaller size for target
+func (m *SizeMemory) downshift(target string, update func(*TargetSize)) {
+	size := m.For(target)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if current, ok := m.sizes[target]; ok {
+		size = current
+	}
+	update(&size)
+	size.Downshifts++
+	size.Updated = time.Now()
+	m.sizes[target] = size
+	stats.Inc("msgsize.downshifts")
+}
+
+// getChunkSize - varbinds per GET of n oids on g
+//
+// The remembered size of the target, capped by the MaxOids of the session.
+func getChunkSize(g *gosnmp.GoSNMP, n int) int {
+	size := sizes.For(SessionTarget(g)).MaxOids
+	if g.MaxOids > 0 && (size <= 0 || size > g.MaxOids) {
+		size = g.MaxOids
+	}
+	if size <= 0 || size > n {
+		size = n
+	}
+	return size
+}
+
+// NegotiatedGet - snmpget split into requests the agent can answer
+//
+// Oids are sent in chunks of the remembered size; a chunk answered with
+// tooBig is halved and retried; the MaxOids of the session caps the chunk
+// size. The variables of all chunks are returned in order, the first
+// other error status is returned as is.
+func NegotiatedGet(g *gosnmp.GoSNMP, oids []string) (*gosnmp.SnmpPacket, error) {
+	size := getChunkSize(g, len(oids))
+
+	merged := &gosnmp.SnmpPacket{Version: g.Version, Community: g.Community}
+	for start := 0; start < len(oids); {
+		end := start + size
+		if end > len(oids) {
+			end = len(oids)
+		}
+		result, err := ObservedGet(g, oids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if result.Error == gosnmp.TooBig && end-start > 1 {
+			size = (end - start) / 2
+			sizes.downshift(SessionTarget(g), func(s *TargetSize) {
+				if s.MaxOids <= 0 || s.MaxOids > size {
+					s.MaxOids = size
+				}
+			})
+			continue
+		}
+
+		if result.Error != gosnmp.NoError && merged.Error == gosnmp.NoError {
+			merged.Error = result.Error
+			// Index of the oid in the whole request; indexes past the
+			// range of the field are dropped rather than wrapped
+			index := int(result.ErrorIndex)
+			if index > 0 {
+				index += start
+			}
+			if index > math.MaxUint8 {
+				index = 0
+			}
+			merged.ErrorIndex = uint8(index)
+		}
+		merged.Variables = append(merged.Variables, result.Variables...)
+		start = end
+	}
+	return merged, nil
+}
+
+// NegotiatedGetBulk - snmpgetbulk with remembered max-repetitions
+//
+// maxReps of 0 uses the remembered value. A tooBig answer halves
+// max-repetitions until the agent responds.
+func NegotiatedGetBulk(g *gosnmp.GoSNMP, oids []string, nonRepeaters uint8, maxReps int) (*gosnmp.SnmpPacket, error) {
+	reps := maxReps
+	if reps <= 0 {
+		reps = sizes.For(SessionTarget(g)).MaxRepetitions
+	}
+	for {
+		result, err := ObservedGetBulk(g, oids, nonRepeaters, uint8(reps))
+		if err != nil || result.Error != gosnmp.TooBig || reps <= 1 {
+			return result, err
+		}
+		reps /= 2
+		sizes.downshift(SessionTarget(g), func(s *TargetSize) {
+			if s.MaxRepetitions > reps {
+				s.MaxRepetitions = reps
+			}
+		})
+	}
+}
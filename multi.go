@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Multi-target operations
+const (
+	MultiGet  = "get"
+	MultiWalk = "walk"
+)
+
+// multiWorkers - upper bound of targets queried concurrently by one request
+var multiWorkers = 32
+
+// MultiTarget - target of a fan-out request with its own credentials
+//
+// credential names a stored credential. Without community or credential
+// the X-SNMP-COMM headers, the target profile and the credential store
+// are tried, as for single target requests.
+type MultiTarget struct {
+	Target     string `json:"target"`
+	Community  string `json:"community"`
+	Credential string `json:"credential"`
+}
+
+// MultiRequest - same oids read from many targets
+//
+// With operation "walk" every oid is the root of a walk. Targets may be
+// registered names; tags adds every registered target carrying all of
+// them.
+type MultiRequest struct {
+	Targets     []MultiTarget `json:"targets"`
+	Tags        []string      `json:"tags,omitempty"`
+	Oids        []string      `json:"oids"`
+	Operation   string        `json:"operation"`
+	Concurrency int           `json:"concurrency"`
+}
+
+// MultiTargetResult - variables read from one target or why it failed
+type MultiTargetResult struct {
+	Variables interface{}    `json:"variables,omitempty"`
+	Errors    []VarbindError `json:"errors,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// MultiHandler - GET or WALK oids on many targets concurrently
+//
+// At most concurrency (capped by -multi-workers) targets are queried at a
+// time. The response maps every target to its result; failures of single
+// targets are reported there and do not fail the request.
+func MultiHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := ParseSnmpVersion(mux.Vars(r)["snmp_version"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := ParseFormatOptions(r); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := ParseRequestOptions(r, ""); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request := MultiRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid multi json")
+		return
+	}
+	seen := map[string]bool{}
+	for _, t := range request.Targets {
+		if t.Target == "" || seen[t.Target] {
+			WriteError(w, http.StatusBadRequest, "targets must be named and unique")
+			return
+		}
+		seen[t.Target] = true
+	}
+	if len(request.Tags) > 0 {
+		for _, t := range inventory.WithTags(request.Tags) {
+			if !seen[t.Name] {
+				seen[t.Name] = true
+				request.Targets = append(request.Targets, MultiTarget{Target: t.Name})
+			}
+		}
+	}
+	if len(request.Targets) == 0 {
+		WriteError(w, http.StatusBadRequest, "targets missing")
+		return
+	}
+	if len(request.Oids) == 0 {
+		WriteError(w, http.StatusBadRequest, "oids missing")
+		return
+	}
+	switch request.Operation {
+	case "":
+		request.Operation = MultiGet
+	case MultiGet, MultiWalk:
+	default:
+		WriteError(w, http.StatusBadRequest, "operation must be get or walk")
+		return
+	}
+	oids, err := ResolveOids(request.Oids)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets := make([]string, 0, len(seen))
+	for target := range seen {
+		targets = append(targets, inventory.Resolve(target))
+	}
+	if target, ok := AuthorizeTargets(r, targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+
+	workers := request.Concurrency
+	if workers <= 0 || workers > multiWorkers {
+		workers = multiWorkers
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]MultiTargetResult, len(request.Targets))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for _, t := range request.Targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t MultiTarget) {
+			defer wg.Done()
+			stats.Add("multi.workers.busy", 1)
+			defer stats.Add("multi.workers.busy", -1)
+			result := queryTarget(r, version, t, request.Operation, oids)
+			<-sem
+
+			mu.Lock()
+			results[t.Target] = result
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	WriteJSON(w, http.StatusOK, results)
+}
+
+// queryTarget - one target of a fan-out request
+func queryTarget(r *http.Request, version gosnmp.SnmpVersion, t MultiTarget, operation string, oids []string) MultiTargetResult {
+	stats.Inc("multi.targets")
+	address := inventory.Resolve(t.Target)
+	options, err := ParseRequestOptions(r, address)
+	if err != nil {
+		return MultiTargetResult{Error: err.Error()}
+	}
+	candidates, err := BodyCandidates(r.Header["X-Snmp-Comm"], t.Community, t.Credential, address, version)
+	if err != nil {
+		return MultiTargetResult{Error: err.Error()}
+	}
+	g, _, err := ConnectWithFallback(address, version, candidates)
+	if err != nil {
+		stats.Inc("multi.targets.failed")
+		return MultiTargetResult{Error: err.Error()}
+	}
+	defer sessions.Put(g)
+	options.Apply(g)
+
+	var pdus []gosnmp.SnmpPDU
+	var failed []VarbindError
+	switch operation {
+	case MultiWalk:
+		for _, oid := range oids {
+			var walked []gosnmp.SnmpPDU
+			walked, err = ObservedWalkAll(g, oid, g.Version != gosnmp.Version1)
+			if err != nil {
+				break
+			}
+			pdus = append(pdus, walked...)
+		}
+	default:
+		if err = LimitsForTarget(SessionTarget(g)).CheckGet(g, PlainOids(oids)); err == nil {
+			pdus, failed, err = GetWithWildcards(g, oids)
+		}
+	}
+	if err != nil {
+		stats.Inc("multi.targets.failed")
+		return MultiTargetResult{Error: err.Error()}
+	}
+
+	variables, err := formatVariables(r, pdus)
+	if err != nil {
+		return MultiTargetResult{Error: err.Error()}
+	}
+	return MultiTargetResult{Variables: variables, Errors: failed}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Textual conventions of octet strings formatted by octet_format=auto
+const (
+	conventionMac      = "mac"
+	conventionDateTime = "datetime"
+	conventionInet     = "inet"
+)
+
+// syntaxConventions - MIB syntaxes of octet strings and how they are formatted
+var syntaxConventions = map[string]string{
+	"MacAddress":      conventionMac,
+	"PhysAddress":     conventionMac,
+	"DateAndTime":     conventionDateTime,
+	"InetAddress":     conventionInet,
+	"InetAddressIPv4": conventionInet,
+	"InetAddressIPv6": conventionInet,
+}
+
+// octetConventions - columns of the standard MIBs formatted without a loaded MIB
+var octetConventions = map[string]string{
+	".1.3.6.1.2.1.2.2.1.6":    conventionMac,      // ifPhysAddress
+	".1.3.6.1.2.1.4.22.1.2":   conventionMac,      // ipNetToMediaPhysAddress
+	".1.3.6.1.2.1.4.35.1.4":   conventionMac,      // ipNetToPhysicalPhysAddress
+	".1.3.6.1.2.1.17.1.1":     conventionMac,      // dot1dBaseBridgeAddress
+	".1.3.6.1.2.1.17.4.3.1.1": conventionMac,      // dot1dTpFdbAddress
+	".1.3.6.1.2.1.25.1.2":     conventionDateTime, // hrSystemDate
+	".1.3.6.1.2.1.25.6.3.1.5": conventionDateTime, // hrSWInstalledDate
+}
+
+// octetConvention - textual convention of the octet string at oid, empty if unknown
+//
+// The syntax of a loaded MIB object wins over the built in columns.
+func octetConvention(oid string) string {
+	if _, object := TranslateOid(oid); object != nil {
+		if convention, ok := syntaxConventions[object.Syntax]; ok {
+			return convention
+		}
+	}
+	for prefix := normalizeOid(oid); prefix != ""; {
+		if convention, ok := octetConventions[prefix]; ok {
+			return convention
+		}
+		i := strings.LastIndex(prefix, ".")
+		if i <= 0 {
+			break
+		}
+		prefix = prefix[:i]
+	}
+	return ""
+}
+
+// autoOctets - octet string at oid as text, its textual convention or hex
+//
+// Values of known MAC address, DateAndTime and InetAddress objects are
+// formatted accordingly. Other printable values are shown as text, and
+// binary values that decode as a valid DateAndTime as a date; anything
+// else as colon separated hex.
+func autoOctets(oid string, octets []byte) string {
+	switch octetConvention(oid) {
+	case conventionMac:
+		return colonHex(octets)
+	case conventionDateTime:
+		if s, ok := formatDateAndTime(octets); ok {
+			return s
+		}
+	case conventionInet:
+		if len(octets) == net.IPv4len || len(octets) == net.IPv6len {
+			return net.IP(octets).String()
+		}
+	}
+	if printable(octets) {
+		return string(octets)
+	}
+	if s, ok := formatDateAndTime(octets); ok {
+		return s
+	}
+	return colonHex(octets)
+}
+
+// formatDateAndTime - SNMPv2-TC DateAndTime as RFC 3339, false if octets are not one
+//
+// The 8 octet form has no time zone and is rendered without offset.
+func formatDateAndTime(octets []byte) (string, bool) {
+	if len(octets) != 8 && len(octets) != 11 {
+		return "", false
+	}
+	year := int(octets[0])<<8 | int(octets[1])
+	month, day, hour, min, sec, deci := octets[2], octets[3], octets[4], octets[5], octets[6], octets[7]
+	if year < 1970 || year > 2200 || month < 1 || month > 12 || day < 1 || day > 31 ||
+		hour > 23 || min > 59 || sec > 60 || deci > 9 {
+		return "", false
+	}
+	s := fmt.Sprintf("%04d-%02d-%02dT%02d:%02d:%02d.%d", year, month, day, hour, min, sec, deci)
+	if len(octets) == 8 {
+		return s, true
+	}
+	direction, offsetHours, offsetMinutes := octets[8], octets[9], octets[10]
+	if (direction != '+' && direction != '-') || offsetHours > 14 || offsetMinutes > 59 {
+		return "", false
+	}
+	return s + fmt.Sprintf("%c%02d:%02d", direction, offsetHours, offsetMinutes), true
+}
+
+// dateAndTimeLayouts - accepted SET values of DateAndTime, with and without offset
+var dateAndTimeLayouts = []string{"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999"}
+
+// dateAndTimeValue - RFC 3339 date as SNMPv2-TC DateAndTime octets
+//
+// Without offset the 8 octet form is encoded. Fractions of a second are
+// truncated to deciseconds.
+func dateAndTimeValue(s string) ([]byte, error) {
+	for i, layout := range dateAndTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		octets := []byte{
+			byte(t.Year() >> 8), byte(t.Year()),
+			byte(t.Month()), byte(t.Day()),
+			byte(t.Hour()), byte(t.Minute()), byte(t.Second()),
+			byte(t.Nanosecond() / int(100*time.Millisecond)),
+		}
+		if i > 0 {
+			return octets, nil
+		}
+		_, offset := t.Zone()
+		direction := byte('+')
+		if offset < 0 {
+			direction, offset = '-', -offset
+		}
+		return append(octets, direction, byte(offset/3600), byte(offset%3600/60)), nil
+	}
+	return nil, fmt.Errorf("expected RFC 3339 date, e.g. 2026-10-15T12:30:00.0+02:00")
+}
+
+// inetAddressValue - IPv4 or IPv6 address as the 4 or 16 octets of an InetAddress
+func inetAddressValue(s string) ([]byte, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("expected IPv4 or IPv6 address")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return []byte(ip4), nil
+	}
+	return []byte(ip), nil
+}
+
+// base64Value - standard base64 string as octets
+func base64Value(s string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected base64 string")
+	}
+	return b, nil
+}
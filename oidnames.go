@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	oidNamesMu sync.RWMutex
+	oidNames   = map[string]string{
+		"iso":         ".1",
+		"internet":    ".1.3.6.1",
+		"mgmt":        ".1.3.6.1.2",
+		"mib-2":       ".1.3.6.1.2.1",
+		"enterprises": ".1.3.6.1.4.1",
+		"snmpV2":      ".1.3.6.1.6",
+
+		"system":      ".1.3.6.1.2.1.1",
+		"sysDescr":    ".1.3.6.1.2.1.1.1",
+		"sysObjectID": ".1.3.6.1.2.1.1.2",
+		"sysUpTime":   ".1.3.6.1.2.1.1.3",
+		"sysContact":  ".1.3.6.1.2.1.1.4",
+		"sysName":     ".1.3.6.1.2.1.1.5",
+		"sysLocation": ".1.3.6.1.2.1.1.6",
+		"sysServices": ".1.3.6.1.2.1.1.7",
+
+		"interfaces":        ".1.3.6.1.2.1.2",
+		"ifNumber":          ".1.3.6.1.2.1.2.1",
+		"ifTable":           ".1.3.6.1.2.1.2.2",
+		"ifEntry":           ".1.3.6.1.2.1.2.2.1",
+		"ifIndex":           ".1.3.6.1.2.1.2.2.1.1",
+		"ifDescr":           ".1.3.6.1.2.1.2.2.1.2",
+		"ifType":            ".1.3.6.1.2.1.2.2.1.3",
+		"ifMtu":             ".1.3.6.1.2.1.2.2.1.4",
+		"ifSpeed":           ".1.3.6.1.2.1.2.2.1.5",
+		"ifPhysAddress":     ".1.3.6.1.2.1.2.2.1.6",
+		"ifAdminStatus":     ".1.3.6.1.2.1.2.2.1.7",
+		"ifOperStatus":      ".1.3.6.1.2.1.2.2.1.8",
+		"ifLastChange":      ".1.3.6.1.2.1.2.2.1.9",
+		"ifInOctets":        ".1.3.6.1.2.1.2.2.1.10",
+		"ifInUcastPkts":     ".1.3.6.1.2.1.2.2.1.11",
+		"ifInNUcastPkts":    ".1.3.6.1.2.1.2.2.1.12",
+		"ifInDiscards":      ".1.3.6.1.2.1.2.2.1.13",
+		"ifInErrors":        ".1.3.6.1.2.1.2.2.1.14",
+		"ifInUnknownProtos": ".1.3.6.1.2.1.2.2.1.15",
+		"ifOutOctets":       ".1.3.6.1.2.1.2.2.1.16",
+		"ifOutUcastPkts":    ".1.3.6.1.2.1.2.2.1.17",
+		"ifOutNUcastPkts":   ".1.3.6.1.2.1.2.2.1.18",
+		"ifOutDiscards":     ".1.3.6.1.2.1.2.2.1.19",
+		"ifOutErrors":       ".1.3.6.1.2.1.2.2.1.20",
+		"ifOutQLen":         ".1.3.6.1.2.1.2.2.1.21",
+
+		"ifMIB":                ".1.3.6.1.2.1.31",
+		"ifXTable":             ".1.3.6.1.2.1.31.1.1",
+		"ifXEntry":             ".1.3.6.1.2.1.31.1.1.1",
+		"ifName":               ".1.3.6.1.2.1.31.1.1.1.1",
+		"ifInMulticastPkts":    ".1.3.6.1.2.1.31.1.1.1.2",
+		"ifInBroadcastPkts":    ".1.3.6.1.2.1.31.1.1.1.3",
+		"ifOutMulticastPkts":   ".1.3.6.1.2.1.31.1.1.1.4",
+		"ifOutBroadcastPkts":   ".1.3.6.1.2.1.31.1.1.1.5",
+		"ifHCInOctets":         ".1.3.6.1.2.1.31.1.1.1.6",
+		"ifHCInUcastPkts":      ".1.3.6.1.2.1.31.1.1.1.7",
+		"ifHCInMulticastPkts":  ".1.3.6.1.2.1.31.1.1.1.8",
+		"ifHCInBroadcastPkts":  ".1.3.6.1.2.1.31.1.1.1.9",
+		"ifHCOutOctets":        ".1.3.6.1.2.1.31.1.1.1.10",
+		"ifHCOutUcastPkts":     ".1.3.6.1.2.1.31.1.1.1.11",
+		"ifHCOutMulticastPkts": ".1.3.6.1.2.1.31.1.1.1.12",
+		"ifHCOutBroadcastPkts": ".1.3.6.1.2.1.31.1.1.1.13",
+		"ifHighSpeed":          ".1.3.6.1.2.1.31.1.1.1.15",
+		"ifAlias":              ".1.3.6.1.2.1.31.1.1.1.18",
+
+		"ip":                ".1.3.6.1.2.1.4",
+		"ipAddrTable":       ".1.3.6.1.2.1.4.20",
+		"ipAdEntAddr":       ".1.3.6.1.2.1.4.20.1.1",
+		"ipAdEntIfIndex":    ".1.3.6.1.2.1.4.20.1.2",
+		"ipAdEntNetMask":    ".1.3.6.1.2.1.4.20.1.3",
+		"ipRouteTable":      ".1.3.6.1.2.1.4.21",
+		"ipNetToMediaTable": ".1.3.6.1.2.1.4.22",
+		"tcp":               ".1.3.6.1.2.1.6",
+		"tcpConnTable":      ".1.3.6.1.2.1.6.13",
+		"udp":               ".1.3.6.1.2.1.7",
+		"udpTable":          ".1.3.6.1.2.1.7.5",
+		"snmp":              ".1.3.6.1.2.1.11",
+
+		"dot1dTpFdbTable":      ".1.3.6.1.2.1.17.4.3",
+		"host":                 ".1.3.6.1.2.1.25",
+		"hrStorageTable":       ".1.3.6.1.2.1.25.2.3",
+		"hrStorageDescr":       ".1.3.6.1.2.1.25.2.3.1.3",
+		"hrStorageSize":        ".1.3.6.1.2.1.25.2.3.1.5",
+		"hrStorageUsed":        ".1.3.6.1.2.1.25.2.3.1.6",
+		"hrProcessorLoad":      ".1.3.6.1.2.1.25.3.3.1.2",
+		"entPhysicalTable":     ".1.3.6.1.2.1.47.1.1.1",
+		"entPhysicalDescr":     ".1.3.6.1.2.1.47.1.1.1.1.2",
+		"entPhysicalName":      ".1.3.6.1.2.1.47.1.1.1.1.7",
+		"entPhysicalSerialNum": ".1.3.6.1.2.1.47.1.1.1.1.11",
+
+		"snmpTrapOID":        ".1.3.6.1.6.3.1.1.4.1",
+		"snmpTrapEnterprise": ".1.3.6.1.6.3.1.1.4.3",
+	}
+)
+
+// RegisterOidName - make symbolic name resolvable
+func RegisterOidName(name string, oid string) {
+	oidNamesMu.Lock()
+	oidNames[name] = "." + strings.Trim(oid, ".")
+	oidNamesMu.Unlock()
+}
+
+// ResolveOid - numeric oid for numeric or symbolic input
+//
+// Symbolic input is a known name optionally followed by numeric
+// sub-identifiers, e.g. ifDescr.3; an "IF-MIB::" style module prefix
+// is ignored. A trailing ".*" wildcard is preserved.
+func ResolveOid(oid string) (string, error) {
+	if oid == "" || oid[0] == '.' || (oid[0] >= '0' && oid[0] <= '9') {
+		return oid, nil
+	}
+	if i := strings.Index(oid, "::"); i >= 0 {
+		oid = oid[i+2:]
+	}
+
+	name, rest := oid, ""
+	if i := strings.Index(oid, "."); i >= 0 {
+		name, rest = oid[:i], oid[i:]
+	}
+
+	oidNamesMu.RLock()
+	numeric, ok := oidNames[name]
+	oidNamesMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown oid name %s", name)
+	}
+	return numeric + rest, nil
+}
+
+// ResolveOids - resolve every oid, failing on the first unknown name
+func ResolveOids(oids []string) ([]string, error) {
+	resolved := make([]string, len(oids))
+	for i, oid := range oids {
+		numeric, err := ResolveOid(oid)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = numeric
+	}
+	return resolved, nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIDoc - description of an operation in the OpenAPI document
+//
+// Request and Response are zero values of the body types; their schemas
+// are derived from the json tags. A nil Response stands for rendered
+// varbinds, whose shape depends on the format options.
+type APIDoc struct {
+	Summary    string
+	Request    interface{}
+	Response   interface{}
+	Status     int
+	Deprecated bool
+}
+
+// apiDocs - documented operations keyed by method and route template
+var apiDocs = map[string]APIDoc{
+	"POST /api/v1/snmp/{snmp_version}/multi":                                         {Summary: "GET or walk oids on many targets", Request: MultiRequest{}, Response: map[string]MultiTargetResult{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}":                                       {Summary: "GET oids listed in the body, 207 if some are missing, ?strict=true fails instead", Request: OidList{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/get":                                  {Summary: "GET oids listed in the body, 207 if some are missing, ?strict=true fails instead", Request: OidList{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/{oid}":                                 {Summary: "GET one oid, or fields and indexes of the body below it as a matrix", Request: GetFieldsRequest{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/{base_oid}/{index}":                    {Summary: "GET fields of the body at index below base_oid", Request: GetFieldsRequest{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/{base_oid}/matrix":                    {Summary: "GET fields at every index below base_oid, grouped by index", Request: GetFieldsRequest{}, Response: MatrixResult{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/{base_oid}/table":                      {Summary: "Walk a table and return rows keyed by index", Response: map[string]map[string]interface{}{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/{base_oid}/walk":                       {Summary: "Walk the subtree of base_oid"},
+	"GET /api/v1/snmp/{snmp_version}/{target}/{base_oid}/bulkwalk":                   {Summary: "Walk the subtree of base_oid with GETBULK"},
+	"POST /api/v1/snmp/{snmp_version}/{target}/getbulk":                              {Summary: "Single GETBULK of the oids in the body", Request: OidList{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/walk/{base_oid}":                       {Summary: "Walk the subtree of base_oid, alias of {base_oid}/walk"},
+	"GET /api/v1/snmp/{snmp_version}/{target}/bulkwalk/{base_oid}":                   {Summary: "Walk the subtree of base_oid with GETBULK, alias of {base_oid}/bulkwalk"},
+	"POST /api/v1/snmp/{snmp_version}/{target}/set":                                  {Summary: "SET absolute oids", Request: SetEntryRequest{}},
+	"PUT /api/v1/snmp/{snmp_version}/{target}/{base_oid}":                            {Summary: "SET oids relative to base_oid", Request: SetEntryRequest{}},
+	"PUT /api/v1/snmp/{snmp_version}/{target}/{base_oid}/{index}":                    {Summary: "SET columns of the row at index", Request: SetEntryRequest{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/{row_oid}/{index}":                    {Summary: "Create a row with createAndGo", Request: SetEntryRequest{}, Deprecated: true},
+	"DELETE /api/v1/snmp/{snmp_version}/{target}/{row_oid}/{index}":                  {Summary: "Destroy a row, ?mode=soft sets it notInService"},
+	"DELETE /api/v1/snmp/{snmp_version}/{target}/{row_oid}":                          {Summary: "Destroy many rows", Request: BulkDeleteRequest{}, Response: []RowResult{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/{row_oid}/{index}/activate":           {Summary: "Set a row active"},
+	"POST /api/v1/snmp/{snmp_version}/{target}/sequence":                             {Summary: "Ordered SET steps", Request: SequenceRequest{}, Response: []StepResult{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/transaction":                          {Summary: "Set, create and delete steps rolled back on failure", Request: TransactionRequest{}, Response: TransactionResult{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/exists/{oid}":                          {Summary: "204 if the instance exists, 404 otherwise", Status: http.StatusNoContent},
+	"GET /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows":                   {Summary: "Rows of a defined table", Response: []TableRow{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/system":                                {Summary: "System group with uptime in seconds, ?vendor=true maps sysObjectID to its vendor", Response: SystemInfo{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/interfaces":                            {Summary: "Interfaces of ifTable merged with ifXTable", Response: []Interface{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/interfaces/{if_index}":                 {Summary: "Interface by ifIndex", Response: Interface{}},
+	"PUT /api/v1/snmp/{snmp_version}/{target}/interfaces/{if_index}":                 {Summary: "Set admin status and alias of an interface", Request: InterfaceChange{}, Response: Interface{}},
+	"GET /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows/{index}":           {Summary: "Row of a defined table", Response: TableRow{}},
+	"POST /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows/{index}":          {Summary: "Create a row of a defined table", Request: RowRequest{}, Response: TableRow{}, Status: http.StatusCreated},
+	"PATCH /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows/{index}":         {Summary: "Set columns of a row of a defined table", Request: RowRequest{}, Response: TableRow{}},
+	"DELETE /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows/{index}":        {Summary: "Destroy a row of a defined table", Status: http.StatusNoContent},
+	"POST /api/v1/snmp/{snmp_version}/{target}/tables/{table}/rows/{index}/activate": {Summary: "Set a row of a defined table active", Status: http.StatusNoContent},
+	"POST /api/v1/groups/{snmp_version}/set":                                         {Summary: "SET on a group of targets", Request: GroupSetRequest{}, Response: GroupSetResult{}},
+	"GET /api/v1/changes":                                                            {Summary: "Changes waiting for approval", Response: []PendingChange{}},
+	"GET /api/v1/changes/{id}":                                                       {Summary: "Change waiting for approval", Response: PendingChange{}},
+	"GET /api/v1/scheduled":                                                          {Summary: "Scheduled writes", Response: []ScheduledChange{}},
+	"GET /api/v1/scheduled/{id}":                                                     {Summary: "Scheduled write", Response: ScheduledChange{}},
+	"GET /api/v1/maintenance-windows":                                                {Summary: "Maintenance windows", Response: []MaintenanceWindow{}},
+	"PUT /api/v1/maintenance-windows/{name}":                                         {Summary: "Create or replace a maintenance window", Request: MaintenanceWindow{}, Response: MaintenanceWindow{}},
+	"GET /api/v1/jobs":                                                               {Summary: "Background walks", Response: []Job{}},
+	"POST /api/v1/jobs":                                                              {Summary: "Queue a background walk", Request: JobRequest{}, Response: Job{}, Status: http.StatusAccepted},
+	"GET /api/v1/jobs/{id}":                                                          {Summary: "Background walk with a page of results", Response: JobPage{}},
+	"GET /api/v1/polls":                                                              {Summary: "Scheduled polls", Response: []Poll{}},
+	"POST /api/v1/polls":                                                             {Summary: "Register a poll", Request: Poll{}, Response: Poll{}, Status: http.StatusCreated},
+	"GET /api/v1/polls/{id}":                                                         {Summary: "Scheduled poll", Response: Poll{}},
+	"GET /api/v1/polls/{id}/latest":                                                  {Summary: "Newest sample of a poll", Response: PollSample{}},
+	"GET /api/v1/polls/{id}/history":                                                 {Summary: "Kept samples of a poll", Response: []PollSample{}},
+	"GET /api/v1/alerts":                                                             {Summary: "Alert rules", Response: []AlertRule{}},
+	"POST /api/v1/alerts":                                                            {Summary: "Create an alert rule", Request: AlertRule{}, Response: AlertRule{}, Status: http.StatusCreated},
+	"GET /api/v1/alerts/active":                                                      {Summary: "Pending and firing alerts", Response: []Alert{}},
+	"GET /api/v1/alerts/{id}":                                                        {Summary: "Alert rule with the state of its alerts", Response: AlertRuleDetail{}},
+	"GET /api/v1/admin/log-level":                                                    {Summary: "Lowest level logged", Response: LogLevel{}},
+	"PUT /api/v1/admin/log-level":                                                    {Summary: "Change the log level until restart", Request: LogLevel{}, Response: LogLevel{}},
+	"GET /api/v1/admin/write-policy":                                                 {Summary: "Write policy and read-only mode", Response: WritePolicy{}},
+	"GET /api/v1/admin/transforms":                                                   {Summary: "User defined result transforms and route defaults", Response: TransformConfig{}},
+	"PUT /api/v1/admin/transforms":                                                   {Summary: "Replace the user defined result transforms and route defaults", Request: TransformConfig{}, Response: TransformConfig{}},
+	"PUT /api/v1/admin/write-policy":                                                 {Summary: "Replace the write policy, read_only included", Request: WritePolicy{}, Response: WritePolicy{}},
+	"GET /api/v1/snmp/probe/{target}":                                                {Summary: "Snmp versions, v3 engine and sysDescr a target answers with", Response: ProbeResult{}},
+	"GET /api/v1/stats":                                                              {Summary: "Internal counters and gauges", Response: StatsSnapshot{}},
+	"GET /api/v1/tables":                                                             {Summary: "Table definitions", Response: []TableDef{}},
+	"GET /api/v1/tables/{name}":                                                      {Summary: "Table definition", Response: TableDef{}},
+	"PUT /api/v1/tables/{name}":                                                      {Summary: "Create or replace a table definition", Request: TableDef{}, Response: TableDef{}},
+	"GET /api/v1/profiles":                                                           {Summary: "Target profiles", Response: []Profile{}},
+	"GET /api/v1/profiles/{name}":                                                    {Summary: "Target profile", Response: Profile{}},
+	"PUT /api/v1/profiles/{name}":                                                    {Summary: "Create or replace a target profile", Request: Profile{}, Response: Profile{}},
+	"GET /api/v1/traps":                                                              {Summary: "Received traps", Response: []Trap{}},
+	"GET /api/v1/stream":                                                             {Summary: "Server-sent events of the channels in ?channels=", Response: Event{}},
+	"GET /api/v1/traps/webhooks":                                                     {Summary: "Trap webhooks", Response: []TrapWebhook{}},
+	"POST /api/v1/traps/webhooks":                                                    {Summary: "Register a trap webhook", Request: TrapWebhook{}, Response: TrapWebhook{}, Status: http.StatusCreated},
+	"GET /api/v1/metric-rules":                                                       {Summary: "Prometheus mapping rules", Response: []MetricRule{}},
+	"GET /api/v1/metric-rules/{name}":                                                {Summary: "Prometheus mapping rule", Response: MetricRule{}},
+	"PUT /api/v1/metric-rules/{name}":                                                {Summary: "Create or replace a Prometheus mapping rule", Request: MetricRule{}, Response: MetricRule{}},
+	"GET /api/v1/credentials":                                                        {Summary: "Stored credentials, secrets redacted", Response: []Credential{}},
+	"GET /api/v1/credentials/{name}":                                                 {Summary: "Stored credential, secrets redacted", Response: Credential{}},
+	"PUT /api/v1/credentials/{name}":                                                 {Summary: "Create or replace a stored credential", Request: Credential{}, Response: Credential{}},
+	"GET /api/v1/targets":                                                            {Summary: "Registered targets, ?tag= narrows them to those with every tag", Response: []targetView{}},
+	"GET /api/v1/targets/{name}":                                                     {Summary: "Registered target", Response: targetView{}},
+	"PUT /api/v1/targets/{name}":                                                     {Summary: "Register or replace a target", Request: RegisteredTarget{}, Response: RegisteredTarget{}},
+	"POST /api/v1/targets/discover":                                                  {Summary: "Sweep a CIDR and register the agents that answer", Request: DiscoveryRequest{}, Response: Discovery{}},
+	"GET /api/v1/targets/circuits":                                                   {Summary: "Circuit state of targets with recent timeouts", Response: []CircuitStatus{}},
+	"GET /api/v1/targets/discoveries/{id}":                                           {Summary: "Progress of a discovery", Response: Discovery{}},
+	"POST /api/v1/targets/{name}/validate":                                           {Summary: "Check which credentials a target accepts", Response: CredentialValidation{}},
+	"GET /api/v1/journal":                                                            {Summary: "Journal of writes", Response: []JournalEntry{}},
+	"GET /api/v1/journal/{id}":                                                       {Summary: "Journal entry", Response: JournalEntry{}},
+	"POST /api/v1/journal/replay":                                                    {Summary: "Reapply journal entries", Request: ReplayRequest{}, Response: []ReplayResult{}},
+	"GET /api/v1/drift/policies":                                                     {Summary: "Drift policies", Response: []DriftPolicy{}},
+	"POST /api/v1/drift/policies":                                                    {Summary: "Register a drift policy", Request: DriftPolicy{}, Response: DriftPolicy{}, Status: http.StatusCreated},
+	"GET /api/v1/drift/policies/{id}":                                                {Summary: "Drift policy", Response: DriftPolicy{}},
+	"GET /api/v1/drift/policies/{id}/report":                                         {Summary: "Latest drift report", Response: DriftReport{}},
+}
+
+// openAPIMethods - http methods OpenAPI can describe
+var openAPIMethods = map[string]bool{
+	http.MethodGet: true, http.MethodPut: true, http.MethodPost: true, http.MethodDelete: true,
+	http.MethodOptions: true, http.MethodHead: true, http.MethodPatch: true,
+}
+
+// pathParam - variable of a mux route template
+var pathParam = regexp.MustCompile(`{([^}:]+)(:[^}]+)?}`)
+
+// openAPISchemas - component schemas collected while describing types
+type openAPISchemas map[string]interface{}
+
+// schema - json schema of t, named structs as references to components
+func (s openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(time.Duration(0)):
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			s[t.Name()] = map[string]interface{}{}
+			s[t.Name()] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object - json schema of the exported fields of struct t
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	s.fields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (s openAPISchemas) fields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			s.fields(f.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = s.schema(f.Type)
+	}
+}
+
+// OpenAPI - OpenAPI 3 document of the routes of router
+//
+// Paths and methods are taken from the routes, bodies from apiDocs.
+// Routes of custom methods such as WALK cannot be described and are
+// listed under x-custom-methods of their path.
+func OpenAPI(router *mux.Router) map[string]interface{} {
+	schemas := openAPISchemas{}
+	errorSchema := schemas.schema(reflect.TypeOf(ErrorResponse{}))
+	paths := map[string]map[string]interface{}{}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pathParam.ReplaceAllString(template, "{$1}")
+		item, ok := paths[path]
+		if !ok {
+			item = map[string]interface{}{}
+			paths[path] = item
+		}
+
+		var params []interface{}
+		for _, m := range pathParam.FindAllStringSubmatch(template, -1) {
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, method := range methods {
+			if !openAPIMethods[method] {
+				custom, _ := item["x-custom-methods"].([]string)
+				item["x-custom-methods"] = append(custom, method)
+				continue
+			}
+			doc := apiDocs[method+" "+path]
+			status := doc.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			if status != http.StatusNoContent {
+				var body map[string]interface{}
+				if doc.Response != nil {
+					body = schemas.schema(reflect.TypeOf(doc.Response))
+				} else {
+					body = map[string]interface{}{"description": "varbinds rendered per the format options"}
+				}
+				response["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": body}}
+			}
+			operation := map[string]interface{}{
+				"operationId": operationID(method, path),
+				"responses": map[string]interface{}{
+					strconv.Itoa(status): response,
+					"default": map[string]interface{}{
+						"description": "error",
+						"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+					},
+				},
+			}
+			if doc.Summary != "" {
+				operation["summary"] = doc.Summary
+			}
+			if doc.Deprecated {
+				operation["deprecated"] = true
+			}
+			if len(params) > 0 {
+				operation["parameters"] = params
+			}
+			if doc.Request != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.schema(reflect.TypeOf(doc.Request))}},
+				}
+			}
+			item[strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "rest-snmp",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+		},
+	}
+}
+
+// operationID - stable camel case id of method and path, e.g. getApiV1JobsById
+func operationID(method string, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		prefix := ""
+		if strings.HasPrefix(segment, "{") {
+			prefix, segment = "By", strings.Trim(segment, "{}")
+		}
+		id += prefix
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '-' || r == '_' }) {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// OpenAPIHandler - serve the OpenAPI document of router
+func OpenAPIHandler(router *mux.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, OpenAPI(router))
+	}
+}
+
+// Deprecated - mark responses of a legacy route
+//
+// The successor is the request path with suffix appended, e.g. /walk for
+// the WALK method.
+func Deprecated(suffix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+strings.TrimSuffix(r.URL.Path, "/")+suffix+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
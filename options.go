@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Server side caps of per request session options
+var (
+	maxRequestTimeout = 30 * time.Second
+	maxRequestRetries = 5
+)
+
+// RequestOptions - per request overrides of snmp session settings
+//
+// Zero Timeout and MaxOids and negative Retries keep the session
+// defaults.
+type RequestOptions struct {
+	Timeout time.Duration
+	Retries int
+	MaxOids int
+}
+
+// requestOption - query parameter, or header if the parameter is absent
+func requestOption(r *http.Request, param string, header string) string {
+	if v := r.URL.Query().Get(param); v != "" {
+		return v
+	}
+	return r.Header.Get(header)
+}
+
+// ParseRequestOptions - timeout, retries and max_oids of request for target
+//
+// Each is read from the query or from the X-SNMP-Timeout, X-SNMP-Retries
+// and X-SNMP-Max-Oids headers. Values above the server caps, or above the
+// max oids of the target, are rejected.
+func ParseRequestOptions(r *http.Request, target string) (RequestOptions, error) {
+	o := RequestOptions{Retries: -1}
+
+	if v := requestOption(r, "timeout", "X-SNMP-Timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return o, fmt.Errorf("timeout must be a positive duration, e.g. 5s")
+		}
+		if d > maxRequestTimeout {
+			return o, fmt.Errorf("timeout cannot exceed %v", maxRequestTimeout)
+		}
+		o.Timeout = d
+	}
+	if v := requestOption(r, "retries", "X-SNMP-Retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxRequestRetries {
+			return o, fmt.Errorf("retries must be between 0 and %d", maxRequestRetries)
+		}
+		o.Retries = n
+	}
+	if v := requestOption(r, "max_oids", "X-SNMP-Max-Oids"); v != "" {
+		limit := LimitsForTarget(target).MaxOids
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || (limit > 0 && n > limit) {
+			return o, fmt.Errorf("max_oids must be between 1 and %d", limit)
+		}
+		o.MaxOids = n
+	}
+	return o, nil
+}
+
+// Apply - set overridden options on a checked out session
+//
+// SessionPool.Put restores the defaults.
+func (o RequestOptions) Apply(g *gosnmp.GoSNMP) {
+	if o.Timeout > 0 {
+		g.Timeout = o.Timeout
+	}
+	if o.Retries >= 0 {
+		g.Retries = o.Retries
+	}
+	if o.MaxOids > 0 {
+		g.MaxOids = o.MaxOids
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Policy actions
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+)
+
+// PolicyRule - writes below oid prefixes allowed or denied on matching targets
+//
+// Oids are prefixes, symbolic names accepted. Targets are exact hosts,
+// globs or CIDRs as for profiles; a rule without targets matches every
+// target.
+type PolicyRule struct {
+	Action  string   `json:"action"`
+	Oids    []string `json:"oids"`
+	Targets []string `json:"targets,omitempty"`
+
+	prefixes []string
+}
+
+// WritePolicy - rules every SET is checked against before it is sent
+//
+// The first rule matching target and oid decides; oids no rule matches
+// follow Default, allow if empty. ReadOnly denies every write.
+type WritePolicy struct {
+	ReadOnly bool         `json:"read_only"`
+	Default  string       `json:"default,omitempty"`
+	Rules    []PolicyRule `json:"rules"`
+}
+
+// validate - check actions and resolve the oid prefixes of every rule
+func (p *WritePolicy) validate() error {
+	if p.Default != "" && p.Default != PolicyAllow && p.Default != PolicyDeny {
+		return fmt.Errorf("default must be allow or deny")
+	}
+	if p.Rules == nil {
+		p.Rules = []PolicyRule{}
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Action != PolicyAllow && rule.Action != PolicyDeny {
+			return fmt.Errorf("rule %d: action must be allow or deny", i)
+		}
+		if len(rule.Oids) == 0 {
+			return fmt.Errorf("rule %d: oids required", i)
+		}
+		rule.prefixes = make([]string, len(rule.Oids))
+		for j, oid := range rule.Oids {
+			resolved, err := ResolveOid(oid)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", i, err)
+			}
+			rule.prefixes[j] = normalizeOid(resolved)
+		}
+	}
+	return nil
+}
+
+// matches - whether rule covers oid on target
+func (rule *PolicyRule) matches(target string, oid string) bool {
+	if len(rule.Targets) > 0 {
+		matched := false
+		for _, pattern := range rule.Targets {
+			if MatchTarget(pattern, target) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, prefix := range rule.prefixes {
+		if oid == prefix || strings.HasPrefix(oid, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyError - write refused by the write policy
+//
+// Rule is the index of the denying rule, -1 if denied by default or
+// read-only mode.
+type PolicyError struct {
+	Target   string
+	Oid      string
+	Rule     int
+	ReadOnly bool
+}
+
+func (e *PolicyError) Error() string {
+	switch {
+	case e.ReadOnly:
+		return "writes disabled, gateway is read-only"
+	case e.Rule < 0:
+		return fmt.Sprintf("write of %s on %s denied by default policy", e.Oid, e.Target)
+	}
+	return fmt.Sprintf("write of %s on %s denied by policy rule %d", e.Oid, e.Target, e.Rule)
+}
+
+// PolicyStore - write policy, persisted to path if not empty
+type PolicyStore struct {
+	mu     sync.RWMutex
+	path   string
+	policy WritePolicy
+}
+
+// policies - write policy of the gateway, loaded from -write-policy
+var policies = NewPolicyStore("")
+
+// NewPolicyStore - store allowing every write, persisted to path if not empty
+func NewPolicyStore(path string) *PolicyStore {
+	return &PolicyStore{path: path, policy: WritePolicy{Rules: []PolicyRule{}}}
+}
+
+// LoadPolicies - policy store from json file, missing file allows every write
+func LoadPolicies(path string) (*PolicyStore, error) {
+	s := NewPolicyStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var policy WritePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	s.policy = policy
+	return s, nil
+}
+
+// save - persist policy, caller holds the lock
+func (s *PolicyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.policy, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+// SetReadOnly - deny or allow writes regardless of the rules
+func (s *PolicyStore) SetReadOnly(readOnly bool) {
+	s.mu.Lock()
+	s.policy.ReadOnly = readOnly
+	s.mu.Unlock()
+}
+
+// ReadOnly - whether every write is denied
+func (s *PolicyStore) ReadOnly() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy.ReadOnly
+}
+
+// Check - PolicyError for the first of pdus target may not be written, nil if all may
+func (s *PolicyStore) Check(target string, pdus []gosnmp.SnmpPDU) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.policy.ReadOnly {
+		return &PolicyError{Target: target, Rule: -1, ReadOnly: true}
+	}
+	for _, pdu := range pdus {
+		oid := normalizeOid(pdu.Name)
+		action, rule := s.policy.Default, -1
+		for i := range s.policy.Rules {
+			if s.policy.Rules[i].matches(target, oid) {
+				action, rule = s.policy.Rules[i].Action, i
+				break
+			}
+		}
+		if action == PolicyDeny {
+			return &PolicyError{Target: target, Oid: oid, Rule: rule}
+		}
+	}
+	return nil
+}
+
+// CheckedSet - Check of the SET of pdus on the session g, denials logged and counted
+func (s *PolicyStore) CheckedSet(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) error {
+	err := s.Check(SessionTarget(g), pdus)
+	if err != nil {
+		stats.Inc("policy.denied")
+		SessionLogger(g).Warn("write denied", Fields{"operation": operation, "err": err})
+	}
+	return err
+}
+
+// Middleware - refuse snmp writes while read-only, before they are scheduled or held for approval
+//
+// Other writes are checked against the rules when their SET is sent,
+// see JournaledSet.
+func (s *PolicyStore) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		if s.ReadOnly() && strings.HasPrefix(route, "/api/v1/snmp/") && RequiredRole(r, route) == RoleWrite {
+			stats.Inc("policy.denied")
+			WriteError(w, http.StatusForbidden, (&PolicyError{ReadOnly: true}).Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetPolicyHandler - current write policy
+func (s *PolicyStore) GetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	WriteJSON(w, http.StatusOK, s.policy)
+}
+
+// PutPolicyHandler - replace the write policy, read_only included
+func (s *PolicyStore) PutPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var policy WritePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid policy json")
+		return
+	}
+	if err := policy.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = policy
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving write policy", Fields{"err": err})
+	}
+	RequestLogger(r).Info("write policy changed", Fields{"read_only": policy.ReadOnly, "rules": len(policy.Rules), "by": RequestIdentity(r)})
+	WriteJSON(w, http.StatusOK, policy)
+}
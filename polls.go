@@ -0,0 +1,508 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Bounds of poll settings
+const (
+	minPollInterval = time.Second
+	maxPollHistory  = 10000
+)
+
+// Poll - oids read from a target at a fixed interval
+//
+// Credentials are resolved as for jobs when the poll is created. History
+// is the number of samples kept, -poll-history if zero. With Rates the
+// counters of each sample are compared with the previous sample, see
+// CounterRates.
+type Poll struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	Version    string     `json:"snmp_version"`
+	Target     string     `json:"target"`
+	Tags       []string   `json:"tags,omitempty"`
+	Community  string     `json:"community,omitempty"`
+	Credential string     `json:"credential,omitempty"`
+	Oids       []string   `json:"oids"`
+	Interval   string     `json:"interval"`
+	History    int        `json:"history"`
+	Rates      bool       `json:"rates,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastPolled *time.Time `json:"last_polled,omitempty"`
+	NextPoll   time.Time  `json:"next_poll"`
+
+	version    gosnmp.SnmpVersion
+	oids       []string
+	interval   time.Duration
+	candidates []CandidateCommunity
+	running    bool
+	samples    []*PollSample
+}
+
+// PollSample - result of one poll
+type PollSample struct {
+	Time      time.Time      `json:"time"`
+	Duration  float64        `json:"duration_seconds"`
+	Variables interface{}    `json:"variables,omitempty"`
+	Errors    []VarbindError `json:"errors,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Derived   []CounterRate  `json:"derived,omitempty"`
+
+	pdus []gosnmp.SnmpPDU
+}
+
+// CounterRate - change of a counter since the previous sample
+//
+// Delta and Rate, per second, are zero when the counter was reset.
+type CounterRate struct {
+	Oid      string  `json:"oid"`
+	Delta    uint64  `json:"delta"`
+	Rate     float64 `json:"rate"`
+	Interval float64 `json:"interval_seconds"`
+	Wrapped  bool    `json:"wrapped,omitempty"`
+	Reset    bool    `json:"reset,omitempty"`
+}
+
+// CounterRates - deltas and rates of the counters in both samples
+//
+// A Counter32 that decreased by more than half its range wrapped once;
+// any other decrease, and every decrease of a Counter64, is taken as a
+// reset of the counter, e.g. by a reboot of the agent.
+func CounterRates(prev *PollSample, cur *PollSample) []CounterRate {
+	if prev.Error != "" || cur.Error != "" {
+		return nil
+	}
+	previous := map[string]gosnmp.SnmpPDU{}
+	for _, pdu := range prev.pdus {
+		previous[pdu.Name] = pdu
+	}
+	interval := cur.Time.Sub(prev.Time).Seconds()
+
+	var rates []CounterRate
+	for _, pdu := range cur.pdus {
+		old, ok := previous[pdu.Name]
+		if !ok || old.Type != pdu.Type || (pdu.Type != gosnmp.Counter32 && pdu.Type != gosnmp.Counter64) {
+			continue
+		}
+		rate := CounterRate{Oid: pdu.Name, Interval: interval}
+		o, n := gosnmp.ToBigInt(old.Value).Uint64(), gosnmp.ToBigInt(pdu.Value).Uint64()
+		switch {
+		case n >= o:
+			rate.Delta = n - o
+		case pdu.Type == gosnmp.Counter32 && o-n > 1<<31:
+			rate.Delta = n + 1<<32 - o
+			rate.Wrapped = true
+		default:
+			rate.Reset = true
+		}
+		if interval > 0 {
+			rate.Rate = float64(rate.Delta) / interval
+		}
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+// redacted - copy of poll safe to return to clients
+func (p *Poll) redacted() Poll {
+	c := *p
+	c.Community = ""
+	c.samples = nil
+	return c
+}
+
+// Poller - polls run by a bounded number of workers, samples kept in memory
+type Poller struct {
+	Workers int
+	History int
+
+	mu    sync.RWMutex
+	polls map[string]*Poll
+}
+
+// poller - scheduled polls of the gateway
+var poller = NewPoller()
+
+// NewPoller - poller without polls
+func NewPoller() *Poller {
+	return &Poller{
+		Workers: 8,
+		History: 100,
+		polls:   map[string]*Poll{},
+	}
+}
+
+// Run - execute due polls until stop is closed
+//
+// A poll still running when it is due again is skipped for that round.
+func (p *Poller) Run(stop <-chan struct{}) {
+	sem := make(chan struct{}, p.Workers)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, poll := range p.due(now) {
+				sem <- struct{}{}
+				go func(poll *Poll) {
+					p.execute(poll)
+					<-sem
+				}(poll)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// due - polls whose time has come, marked running
+func (p *Poller) due(now time.Time) []*Poll {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var due []*Poll
+	for _, poll := range p.polls {
+		if poll.running || poll.NextPoll.After(now) {
+			continue
+		}
+		poll.running = true
+		due = append(due, poll)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextPoll.Before(due[j].NextPoll) })
+	return due
+}
+
+func (p *Poller) execute(poll *Poll) {
+	sample := pollOnce(poll)
+
+	p.mu.Lock()
+	if n := len(poll.samples); poll.Rates && n > 0 {
+		sample.Derived = CounterRates(poll.samples[n-1], sample)
+	}
+	poll.running = false
+	poll.LastPolled = &sample.Time
+	for !poll.NextPoll.After(sample.Time) {
+		poll.NextPoll = poll.NextPoll.Add(poll.interval)
+	}
+	poll.samples = append(poll.samples, sample)
+	if len(poll.samples) > poll.History {
+		poll.samples = poll.samples[len(poll.samples)-poll.History:]
+	}
+	p.mu.Unlock()
+
+	event := PollEvent{Poll: poll.ID, Name: poll.Name, Sample: *sample}
+	if sample.Error == "" {
+		pdus := append([]gosnmp.SnmpPDU(nil), sample.pdus...)
+		event.Sample.Variables = SanitizeResultVariables(&pdus)
+	}
+	events.Publish(ChannelPolls, poll.Target, event)
+	alerts.Evaluate(poll, sample)
+}
+
+// pollOnce - read the oids of poll
+func pollOnce(poll *Poll) *PollSample {
+	sample := &PollSample{Time: time.Now()}
+	stats.Inc("polls.executed")
+	defer func() {
+		sample.Duration = time.Since(sample.Time).Seconds()
+		if sample.Error != "" {
+			stats.Inc("polls.failed")
+		}
+	}()
+
+	g, _, err := ConnectWithFallback(poll.Target, poll.version, poll.candidates)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	defer sessions.Put(g)
+
+	if err := LimitsForTarget(SessionTarget(g)).CheckGet(g, PlainOids(poll.oids)); err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	sample.pdus, sample.Errors, err = GetWithWildcards(g, poll.oids)
+	if err != nil {
+		sample.Error = err.Error()
+	}
+	return sample
+}
+
+// rendered - copy of sample with variables formatted per request options
+func (s *PollSample) rendered(r *http.Request) (PollSample, error) {
+	c := *s
+	if s.Error != "" {
+		return c, nil
+	}
+	// Copied as legacy output sanitizes in place
+	pdus := append([]gosnmp.SnmpPDU(nil), s.pdus...)
+	variables, err := formatVariables(r, pdus)
+	if err != nil {
+		return c, err
+	}
+	c.Variables = variables
+	return c, nil
+}
+
+func (p *Poller) poll(id string) (*Poll, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	poll, ok := p.polls[id]
+	return poll, ok
+}
+
+// Count - number of polls
+func (p *Poller) Count() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.polls)
+}
+
+// CreatePollHandler - register a poll, first run within a second
+//
+// The target may be a registered name. Without target, tags creates one
+// poll for every registered target carrying all of them, answered with
+// the list of polls.
+func (p *Poller) CreatePollHandler(w http.ResponseWriter, r *http.Request) {
+	poll := &Poll{}
+	if err := json.NewDecoder(r.Body).Decode(poll); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid poll json")
+		return
+	}
+	if poll.Version == "" {
+		poll.Version = "v2c"
+	}
+	version, err := ParseSnmpVersion(poll.Version)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (poll.Target == "" && len(poll.Tags) == 0) || len(poll.Oids) == 0 {
+		WriteError(w, http.StatusBadRequest, "target or tags, and oids required")
+		return
+	}
+	interval, err := time.ParseDuration(poll.Interval)
+	if err != nil || interval < minPollInterval {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("interval must be a duration of at least %v", minPollInterval))
+		return
+	}
+	if poll.History == 0 {
+		poll.History = p.History
+	}
+	if poll.History < 1 || poll.History > maxPollHistory {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("history must be between 1 and %d", maxPollHistory))
+		return
+	}
+	oids, err := ResolveOids(poll.Oids)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Registered name of every poll to create, keyed by address
+	names := map[string]string{}
+	if poll.Target != "" {
+		names[inventory.Resolve(poll.Target)] = poll.Name
+	} else {
+		for _, t := range inventory.WithTags(poll.Tags) {
+			names[t.Address] = t.Name
+		}
+		if len(names) == 0 {
+			WriteError(w, http.StatusBadRequest, "no registered target has tags "+strings.Join(poll.Tags, ", "))
+			return
+		}
+	}
+	addresses := make([]string, 0, len(names))
+	for address := range names {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	if target, ok := AuthorizeTargets(r, addresses); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+
+	polls := make([]*Poll, len(addresses))
+	for i, address := range addresses {
+		candidates, err := BodyCandidates(r.Header["X-Snmp-Comm"], poll.Community, poll.Credential, address, version)
+		if err == errCredentialNotAllowed {
+			WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(candidates) == 0 {
+			WriteError(w, http.StatusBadRequest, "SNMP Community undefined")
+			return
+		}
+
+		c := *poll
+		c.ID = NewID()
+		c.Name = names[address]
+		c.Target = address
+		c.CreatedBy = RequestIdentity(r)
+		c.CreatedAt = time.Now()
+		c.NextPoll = c.CreatedAt
+		c.LastPolled = nil
+		c.version = version
+		c.oids = oids
+		c.interval = interval
+		c.candidates = candidates
+		polls[i] = &c
+	}
+
+	created := make([]Poll, len(polls))
+	p.mu.Lock()
+	for i, c := range polls {
+		p.polls[c.ID] = c
+		created[i] = c.redacted()
+	}
+	p.mu.Unlock()
+
+	if poll.Target == "" {
+		WriteJSON(w, http.StatusCreated, created)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/polls/"+created[0].ID)
+	WriteJSON(w, http.StatusCreated, created[0])
+}
+
+// ListPollsHandler - registered polls
+func (p *Poller) ListPollsHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	polls := make([]Poll, 0, len(p.polls))
+	for _, poll := range p.polls {
+		polls = append(polls, poll.redacted())
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(polls, func(i, j int) bool { return polls[i].CreatedAt.Before(polls[j].CreatedAt) })
+	WriteJSON(w, http.StatusOK, polls)
+}
+
+// GetPollHandler - single poll
+func (p *Poller) GetPollHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.RLock()
+	poll, ok := p.polls[mux.Vars(r)["id"]]
+	var c Poll
+	if ok {
+		c = poll.redacted()
+	}
+	p.mu.RUnlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "poll not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, c)
+}
+
+// DeletePollHandler - stop poll and drop its samples
+func (p *Poller) DeletePollHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	p.mu.Lock()
+	_, ok := p.polls[id]
+	delete(p.polls, id)
+	p.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "poll not found")
+		return
+	}
+	alerts.ForgetPoll(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LatestHandler - most recent sample of poll
+func (p *Poller) LatestHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := p.poll(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "poll not found")
+		return
+	}
+
+	p.mu.RLock()
+	var latest *PollSample
+	if n := len(poll.samples); n > 0 {
+		latest = poll.samples[n-1]
+	}
+	p.mu.RUnlock()
+
+	if latest == nil {
+		WriteError(w, http.StatusNotFound, "poll has not run yet")
+		return
+	}
+	sample, err := latest.rendered(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, sample)
+}
+
+// HistoryHandler - kept samples of poll, oldest first
+//
+// ?since= (RFC3339) drops older samples, ?limit= keeps only the newest.
+func (p *Poller) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := p.poll(mux.Vars(r)["id"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "poll not found")
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "since must be RFC3339")
+			return
+		}
+		since = t
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			WriteError(w, http.StatusBadRequest, "limit must be positive")
+			return
+		}
+		limit = n
+	}
+
+	p.mu.RLock()
+	var kept []*PollSample
+	for _, s := range poll.samples {
+		if !s.Time.Before(since) {
+			kept = append(kept, s)
+		}
+	}
+	p.mu.RUnlock()
+	if limit > 0 && len(kept) > limit {
+		kept = kept[len(kept)-limit:]
+	}
+
+	samples := make([]PollSample, 0, len(kept))
+	for _, s := range kept {
+		sample, err := s.rendered(r)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		samples = append(samples, sample)
+	}
+	WriteJSON(w, http.StatusOK, samples)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// pooledSession - idle session and when it was returned
+type pooledSession struct {
+	g        *gosnmp.GoSNMP
+	key      string
+	returned time.Time
+}
+
+// SessionPool - reusable snmp sessions keyed by target, version and credentials
+//
+// A session is used by one request at a time: Get checks out an idle
+// session or dials a new one and Put returns it. At most MaxSessions are
+// open; when the cap is reached the oldest idle session of another key is
+// closed, or Get waits until a session is returned. Sessions idle for
+// longer than IdleTimeout are closed by Run.
+type SessionPool struct {
+	MaxSessions int
+	IdleTimeout time.Duration
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	open  int
+	inUse int
+	idle  map[string][]*pooledSession
+	keys  map[*gosnmp.GoSNMP]string
+}
+
+// sessions - pool used for every snmp request
+var sessions = NewSessionPool(256, 2*time.Minute)
+
+// NewSessionPool - empty pool
+func NewSessionPool(maxSessions int, idleTimeout time.Duration) *SessionPool {
+	p := &SessionPool{
+		MaxSessions: maxSessions,
+		IdleTimeout: idleTimeout,
+		idle:        map[string][]*pooledSession{},
+		keys:        map[*gosnmp.GoSNMP]string{},
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// sessionKey - pool key of session parameters
+func sessionKey(target string, version gosnmp.SnmpVersion, community string) string {
+	return fmt.Sprintf("%s|%d|%s", target, version, community)
+}
+
+// Key - pool key of checked out session g
+func (p *SessionPool) Key(g *gosnmp.GoSNMP) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[g]; ok {
+		return key
+	}
+	return sessionKey(SessionTarget(g), g.Version, g.Community)
+}
+
+// Get - idle session for parameters or a newly connected one
+func (p *SessionPool) Get(target string, version gosnmp.SnmpVersion, community string) (*gosnmp.GoSNMP, error) {
+	key := sessionKey(target, version, community)
+
+	p.mu.Lock()
+	for {
+		if idle := p.idle[key]; len(idle) > 0 {
+			s := idle[len(idle)-1]
+			p.idle[key] = idle[:len(idle)-1]
+			p.keys[s.g] = key
+			p.inUse++
+			p.mu.Unlock()
+			stats.Inc("pool.hits")
+			return s.g, nil
+		}
+		if p.MaxSessions <= 0 || p.open < p.MaxSessions {
+			break
+		}
+		if !p.closeOldestIdle() {
+			stats.Inc("pool.waits")
+			p.cond.Wait()
+		}
+	}
+	p.open++
+	p.inUse++
+	p.mu.Unlock()
+
+	stats.Inc("pool.misses")
+	g, err := NewSnmpSession(target, version, community)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.open--
+		p.inUse--
+		p.cond.Signal()
+		return nil, err
+	}
+	p.keys[g] = key
+	return g, nil
+}
+
+// Put - return checked out session for reuse
+//
+// Settings a request may have changed are reset to the session defaults.
+func (p *SessionPool) Put(g *gosnmp.GoSNMP) {
+	g.Timeout = gosnmp.Default.Timeout
+	g.Retries = gosnmp.Default.Retries
+	g.MaxOids = LimitsForTarget(SessionTarget(g)).MaxOids
+	g.MaxRepetitions = uint8(sizes.For(SessionTarget(g)).MaxRepetitions)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[g]
+	if !ok {
+		g.Conn.Close()
+		return
+	}
+	delete(p.keys, g)
+	p.inUse--
+	p.idle[key] = append(p.idle[key], &pooledSession{g: g, key: key, returned: time.Now()})
+	p.cond.Signal()
+}
+
+// closeOldestIdle - close least recently returned idle session, caller holds the lock
+func (p *SessionPool) closeOldestIdle() bool {
+	var oldest *pooledSession
+	for _, idle := range p.idle {
+		if len(idle) > 0 && (oldest == nil || idle[0].returned.Before(oldest.returned)) {
+			oldest = idle[0]
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	p.idle[oldest.key] = p.idle[oldest.key][1:]
+	if len(p.idle[oldest.key]) == 0 {
+		delete(p.idle, oldest.key)
+	}
+	oldest.g.Conn.Close()
+	p.open--
+	stats.Inc("pool.evictions")
+	return true
+}
+
+// evictIdle - close sessions idle since before cutoff
+func (p *SessionPool) evictIdle(cutoff time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, idle := range p.idle {
+		// idle sessions are appended in return order
+		n := 0
+		for n < len(idle) && idle[n].returned.Before(cutoff) {
+			idle[n].g.Conn.Close()
+			n++
+		}
+		p.open -= n
+		stats.Add("pool.evictions", int64(n))
+		if n == len(idle) {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = idle[n:]
+		}
+	}
+	p.cond.Broadcast()
+}
+
+// Run - evict idle sessions until stop is closed, then close all idle ones
+func (p *SessionPool) Run(stop <-chan struct{}) {
+	interval := p.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.evictIdle(now.Add(-p.IdleTimeout))
+		case <-stop:
+			p.evictIdle(time.Now().Add(time.Hour))
+			return
+		}
+	}
+}
+
+// Counts - open, in use and idle sessions
+func (p *SessionPool) Counts() (int, int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.open, p.inUse, p.open - p.inUse
+}
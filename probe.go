@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// sysDescrOid - sysDescr.0, read by probes
+const sysDescrOid = ".1.3.6.1.2.1.1.1.0"
+
+// probeUser - USM user of engine discovery, any name is answered with the engine id
+const probeUser = "rest-snmp-probe"
+
+// probeVersions - versions probed unless ?versions= narrows them, lowest first
+var probeVersions = []gosnmp.SnmpVersion{gosnmp.Version1, gosnmp.Version2c, gosnmp.Version3}
+
+// VersionProbe - GET of sysDescr.0 with one snmp version
+//
+// Credential is where the working community came from, request, profile
+// or store, or the name of the stored v3 credential; never its value.
+type VersionProbe struct {
+	Version    string  `json:"snmp_version"`
+	OK         bool    `json:"ok"`
+	Credential string  `json:"credential,omitempty"`
+	RTT        float64 `json:"rtt_ms,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// EngineProbe - SNMPv3 engine discovery, answered without credentials
+type EngineProbe struct {
+	OK     bool    `json:"ok"`
+	ID     string  `json:"engine_id,omitempty"`
+	Boots  uint32  `json:"engine_boots,omitempty"`
+	Time   uint32  `json:"engine_time,omitempty"`
+	RTT    float64 `json:"rtt_ms,omitempty"`
+	Error  string  `json:"error,omitempty"`
+	Vendor string  `json:"vendor,omitempty"`
+}
+
+// ProbeResult - snmp versions and engine a target answers to
+type ProbeResult struct {
+	Target         string         `json:"target"`
+	HighestVersion string         `json:"highest_version,omitempty"`
+	SysDescr       string         `json:"sys_descr,omitempty"`
+	Engine         *EngineProbe   `json:"engine,omitempty"`
+	Versions       []VersionProbe `json:"versions"`
+}
+
+// probeCandidates - credentials tried for version, the named one only if the request names one
+func probeCandidates(r *http.Request, target string, version gosnmp.SnmpVersion) ([]CandidateCommunity, error) {
+	if name := RequestCredential(r); name != "" {
+		candidate, err := credentials.Reference(name, target, version)
+		if err != nil {
+			return nil, err
+		}
+		return []CandidateCommunity{candidate}, nil
+	}
+	candidates := CandidateCommunities(r.Header["X-Snmp-Comm"], target, version)
+	if len(candidates) == 0 {
+		if version == gosnmp.Version3 {
+			return nil, fmt.Errorf("no stored v3 credential for %s", target)
+		}
+		return nil, fmt.Errorf("no community given or stored for %s", target)
+	}
+	return candidates, nil
+}
+
+// probeVersion - GET sysDescr.0 with each candidate until one answers
+//
+// Sessions are not pooled and not retried, so the round trip is that of
+// a single request; for v3 it includes engine discovery.
+func probeVersion(r *http.Request, target string, version gosnmp.SnmpVersion, timeout time.Duration) (VersionProbe, string) {
+	probe := VersionProbe{Version: VersionLabel(version)}
+	candidates, err := probeCandidates(r, target, version)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe, ""
+	}
+	for _, candidate := range candidates {
+		g, err := NewSnmpSession(target, version, candidate.Community)
+		if err != nil {
+			probe.Error = err.Error()
+			return probe, ""
+		}
+		g.Timeout = timeout
+		g.Retries = 0
+		release := BindSession(g, r)
+		start := time.Now()
+		result, err := ObservedGet(g, []string{sysDescrOid})
+		rtt := time.Since(start)
+		release()
+		g.Conn.Close()
+		if err == nil {
+			err = probeResponseError(result)
+		}
+		if err != nil {
+			probe.Error = err.Error()
+			continue
+		}
+		probe.OK = true
+		probe.Error = ""
+		probe.RTT = millis(rtt)
+		probe.Credential = candidate.Source
+		if version == gosnmp.Version3 {
+			probe.Credential = candidate.Community
+		}
+		descr := ""
+		if pdu := result.Variables[0]; pdu.Type == gosnmp.OctetString {
+			descr = octetString(pdu.Value)
+		}
+		return probe, descr
+	}
+	return probe, ""
+}
+
+// probeResponseError - error of a probe response, agent errors and usm reports included
+func probeResponseError(result *gosnmp.SnmpPacket) error {
+	if result.Error != gosnmp.NoError {
+		return fmt.Errorf("%v", result.Error)
+	}
+	if len(result.Variables) == 0 {
+		return fmt.Errorf("empty response")
+	}
+	if result.PDUType == gosnmp.Report {
+		return fmt.Errorf("report %s", result.Variables[0].Name)
+	}
+	return nil
+}
+
+// probeEngine - discover the SNMPv3 engine of target
+//
+// gosnmp discovers the engine before its first v3 request; the GET that
+// follows with an unknown user is expected to be refused by the agent.
+func probeEngine(r *http.Request, target string, timeout time.Duration) *EngineProbe {
+	probe := &EngineProbe{}
+	addr, err := ParseTarget(target)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	usm := &gosnmp.UsmSecurityParameters{UserName: probeUser}
+	g := &gosnmp.GoSNMP{
+		Target:             addr.Host,
+		Port:               addr.Port,
+		Transport:          addr.Transport,
+		Version:            gosnmp.Version3,
+		Timeout:            timeout,
+		SecurityModel:      gosnmp.UserSecurityModel,
+		MsgFlags:           gosnmp.NoAuthNoPriv,
+		SecurityParameters: usm,
+	}
+	if err := g.Connect(); err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer g.Conn.Close()
+
+	release := BindSession(g, r)
+	start := time.Now()
+	_, err = ObservedGet(g, []string{sysDescrOid})
+	rtt := time.Since(start)
+	release()
+	if usm.AuthoritativeEngineID == "" {
+		if err == nil {
+			err = fmt.Errorf("no engine id in response")
+		}
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.OK = true
+	probe.ID = hex.EncodeToString([]byte(usm.AuthoritativeEngineID))
+	probe.Boots = usm.AuthoritativeEngineBoots
+	probe.Time = usm.AuthoritativeEngineTime
+	probe.RTT = millis(rtt)
+	probe.Vendor = engineVendor(usm.AuthoritativeEngineID)
+	return probe
+}
+
+// engineVendor - vendor of the enterprise number an RFC 3411 engine id starts with
+func engineVendor(id string) string {
+	if len(id) < 4 {
+		return ""
+	}
+	enterprise := int(id[0]&0x7f)<<24 | int(id[1])<<16 | int(id[2])<<8 | int(id[3])
+	return enterpriseNumbers[enterprise]
+}
+
+// parseProbeVersions - versions of ?versions=, all by default
+func parseProbeVersions(r *http.Request) ([]gosnmp.SnmpVersion, error) {
+	param := r.URL.Query().Get("versions")
+	if param == "" {
+		return probeVersions, nil
+	}
+	seen := map[gosnmp.SnmpVersion]bool{}
+	for _, label := range strings.Split(param, ",") {
+		version, err := ParseSnmpVersion(strings.TrimSpace(label))
+		if err != nil {
+			return nil, fmt.Errorf("versions must be a list of v1, v2c and v3")
+		}
+		seen[version] = true
+	}
+	var versions []gosnmp.SnmpVersion
+	for _, version := range probeVersions {
+		if seen[version] {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// ProbeHandler - snmp versions, engine and sysDescr of target
+//
+// v1 and v2c are tried with the X-SNMP-COMM communities and those of the
+// profile and credential store, v3 with the stored v3 credentials, or
+// only with the credential named by ?credential. Engine discovery needs
+// no credentials, so an engine id without any working version points at
+// credentials rather than the network.
+func ProbeHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := RequestTarget(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	versions, err := parseProbeVersions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	options, err := ParseRequestOptions(r, target)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeout := gosnmp.Default.Timeout
+	if options.Timeout > 0 {
+		timeout = options.Timeout
+	}
+	if name := RequestCredential(r); name != "" {
+		c := credentials.Lookup(name)
+		if c == nil {
+			WriteError(w, http.StatusBadRequest, "unknown credential "+name)
+			return
+		}
+		if !c.Allows(target) {
+			WriteError(w, http.StatusForbidden, errCredentialNotAllowed.Error())
+			return
+		}
+	}
+
+	result := ProbeResult{Target: target, Versions: make([]VersionProbe, len(versions))}
+	descrs := make([]string, len(versions))
+	var wg sync.WaitGroup
+	for i, version := range versions {
+		wg.Add(1)
+		go func(i int, version gosnmp.SnmpVersion) {
+			defer wg.Done()
+			result.Versions[i], descrs[i] = probeVersion(r, target, version, timeout)
+		}(i, version)
+		if version == gosnmp.Version3 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result.Engine = probeEngine(r, target, timeout)
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i, probe := range result.Versions {
+		if probe.OK {
+			result.HighestVersion = probe.Version
+			if descrs[i] != "" {
+				result.SysDescr = descrs[i]
+			}
+		}
+	}
+	stats.Inc("probes")
+	WriteJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// RequestLimits - limits enforced when building snmp requests
+//
+// RateLimit is in operations per second, RateBurst the operations that
+// may start at once, the rate rounded up if zero.
+type RequestLimits struct {
+	MaxOids        int     `json:"max_oids,omitempty"`
+	MaxMsgSize     int     `json:"max_msg_size,omitempty"`
+	MaxRepetitions int     `json:"max_repetitions,omitempty"`
+	RateLimit      float64 `json:"rate_limit,omitempty"`
+	RateBurst      int     `json:"rate_burst,omitempty"`
+}
+
+// Profile - snmp settings applied to matching targets
+type Profile struct {
+	Name        string   `json:"name"`
+	Targets     []string `json:"targets"`
+	Communities []string `json:"communities,omitempty"`
+	RequestLimits
+}
+
+// redacted - copy of profile safe to return to clients
+func (p *Profile) redacted() Profile {
+	c := *p
+	c.Communities = nil
+	return c
+}
+
+// validate - reject limits that cannot be applied to snmp requests
+func (p *Profile) validate() error {
+	if p.MaxOids < 0 || p.MaxMsgSize < 0 || p.MaxRepetitions < 0 || p.RateLimit < 0 || p.RateBurst < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if p.MaxRepetitions > 255 {
+		return fmt.Errorf("max_repetitions cannot exceed 255")
+	}
+	return nil
+}
+
+// ProfileStore - target profiles, optionally backed by a json file
+type ProfileStore struct {
+	mu       sync.RWMutex
+	path     string
+	profiles map[string]*Profile
+}
+
+// serverLimits - server wide defaults, overridden by target profiles
+var serverLimits = RequestLimits{MaxOids: gosnmp.MaxOids}
+
+// profiles - target profiles loaded at startup
+var profiles = NewProfileStore("")
+
+// NewProfileStore - empty profile store persisted to path if not empty
+func NewProfileStore(path string) *ProfileStore {
+	return &ProfileStore{path: path, profiles: map[string]*Profile{}}
+}
+
+// LoadProfiles - profile store from json file, missing file is empty store
+func LoadProfiles(path string) (*ProfileStore, error) {
+	s := NewProfileStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Profile
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, p := range list {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		s.profiles[p.Name] = p
+	}
+	return s, nil
+}
+
+// save - persist profiles, caller holds the lock
+func (s *ProfileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+func (s *ProfileStore) list() []*Profile {
+	list := make([]*Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ForTarget - profile of the registered target, else first profile (by name) matching target, nil if none
+func (s *ProfileStore) ForTarget(target string) *Profile {
+	name := inventory.ProfileOf(target)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.profiles[name]; ok {
+		return p
+	}
+	for _, p := range s.list() {
+		for _, pattern := range p.Targets {
+			if MatchTarget(pattern, target) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// MatchTarget - target matches exact host, glob or CIDR pattern
+//
+// Globs match the whole target or its host, so patterns without a port
+// also cover agents on other ports and transports.
+func MatchTarget(pattern string, target string) bool {
+	host := TargetHost(target)
+	if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && cidr.Contains(ip)
+	}
+	if ok, err := path.Match(pattern, target); err == nil && ok {
+		return true
+	}
+	ok, err := path.Match(pattern, host)
+	return err == nil && ok
+}
+
+// LimitsForTarget - request limits of target profile over server defaults
+func LimitsForTarget(target string) RequestLimits {
+	limits := serverLimits
+	if p := profiles.ForTarget(target); p != nil {
+		if p.MaxOids > 0 {
+			limits.MaxOids = p.MaxOids
+		}
+		if p.MaxMsgSize > 0 {
+			limits.MaxMsgSize = p.MaxMsgSize
+		}
+		if p.MaxRepetitions > 0 {
+			limits.MaxRepetitions = p.MaxRepetitions
+		}
+		if p.RateLimit > 0 {
+			limits.RateLimit = p.RateLimit
+			limits.RateBurst = p.RateBurst
+		}
+	}
+	return limits
+}
+
+// Check - error if pdus exceed varbind or encoded message size limits
+func (l RequestLimits) Check(g *gosnmp.GoSNMP, pduType gosnmp.PDUType, pdus []gosnmp.SnmpPDU) error {
+	if l.MaxOids > 0 && len(pdus) > l.MaxOids {
+		return fmt.Errorf("oid count (%d) is greater than max oids (%d)", len(pdus), l.MaxOids)
+	}
+	if l.MaxMsgSize > 0 && g.Version != gosnmp.Version3 {
+		msg, err := g.SnmpEncodePacket(pduType, pdus, 0, 0)
+		if err != nil {
+			return err
+		}
+		if len(msg) > l.MaxMsgSize {
+			return fmt.Errorf("message size (%d) is greater than max message size (%d)", len(msg), l.MaxMsgSize)
+		}
+	}
+	return nil
+}
+
+// CheckGet - error if a GET of oids on g would exceed the limits
+//
+// GETs larger than MaxOids are sent in chunks, see GetPartial, so each
+// chunk is checked rather than the whole request.
+func (l RequestLimits) CheckGet(g *gosnmp.GoSNMP, oids []string) error {
+	size := getChunkSize(g, len(oids))
+	if l.MaxOids > 0 && size > l.MaxOids {
+		size = l.MaxOids
+	}
+	for start := 0; start < len(oids); start += size {
+		end := start + size
+		if end > len(oids) {
+			end = len(oids)
+		}
+		if err := l.Check(g, gosnmp.GetRequest, NullPDUs(oids[start:end])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NullPDUs - request varbinds for oids
+func NullPDUs(oids []string) []gosnmp.SnmpPDU {
+	pdus := make([]gosnmp.SnmpPDU, len(oids))
+	for i, oid := range oids {
+		pdus[i] = gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Null}
+	}
+	return pdus
+}
+
+// ListProfilesHandler - list target profiles
+func (s *ProfileStore) ListProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := []Profile{}
+	for _, p := range s.list() {
+		list = append(list, p.redacted())
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// GetProfileHandler - single target profile
+func (s *ProfileStore) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.profiles[mux.Vars(r)["name"]]
+	if !ok {
+		WriteError(w, http.StatusNotFound, "profile not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, p.redacted())
+}
+
+// PutProfileHandler - create or replace target profile
+func (s *ProfileStore) PutProfileHandler(w http.ResponseWriter, r *http.Request) {
+	p := &Profile{}
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid profile json")
+		return
+	}
+	if err := p.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p.Name = mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[p.Name] = p
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving profiles", Fields{"err": err})
+	}
+	WriteJSON(w, http.StatusOK, p.redacted())
+}
+
+// DeleteProfileHandler - remove target profile
+func (s *ProfileStore) DeleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.profiles[name]; !ok {
+		WriteError(w, http.StatusNotFound, "profile not found")
+		return
+	}
+	delete(s.profiles, name)
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving profiles", Fields{"err": err})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
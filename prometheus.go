@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Prometheus metric types of mapping rules
+const (
+	MetricGauge   = "gauge"
+	MetricCounter = "counter"
+)
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// LabelRule - label attached to the samples of a mapping rule
+//
+// The value is taken from exactly one source: the whole instance index
+// (index), one part of a decoded table index (index_part) or the value
+// of another column with the same index (oid).
+type LabelRule struct {
+	Name      string `json:"name"`
+	Index     bool   `json:"index,omitempty"`
+	IndexPart string `json:"index_part,omitempty"`
+	Oid       string `json:"oid,omitempty"`
+}
+
+// MetricRule - oid or subtree rendered as prometheus metric
+type MetricRule struct {
+	Name    string      `json:"name"`
+	Oid     string      `json:"oid"`
+	Type    string      `json:"type"`
+	Help    string      `json:"help,omitempty"`
+	Labels  []LabelRule `json:"labels,omitempty"`
+	Targets []string    `json:"targets,omitempty"`
+}
+
+// MetricRules - user defined prometheus mapping rules
+type MetricRules struct {
+	mu    sync.RWMutex
+	rules map[string]*MetricRule
+}
+
+// NewMetricRules - empty rule set
+func NewMetricRules() *MetricRules {
+	return &MetricRules{rules: map[string]*MetricRule{}}
+}
+
+// validate - check rule and resolve its oids
+func (m *MetricRule) validate() error {
+	if !metricNameRe.MatchString(m.Name) {
+		return fmt.Errorf("invalid metric name %q", m.Name)
+	}
+	switch m.Type {
+	case "":
+		m.Type = MetricGauge
+	case MetricGauge, MetricCounter:
+	default:
+		return fmt.Errorf("type must be gauge or counter")
+	}
+
+	oid, err := ResolveOid(m.Oid)
+	if err != nil || oid == "" {
+		return fmt.Errorf("invalid oid %q", m.Oid)
+	}
+	m.Oid = "." + strings.Trim(oid, ".")
+
+	for i, l := range m.Labels {
+		if !labelNameRe.MatchString(l.Name) {
+			return fmt.Errorf("invalid label name %q", l.Name)
+		}
+		sources := 0
+		for _, set := range []bool{l.Index, l.IndexPart != "", l.Oid != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("label %s needs exactly one of index, index_part or oid", l.Name)
+		}
+		if l.Oid != "" {
+			oid, err := ResolveOid(l.Oid)
+			if err != nil {
+				return err
+			}
+			m.Labels[i].Oid = "." + strings.Trim(oid, ".")
+		}
+	}
+	return nil
+}
+
+// forTarget - rules applying to target, sorted by name
+func (rs *MetricRules) forTarget(target string, names []string) []MetricRule {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+	var rules []MetricRule
+	for _, rule := range rs.rules {
+		if len(selected) > 0 && !selected[rule.Name] {
+			continue
+		}
+		match := len(rule.Targets) == 0
+		for _, pattern := range rule.Targets {
+			match = match || MatchTarget(pattern, target)
+		}
+		if match {
+			rules = append(rules, *rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// instanceSuffix - index of pdu below base oid, false if outside
+func instanceSuffix(base string, name string) (string, bool) {
+	name = "." + strings.Trim(name, ".")
+	if name == base {
+		return "", true
+	}
+	if !strings.HasPrefix(name, base+".") {
+		return "", false
+	}
+	return name[len(base)+1:], true
+}
+
+// labelValue - label value rendered as string
+func labelValue(pdu gosnmp.SnmpPDU) string {
+	switch pdu.Type {
+	case gosnmp.OctetString:
+		return octetString(pdu.Value)
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Counter64, gosnmp.Gauge32,
+		gosnmp.TimeTicks, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).String()
+	}
+	return fmt.Sprint(pdu.Value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// RenderMetrics - prometheus text exposition of rules against session
+//
+// Every rule walks its oid; numeric varbinds become samples, others are
+// skipped. Label columns are walked once per scrape.
+func RenderMetrics(g *gosnmp.GoSNMP, rules []MetricRule) ([]byte, error) {
+	var buf bytes.Buffer
+	columns := map[string]map[string]string{}
+
+	for _, rule := range rules {
+		pdus, err := ObservedWalkAll(g, rule.Oid, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rule.Name, err)
+		}
+
+		for _, l := range rule.Labels {
+			if l.Oid == "" || columns[l.Oid] != nil {
+				continue
+			}
+			walked, err := ObservedWalkAll(g, l.Oid, false)
+			if err != nil {
+				return nil, fmt.Errorf("%s label %s: %v", rule.Name, l.Name, err)
+			}
+			values := map[string]string{}
+			for _, pdu := range walked {
+				if suffix, ok := instanceSuffix(l.Oid, pdu.Name); ok {
+					values[suffix] = labelValue(pdu)
+				}
+			}
+			columns[l.Oid] = values
+		}
+
+		if rule.Help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", rule.Name, strings.Replace(rule.Help, "\n", " ", -1))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", rule.Name, rule.Type)
+
+		indexed := DecodeIndexes(pdus)
+		for i, pdu := range pdus {
+			switch pdu.Type {
+			case gosnmp.Integer, gosnmp.Counter32, gosnmp.Counter64, gosnmp.Gauge32,
+				gosnmp.TimeTicks, gosnmp.Uinteger32:
+			default:
+				continue
+			}
+			suffix, ok := instanceSuffix(rule.Oid, pdu.Name)
+			if !ok {
+				continue
+			}
+
+			labels := make([]string, 0, len(rule.Labels))
+			for _, l := range rule.Labels {
+				var value string
+				switch {
+				case l.Index:
+					value = suffix
+				case l.IndexPart != "":
+					if v, ok := indexed[i].Index[l.IndexPart]; ok {
+						value = fmt.Sprint(v)
+					}
+				default:
+					value = columns[l.Oid][suffix]
+				}
+				labels = append(labels, l.Name+`="`+labelEscaper.Replace(value)+`"`)
+			}
+
+			buf.WriteString(rule.Name)
+			if len(labels) > 0 {
+				buf.WriteString("{" + strings.Join(labels, ",") + "}")
+			}
+			fmt.Fprintf(&buf, " %s\n", gosnmp.ToBigInt(pdu.Value).String())
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ScrapeHandler - prometheus metrics of target, ?rule= selects rules
+func (rs *MetricRules) ScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	rules := rs.forTarget(SessionTarget(g), r.URL.Query()["rule"])
+	body, err := RenderMetrics(g, rules)
+	if err != nil {
+		WriteError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write(body); err != nil {
+		RequestLogger(r).Error("writing response", Fields{"err": err})
+	}
+}
+
+// ListRulesHandler - list metric mapping rules
+func (rs *MetricRules) ListRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rs.mu.RLock()
+	rules := make([]MetricRule, 0, len(rs.rules))
+	for _, rule := range rs.rules {
+		rules = append(rules, *rule)
+	}
+	rs.mu.RUnlock()
+
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	WriteJSON(w, http.StatusOK, rules)
+}
+
+// GetRuleHandler - single metric mapping rule
+func (rs *MetricRules) GetRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rs.mu.RLock()
+	rule, ok := rs.rules[mux.Vars(r)["name"]]
+	rs.mu.RUnlock()
+	if !ok {
+		WriteError(w, http.StatusNotFound, "metric rule not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, rule)
+}
+
+// PutRuleHandler - create or replace metric mapping rule
+func (rs *MetricRules) PutRuleHandler(w http.ResponseWriter, r *http.Request) {
+	rule := &MetricRule{}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid metric rule json")
+		return
+	}
+	rule.Name = mux.Vars(r)["name"]
+	if err := rule.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rs.mu.Lock()
+	rs.rules[rule.Name] = rule
+	rs.mu.Unlock()
+	WriteJSON(w, http.StatusOK, rule)
+}
+
+// DeleteRuleHandler - remove metric mapping rule
+func (rs *MetricRules) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	rs.mu.Lock()
+	_, ok := rs.rules[name]
+	delete(rs.rules, name)
+	rs.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "metric rule not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,1028 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: restsnmp.proto
+
+package restsnmp
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// Target - /api/v1/snmp/{snmp_version}/{target}
+type Target struct {
+	SnmpVersion          string   `protobuf:"bytes,1,opt,name=snmp_version,json=snmpVersion,proto3" json:"snmp_version,omitempty"`
+	Target               string   `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Target) Reset()         { *m = Target{} }
+func (m *Target) String() string { return proto.CompactTextString(m) }
+func (*Target) ProtoMessage()    {}
+func (*Target) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{0}
+}
+
+func (m *Target) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Target.Unmarshal(m, b)
+}
+func (m *Target) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Target.Marshal(b, m, deterministic)
+}
+func (m *Target) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Target.Merge(m, src)
+}
+func (m *Target) XXX_Size() int {
+	return xxx_messageInfo_Target.Size(m)
+}
+func (m *Target) XXX_DiscardUnknown() {
+	xxx_messageInfo_Target.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Target proto.InternalMessageInfo
+
+func (m *Target) GetSnmpVersion() string {
+	if m != nil {
+		return m.SnmpVersion
+	}
+	return ""
+}
+
+func (m *Target) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+// Varbind - structured varbind of REST responses
+type Varbind struct {
+	Oid string `protobuf:"bytes,1,opt,name=oid,proto3" json:"oid,omitempty"`
+	// Type name, e.g. OctetString
+	Type string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	// Value as rendered in REST responses, json encoded
+	Value   string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Display string `protobuf:"bytes,4,opt,name=display,proto3" json:"display,omitempty"`
+	Unit    string `protobuf:"bytes,5,opt,name=unit,proto3" json:"unit,omitempty"`
+	// Exception or error of oids without value, e.g. noSuchInstance
+	Error                string   `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Varbind) Reset()         { *m = Varbind{} }
+func (m *Varbind) String() string { return proto.CompactTextString(m) }
+func (*Varbind) ProtoMessage()    {}
+func (*Varbind) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{1}
+}
+
+func (m *Varbind) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Varbind.Unmarshal(m, b)
+}
+func (m *Varbind) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Varbind.Marshal(b, m, deterministic)
+}
+func (m *Varbind) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Varbind.Merge(m, src)
+}
+func (m *Varbind) XXX_Size() int {
+	return xxx_messageInfo_Varbind.Size(m)
+}
+func (m *Varbind) XXX_DiscardUnknown() {
+	xxx_messageInfo_Varbind.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Varbind proto.InternalMessageInfo
+
+func (m *Varbind) GetOid() string {
+	if m != nil {
+		return m.Oid
+	}
+	return ""
+}
+
+func (m *Varbind) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Varbind) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *Varbind) GetDisplay() string {
+	if m != nil {
+		return m.Display
+	}
+	return ""
+}
+
+func (m *Varbind) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
+func (m *Varbind) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type GetRequest struct {
+	Target               *Target  `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Oids                 []string `protobuf:"bytes,2,rep,name=oids,proto3" json:"oids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetRequest) Reset()         { *m = GetRequest{} }
+func (m *GetRequest) String() string { return proto.CompactTextString(m) }
+func (*GetRequest) ProtoMessage()    {}
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{2}
+}
+
+func (m *GetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetRequest.Unmarshal(m, b)
+}
+func (m *GetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetRequest.Marshal(b, m, deterministic)
+}
+func (m *GetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetRequest.Merge(m, src)
+}
+func (m *GetRequest) XXX_Size() int {
+	return xxx_messageInfo_GetRequest.Size(m)
+}
+func (m *GetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetRequest proto.InternalMessageInfo
+
+func (m *GetRequest) GetTarget() *Target {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+func (m *GetRequest) GetOids() []string {
+	if m != nil {
+		return m.Oids
+	}
+	return nil
+}
+
+type GetResponse struct {
+	Variables            []*Varbind `protobuf:"bytes,1,rep,name=variables,proto3" json:"variables,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *GetResponse) Reset()         { *m = GetResponse{} }
+func (m *GetResponse) String() string { return proto.CompactTextString(m) }
+func (*GetResponse) ProtoMessage()    {}
+func (*GetResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{3}
+}
+
+func (m *GetResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetResponse.Unmarshal(m, b)
+}
+func (m *GetResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetResponse.Marshal(b, m, deterministic)
+}
+func (m *GetResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetResponse.Merge(m, src)
+}
+func (m *GetResponse) XXX_Size() int {
+	return xxx_messageInfo_GetResponse.Size(m)
+}
+func (m *GetResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetResponse proto.InternalMessageInfo
+
+func (m *GetResponse) GetVariables() []*Varbind {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+type WalkRequest struct {
+	Target  *Target `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	BaseOid string  `protobuf:"bytes,2,opt,name=base_oid,json=baseOid,proto3" json:"base_oid,omitempty"`
+	// GETBULK max-repetitions of BulkWalk, 0 for the target profile's
+	MaxRepetitions       uint32   `protobuf:"varint,3,opt,name=max_repetitions,json=maxRepetitions,proto3" json:"max_repetitions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WalkRequest) Reset()         { *m = WalkRequest{} }
+func (m *WalkRequest) String() string { return proto.CompactTextString(m) }
+func (*WalkRequest) ProtoMessage()    {}
+func (*WalkRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{4}
+}
+
+func (m *WalkRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WalkRequest.Unmarshal(m, b)
+}
+func (m *WalkRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WalkRequest.Marshal(b, m, deterministic)
+}
+func (m *WalkRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WalkRequest.Merge(m, src)
+}
+func (m *WalkRequest) XXX_Size() int {
+	return xxx_messageInfo_WalkRequest.Size(m)
+}
+func (m *WalkRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WalkRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WalkRequest proto.InternalMessageInfo
+
+func (m *WalkRequest) GetTarget() *Target {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+func (m *WalkRequest) GetBaseOid() string {
+	if m != nil {
+		return m.BaseOid
+	}
+	return ""
+}
+
+func (m *WalkRequest) GetMaxRepetitions() uint32 {
+	if m != nil {
+		return m.MaxRepetitions
+	}
+	return 0
+}
+
+// SetVarbind - [oid, type, value, expected] of REST SET bodies
+type SetVarbind struct {
+	Oid   string `protobuf:"bytes,1,opt,name=oid,proto3" json:"oid,omitempty"`
+	Type  string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Value string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	// Current value the oid must hold for the SET to be sent, compare-and-set
+	Expected             string   `protobuf:"bytes,4,opt,name=expected,proto3" json:"expected,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetVarbind) Reset()         { *m = SetVarbind{} }
+func (m *SetVarbind) String() string { return proto.CompactTextString(m) }
+func (*SetVarbind) ProtoMessage()    {}
+func (*SetVarbind) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{5}
+}
+
+func (m *SetVarbind) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetVarbind.Unmarshal(m, b)
+}
+func (m *SetVarbind) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetVarbind.Marshal(b, m, deterministic)
+}
+func (m *SetVarbind) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetVarbind.Merge(m, src)
+}
+func (m *SetVarbind) XXX_Size() int {
+	return xxx_messageInfo_SetVarbind.Size(m)
+}
+func (m *SetVarbind) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetVarbind.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetVarbind proto.InternalMessageInfo
+
+func (m *SetVarbind) GetOid() string {
+	if m != nil {
+		return m.Oid
+	}
+	return ""
+}
+
+func (m *SetVarbind) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *SetVarbind) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+func (m *SetVarbind) GetExpected() string {
+	if m != nil {
+		return m.Expected
+	}
+	return ""
+}
+
+type SetRequest struct {
+	Target               *Target       `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	Varbinds             []*SetVarbind `protobuf:"bytes,2,rep,name=varbinds,proto3" json:"varbinds,omitempty"`
+	DryRun               bool          `protobuf:"varint,3,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *SetRequest) Reset()         { *m = SetRequest{} }
+func (m *SetRequest) String() string { return proto.CompactTextString(m) }
+func (*SetRequest) ProtoMessage()    {}
+func (*SetRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{6}
+}
+
+func (m *SetRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetRequest.Unmarshal(m, b)
+}
+func (m *SetRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetRequest.Marshal(b, m, deterministic)
+}
+func (m *SetRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetRequest.Merge(m, src)
+}
+func (m *SetRequest) XXX_Size() int {
+	return xxx_messageInfo_SetRequest.Size(m)
+}
+func (m *SetRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetRequest proto.InternalMessageInfo
+
+func (m *SetRequest) GetTarget() *Target {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+func (m *SetRequest) GetVarbinds() []*SetVarbind {
+	if m != nil {
+		return m.Varbinds
+	}
+	return nil
+}
+
+func (m *SetRequest) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+type SetResponse struct {
+	Variables            []*Varbind `protobuf:"bytes,1,rep,name=variables,proto3" json:"variables,omitempty"`
+	DryRun               bool       `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *SetResponse) Reset()         { *m = SetResponse{} }
+func (m *SetResponse) String() string { return proto.CompactTextString(m) }
+func (*SetResponse) ProtoMessage()    {}
+func (*SetResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{7}
+}
+
+func (m *SetResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetResponse.Unmarshal(m, b)
+}
+func (m *SetResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetResponse.Marshal(b, m, deterministic)
+}
+func (m *SetResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetResponse.Merge(m, src)
+}
+func (m *SetResponse) XXX_Size() int {
+	return xxx_messageInfo_SetResponse.Size(m)
+}
+func (m *SetResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetResponse proto.InternalMessageInfo
+
+func (m *SetResponse) GetVariables() []*Varbind {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+func (m *SetResponse) GetDryRun() bool {
+	if m != nil {
+		return m.DryRun
+	}
+	return false
+}
+
+// SubscribeRequest - filters of GET /api/v1/traps
+//
+// Buffered traps after since_seq are sent first, then traps as received.
+type SubscribeRequest struct {
+	Source               string   `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	TrapOid              string   `protobuf:"bytes,2,opt,name=trap_oid,json=trapOid,proto3" json:"trap_oid,omitempty"`
+	SinceSeq             uint64   `protobuf:"varint,3,opt,name=since_seq,json=sinceSeq,proto3" json:"since_seq,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SubscribeRequest) Reset()         { *m = SubscribeRequest{} }
+func (m *SubscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*SubscribeRequest) ProtoMessage()    {}
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{8}
+}
+
+func (m *SubscribeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SubscribeRequest.Unmarshal(m, b)
+}
+func (m *SubscribeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SubscribeRequest.Marshal(b, m, deterministic)
+}
+func (m *SubscribeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SubscribeRequest.Merge(m, src)
+}
+func (m *SubscribeRequest) XXX_Size() int {
+	return xxx_messageInfo_SubscribeRequest.Size(m)
+}
+func (m *SubscribeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SubscribeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SubscribeRequest proto.InternalMessageInfo
+
+func (m *SubscribeRequest) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *SubscribeRequest) GetTrapOid() string {
+	if m != nil {
+		return m.TrapOid
+	}
+	return ""
+}
+
+func (m *SubscribeRequest) GetSinceSeq() uint64 {
+	if m != nil {
+		return m.SinceSeq
+	}
+	return 0
+}
+
+type Trap struct {
+	Id  string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Seq uint64 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	// RFC 3339
+	Received             string     `protobuf:"bytes,3,opt,name=received,proto3" json:"received,omitempty"`
+	Source               string     `protobuf:"bytes,4,opt,name=source,proto3" json:"source,omitempty"`
+	SnmpVersion          string     `protobuf:"bytes,5,opt,name=snmp_version,json=snmpVersion,proto3" json:"snmp_version,omitempty"`
+	Kind                 string     `protobuf:"bytes,6,opt,name=kind,proto3" json:"kind,omitempty"`
+	TrapOid              string     `protobuf:"bytes,7,opt,name=trap_oid,json=trapOid,proto3" json:"trap_oid,omitempty"`
+	Enterprise           string     `protobuf:"bytes,8,opt,name=enterprise,proto3" json:"enterprise,omitempty"`
+	AgentAddress         string     `protobuf:"bytes,9,opt,name=agent_address,json=agentAddress,proto3" json:"agent_address,omitempty"`
+	GenericTrap          int32      `protobuf:"varint,10,opt,name=generic_trap,json=genericTrap,proto3" json:"generic_trap,omitempty"`
+	SpecificTrap         int32      `protobuf:"varint,11,opt,name=specific_trap,json=specificTrap,proto3" json:"specific_trap,omitempty"`
+	Uptime               uint32     `protobuf:"varint,12,opt,name=uptime,proto3" json:"uptime,omitempty"`
+	Variables            []*Varbind `protobuf:"bytes,13,rep,name=variables,proto3" json:"variables,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Trap) Reset()         { *m = Trap{} }
+func (m *Trap) String() string { return proto.CompactTextString(m) }
+func (*Trap) ProtoMessage()    {}
+func (*Trap) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a820d515b7957b4d, []int{9}
+}
+
+func (m *Trap) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Trap.Unmarshal(m, b)
+}
+func (m *Trap) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Trap.Marshal(b, m, deterministic)
+}
+func (m *Trap) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Trap.Merge(m, src)
+}
+func (m *Trap) XXX_Size() int {
+	return xxx_messageInfo_Trap.Size(m)
+}
+func (m *Trap) XXX_DiscardUnknown() {
+	xxx_messageInfo_Trap.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Trap proto.InternalMessageInfo
+
+func (m *Trap) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Trap) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *Trap) GetReceived() string {
+	if m != nil {
+		return m.Received
+	}
+	return ""
+}
+
+func (m *Trap) GetSource() string {
+	if m != nil {
+		return m.Source
+	}
+	return ""
+}
+
+func (m *Trap) GetSnmpVersion() string {
+	if m != nil {
+		return m.SnmpVersion
+	}
+	return ""
+}
+
+func (m *Trap) GetKind() string {
+	if m != nil {
+		return m.Kind
+	}
+	return ""
+}
+
+func (m *Trap) GetTrapOid() string {
+	if m != nil {
+		return m.TrapOid
+	}
+	return ""
+}
+
+func (m *Trap) GetEnterprise() string {
+	if m != nil {
+		return m.Enterprise
+	}
+	return ""
+}
+
+func (m *Trap) GetAgentAddress() string {
+	if m != nil {
+		return m.AgentAddress
+	}
+	return ""
+}
+
+func (m *Trap) GetGenericTrap() int32 {
+	if m != nil {
+		return m.GenericTrap
+	}
+	return 0
+}
+
+func (m *Trap) GetSpecificTrap() int32 {
+	if m != nil {
+		return m.SpecificTrap
+	}
+	return 0
+}
+
+func (m *Trap) GetUptime() uint32 {
+	if m != nil {
+		return m.Uptime
+	}
+	return 0
+}
+
+func (m *Trap) GetVariables() []*Varbind {
+	if m != nil {
+		return m.Variables
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Target)(nil), "restsnmp.v1.Target")
+	proto.RegisterType((*Varbind)(nil), "restsnmp.v1.Varbind")
+	proto.RegisterType((*GetRequest)(nil), "restsnmp.v1.GetRequest")
+	proto.RegisterType((*GetResponse)(nil), "restsnmp.v1.GetResponse")
+	proto.RegisterType((*WalkRequest)(nil), "restsnmp.v1.WalkRequest")
+	proto.RegisterType((*SetVarbind)(nil), "restsnmp.v1.SetVarbind")
+	proto.RegisterType((*SetRequest)(nil), "restsnmp.v1.SetRequest")
+	proto.RegisterType((*SetResponse)(nil), "restsnmp.v1.SetResponse")
+	proto.RegisterType((*SubscribeRequest)(nil), "restsnmp.v1.SubscribeRequest")
+	proto.RegisterType((*Trap)(nil), "restsnmp.v1.Trap")
+}
+
+func init() { proto.RegisterFile("restsnmp.proto", fileDescriptor_a820d515b7957b4d) }
+
+var fileDescriptor_a820d515b7957b4d = []byte{
+	// 701 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0x4d, 0x6b, 0xdb, 0x4a,
+	0x14, 0x45, 0xb6, 0xfc, 0x75, 0x65, 0xe7, 0xe5, 0xcd, 0x0b, 0x89, 0x9e, 0x4b, 0x8b, 0xab, 0x2c,
+	0x1a, 0x28, 0xb1, 0x53, 0x67, 0x13, 0x68, 0xa1, 0x24, 0x5d, 0x64, 0x55, 0x0a, 0x72, 0x48, 0x21,
+	0x1b, 0x33, 0x92, 0x6e, 0x9d, 0x21, 0xf6, 0x68, 0x32, 0x33, 0x32, 0xf1, 0xa6, 0xdb, 0xd0, 0xdf,
+	0xd3, 0x3f, 0x58, 0x66, 0x24, 0x7f, 0x28, 0x69, 0xa1, 0xa4, 0xdd, 0xcd, 0x39, 0x3a, 0x73, 0xe7,
+	0xdc, 0x2f, 0x1b, 0xb6, 0x24, 0x2a, 0xad, 0xf8, 0x4c, 0xf4, 0x85, 0x4c, 0x75, 0x4a, 0xbc, 0x15,
+	0x9e, 0xbf, 0x09, 0x3e, 0x40, 0xfd, 0x82, 0xca, 0x09, 0x6a, 0xf2, 0x12, 0xda, 0x86, 0x1c, 0xcf,
+	0x51, 0x2a, 0x96, 0x72, 0xdf, 0xe9, 0x39, 0x07, 0xad, 0xd0, 0x33, 0xdc, 0x65, 0x4e, 0x91, 0x5d,
+	0xa8, 0x6b, 0x2b, 0xf6, 0x2b, 0xf6, 0x63, 0x81, 0x82, 0x6f, 0x0e, 0x34, 0x2e, 0xa9, 0x8c, 0x18,
+	0x4f, 0xc8, 0x36, 0x54, 0x53, 0x96, 0x14, 0xb7, 0xcd, 0x91, 0x10, 0x70, 0xf5, 0x42, 0x60, 0x71,
+	0xc7, 0x9e, 0xc9, 0x0e, 0xd4, 0xe6, 0x74, 0x9a, 0xa1, 0x5f, 0xb5, 0x64, 0x0e, 0x88, 0x0f, 0x8d,
+	0x84, 0x29, 0x31, 0xa5, 0x0b, 0xdf, 0xb5, 0xfc, 0x12, 0x9a, 0x18, 0x19, 0x67, 0xda, 0xaf, 0xe5,
+	0x31, 0xcc, 0xd9, 0xc4, 0x40, 0x29, 0x53, 0xe9, 0xd7, 0xf3, 0x18, 0x16, 0x04, 0x1f, 0x01, 0xce,
+	0x51, 0x87, 0x78, 0x9b, 0xa1, 0xd2, 0xe4, 0xf5, 0xca, 0xb1, 0x31, 0xe4, 0x0d, 0xff, 0xeb, 0x6f,
+	0x24, 0xdf, 0xcf, 0x33, 0x5f, 0xa6, 0x61, 0x1e, 0x49, 0x59, 0xa2, 0xfc, 0x4a, 0xaf, 0x6a, 0x1e,
+	0x31, 0xe7, 0xe0, 0x14, 0x3c, 0x1b, 0x4e, 0x89, 0x94, 0x2b, 0x24, 0x43, 0x68, 0xcd, 0xa9, 0x64,
+	0x34, 0x9a, 0xa2, 0xf2, 0x9d, 0x5e, 0xf5, 0xc0, 0x1b, 0xee, 0x94, 0x42, 0x16, 0x65, 0x08, 0xd7,
+	0xb2, 0xe0, 0x2b, 0x78, 0x9f, 0xe9, 0xf4, 0xe6, 0x49, 0x96, 0xfe, 0x87, 0x66, 0x44, 0x15, 0x8e,
+	0x4d, 0x49, 0xf3, 0xfa, 0x35, 0x0c, 0xfe, 0xc4, 0x12, 0xf2, 0x0a, 0xfe, 0x99, 0xd1, 0xbb, 0xb1,
+	0x44, 0x81, 0x9a, 0x69, 0x96, 0x72, 0x65, 0x8b, 0xd9, 0x09, 0xb7, 0x66, 0xf4, 0x2e, 0x5c, 0xb3,
+	0x41, 0x02, 0x30, 0x42, 0xfd, 0x37, 0xfa, 0xd3, 0x85, 0x26, 0xde, 0x09, 0x8c, 0x35, 0x26, 0x45,
+	0x83, 0x56, 0x38, 0xb8, 0x77, 0xec, 0x33, 0x4f, 0xca, 0xf2, 0x18, 0x9a, 0xf3, 0xdc, 0x5e, 0x5e,
+	0x7c, 0x6f, 0xb8, 0x57, 0x92, 0xaf, 0xed, 0x87, 0x2b, 0x21, 0xd9, 0x83, 0x46, 0x22, 0x17, 0x63,
+	0x99, 0x71, 0x6b, 0xb2, 0x19, 0xd6, 0x13, 0xb9, 0x08, 0x33, 0x1e, 0x5c, 0x81, 0x37, 0xfa, 0xb3,
+	0x96, 0x6d, 0xc6, 0xae, 0x94, 0x62, 0x47, 0xb0, 0x3d, 0xca, 0x22, 0x15, 0x4b, 0x16, 0xe1, 0x32,
+	0xd5, 0x5d, 0xa8, 0xab, 0x34, 0x93, 0x31, 0x16, 0x45, 0x2d, 0x90, 0xe9, 0x9d, 0x96, 0x54, 0x6c,
+	0xf6, 0xce, 0x60, 0xd3, 0xbb, 0x67, 0xd0, 0x52, 0x8c, 0xc7, 0x38, 0x56, 0x78, 0x6b, 0xdd, 0xbb,
+	0x61, 0xd3, 0x12, 0x23, 0xbc, 0x0d, 0xee, 0xab, 0xe0, 0x5e, 0x48, 0x2a, 0xc8, 0x16, 0x54, 0x56,
+	0x9d, 0xaa, 0x30, 0xdb, 0x3a, 0xa3, 0xaf, 0x58, 0xbd, 0x39, 0x9a, 0x86, 0x48, 0x8c, 0x91, 0xcd,
+	0x31, 0x29, 0x3a, 0xb5, 0xc2, 0x1b, 0xb6, 0xdc, 0x92, 0xad, 0x87, 0x7b, 0x5e, 0x7b, 0xbc, 0xe7,
+	0x04, 0xdc, 0x1b, 0xc6, 0x93, 0x62, 0xb1, 0xec, 0xb9, 0x94, 0x4d, 0xa3, 0x9c, 0xcd, 0x0b, 0x00,
+	0xe4, 0x1a, 0xa5, 0x90, 0x4c, 0xa1, 0xdf, 0xb4, 0x1f, 0x37, 0x18, 0xb2, 0x0f, 0x1d, 0x3a, 0x41,
+	0xae, 0xc7, 0x34, 0x49, 0x24, 0x2a, 0xe5, 0xb7, 0xac, 0xa4, 0x6d, 0xc9, 0xd3, 0x9c, 0x33, 0xb6,
+	0x26, 0xc8, 0x51, 0xb2, 0x78, 0x6c, 0xe2, 0xfa, 0xd0, 0x73, 0x0e, 0x6a, 0xa1, 0x57, 0x70, 0xb6,
+	0x1e, 0xfb, 0xd0, 0x51, 0x02, 0x63, 0xf6, 0x65, 0xa9, 0xf1, 0xac, 0xa6, 0xbd, 0x24, 0xad, 0x68,
+	0x17, 0xea, 0x99, 0xd0, 0x6c, 0x86, 0x7e, 0xdb, 0x6e, 0x43, 0x81, 0xca, 0x63, 0xd0, 0xf9, 0xad,
+	0x31, 0x18, 0x7e, 0xaf, 0x80, 0x3b, 0xe2, 0x33, 0x41, 0x4e, 0xa0, 0x7a, 0x8e, 0x9a, 0x94, 0xa7,
+	0x72, 0xfd, 0x33, 0xd3, 0xf5, 0x1f, 0x7f, 0x28, 0xa6, 0xef, 0x04, 0x5c, 0xb3, 0xfc, 0xa4, 0xac,
+	0xd8, 0xf8, 0x3d, 0xe8, 0xfe, 0xd4, 0xc5, 0x91, 0x43, 0xde, 0x41, 0xf3, 0x2c, 0x9b, 0xde, 0x3c,
+	0xf1, 0xf6, 0x09, 0x54, 0x47, 0x8f, 0x1c, 0x8f, 0x7e, 0xe5, 0x78, 0x73, 0x5f, 0xde, 0x43, 0x6b,
+	0x35, 0xe2, 0xe4, 0x79, 0x59, 0xf6, 0x60, 0xf4, 0xbb, 0xff, 0x96, 0xb7, 0x5a, 0x52, 0x71, 0xe4,
+	0x9c, 0x0d, 0xae, 0x0e, 0x27, 0x4c, 0x5f, 0x67, 0x51, 0x3f, 0x4e, 0x67, 0x03, 0x7d, 0x8d, 0x11,
+	0xe3, 0x54, 0x2e, 0x06, 0x46, 0x7a, 0x68, 0xb4, 0x03, 0xfb, 0x3f, 0xf4, 0x76, 0x79, 0x35, 0xaa,
+	0x5b, 0x7c, 0xfc, 0x63, 0x00, 0x06, 0x73, 0xa4, 0x54, 0xa9, 0x06, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// SnmpClient is the client API for Snmp service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SnmpClient interface {
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (Snmp_WalkClient, error)
+	BulkWalk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (Snmp_BulkWalkClient, error)
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Snmp_SubscribeClient, error)
+}
+
+type snmpClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSnmpClient(cc *grpc.ClientConn) SnmpClient {
+	return &snmpClient{cc}
+}
+
+func (c *snmpClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/restsnmp.v1.Snmp/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snmpClient) Walk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (Snmp_WalkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Snmp_serviceDesc.Streams[0], "/restsnmp.v1.Snmp/Walk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &snmpWalkClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Snmp_WalkClient interface {
+	Recv() (*Varbind, error)
+	grpc.ClientStream
+}
+
+type snmpWalkClient struct {
+	grpc.ClientStream
+}
+
+func (x *snmpWalkClient) Recv() (*Varbind, error) {
+	m := new(Varbind)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *snmpClient) BulkWalk(ctx context.Context, in *WalkRequest, opts ...grpc.CallOption) (Snmp_BulkWalkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Snmp_serviceDesc.Streams[1], "/restsnmp.v1.Snmp/BulkWalk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &snmpBulkWalkClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Snmp_BulkWalkClient interface {
+	Recv() (*Varbind, error)
+	grpc.ClientStream
+}
+
+type snmpBulkWalkClient struct {
+	grpc.ClientStream
+}
+
+func (x *snmpBulkWalkClient) Recv() (*Varbind, error) {
+	m := new(Varbind)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *snmpClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*SetResponse, error) {
+	out := new(SetResponse)
+	err := c.cc.Invoke(ctx, "/restsnmp.v1.Snmp/Set", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snmpClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Snmp_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Snmp_serviceDesc.Streams[2], "/restsnmp.v1.Snmp/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &snmpSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Snmp_SubscribeClient interface {
+	Recv() (*Trap, error)
+	grpc.ClientStream
+}
+
+type snmpSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *snmpSubscribeClient) Recv() (*Trap, error) {
+	m := new(Trap)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SnmpServer is the server API for Snmp service.
+type SnmpServer interface {
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Walk(*WalkRequest, Snmp_WalkServer) error
+	BulkWalk(*WalkRequest, Snmp_BulkWalkServer) error
+	Set(context.Context, *SetRequest) (*SetResponse, error)
+	Subscribe(*SubscribeRequest, Snmp_SubscribeServer) error
+}
+
+// UnimplementedSnmpServer can be embedded to have forward compatible implementations.
+type UnimplementedSnmpServer struct {
+}
+
+func (*UnimplementedSnmpServer) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (*UnimplementedSnmpServer) Walk(req *WalkRequest, srv Snmp_WalkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Walk not implemented")
+}
+func (*UnimplementedSnmpServer) BulkWalk(req *WalkRequest, srv Snmp_BulkWalkServer) error {
+	return status.Errorf(codes.Unimplemented, "method BulkWalk not implemented")
+}
+func (*UnimplementedSnmpServer) Set(ctx context.Context, req *SetRequest) (*SetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (*UnimplementedSnmpServer) Subscribe(req *SubscribeRequest, srv Snmp_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+
+func RegisterSnmpServer(s *grpc.Server, srv SnmpServer) {
+	s.RegisterService(&_Snmp_serviceDesc, srv)
+}
+
+func _Snmp_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnmpServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/restsnmp.v1.Snmp/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnmpServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Snmp_Walk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WalkRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnmpServer).Walk(m, &snmpWalkServer{stream})
+}
+
+type Snmp_WalkServer interface {
+	Send(*Varbind) error
+	grpc.ServerStream
+}
+
+type snmpWalkServer struct {
+	grpc.ServerStream
+}
+
+func (x *snmpWalkServer) Send(m *Varbind) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Snmp_BulkWalk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WalkRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnmpServer).BulkWalk(m, &snmpBulkWalkServer{stream})
+}
+
+type Snmp_BulkWalkServer interface {
+	Send(*Varbind) error
+	grpc.ServerStream
+}
+
+type snmpBulkWalkServer struct {
+	grpc.ServerStream
+}
+
+func (x *snmpBulkWalkServer) Send(m *Varbind) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Snmp_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnmpServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/restsnmp.v1.Snmp/Set",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnmpServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Snmp_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SnmpServer).Subscribe(m, &snmpSubscribeServer{stream})
+}
+
+type Snmp_SubscribeServer interface {
+	Send(*Trap) error
+	grpc.ServerStream
+}
+
+type snmpSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *snmpSubscribeServer) Send(m *Trap) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Snmp_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "restsnmp.v1.Snmp",
+	HandlerType: (*SnmpServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Snmp_Get_Handler,
+		},
+		{
+			MethodName: "Set",
+			Handler:    _Snmp_Set_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Walk",
+			Handler:       _Snmp_Walk_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BulkWalk",
+			Handler:       _Snmp_BulkWalk_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Snmp_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "restsnmp.proto",
+}
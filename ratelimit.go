@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitError - operation refused because target is over its rate limit
+type RateLimitError struct {
+	Target     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %s exceeded, retry after %v", e.Target, e.RetryAfter)
+}
+
+// tokenBucket - operations a target may still start at once
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TargetLimiter - per target token buckets and a global cap on in-flight operations
+//
+// Rates and bursts come from the target profile over the server
+// defaults, see RequestLimits. Operations beyond MaxInFlight wait for a
+// running one to finish.
+type TargetLimiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	inFlight chan struct{}
+}
+
+// limiter - rate limits applied to every snmp operation
+var limiter = NewTargetLimiter(0)
+
+// NewTargetLimiter - limiter allowing maxInFlight concurrent operations, 0 for no cap
+func NewTargetLimiter(maxInFlight int) *TargetLimiter {
+	l := &TargetLimiter{buckets: map[string]*tokenBucket{}}
+	if maxInFlight > 0 {
+		l.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// allow - take a token of target, or the time until one is available
+func (l *TargetLimiter) allow(target string, now time.Time) (time.Duration, bool) {
+	limits := LimitsForTarget(target)
+	if limits.RateLimit <= 0 {
+		return 0, true
+	}
+	burst := float64(limits.RateBurst)
+	if burst < 1 {
+		burst = math.Max(1, math.Ceil(limits.RateLimit))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[target]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[target] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limits.RateLimit)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / limits.RateLimit * float64(time.Second)), false
+}
+
+// Begin - admit an operation on target, release must be called when it is done
+func (l *TargetLimiter) Begin(target string) (func(), error) {
+	if wait, ok := l.allow(target, time.Now()); !ok {
+		stats.Inc("ratelimit.rejected")
+		return nil, &RateLimitError{Target: target, RetryAfter: wait}
+	}
+	if l.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.inFlight <- struct{}{}:
+	default:
+		stats.Inc("ratelimit.waits")
+		l.inFlight <- struct{}{}
+	}
+	return func() { <-l.inFlight }, nil
+}
+
+// InFlight - number of running operations, 0 without cap
+func (l *TargetLimiter) InFlight() int {
+	return len(l.inFlight)
+}
+
+// Run - drop buckets of targets idle for ten minutes until stop is closed
+func (l *TargetLimiter) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for target, b := range l.buckets {
+				if now.Sub(b.last) > 10*time.Minute {
+					delete(l.buckets, target)
+				}
+			}
+			l.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
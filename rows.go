@@ -0,0 +1,621 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// RowStatus values used when creating rows, see RFC 2579
+const (
+	RowStatusNotReady      = 3
+	RowStatusCreateAndGo   = 4
+	RowStatusCreateAndWait = 5
+)
+
+// Row creation modes
+const (
+	RowCreateAndGo   = "createAndGo"
+	RowCreateAndWait = "createAndWait"
+)
+
+// rowStatusNames - labels of the RowStatus values an agent reports
+var rowStatusNames = map[int]string{
+	RowStatusActive:       "active",
+	RowStatusNotInService: "notInService",
+	RowStatusNotReady:     "notReady",
+}
+
+// TableColumn - named column of a table and the type its values are set with
+//
+// Type is one of the types accepted in SET triplets, e.g. "i" or "s".
+type TableColumn struct {
+	Name     string `json:"name"`
+	Column   int    `json:"column"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// TableDef - conceptual table whose rows are managed through its RowStatus
+//
+// Entry is the oid of the table entry, symbolic names are accepted;
+// rowstatus_column is the number of its RowStatus column.
+type TableDef struct {
+	Name            string        `json:"name"`
+	Entry           string        `json:"entry"`
+	RowStatusColumn int           `json:"rowstatus_column"`
+	Columns         []TableColumn `json:"columns"`
+}
+
+// validate - check definition, returns resolved entry oid
+func (t *TableDef) validate() (string, error) {
+	if t.Entry == "" || t.RowStatusColumn < 1 {
+		return "", fmt.Errorf("entry and rowstatus_column required")
+	}
+	entry, err := ResolveOid(t.Entry)
+	if err != nil {
+		return "", err
+	}
+	names := map[string]bool{}
+	numbers := map[int]bool{t.RowStatusColumn: true}
+	for _, c := range t.Columns {
+		if c.Name == "" || c.Column < 1 || names[c.Name] || numbers[c.Column] {
+			return "", fmt.Errorf("columns need unique names and numbers other than the rowstatus column")
+		}
+		if _, err := ParsePDUType(c.Type); err != nil {
+			return "", fmt.Errorf("column %s: %v", c.Name, err)
+		}
+		names[c.Name] = true
+		numbers[c.Column] = true
+	}
+	return entry, nil
+}
+
+// column - column definition by name
+func (t *TableDef) column(name string) (TableColumn, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return TableColumn{}, false
+}
+
+// columnName - defined name of column number, the number if undefined
+func (t *TableDef) columnName(number string) string {
+	for _, c := range t.Columns {
+		if strconv.Itoa(c.Column) == number {
+			return c.Name
+		}
+	}
+	return number
+}
+
+// TableStore - table definitions, optionally backed by a json file
+type TableStore struct {
+	mu     sync.RWMutex
+	path   string
+	tables map[string]*TableDef
+}
+
+// tables - table definitions loaded at startup
+var tables = NewTableStore("")
+
+// NewTableStore - empty table store persisted to path if not empty
+func NewTableStore(path string) *TableStore {
+	return &TableStore{path: path, tables: map[string]*TableDef{}}
+}
+
+// LoadTables - table store from json file, missing file is empty store
+//
+// Entries are resolved when used, so definitions may name objects of
+// MIBs loaded after the store.
+func LoadTables(path string) (*TableStore, error) {
+	s := NewTableStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*TableDef
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, t := range list {
+		s.tables[t.Name] = t
+	}
+	return s, nil
+}
+
+// save - persist definitions, caller holds the lock
+func (s *TableStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+func (s *TableStore) list() []*TableDef {
+	list := make([]*TableDef, 0, len(s.tables))
+	for _, t := range s.tables {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get - table definition by name
+func (s *TableStore) Get(name string) (*TableDef, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tables[name]
+	return t, ok
+}
+
+// ListTablesHandler - list table definitions
+func (s *TableStore) ListTablesHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	WriteJSON(w, http.StatusOK, s.list())
+}
+
+// GetTableHandler - single table definition
+func (s *TableStore) GetTableHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.Get(mux.Vars(r)["name"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "table not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, t)
+}
+
+// PutTableHandler - create or replace table definition
+func (s *TableStore) PutTableHandler(w http.ResponseWriter, r *http.Request) {
+	t := &TableDef{}
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid table json")
+		return
+	}
+	if _, err := t.validate(); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t.Name = mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[t.Name] = t
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving tables", Fields{"err": err})
+	}
+	WriteJSON(w, http.StatusOK, t)
+}
+
+// DeleteTableHandler - remove table definition
+func (s *TableStore) DeleteTableHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tables[name]; !ok {
+		WriteError(w, http.StatusNotFound, "table not found")
+		return
+	}
+	delete(s.tables, name)
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving tables", Fields{"err": err})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RowRequest - column values of a row to create or update
+//
+// mode applies to creation only: createAndGo (default) sends RowStatus
+// and columns in one request; createAndWait creates the row, sets the
+// columns in a second request and, unless activate is false, sets the
+// row active in a third.
+type RowRequest struct {
+	Columns  map[string]interface{} `json:"columns"`
+	Mode     string                 `json:"mode,omitempty"`
+	Activate *bool                  `json:"activate,omitempty"`
+}
+
+// TableRow - row read back from the agent
+type TableRow struct {
+	Index     string                 `json:"index"`
+	RowStatus string                 `json:"rowstatus"`
+	Columns   map[string]interface{} `json:"columns"`
+}
+
+// tableRowContext - definition, resolved entry and session of a row request
+func tableRowContext(w http.ResponseWriter, r *http.Request) (*TableDef, string, *gosnmp.GoSNMP, bool) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	t, ok := tables.Get(mux.Vars(r)["table"])
+	if !ok {
+		WriteError(w, http.StatusNotFound, "table not found")
+		return nil, "", nil, false
+	}
+	entry, err := t.validate()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "table "+t.Name+": "+err.Error())
+		return nil, "", nil, false
+	}
+	if index, ok := mux.Vars(r)["index"]; ok {
+		if _, err := ParseOid(index); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid index "+index)
+			return nil, "", nil, false
+		}
+	}
+	return t, entry, g, true
+}
+
+// columnPDUs - varbinds setting the named columns of row index
+func columnPDUs(t *TableDef, entry string, index string, values map[string]interface{}) ([]gosnmp.SnmpPDU, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pdus := make([]gosnmp.SnmpPDU, 0, len(values))
+	for _, name := range names {
+		c, ok := t.column(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		pdu, err := ToSnmpPDU(fmt.Sprintf("%s.%d.%s", entry, c.Column, index), c.Type, values[name])
+		if err == nil {
+			err = WritablePDU(pdu)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", name, err)
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus, nil
+}
+
+// rowStatusPDU - varbind setting RowStatus of row index
+func rowStatusPDU(t *TableDef, entry string, index string, status int) gosnmp.SnmpPDU {
+	return gosnmp.SnmpPDU{
+		Name:  fmt.Sprintf("%s.%d.%s", entry, t.RowStatusColumn, index),
+		Type:  gosnmp.Integer,
+		Value: status,
+	}
+}
+
+// setRow - journaled snmpset, agent error statuses returned as error
+func setRow(g *gosnmp.GoSNMP, operation string, pdus []gosnmp.SnmpPDU) error {
+	result, err := JournaledSet(g, operation, pdus)
+	if err != nil {
+		return err
+	}
+	return NewPacketError("Set", result)
+}
+
+// readRow - RowStatus and defined columns of row index, nil if it does not exist
+func readRow(g *gosnmp.GoSNMP, o FormatOptions, t *TableDef, entry string, index string) (*TableRow, error) {
+	oids := []string{fmt.Sprintf("%s.%d.%s", entry, t.RowStatusColumn, index)}
+	for _, c := range t.Columns {
+		oids = append(oids, fmt.Sprintf("%s.%d.%s", entry, c.Column, index))
+	}
+	pdus, failed, err := GetPartial(g, oids)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := failed[0]; ok || len(pdus) == 0 || pdus[0].Type != gosnmp.Integer {
+		return nil, nil
+	}
+
+	row := &TableRow{Index: index, RowStatus: rowStatusLabel(gosnmp.ToBigInt(pdus[0].Value).Int64()), Columns: map[string]interface{}{}}
+	pdus = SanitizeResultVariables(&pdus)
+	for i, c := range t.Columns {
+		if _, ok := failed[i+1]; ok || !cacheable(pdus[i+1]) {
+			continue
+		}
+		row.Columns[c.Name] = o.FormatValue(pdus[i+1])
+	}
+	return row, nil
+}
+
+// rowStatusLabel - name of a RowStatus value
+func rowStatusLabel(status int64) string {
+	if name, ok := rowStatusNames[int(status)]; ok {
+		return name
+	}
+	return strconv.FormatInt(status, 10)
+}
+
+// ListRowsHandler - rows of a defined table with named columns
+func ListRowsHandler(w http.ResponseWriter, r *http.Request) {
+	t, entry, g, ok := tableRowContext(w, r)
+	if !ok {
+		return
+	}
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pdus, err := walkAll(g, entry, g.Version != gosnmp.Version1, 0)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	statusColumn := strconv.Itoa(t.RowStatusColumn)
+	byIndex := map[string]*TableRow{}
+	for _, pdu := range SanitizeResultVariables(&pdus) {
+		suffix, ok := instanceSuffix(normalizeOid(entry), pdu.Name)
+		if !ok {
+			continue
+		}
+		i := strings.Index(suffix, ".")
+		if i <= 0 {
+			continue
+		}
+		column, index := suffix[:i], suffix[i+1:]
+		row, ok := byIndex[index]
+		if !ok {
+			row = &TableRow{Index: index, Columns: map[string]interface{}{}}
+			byIndex[index] = row
+		}
+		if column == statusColumn {
+			row.RowStatus = rowStatusLabel(gosnmp.ToBigInt(pdu.Value).Int64())
+			continue
+		}
+		row.Columns[t.columnName(column)] = o.FormatValue(pdu)
+	}
+
+	rows := make([]*TableRow, 0, len(byIndex))
+	for _, row := range byIndex {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Index < rows[j].Index })
+	WriteJSON(w, http.StatusOK, rows)
+}
+
+// GetRowHandler - single row of a defined table
+func GetRowHandler(w http.ResponseWriter, r *http.Request) {
+	t, entry, g, ok := tableRowContext(w, r)
+	if !ok {
+		return
+	}
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	row, err := readRow(g, o, t, entry, mux.Vars(r)["index"])
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if row == nil {
+		WriteError(w, http.StatusNotFound, "row does not exist")
+		return
+	}
+	WriteJSON(w, http.StatusOK, row)
+}
+
+// CreateRowHandler - create a row of a defined table and read it back
+//
+// Responds 201 with the row as read after creation; 502 if the agent
+// accepted the request but the row does not exist or is not in the
+// expected state afterwards. A createAndWait row whose columns are
+// rejected is destroyed again.
+func CreateRowHandler(w http.ResponseWriter, r *http.Request) {
+	t, entry, g, ok := tableRowContext(w, r)
+	if !ok {
+		return
+	}
+	index := mux.Vars(r)["index"]
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request := RowRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid row json: "+err.Error())
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = RowCreateAndGo
+	}
+	if request.Mode != RowCreateAndGo && request.Mode != RowCreateAndWait {
+		WriteError(w, http.StatusBadRequest, "mode must be createAndGo or createAndWait")
+		return
+	}
+	for _, c := range t.Columns {
+		if _, ok := request.Columns[c.Name]; c.Required && !ok {
+			WriteError(w, http.StatusBadRequest, "column "+c.Name+" required")
+			return
+		}
+	}
+	pdus, err := columnPDUs(t, entry, index, request.Columns)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	existing, err := readRow(g, o, t, entry, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if existing != nil {
+		WriteError(w, http.StatusConflict, "row already exists")
+		return
+	}
+
+	expected := rowStatusNames[RowStatusActive]
+	if request.Mode == RowCreateAndGo {
+		pdus = append([]gosnmp.SnmpPDU{rowStatusPDU(t, entry, index, RowStatusCreateAndGo)}, pdus...)
+		if err := LimitsForTarget(SessionTarget(g)).Check(g, gosnmp.SetRequest, pdus); err != nil {
+			WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err := setRow(g, "create", pdus); err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+	} else {
+		if err := setRow(g, "create", []gosnmp.SnmpPDU{rowStatusPDU(t, entry, index, RowStatusCreateAndWait)}); err != nil {
+			WriteSnmpError(w, err)
+			return
+		}
+		if len(pdus) > 0 {
+			if err := setRow(g, "set", pdus); err != nil {
+				if derr := setRow(g, "delete", []gosnmp.SnmpPDU{rowStatusPDU(t, entry, index, RowStatusDestroy)}); derr != nil {
+					RequestLogger(r).Error("destroying incomplete row", Fields{"entry": entry, "index": index, "err": derr})
+				}
+				WriteSnmpError(w, err)
+				return
+			}
+		}
+		if request.Activate == nil || *request.Activate {
+			if err := setRow(g, "activate", []gosnmp.SnmpPDU{rowStatusPDU(t, entry, index, RowStatusActive)}); err != nil {
+				WriteSnmpError(w, err)
+				return
+			}
+		} else {
+			expected = ""
+		}
+	}
+
+	row, err := readRow(g, o, t, entry, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if row == nil {
+		WriteError(w, http.StatusBadGateway, "row missing after creation")
+		return
+	}
+	if expected != "" && row.RowStatus != expected {
+		WriteError(w, http.StatusBadGateway, "row is "+row.RowStatus+" after creation")
+		return
+	}
+	w.Header().Set("Location", r.URL.Path)
+	WriteJSON(w, http.StatusCreated, row)
+}
+
+// UpdateRowHandler - set columns of an existing row and read it back
+func UpdateRowHandler(w http.ResponseWriter, r *http.Request) {
+	t, entry, g, ok := tableRowContext(w, r)
+	if !ok {
+		return
+	}
+	index := mux.Vars(r)["index"]
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request := RowRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid row json: "+err.Error())
+		return
+	}
+	if len(request.Columns) == 0 {
+		WriteError(w, http.StatusBadRequest, "columns missing")
+		return
+	}
+	pdus, err := columnPDUs(t, entry, index, request.Columns)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := LimitsForTarget(SessionTarget(g)).Check(g, gosnmp.SetRequest, pdus); err != nil {
+		WriteError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	existing, err := readRow(g, o, t, entry, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if existing == nil {
+		WriteError(w, http.StatusNotFound, "row does not exist")
+		return
+	}
+	if err := setRow(g, "set", pdus); err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+
+	row, err := readRow(g, o, t, entry, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	if row == nil {
+		WriteError(w, http.StatusBadGateway, "row missing after update")
+		return
+	}
+	WriteJSON(w, http.StatusOK, row)
+}
+
+// writeRowStatus - set RowStatus of an existing row of a defined table
+func writeRowStatus(w http.ResponseWriter, r *http.Request, status int, operation string) bool {
+	t, entry, g, ok := tableRowContext(w, r)
+	if !ok {
+		return false
+	}
+	index := mux.Vars(r)["index"]
+
+	existing, err := readRow(g, FormatOptions{}, t, entry, index)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return false
+	}
+	if existing == nil {
+		WriteError(w, http.StatusNotFound, "row does not exist")
+		return false
+	}
+	if err := setRow(g, operation, []gosnmp.SnmpPDU{rowStatusPDU(t, entry, index, status)}); err != nil {
+		WriteSnmpError(w, err)
+		return false
+	}
+	return true
+}
+
+// DeleteRowHandler - destroy a row of a defined table, ?mode=soft sets it notInService
+func DeleteRowHandler(w http.ResponseWriter, r *http.Request) {
+	status, operation, err := deleteMode(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if writeRowStatus(w, r, status, operation) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ActivateRowHandler - set a row of a defined table active
+func ActivateRowHandler(w http.ResponseWriter, r *http.Request) {
+	if writeRowStatus(w, r, RowStatusActive, "activate") {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Scheduled change states
+const (
+	ScheduleQueued    = "scheduled"
+	ScheduleRunning   = "running"
+	ScheduleExecuted  = "executed"
+	ScheduleCancelled = "cancelled"
+	ScheduleMissed    = "missed"
+)
+
+// ScheduledKey - context key marking execution of a scheduled change
+type ScheduledKey string
+
+// ScheduledKeyName - keyname defined for context
+const ScheduledKeyName ScheduledKey = "SCHEDULED"
+
+// MaintenanceWindow - named period in which scheduled changes may run
+type MaintenanceWindow struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ScheduledChange - write request queued for later execution
+type ScheduledChange struct {
+	ID          string        `json:"id"`
+	Status      string        `json:"status"`
+	ExecuteAt   time.Time     `json:"execute_at"`
+	Window      string        `json:"maintenance_window,omitempty"`
+	Method      string        `json:"method"`
+	URL         string        `json:"url"`
+	Body        string        `json:"body,omitempty"`
+	RequestedBy string        `json:"requested_by,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	ExecutedAt  *time.Time    `json:"executed_at,omitempty"`
+	Result      *ChangeResult `json:"result,omitempty"`
+	Callback    *Callback     `json:"callback,omitempty"`
+
+	request    CapturedRequest
+	approvedBy interface{}
+	approver   interface{}
+	principal  *Principal
+	targets    []string
+}
+
+// scheduleFields - scheduling fields accepted in write request bodies
+type scheduleFields struct {
+	ExecuteAt *time.Time `json:"execute_at"`
+	Window    string     `json:"maintenance_window"`
+}
+
+// Scheduler - queue of write requests executed at a given time
+type Scheduler struct {
+	Handler http.Handler
+
+	mu      sync.RWMutex
+	changes map[string]*ScheduledChange
+	windows map[string]MaintenanceWindow
+}
+
+// NewScheduler - empty scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		changes: map[string]*ScheduledChange{},
+		windows: map[string]MaintenanceWindow{},
+	}
+}
+
+// Schedule - queue requests to next carrying execute_at or maintenance_window
+//
+// The fields are read from the json body or the query string; requests
+// without them are passed through unchanged.
+func (s *Scheduler) Schedule(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Context().Value(ScheduledKeyName) != nil || IsDryRun(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "reading request body")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fields := scheduleFields{Window: r.URL.Query().Get("maintenance_window")}
+		if v := r.URL.Query().Get("execute_at"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, "execute_at must be RFC3339")
+				return
+			}
+			fields.ExecuteAt = &t
+		}
+		if len(body) > 0 {
+			// Malformed bodies are left for the handler to report
+			_ = json.Unmarshal(body, &fields)
+		}
+		if fields.ExecuteAt == nil && fields.Window == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		callbackURL, err := RequestCallbackURL(r, body)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		change := &ScheduledChange{
+			ID:          NewID(),
+			Status:      ScheduleQueued,
+			Window:      fields.Window,
+			Method:      r.Method,
+			URL:         r.URL.RequestURI(),
+			Body:        string(body),
+			RequestedBy: RequestIdentity(r),
+			CreatedAt:   time.Now(),
+			Callback:    NewCallback(callbackURL),
+			approvedBy:  r.Context().Value(ApprovedKeyName),
+			approver:    r.Context().Value(ApproverKeyName),
+			principal:   RequestPrincipal(r),
+			request:     NewCapturedRequest(r, body),
+			targets:     RequestTargets(r, body),
+		}
+		if fields.ExecuteAt != nil {
+			change.ExecuteAt = *fields.ExecuteAt
+		}
+		if fields.Window != "" {
+			s.mu.RLock()
+			window, ok := s.windows[fields.Window]
+			s.mu.RUnlock()
+			if !ok {
+				WriteError(w, http.StatusBadRequest, "unknown maintenance window "+fields.Window)
+				return
+			}
+			if change.ExecuteAt.Before(window.Start) {
+				change.ExecuteAt = window.Start
+			}
+			if !change.ExecuteAt.Before(window.End) {
+				WriteError(w, http.StatusBadRequest, "execution time is outside maintenance window")
+				return
+			}
+		}
+
+		s.mu.Lock()
+		s.changes[change.ID] = change
+		s.mu.Unlock()
+
+		w.Header().Set("Location", "/api/v1/scheduled/"+change.ID)
+		WriteJSON(w, http.StatusAccepted, change)
+	})
+}
+
+// Run - execute due changes until stop is closed
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, c := range s.due(now) {
+				s.execute(c)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// due - queued changes whose time has come, marked running
+func (s *Scheduler) due(now time.Time) []*ScheduledChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*ScheduledChange
+	for _, c := range s.changes {
+		if c.Status != ScheduleQueued || c.ExecuteAt.After(now) {
+			continue
+		}
+		if c.Window != "" {
+			if window, ok := s.windows[c.Window]; !ok || !now.Before(window.End) {
+				c.Status = ScheduleMissed
+				s.notify(c, "scheduled.missed")
+				continue
+			}
+		}
+		c.Status = ScheduleRunning
+		due = append(due, c)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ExecuteAt.Before(due[j].ExecuteAt) })
+	return due
+}
+
+func (s *Scheduler) execute(c *ScheduledChange) {
+	ctx := context.WithValue(context.Background(), ScheduledKeyName, c.ID)
+	if c.approvedBy != nil {
+		ctx = context.WithValue(ctx, ApprovedKeyName, c.approvedBy)
+		ctx = context.WithValue(ctx, ApproverKeyName, c.approver)
+	}
+	if c.principal != nil {
+		ctx = context.WithValue(ctx, PrincipalKeyName, c.principal)
+	}
+	result := c.request.Execute(ctx, s.Handler)
+
+	now := time.Now()
+	s.mu.Lock()
+	c.Status = ScheduleExecuted
+	c.ExecutedAt = &now
+	c.Result = result
+	s.notify(c, "scheduled.executed")
+	s.mu.Unlock()
+}
+
+// notify - deliver callback of change, caller holds the lock
+func (s *Scheduler) notify(c *ScheduledChange, event string) {
+	if c.Callback == nil {
+		return
+	}
+	c.Callback.Deliver(CallbackEvent{Event: event, ID: c.ID, Time: time.Now(), Data: *c}, func(cb Callback) {
+		s.mu.Lock()
+		c.Callback = &cb
+		s.mu.Unlock()
+	})
+}
+
+// Count - number of changes in status
+func (s *Scheduler) Count(status string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, c := range s.changes {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Scheduler) list() []ScheduledChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]ScheduledChange, 0, len(s.changes))
+	for _, c := range s.changes {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ExecuteAt.Before(list[j].ExecuteAt) })
+	return list
+}
+
+// ListScheduledHandler - scheduled changes, ?status= filters
+func (s *Scheduler) ListScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	changes := []ScheduledChange{}
+	for _, c := range s.list() {
+		if status == "" || c.Status == status {
+			changes = append(changes, c)
+		}
+	}
+	WriteJSON(w, http.StatusOK, changes)
+}
+
+// GetScheduledHandler - single scheduled change and its result
+func (s *Scheduler) GetScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	c, ok := s.changes[mux.Vars(r)["id"]]
+	var change ScheduledChange
+	if ok {
+		change = *c
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "scheduled change not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, change)
+}
+
+// CancelScheduledHandler - cancel change that has not started yet
+//
+// Only the requester or an admin may cancel a change, and only if the
+// targets of the change are within their scope.
+func (s *Scheduler) CancelScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.changes[mux.Vars(r)["id"]]
+	if !ok {
+		WriteError(w, http.StatusNotFound, "scheduled change not found")
+		return
+	}
+	p := RequestPrincipal(r)
+	if RequestIdentity(r) != c.RequestedBy && (p == nil || !p.Can(RoleAdmin)) {
+		WriteError(w, http.StatusForbidden, "change can only be cancelled by its requester or an admin")
+		return
+	}
+	if target, ok := AuthorizeTargets(r, c.targets); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	if c.Status != ScheduleQueued {
+		WriteError(w, http.StatusConflict, "change is "+c.Status)
+		return
+	}
+	c.Status = ScheduleCancelled
+	WriteJSON(w, http.StatusOK, c)
+}
+
+// ListWindowsHandler - maintenance windows
+func (s *Scheduler) ListWindowsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	windows := make([]MaintenanceWindow, 0, len(s.windows))
+	for _, window := range s.windows {
+		windows = append(windows, window)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	WriteJSON(w, http.StatusOK, windows)
+}
+
+// PutWindowHandler - create or replace maintenance window
+func (s *Scheduler) PutWindowHandler(w http.ResponseWriter, r *http.Request) {
+	window := MaintenanceWindow{}
+	if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid maintenance window json")
+		return
+	}
+	if !window.End.After(window.Start) {
+		WriteError(w, http.StatusBadRequest, "end must be after start")
+		return
+	}
+	window.Name = mux.Vars(r)["name"]
+
+	s.mu.Lock()
+	s.windows[window.Name] = window
+	s.mu.Unlock()
+	WriteJSON(w, http.StatusOK, window)
+}
+
+// DeleteWindowHandler - remove maintenance window
+func (s *Scheduler) DeleteWindowHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	s.mu.Lock()
+	_, ok := s.windows[name]
+	delete(s.windows, name)
+	s.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "maintenance window not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
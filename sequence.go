@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Step outcomes reported by SequenceHandler
+const (
+	StepOK      = "ok"
+	StepFailed  = "failed"
+	StepSkipped = "skipped"
+)
+
+// SetStep - varbinds written in one SET exchange, see SetEntryRequest
+type SetStep struct {
+	Values   [][]interface{} `json:"values,omitempty"`
+	Varbinds []SetVarbind    `json:"varbinds,omitempty"`
+}
+
+// SequenceRequest - ordered SET steps
+type SequenceRequest struct {
+	OnError string    `json:"on_error"`
+	Steps   []SetStep `json:"steps"`
+}
+
+// StepResult - outcome of a single SET step
+//
+// Variables are formatted per request options, as in SET responses.
+type StepResult struct {
+	Step      int         `json:"step"`
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	Variables interface{} `json:"variables,omitempty"`
+}
+
+// SequenceHandler - ordered multi-step snmpset, each step its own PDU
+//
+// on_error is "stop" (default) to skip remaining steps after the first
+// failure or "continue" to attempt every step.
+func SequenceHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	request := SequenceRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid sequence json")
+		return
+	}
+	if request.OnError == "" {
+		request.OnError = "stop"
+	}
+	if request.OnError != "stop" && request.OnError != "continue" {
+		WriteError(w, http.StatusBadRequest, "on_error must be stop or continue")
+		return
+	}
+	if len(request.Steps) == 0 {
+		WriteError(w, http.StatusBadRequest, "Nothing to set")
+		return
+	}
+	if _, err := ParseFormatOptions(r); err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Validate every step before touching the device
+	limits := LimitsForTarget(SessionTarget(g))
+	steps := make([][]gosnmp.SnmpPDU, len(request.Steps))
+	for i, step := range request.Steps {
+		pdus, err := ValuesToPDUs(append(step.Values, VarbindValues(step.Varbinds)...))
+		if err == nil {
+			err = limits.Check(g, gosnmp.SetRequest, pdus)
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("step %d: %v", i, err))
+			return
+		}
+		steps[i] = pdus
+	}
+
+	status := http.StatusOK
+	results := make([]StepResult, len(steps))
+	failed := false
+	for i, pdus := range steps {
+		results[i].Step = i
+		if failed && request.OnError == "stop" {
+			results[i].Status = StepSkipped
+			continue
+		}
+
+		result, err := JournaledSet(g, "sequence", pdus)
+		if err == nil {
+			if perr := NewPacketError("Set", result); perr != nil {
+				err = perr
+			}
+		}
+		if err != nil {
+			results[i].Status = StepFailed
+			results[i].Error = err.Error()
+			failed = true
+			status = http.StatusMultiStatus
+			continue
+		}
+		results[i].Status = StepOK
+		results[i].Variables, _ = formatVariables(r, result.Variables)
+	}
+
+	WriteJSON(w, status, results)
+}
+
+// ValuesToPDUs - convert [oid, type, value] triplets to pdus
+func ValuesToPDUs(values [][]interface{}) ([]gosnmp.SnmpPDU, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no values")
+	}
+	pdus := make([]gosnmp.SnmpPDU, len(values))
+	for i, val := range values {
+		if len(val) < 3 {
+			return nil, fmt.Errorf("value %d: expected [oid, type, value]", i)
+		}
+		oid, ok := val[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("value %d: oid must be a string", i)
+		}
+		pdu, err := ToSnmpPDU(oid, val[1], val[2])
+		if err == nil {
+			err = WritablePDU(pdu)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %v", i, err)
+		}
+		pdus[i] = pdu
+	}
+	return pdus, nil
+}
@@ -0,0 +1,954 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/soniah/gosnmp"
+)
+
+// SimulatorTarget - reserved target name routed to the agent of -simulate
+const SimulatorTarget = "_sim"
+
+// CredentialFromSimulator - X-SNMP-Credential-Source of the community of -simulate-write-community
+const CredentialFromSimulator = "simulator"
+
+// simulatorMaxMsgSize - largest response the simulator sends, that of a udp datagram
+const simulatorMaxMsgSize = 65507
+
+// snmprecTypes - type tags of snmprec fixtures, as recorded by snmpsim
+var snmprecTypes = map[string]gosnmp.Asn1BER{
+	"2":  gosnmp.Integer,
+	"4":  gosnmp.OctetString,
+	"5":  gosnmp.Null,
+	"6":  gosnmp.ObjectIdentifier,
+	"64": gosnmp.IPAddress,
+	"65": gosnmp.Counter32,
+	"66": gosnmp.Gauge32,
+	"67": gosnmp.TimeTicks,
+	"68": gosnmp.Opaque,
+	"70": gosnmp.Counter64,
+}
+
+// simObject - object of the simulated agent
+type simObject struct {
+	arcs []int
+	pdu  gosnmp.SnmpPDU
+}
+
+// Simulator - embedded v1/v2c agent answering from an in memory copy of a fixture
+//
+// GET, GETNEXT, GETBULK and SET are answered like a real agent would,
+// noSuchObject, noSuchInstance and endOfMibView included. SETs change
+// the copy only. Rows of the table definitions are created and destroyed
+// through their RowStatus column, so the row endpoints work against it.
+// Requests with other communities, and v3 requests, are dropped.
+type Simulator struct {
+	ReadCommunity  string
+	WriteCommunity string
+
+	mu      sync.RWMutex
+	objects []simObject
+	address string
+}
+
+// simulator - agent of -simulate, nil unless simulating
+var simulator *Simulator
+
+// NewSimulator - simulator with the objects of pdus, later ones replacing earlier ones of the same oid
+func NewSimulator(pdus []gosnmp.SnmpPDU) (*Simulator, error) {
+	s := &Simulator{ReadCommunity: "public", WriteCommunity: "private"}
+	for _, pdu := range pdus {
+		arcs, err := ParseOid(pdu.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(arcs) < 2 {
+			return nil, fmt.Errorf("invalid oid %s", pdu.Name)
+		}
+		pdu.Name = oidString(arcs)
+		s.store(arcs, pdu)
+	}
+	return s, nil
+}
+
+// LoadFixture - objects of a .json or snmprec fixture
+//
+// json fixtures are a list of SET triplets, e.g. [["sysName.0", "s", "sim"]];
+// other files are read as snmprec, one oid|type|value line per object.
+func LoadFixture(path string) ([]gosnmp.SnmpPDU, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return parseJSONFixture(f)
+	}
+	return parseSnmprec(f)
+}
+
+// parseJSONFixture - objects of a list of SET triplets
+func parseJSONFixture(r io.Reader) ([]gosnmp.SnmpPDU, error) {
+	var triplets [][]interface{}
+	if err := json.NewDecoder(r).Decode(&triplets); err != nil {
+		return nil, fmt.Errorf("invalid fixture json: %v", err)
+	}
+	pdus := make([]gosnmp.SnmpPDU, 0, len(triplets))
+	for i, triplet := range triplets {
+		oid, ok := "", len(triplet) == 3
+		if ok {
+			oid, ok = triplet[0].(string)
+		}
+		if !ok {
+			return nil, fmt.Errorf("object %d: expected [oid, type, value]", i)
+		}
+		resolved, err := ResolveOid(oid)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %v", i, err)
+		}
+		pdu, err := ToSnmpPDU(resolved, triplet[1], triplet[2])
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %v", i, err)
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus, nil
+}
+
+// parseSnmprec - objects of snmprec lines, blank and # comment lines skipped
+func parseSnmprec(r io.Reader) ([]gosnmp.SnmpPDU, error) {
+	var pdus []gosnmp.SnmpPDU
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, "|", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: expected oid|type|value", line)
+		}
+		pdu, err := snmprecPDU(parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		pdus = append(pdus, pdu)
+	}
+	return pdus, scanner.Err()
+}
+
+// snmprecPDU - object of a snmprec line, a type tag ending in x has a hex value
+func snmprecPDU(oid string, tag string, value string) (gosnmp.SnmpPDU, error) {
+	pdu := gosnmp.SnmpPDU{Name: normalizeOid(oid)}
+	if _, err := ParseOid(oid); err != nil {
+		return pdu, err
+	}
+	hexed := strings.HasSuffix(tag, "x")
+	pduType, ok := snmprecTypes[strings.TrimSuffix(tag, "x")]
+	if !ok {
+		return pdu, fmt.Errorf("unsupported type %s", tag)
+	}
+	pdu.Type = pduType
+
+	var octets []byte
+	if hexed {
+		var err error
+		if octets, err = hexValue(value); err != nil {
+			return pdu, err
+		}
+	}
+	switch pduType {
+	case gosnmp.OctetString, gosnmp.Opaque:
+		if hexed {
+			pdu.Value = octets
+		} else {
+			pdu.Value = []byte(value)
+		}
+		return pdu, nil
+	case gosnmp.IPAddress:
+		ip := net.ParseIP(value)
+		if hexed && len(octets) == net.IPv4len {
+			ip = net.IP(octets)
+		}
+		if ip == nil || ip.To4() == nil {
+			return pdu, fmt.Errorf("expected IPv4 address")
+		}
+		pdu.Value = ip.To4().String()
+		return pdu, nil
+	}
+	if hexed {
+		return pdu, fmt.Errorf("hex values only for types 4, 64 and 68")
+	}
+
+	switch pduType {
+	case gosnmp.Integer:
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return pdu, fmt.Errorf("expected 32 bit integer")
+		}
+		pdu.Value = int(n)
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return pdu, fmt.Errorf("expected 32 bit unsigned integer")
+		}
+		pdu.Value = uint32(n)
+	case gosnmp.Counter64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return pdu, fmt.Errorf("expected 64 bit unsigned integer")
+		}
+		pdu.Value = n
+	case gosnmp.ObjectIdentifier:
+		if _, err := ParseOid(value); err != nil {
+			return pdu, err
+		}
+		pdu.Value = normalizeOid(value)
+	}
+	return pdu, nil
+}
+
+// Address - target address of the simulator, empty until started
+func (s *Simulator) Address() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.address
+}
+
+// Len - number of objects
+func (s *Simulator) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.objects)
+}
+
+// Start - answer requests on udp addr until stop is closed
+//
+// The socket is bound before Start returns, so SimulatorTarget resolves
+// right away; an unspecified listen address is reached through loopback.
+func (s *Simulator) Start(addr string, stop <-chan struct{}) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	host := local.IP
+	if host == nil || host.IsUnspecified() {
+		host = net.IPv4(127, 0, 0, 1)
+	}
+	s.mu.Lock()
+	s.address = TargetAddress{Host: host.String(), Port: uint16(local.Port), Transport: TransportUDP}.String()
+	s.mu.Unlock()
+
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+	go s.serve(conn, stop)
+	return nil
+}
+
+// serve - answer every request read from conn
+func (s *Simulator) serve(conn *net.UDPConn, stop <-chan struct{}) {
+	buf := make([]byte, 65535)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			logger.Error("reading simulator request", Fields{"err": err})
+			continue
+		}
+		response, err := s.respond(buf[:n])
+		if err != nil {
+			stats.Inc("simulator.dropped")
+			logger.Debug("simulator request dropped", Fields{"remote": remote.String(), "err": err})
+			continue
+		}
+		stats.Inc("simulator.requests")
+		if _, err := conn.WriteToUDP(response, remote); err != nil {
+			logger.Error("writing simulator response", Fields{"remote": remote.String(), "err": err})
+		}
+	}
+}
+
+// simRequest - decoded v1/v2c request
+//
+// NonRepeaters and MaxRepetitions hold the error status and index fields
+// of PDUs other than GetBulkRequest.
+type simRequest struct {
+	Version        gosnmp.SnmpVersion
+	Community      string
+	PDUType        gosnmp.PDUType
+	RequestID      int64
+	NonRepeaters   int64
+	MaxRepetitions int64
+	Variables      []gosnmp.SnmpPDU
+	arcs           [][]int
+}
+
+// respond - encoded response to msg, an error for requests to drop
+func (s *Simulator) respond(msg []byte) ([]byte, error) {
+	req, err := decodeSimRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	write := req.PDUType == gosnmp.SetRequest
+	if req.Community != s.WriteCommunity && (write || req.Community != s.ReadCommunity) {
+		return nil, fmt.Errorf("community not allowed")
+	}
+
+	status, index := gosnmp.NoError, 0
+	var variables []gosnmp.SnmpPDU
+	switch req.PDUType {
+	case gosnmp.GetRequest:
+		variables, status, index = s.get(req)
+	case gosnmp.GetNextRequest:
+		variables, status, index = s.getNext(req)
+	case gosnmp.GetBulkRequest:
+		if req.Version == gosnmp.Version1 {
+			return nil, fmt.Errorf("getbulk with v1")
+		}
+		variables = s.getBulk(req)
+	case gosnmp.SetRequest:
+		status, index = s.set(req)
+	default:
+		return nil, fmt.Errorf("unsupported pdu type %#x", byte(req.PDUType))
+	}
+	if status != gosnmp.NoError {
+		variables = req.Variables
+		if req.Version == gosnmp.Version1 {
+			status = v1ErrorStatus(status)
+		}
+	} else if variables == nil {
+		variables = req.Variables
+	}
+
+	response, err := encodeSimResponse(req, status, index, variables)
+	if err != nil {
+		return nil, err
+	}
+	for req.PDUType == gosnmp.GetBulkRequest && len(response) > simulatorMaxMsgSize && len(variables) > 1 {
+		variables = variables[:len(variables)/2]
+		if response, err = encodeSimResponse(req, status, index, variables); err != nil {
+			return nil, err
+		}
+	}
+	if len(response) > simulatorMaxMsgSize {
+		return encodeSimResponse(req, gosnmp.TooBig, 0, nil)
+	}
+	return response, nil
+}
+
+// v1ErrorStatus - SNMPv1 error status of a v2c one, see RFC 3584
+func v1ErrorStatus(status gosnmp.SNMPError) gosnmp.SNMPError {
+	switch status {
+	case gosnmp.NoCreation, gosnmp.NotWritable, gosnmp.NoAccess, gosnmp.AuthorizationError, gosnmp.InconsistentName:
+		return gosnmp.NoSuchName
+	case gosnmp.WrongType, gosnmp.WrongLength, gosnmp.WrongEncoding, gosnmp.WrongValue, gosnmp.InconsistentValue:
+		return gosnmp.BadValue
+	case gosnmp.ResourceUnavailable, gosnmp.CommitFailed, gosnmp.UndoFailed:
+		return gosnmp.GenErr
+	}
+	return status
+}
+
+// get - value of every requested oid
+func (s *Simulator) get(req *simRequest) ([]gosnmp.SnmpPDU, gosnmp.SNMPError, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	variables := make([]gosnmp.SnmpPDU, len(req.Variables))
+	for i, arcs := range req.arcs {
+		pdu, ok := s.lookup(arcs)
+		if !ok {
+			if req.Version == gosnmp.Version1 {
+				return nil, gosnmp.NoSuchName, i + 1
+			}
+			pdu = gosnmp.SnmpPDU{Name: oidString(arcs), Type: s.missing(arcs)}
+		}
+		variables[i] = pdu
+	}
+	return variables, gosnmp.NoError, 0
+}
+
+// getNext - object following every requested oid
+func (s *Simulator) getNext(req *simRequest) ([]gosnmp.SnmpPDU, gosnmp.SNMPError, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	variables := make([]gosnmp.SnmpPDU, len(req.Variables))
+	for i, arcs := range req.arcs {
+		pdu, ok := s.next(arcs)
+		if !ok && req.Version == gosnmp.Version1 {
+			return nil, gosnmp.NoSuchName, i + 1
+		}
+		variables[i] = pdu
+	}
+	return variables, gosnmp.NoError, 0
+}
+
+// getBulk - objects following the non-repeaters once and the repeaters max-repetitions times
+//
+// Repetitions stop early once every repeater reached the end of the MIB.
+func (s *Simulator) getBulk(req *simRequest) []gosnmp.SnmpPDU {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	nonRepeaters := int(req.NonRepeaters)
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(req.arcs) {
+		nonRepeaters = len(req.arcs)
+	}
+	variables := []gosnmp.SnmpPDU{}
+	for _, arcs := range req.arcs[:nonRepeaters] {
+		pdu, _ := s.next(arcs)
+		variables = append(variables, pdu)
+	}
+
+	cursors := append([][]int(nil), req.arcs[nonRepeaters:]...)
+	for r := int64(0); r < req.MaxRepetitions && len(cursors) > 0; r++ {
+		more := false
+		for i, arcs := range cursors {
+			pdu, ok := s.next(arcs)
+			if ok {
+				cursors[i], _ = ParseOid(pdu.Name)
+				more = true
+			}
+			variables = append(variables, pdu)
+		}
+		if !more {
+			break
+		}
+	}
+	return variables
+}
+
+// set - apply every varbind, or none if any of them is refused
+func (s *Simulator) set(req *simRequest) (gosnmp.SNMPError, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, pdu := range req.Variables {
+		if status := s.checkSet(req.arcs[i], pdu); status != gosnmp.NoError {
+			return status, i + 1
+		}
+	}
+	for i, pdu := range req.Variables {
+		s.applySet(req.arcs[i], pdu)
+	}
+	return gosnmp.NoError, 0
+}
+
+// checkSet - error status of setting arcs to the value of pdu
+//
+// Existing objects keep their type. Missing objects can only be created
+// as columns of a defined table.
+func (s *Simulator) checkSet(arcs []int, pdu gosnmp.SnmpPDU) gosnmp.SNMPError {
+	existing, exists := s.lookup(arcs)
+	row, inTable := simTableRow(arcs)
+	if inTable && row.column == row.table.RowStatusColumn {
+		if pdu.Type != gosnmp.Integer {
+			return gosnmp.WrongType
+		}
+		switch gosnmp.ToBigInt(pdu.Value).Int64() {
+		case RowStatusActive, RowStatusNotInService:
+			if !exists {
+				return gosnmp.InconsistentValue
+			}
+		case RowStatusCreateAndGo, RowStatusCreateAndWait:
+			if exists {
+				return gosnmp.InconsistentValue
+			}
+		case RowStatusDestroy:
+		default:
+			return gosnmp.WrongValue
+		}
+		return gosnmp.NoError
+	}
+	if exists {
+		if existing.Type != pdu.Type {
+			return gosnmp.WrongType
+		}
+		return gosnmp.NoError
+	}
+	if !inTable {
+		return gosnmp.NoCreation
+	}
+	for _, c := range row.table.Columns {
+		if c.Column == row.column {
+			if t, err := ParsePDUType(c.Type); err == nil && t != pdu.Type {
+				return gosnmp.WrongType
+			}
+			return gosnmp.NoError
+		}
+	}
+	return gosnmp.NoCreation
+}
+
+// applySet - set arcs to the value of pdu, RowStatus values as an agent keeps them
+func (s *Simulator) applySet(arcs []int, pdu gosnmp.SnmpPDU) {
+	if row, ok := simTableRow(arcs); ok && row.column == row.table.RowStatusColumn {
+		switch gosnmp.ToBigInt(pdu.Value).Int64() {
+		case RowStatusDestroy:
+			s.destroyRow(row)
+			return
+		case RowStatusCreateAndGo:
+			pdu.Value = RowStatusActive
+		case RowStatusCreateAndWait:
+			pdu.Value = RowStatusNotInService
+		}
+	}
+	pdu.Name = oidString(arcs)
+	s.store(arcs, pdu)
+}
+
+// simRow - row of a defined table an oid is a column of
+type simRow struct {
+	table  *TableDef
+	entry  []int
+	column int
+	index  []int
+}
+
+// simTableRow - row of the table definitions arcs belong to
+func simTableRow(arcs []int) (simRow, bool) {
+	tables.mu.RLock()
+	defer tables.mu.RUnlock()
+	for _, t := range tables.list() {
+		resolved, err := t.validate()
+		if err != nil {
+			continue
+		}
+		entry, err := ParseOid(resolved)
+		if err != nil || len(arcs) < len(entry)+2 || compareOids(arcs[:len(entry)], entry) != 0 {
+			continue
+		}
+		return simRow{table: t, entry: entry, column: arcs[len(entry)], index: arcs[len(entry)+1:]}, true
+	}
+	return simRow{}, false
+}
+
+// destroyRow - remove every column of row
+func (s *Simulator) destroyRow(row simRow) {
+	kept := s.objects[:0]
+	for _, o := range s.objects {
+		n := len(row.entry)
+		if len(o.arcs) == n+1+len(row.index) && compareOids(o.arcs[:n], row.entry) == 0 &&
+			compareOids(o.arcs[n+1:], row.index) == 0 {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	s.objects = kept
+}
+
+// search - position of the first object not before arcs
+func (s *Simulator) search(arcs []int) int {
+	return sort.Search(len(s.objects), func(i int) bool {
+		return compareOids(s.objects[i].arcs, arcs) >= 0
+	})
+}
+
+// lookup - object at arcs
+func (s *Simulator) lookup(arcs []int) (gosnmp.SnmpPDU, bool) {
+	i := s.search(arcs)
+	if i < len(s.objects) && compareOids(s.objects[i].arcs, arcs) == 0 {
+		return s.objects[i].pdu, true
+	}
+	return gosnmp.SnmpPDU{}, false
+}
+
+// next - object following arcs, endOfMibView at arcs after the last one
+func (s *Simulator) next(arcs []int) (gosnmp.SnmpPDU, bool) {
+	i := s.search(arcs)
+	if i < len(s.objects) && compareOids(s.objects[i].arcs, arcs) == 0 {
+		i++
+	}
+	if i == len(s.objects) {
+		return gosnmp.SnmpPDU{Name: oidString(arcs), Type: gosnmp.EndOfMibView}, false
+	}
+	return s.objects[i].pdu, true
+}
+
+// missing - noSuchInstance for other instances of an object the agent has, noSuchObject otherwise
+//
+// The object is that of a loaded MIB. Without one, instances are the
+// objects of the same length differing only in their last arc.
+func (s *Simulator) missing(arcs []int) gosnmp.Asn1BER {
+	object, sameLength := arcs[:len(arcs)-1], true
+	if _, o := TranslateOid(oidString(arcs)); o != nil {
+		if objectArcs, err := ParseOid(o.Oid); err == nil && len(objectArcs) < len(arcs) {
+			object, sameLength = objectArcs, false
+		}
+	}
+	for i := s.search(object); i < len(s.objects); i++ {
+		o := s.objects[i].arcs
+		if len(o) <= len(object) || compareOids(o[:len(object)], object) != 0 {
+			break
+		}
+		if !sameLength || len(o) == len(arcs) {
+			return gosnmp.NoSuchInstance
+		}
+	}
+	return gosnmp.NoSuchObject
+}
+
+// store - add or replace the object at arcs
+func (s *Simulator) store(arcs []int, pdu gosnmp.SnmpPDU) {
+	i := s.search(arcs)
+	if i < len(s.objects) && compareOids(s.objects[i].arcs, arcs) == 0 {
+		s.objects[i].pdu = pdu
+		return
+	}
+	s.objects = append(s.objects, simObject{})
+	copy(s.objects[i+1:], s.objects[i:])
+	s.objects[i] = simObject{arcs: arcs, pdu: pdu}
+}
+
+// resolveSimulator - address of the simulator for SimulatorTarget, target otherwise
+func resolveSimulator(target string) string {
+	if target == SimulatorTarget && simulator != nil {
+		return simulator.Address()
+	}
+	return target
+}
+
+// oidString - dotted oid of arcs, with a leading dot
+func oidString(arcs []int) string {
+	parts := make([]string, len(arcs))
+	for i, arc := range arcs {
+		parts[i] = strconv.Itoa(arc)
+	}
+	return "." + strings.Join(parts, ".")
+}
+
+// decodeSimRequest - v1/v2c request of msg
+func decodeSimRequest(msg []byte) (*simRequest, error) {
+	tag, message, _, err := berRead(msg)
+	if err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, fmt.Errorf("not an snmp message")
+	}
+	req := &simRequest{}
+	tag, content, message, err := berRead(message)
+	if err != nil || tag != byte(gosnmp.Integer) {
+		return nil, fmt.Errorf("missing version")
+	}
+	version, err := berInt(content)
+	if err != nil || (version != int64(gosnmp.Version1) && version != int64(gosnmp.Version2c)) {
+		return nil, fmt.Errorf("unsupported snmp version")
+	}
+	req.Version = gosnmp.SnmpVersion(version)
+	if tag, content, message, err = berRead(message); err != nil || tag != byte(gosnmp.OctetString) {
+		return nil, fmt.Errorf("missing community")
+	}
+	req.Community = string(content)
+	if tag, message, _, err = berRead(message); err != nil {
+		return nil, fmt.Errorf("missing pdu")
+	}
+	req.PDUType = gosnmp.PDUType(tag)
+
+	fields := make([]int64, 3)
+	for i := range fields {
+		if tag, content, message, err = berRead(message); err != nil || tag != byte(gosnmp.Integer) {
+			return nil, fmt.Errorf("invalid pdu header")
+		}
+		if fields[i], err = berInt(content); err != nil {
+			return nil, err
+		}
+	}
+	req.RequestID, req.NonRepeaters, req.MaxRepetitions = fields[0], fields[1], fields[2]
+
+	if tag, message, _, err = berRead(message); err != nil || tag != byte(gosnmp.Sequence) {
+		return nil, fmt.Errorf("missing varbinds")
+	}
+	for len(message) > 0 {
+		var varbind []byte
+		if tag, varbind, message, err = berRead(message); err != nil || tag != byte(gosnmp.Sequence) {
+			return nil, fmt.Errorf("invalid varbind")
+		}
+		if tag, content, varbind, err = berRead(varbind); err != nil || tag != byte(gosnmp.ObjectIdentifier) {
+			return nil, fmt.Errorf("invalid varbind name")
+		}
+		arcs, err := berOidArcs(content)
+		if err != nil {
+			return nil, err
+		}
+		if tag, content, _, err = berRead(varbind); err != nil {
+			return nil, fmt.Errorf("invalid varbind value")
+		}
+		pdu, err := berValue(gosnmp.Asn1BER(tag), content)
+		if err != nil {
+			return nil, err
+		}
+		pdu.Name = oidString(arcs)
+		req.Variables = append(req.Variables, pdu)
+		req.arcs = append(req.arcs, arcs)
+	}
+	return req, nil
+}
+
+// encodeSimResponse - GetResponse to req with status, index and variables
+func encodeSimResponse(req *simRequest, status gosnmp.SNMPError, index int, variables []gosnmp.SnmpPDU) ([]byte, error) {
+	var list []byte
+	for _, pdu := range variables {
+		arcs, err := ParseOid(pdu.Name)
+		if err != nil {
+			return nil, err
+		}
+		name, err := berOid(arcs)
+		if err != nil {
+			return nil, err
+		}
+		value, err := berEncodeValue(pdu)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pdu.Name, err)
+		}
+		list = append(list, berTLV(byte(gosnmp.Sequence), append(berTLV(byte(gosnmp.ObjectIdentifier), name), value...))...)
+	}
+	var pdu []byte
+	pdu = append(pdu, berTLV(byte(gosnmp.Integer), berInteger(req.RequestID))...)
+	pdu = append(pdu, berTLV(byte(gosnmp.Integer), berInteger(int64(status)))...)
+	pdu = append(pdu, berTLV(byte(gosnmp.Integer), berInteger(int64(index)))...)
+	pdu = append(pdu, berTLV(byte(gosnmp.Sequence), list)...)
+
+	var message []byte
+	message = append(message, berTLV(byte(gosnmp.Integer), berInteger(int64(req.Version)))...)
+	message = append(message, berTLV(byte(gosnmp.OctetString), []byte(req.Community))...)
+	message = append(message, berTLV(byte(gosnmp.GetResponse), pdu)...)
+	return berTLV(byte(gosnmp.Sequence), message), nil
+}
+
+// berRead - tag and content of the first element of b, and what follows it
+func berRead(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, fmt.Errorf("truncated ber element")
+	}
+	length, header, ok := berLength(b[1:])
+	if !ok || 1+header+length > len(b) {
+		return 0, nil, nil, fmt.Errorf("truncated ber element")
+	}
+	start := 1 + header
+	return b[0], b[start : start+length], b[start+length:], nil
+}
+
+// berTLV - element of tag with content
+func berTLV(tag byte, content []byte) []byte {
+	n := len(content)
+	length := []byte{byte(n)}
+	if n >= 0x80 {
+		length = nil
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		length = append([]byte{0x80 | byte(len(length))}, length...)
+	}
+	element := append([]byte{tag}, length...)
+	return append(element, content...)
+}
+
+// berInteger - shortest two's complement content of n
+func berInteger(n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if n >= -128 && n < 128 {
+			return b
+		}
+		n >>= 8
+	}
+}
+
+// berUnsigned - shortest content of the unsigned n, zero padded if its high bit is set
+func berUnsigned(n uint64) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// berInt - signed integer content
+func berInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, fmt.Errorf("invalid integer")
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+// berUint - unsigned integer content
+func berUint(b []byte) (uint64, error) {
+	if len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 8 {
+		return 0, fmt.Errorf("invalid unsigned integer")
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// berOid - content of the oid of arcs
+func berOid(arcs []int) ([]byte, error) {
+	if len(arcs) < 2 || arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("invalid oid %s", oidString(arcs))
+	}
+	b := base128(arcs[0]*40 + arcs[1])
+	for _, arc := range arcs[2:] {
+		b = append(b, base128(arc)...)
+	}
+	return b, nil
+}
+
+// base128 - sub-identifier in 7 bit groups, high bit set on all but the last
+func base128(n int) []byte {
+	b := []byte{byte(n & 0x7f)}
+	for n >>= 7; n > 0; n >>= 7 {
+		b = append([]byte{byte(n&0x7f | 0x80)}, b...)
+	}
+	return b
+}
+
+// berOidArcs - arcs of oid content
+func berOidArcs(b []byte) ([]int, error) {
+	var arcs []int
+	n := 0
+	for i, c := range b {
+		if n > math.MaxInt32>>7 {
+			return nil, fmt.Errorf("invalid oid")
+		}
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, fmt.Errorf("invalid oid")
+			}
+			continue
+		}
+		if arcs == nil {
+			switch {
+			case n < 40:
+				arcs = []int{0, n}
+			case n < 80:
+				arcs = []int{1, n - 40}
+			default:
+				arcs = []int{2, n - 80}
+			}
+		} else {
+			arcs = append(arcs, n)
+		}
+		n = 0
+	}
+	if arcs == nil {
+		return nil, fmt.Errorf("invalid oid")
+	}
+	return arcs, nil
+}
+
+// berValue - varbind of a value of type with content, values as gosnmp encodes them
+func berValue(pduType gosnmp.Asn1BER, content []byte) (gosnmp.SnmpPDU, error) {
+	pdu := gosnmp.SnmpPDU{Type: pduType}
+	switch pduType {
+	case gosnmp.Null:
+	case gosnmp.Integer:
+		n, err := berInt(content)
+		if err != nil || n < math.MinInt32 || n > math.MaxInt32 {
+			return pdu, fmt.Errorf("invalid integer")
+		}
+		pdu.Value = int(n)
+	case gosnmp.OctetString, gosnmp.Opaque:
+		pdu.Value = append([]byte(nil), content...)
+	case gosnmp.ObjectIdentifier:
+		arcs, err := berOidArcs(content)
+		if err != nil {
+			return pdu, err
+		}
+		pdu.Value = oidString(arcs)
+	case gosnmp.IPAddress:
+		if len(content) != net.IPv4len {
+			return pdu, fmt.Errorf("invalid ip address")
+		}
+		pdu.Value = net.IP(content).String()
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		n, err := berUint(content)
+		if err != nil || n > math.MaxUint32 {
+			return pdu, fmt.Errorf("invalid unsigned integer")
+		}
+		pdu.Value = uint32(n)
+	case gosnmp.Counter64:
+		n, err := berUint(content)
+		if err != nil {
+			return pdu, err
+		}
+		pdu.Value = n
+	default:
+		return pdu, fmt.Errorf("unsupported value type %#x", byte(pduType))
+	}
+	return pdu, nil
+}
+
+// berEncodeValue - value element of pdu
+func berEncodeValue(pdu gosnmp.SnmpPDU) ([]byte, error) {
+	var content []byte
+	switch pdu.Type {
+	case gosnmp.Null, gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+	case gosnmp.Integer:
+		content = berInteger(gosnmp.ToBigInt(pdu.Value).Int64())
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32, gosnmp.Counter64:
+		content = berUnsigned(gosnmp.ToBigInt(pdu.Value).Uint64())
+	case gosnmp.OctetString, gosnmp.Opaque:
+		switch v := pdu.Value.(type) {
+		case []byte:
+			content = v
+		case string:
+			content = []byte(v)
+		default:
+			return nil, fmt.Errorf("invalid octet string")
+		}
+	case gosnmp.ObjectIdentifier:
+		s, _ := pdu.Value.(string)
+		arcs, err := ParseOid(s)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = berOid(arcs); err != nil {
+			return nil, err
+		}
+	case gosnmp.IPAddress:
+		s, _ := pdu.Value.(string)
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address")
+		}
+		content = []byte(ip)
+	default:
+		return nil, fmt.Errorf("unsupported value type %#x", byte(pdu.Type))
+	}
+	return berTLV(byte(pdu.Type), content), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats - registry of internal counters and gauges
+//
+// Counters are incremented by the instrumented code; gauges are sampled
+// from a function when a snapshot is taken. Ratios are derived from two
+// counters, e.g. hits and misses of a cache.
+type Stats struct {
+	mu       sync.RWMutex
+	counters map[string]*int64
+	gauges   map[string]func() float64
+	ratios   map[string][2]string
+}
+
+// stats - registry used by all instrumented components
+var stats = NewStats()
+
+// NewStats - empty registry
+func NewStats() *Stats {
+	return &Stats{
+		counters: map[string]*int64{},
+		gauges:   map[string]func() float64{},
+		ratios:   map[string][2]string{},
+	}
+}
+
+func (s *Stats) counter(name string) *int64 {
+	s.mu.RLock()
+	c, ok := s.counters[name]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok = s.counters[name]; !ok {
+		c = new(int64)
+		s.counters[name] = c
+	}
+	return c
+}
+
+// Add - add delta to counter, negative deltas track in-use values
+func (s *Stats) Add(name string, delta int64) {
+	atomic.AddInt64(s.counter(name), delta)
+}
+
+// Inc - increment counter by one
+func (s *Stats) Inc(name string) {
+	s.Add(name, 1)
+}
+
+// Gauge - register gauge sampled from f
+func (s *Stats) Gauge(name string, f func() float64) {
+	s.mu.Lock()
+	s.gauges[name] = f
+	s.mu.Unlock()
+}
+
+// Ratio - register ratio hits/(hits+misses) of two counters
+func (s *Stats) Ratio(name, hits, misses string) {
+	s.counter(hits)
+	s.counter(misses)
+	s.mu.Lock()
+	s.ratios[name] = [2]string{hits, misses}
+	s.mu.Unlock()
+}
+
+// StatsSnapshot - values of all registered stats
+type StatsSnapshot struct {
+	Counters map[string]int64   `json:"counters"`
+	Gauges   map[string]float64 `json:"gauges"`
+	Ratios   map[string]float64 `json:"ratios"`
+}
+
+// Snapshot - current value of every counter, gauge and ratio
+func (s *Stats) Snapshot() StatsSnapshot {
+	snapshot := StatsSnapshot{
+		Counters: map[string]int64{},
+		Gauges:   map[string]float64{},
+		Ratios:   map[string]float64{},
+	}
+
+	s.mu.RLock()
+	gauges := make(map[string]func() float64, len(s.gauges))
+	for name, c := range s.counters {
+		snapshot.Counters[name] = atomic.LoadInt64(c)
+	}
+	for name, f := range s.gauges {
+		gauges[name] = f
+	}
+	for name, pair := range s.ratios {
+		hits, misses := snapshot.Counters[pair[0]], snapshot.Counters[pair[1]]
+		if hits+misses > 0 {
+			snapshot.Ratios[name] = float64(hits) / float64(hits+misses)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Gauges may take locks of their own components
+	for name, f := range gauges {
+		snapshot.Gauges[name] = f()
+	}
+	return snapshot
+}
+
+// StatsHandler - snapshot of internal stats
+func (s *Stats) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Snapshot())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/soniah/gosnmp"
+)
+
+// StreamError - last line of a stream whose walk failed
+type StreamError struct {
+	Error string `json:"error"`
+}
+
+// streamVariable - single pdu rendered as formatVariables renders lists
+func streamVariable(o FormatOptions, pdu gosnmp.SnmpPDU) interface{} {
+	pdus := []gosnmp.SnmpPDU{pdu}
+	if o.Output == FormatStructured {
+		return o.Varbinds(pdus)[0]
+	}
+	sanitized := SanitizeResultVariables(&pdus)
+	if !o.custom {
+		return sanitized[0]
+	}
+	return o.Format(sanitized)[0]
+}
+
+// StreamWalk - walk rootOid writing each varbind as a json line when received
+//
+// The response is newline delimited json, flushed after every varbind so
+// clients can process results while the walk runs. Status and headers are
+// sent before the walk starts; a failure is reported as a final line with
+// an error member. The walk stops when the client goes away. A scoped walk
+// that stops early ends with a WalkCursor line.
+func StreamWalk(w http.ResponseWriter, r *http.Request, g *gosnmp.GoSNMP, rootOid string, bulk bool, scope WalkScope) {
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	done := r.Context().Done()
+
+	stats.Inc("walk.streams")
+	walkFn := func(pdu gosnmp.SnmpPDU) error {
+		select {
+		case <-done:
+			return r.Context().Err()
+		default:
+		}
+		if err := encoder.Encode(streamVariable(o, pdu)); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	if scope.Scoped() {
+		var cursor WalkCursor
+		if cursor, err = ScopedWalk(g, rootOid, bulk, int(g.MaxRepetitions), scope, walkFn); err == nil && cursor.Truncated != "" {
+			if err := encoder.Encode(cursor); err != nil {
+				RequestLogger(r).Error("writing response", Fields{"err": err})
+			}
+		}
+	} else {
+		err = ObservedWalk(g, rootOid, bulk, walkFn)
+	}
+	if err != nil {
+		RequestLogger(r).Error("streaming walk", Fields{"target": SessionTarget(g), "oid": rootOid, "err": err})
+		if err := encoder.Encode(StreamError{Error: err.Error()}); err != nil {
+			RequestLogger(r).Error("writing response", Fields{"err": err})
+		}
+	}
+}
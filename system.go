@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/soniah/gosnmp"
+)
+
+// systemOids - scalars of the MIB-2 system group, in SystemInfo order
+var systemOids = []string{
+	".1.3.6.1.2.1.1.1.0",
+	".1.3.6.1.2.1.1.2.0",
+	".1.3.6.1.2.1.1.3.0",
+	".1.3.6.1.2.1.1.4.0",
+	".1.3.6.1.2.1.1.5.0",
+	".1.3.6.1.2.1.1.6.0",
+	".1.3.6.1.2.1.1.7.0",
+}
+
+// enterprisesOid - prefix of the private enterprise numbers
+const enterprisesOid = ".1.3.6.1.4.1."
+
+// enterpriseNumbers - IANA private enterprise numbers of common vendors
+var enterpriseNumbers = map[int]string{
+	2:     "IBM",
+	9:     "Cisco",
+	11:    "Hewlett-Packard",
+	23:    "Novell",
+	43:    "3Com",
+	42:    "Sun Microsystems",
+	171:   "D-Link",
+	207:   "Allied Telesis",
+	232:   "Compaq",
+	311:   "Microsoft",
+	318:   "APC",
+	674:   "Dell",
+	789:   "NetApp",
+	1588:  "Brocade",
+	1916:  "Extreme Networks",
+	1991:  "Foundry Networks",
+	2011:  "Huawei",
+	2021:  "UC Davis (net-snmp)",
+	2272:  "Nortel",
+	2636:  "Juniper Networks",
+	3076:  "Cisco (Altiga)",
+	3375:  "F5 Networks",
+	3902:  "ZTE",
+	4526:  "Netgear",
+	4874:  "Juniper Networks (Unisphere)",
+	5951:  "Citrix",
+	6027:  "Force10 Networks",
+	6486:  "Alcatel-Lucent",
+	6876:  "VMware",
+	8072:  "net-snmp",
+	9148:  "Acme Packet",
+	11863: "TP-Link",
+	12356: "Fortinet",
+	14179: "Cisco (Airespace)",
+	14823: "Aruba Networks",
+	14988: "MikroTik",
+	17163: "Riverbed",
+	25461: "Palo Alto Networks",
+	25506: "H3C",
+	30065: "Arista Networks",
+	41112: "Ubiquiti",
+}
+
+// SystemInfo - system group of a target
+//
+// Fields the agent does not implement are left empty.
+type SystemInfo struct {
+	Descr         string  `json:"descr"`
+	ObjectID      string  `json:"object_id"`
+	Vendor        string  `json:"vendor,omitempty"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Contact       string  `json:"contact"`
+	Name          string  `json:"name"`
+	Location      string  `json:"location"`
+	Services      int64   `json:"services"`
+}
+
+// EnterpriseVendor - vendor owning the enterprise arc of sysObjectID, empty if unknown
+func EnterpriseVendor(objectID string) string {
+	oid := normalizeOid(objectID)
+	if !strings.HasPrefix(oid, enterprisesOid) {
+		return ""
+	}
+	number, err := strconv.Atoi(strings.SplitN(strings.TrimPrefix(oid, enterprisesOid), ".", 2)[0])
+	if err != nil {
+		return ""
+	}
+	return enterpriseNumbers[number]
+}
+
+// ReadSystemInfo - system group of g in a single GET
+func ReadSystemInfo(g *gosnmp.GoSNMP, vendor bool) (*SystemInfo, error) {
+	pdus, failed, err := GetPartial(g, systemOids)
+	if err != nil {
+		return nil, err
+	}
+	info := &SystemInfo{}
+	for i, pdu := range pdus {
+		if _, ok := failed[i]; ok || !cacheable(pdu) || pdu.Value == nil {
+			continue
+		}
+		switch i {
+		case 0:
+			info.Descr = octetString(pdu.Value)
+		case 1:
+			info.ObjectID, _ = pdu.Value.(string)
+		case 2:
+			// sysUpTime counts hundredths of a second
+			info.UptimeSeconds = float64(pduUint(pdu)) / 100
+		case 3:
+			info.Contact = octetString(pdu.Value)
+		case 4:
+			info.Name = octetString(pdu.Value)
+		case 5:
+			info.Location = octetString(pdu.Value)
+		case 6:
+			info.Services = pduInt(pdu)
+		}
+	}
+	if vendor {
+		info.Vendor = EnterpriseVendor(info.ObjectID)
+	}
+	return info, nil
+}
+
+// SystemHandler - sysDescr, sysObjectID, sysUpTime, sysContact, sysName,
+// sysLocation and sysServices as named fields
+//
+// With ?vendor=true sysObjectID is mapped to the vendor owning its
+// enterprise number.
+func SystemHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	info, err := ReadSystemInfo(g, r.URL.Query().Get("vendor") == "true")
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// TableHandler - walk a table and return its rows keyed by index
+func TableHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	rootOid, err := ResolveOid(mux.Vars(r)["base_oid"])
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxReps, _, err := BulkParams(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bulk := g.Version != gosnmp.Version1 && r.URL.Query().Get("bulk") != "false"
+	result, err := walkAll(g, rootOid, bulk, maxReps)
+	if err != nil {
+		WriteSnmpError(w, err)
+		return
+	}
+	RenderTable(w, r, rootOid, result)
+}
+
+// RenderTable - write walked pdus as {"<index>": {"<column>": value}}
+func RenderTable(w http.ResponseWriter, r *http.Request, rootOid string, pdus []gosnmp.SnmpPDU) {
+	o, err := ParseFormatOptions(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, TableRows(o, rootOid, SanitizeResultVariables(&pdus)))
+}
+
+// TableRows - varbinds of a table grouped by row index
+//
+// rootOid is the table or its entry. Columns are named after the MIB
+// object when known and numbered otherwise; values are rendered
+// according to o.
+func TableRows(o FormatOptions, rootOid string, pdus []gosnmp.SnmpPDU) map[string]map[string]interface{} {
+	entry := tableEntry(rootOid, pdus)
+
+	rows := map[string]map[string]interface{}{}
+	for _, pdu := range pdus {
+		suffix, ok := instanceSuffix(entry, pdu.Name)
+		if !ok {
+			continue
+		}
+		i := strings.Index(suffix, ".")
+		if i <= 0 {
+			continue
+		}
+		column, index := suffix[:i], suffix[i+1:]
+
+		row, ok := rows[index]
+		if !ok {
+			row = map[string]interface{}{}
+			rows[index] = row
+		}
+		row[columnName(entry, column)] = o.FormatValue(pdu)
+	}
+	return rows
+}
+
+// tableEntry - oid of the entry of a table given as table or entry
+//
+// The MIB name decides if known; otherwise rootOid is taken as the table
+// when every varbind lies below rootOid.1 with column and index.
+func tableEntry(rootOid string, pdus []gosnmp.SnmpPDU) string {
+	rootOid = "." + strings.Trim(rootOid, ".")
+	symbol, _ := TranslateOid(rootOid)
+	switch {
+	case strings.HasSuffix(symbol, "Entry"):
+		return rootOid
+	case strings.HasSuffix(symbol, "Table"):
+		return rootOid + ".1"
+	}
+
+	for _, pdu := range pdus {
+		suffix, ok := instanceSuffix(rootOid, pdu.Name)
+		if !ok || !strings.HasPrefix(suffix, "1.") || strings.Count(suffix, ".") < 2 {
+			return rootOid
+		}
+	}
+	return rootOid + ".1"
+}
+
+// columnName - MIB name of column of entry, its number if unknown
+func columnName(entry string, column string) string {
+	symbol, _ := TranslateOid(entry + "." + column)
+	if i := strings.Index(symbol, "::"); i >= 0 {
+		symbol = symbol[i+2:]
+	}
+	if strings.Contains(symbol, ".") {
+		return column
+	}
+	return symbol
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Snmp transports
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+)
+
+// TargetAddress - host, port and transport of an snmp agent
+type TargetAddress struct {
+	Host      string
+	Port      uint16
+	Transport string
+}
+
+// ParseTarget - address of target
+//
+// Accepted forms are host, host:port, a bare or bracketed IPv6 literal,
+// [v6]:port, each optionally prefixed by udp:// or tcp://. The port
+// defaults to 161 and the transport to udp.
+func ParseTarget(target string) (TargetAddress, error) {
+	addr := TargetAddress{Port: gosnmp.Default.Port, Transport: TransportUDP}
+	for _, transport := range []string{TransportUDP, TransportTCP} {
+		if strings.HasPrefix(target, transport+"://") {
+			addr.Transport = transport
+			target = strings.TrimPrefix(target, transport+"://")
+		}
+	}
+
+	host, port := target, ""
+	switch {
+	case strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]"):
+		host = target[1 : len(target)-1]
+	case strings.HasPrefix(target, "[") || strings.Count(target, ":") == 1:
+		var err error
+		if host, port, err = net.SplitHostPort(target); err != nil {
+			return addr, fmt.Errorf("invalid target %s", target)
+		}
+	}
+	if host == "" || strings.ContainsAny(host, "[]/") {
+		return addr, fmt.Errorf("invalid target %s", target)
+	}
+	if (strings.HasPrefix(target, "[") || strings.Contains(host, ":")) && (net.ParseIP(host) == nil || !strings.Contains(host, ":")) {
+		return addr, fmt.Errorf("invalid IPv6 address %s", host)
+	}
+	addr.Host = host
+	if port != "" {
+		n, err := parsePort(port)
+		if err != nil {
+			return addr, err
+		}
+		addr.Port = n
+	}
+	return addr, nil
+}
+
+func parsePort(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("port must be between 1 and 65535")
+	}
+	return uint16(n), nil
+}
+
+// String - canonical target, the bare host for udp on port 161
+//
+// The canonical target keys sessions, caches, limits and the journal, so
+// that agents on the same host but another port or transport are kept
+// apart.
+func (a TargetAddress) String() string {
+	if a.Transport == TransportUDP && a.Port == gosnmp.Default.Port {
+		return a.Host
+	}
+	s := net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+	if a.Transport == TransportTCP {
+		return TransportTCP + "://" + s
+	}
+	return s
+}
+
+// TargetHost - host of target, target itself if it does not parse
+func TargetHost(target string) string {
+	if addr, err := ParseTarget(target); err == nil {
+		return addr.Host
+	}
+	return target
+}
+
+// SessionTarget - canonical target of connected session g
+//
+// gosnmp dials and reconnects to g.Target, which therefore holds the bare
+// host; port and transport are kept in their own fields.
+func SessionTarget(g *gosnmp.GoSNMP) string {
+	return TargetAddress{Host: g.Target, Port: g.Port, Transport: g.Transport}.String()
+}
+
+// RequestTarget - canonical target of request
+//
+// The target path segment may carry a port, which the port query
+// parameter or X-SNMP-Port header overrides; transport is read from the
+// transport parameter or X-SNMP-Transport header.
+func RequestTarget(r *http.Request) (string, error) {
+	addr, err := ParseTarget(resolveSimulator(mux.Vars(r)["target"]))
+	if err != nil {
+		return "", err
+	}
+	if v := requestOption(r, "port", "X-SNMP-Port"); v != "" {
+		if addr.Port, err = parsePort(v); err != nil {
+			return "", err
+		}
+	}
+	switch v := requestOption(r, "transport", "X-SNMP-Transport"); v {
+	case "":
+	case TransportUDP, TransportTCP:
+		addr.Transport = v
+	default:
+		return "", fmt.Errorf("transport must be udp or tcp")
+	}
+	return addr.String(), nil
+}
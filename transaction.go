@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Transaction step actions
+const (
+	TxSet    = "set"
+	TxCreate = "create"
+	TxDelete = "delete"
+)
+
+// Transaction outcomes and rollback outcomes of its steps
+const (
+	TxCommitted          = "committed"
+	TxRolledBack         = "rolled_back"
+	TxRollbackIncomplete = "rollback_incomplete"
+	StepRollbackFailed   = "rollback_failed"
+)
+
+// TransactionStep - one write of a transaction
+//
+// set writes values or varbinds, or the columns of row index of a defined
+// table; create and delete add and destroy a row of a defined table.
+type TransactionStep struct {
+	Action   string                 `json:"action"`
+	Table    string                 `json:"table,omitempty"`
+	Index    string                 `json:"index,omitempty"`
+	Columns  map[string]interface{} `json:"columns,omitempty"`
+	Values   [][]interface{}        `json:"values,omitempty"`
+	Varbinds []SetVarbind           `json:"varbinds,omitempty"`
+}
+
+// TransactionRequest - ordered steps applied as one logical operation
+type TransactionRequest struct {
+	Steps []TransactionStep `json:"steps"`
+}
+
+// TransactionStepResult - outcome of a step and of its rollback
+type TransactionStepResult struct {
+	Step          int    `json:"step"`
+	Action        string `json:"action"`
+	Status        string `json:"status"`
+	Error         string `json:"error,omitempty"`
+	Rollback      string `json:"rollback,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+// TransactionResult - outcome of a transaction
+type TransactionResult struct {
+	Status string                  `json:"status"`
+	Steps  []TransactionStepResult `json:"steps"`
+}
+
+// txStep - validated step, pdus are the varbinds it writes
+type txStep struct {
+	action string
+	table  *TableDef
+	entry  string
+	index  string
+	pdus   []gosnmp.SnmpPDU
+}
+
+// prepareStep - validate step and build its varbinds without touching the device
+func prepareStep(step TransactionStep) (txStep, error) {
+	s := txStep{action: step.Action, index: step.Index}
+	switch step.Action {
+	case TxSet, TxCreate, TxDelete:
+	default:
+		return s, fmt.Errorf("action must be set, create or delete")
+	}
+
+	if step.Table == "" {
+		if step.Action != TxSet {
+			return s, fmt.Errorf("table missing")
+		}
+		pdus, err := ValuesToPDUs(append(step.Values, VarbindValues(step.Varbinds)...))
+		s.pdus = pdus
+		return s, err
+	}
+
+	t, ok := tables.Get(step.Table)
+	if !ok {
+		return s, fmt.Errorf("table %s not found", step.Table)
+	}
+	entry, err := t.validate()
+	if err != nil {
+		return s, fmt.Errorf("table %s: %v", t.Name, err)
+	}
+	if _, err := ParseOid(step.Index); err != nil || step.Index == "" {
+		return s, fmt.Errorf("invalid index %s", step.Index)
+	}
+	s.table, s.entry = t, entry
+
+	switch step.Action {
+	case TxSet:
+		if len(step.Columns) == 0 {
+			return s, fmt.Errorf("columns missing")
+		}
+		s.pdus, err = columnPDUs(t, entry, step.Index, step.Columns)
+	case TxCreate:
+		for _, c := range t.Columns {
+			if _, ok := step.Columns[c.Name]; c.Required && !ok {
+				return s, fmt.Errorf("column %s required", c.Name)
+			}
+		}
+		s.pdus, err = columnPDUs(t, entry, step.Index, step.Columns)
+		s.pdus = append([]gosnmp.SnmpPDU{rowStatusPDU(t, entry, step.Index, RowStatusCreateAndGo)}, s.pdus...)
+	case TxDelete:
+		s.pdus = []gosnmp.SnmpPDU{rowStatusPDU(t, entry, step.Index, RowStatusDestroy)}
+	}
+	return s, err
+}
+
+// readPrior - current values of oids as settable varbinds and the oids without value
+func readPrior(g *gosnmp.GoSNMP, oids []string) ([]gosnmp.SnmpPDU, []string, error) {
+	pdus, failed, err := GetPartial(g, oids)
+	if err != nil {
+		return nil, nil, err
+	}
+	var found []gosnmp.SnmpPDU
+	var missing []string
+	for i, pdu := range pdus {
+		if _, ok := failed[i]; ok || !cacheable(pdu) || pdu.Type == gosnmp.Null {
+			missing = append(missing, oids[i])
+			continue
+		}
+		found = append(found, pdu)
+	}
+	if len(found) == 0 {
+		return nil, missing, nil
+	}
+	prior, err := ValuesToPDUs(PDUsToValues(found))
+	return prior, missing, err
+}
+
+// run - apply step, returning how to undo it
+//
+// Prior values are read before writing: a set whose oids cannot all be
+// read is refused, a destroyed row is recreated from the columns that
+// could be read, in its prior state.
+func (s txStep) run(g *gosnmp.GoSNMP) (func() error, error) {
+	switch s.action {
+	case TxCreate, TxDelete:
+		existing, err := readRow(g, FormatOptions{}, s.table, s.entry, s.index)
+		if err != nil {
+			return nil, err
+		}
+		if s.action == TxCreate && existing != nil {
+			return nil, fmt.Errorf("row %s already exists", s.index)
+		}
+		if s.action == TxDelete && existing == nil {
+			return nil, fmt.Errorf("row %s does not exist", s.index)
+		}
+		if s.action == TxCreate {
+			if err := setRow(g, "transaction:create", s.pdus); err != nil {
+				return nil, err
+			}
+			return func() error {
+				return setRow(g, "rollback:create", []gosnmp.SnmpPDU{rowStatusPDU(s.table, s.entry, s.index, RowStatusDestroy)})
+			}, nil
+		}
+
+		oids := make([]string, len(s.table.Columns))
+		for i, c := range s.table.Columns {
+			oids[i] = fmt.Sprintf("%s.%d.%s", s.entry, c.Column, s.index)
+		}
+		columns, _, err := readPrior(g, oids)
+		if err != nil {
+			return nil, err
+		}
+		active := existing.RowStatus == rowStatusNames[RowStatusActive]
+		if err := setRow(g, "transaction:delete", s.pdus); err != nil {
+			return nil, err
+		}
+		return func() error {
+			if err := setRow(g, "rollback:delete", []gosnmp.SnmpPDU{rowStatusPDU(s.table, s.entry, s.index, RowStatusCreateAndWait)}); err != nil {
+				return err
+			}
+			if len(columns) > 0 {
+				if err := setRow(g, "rollback:delete", columns); err != nil {
+					return err
+				}
+			}
+			if !active {
+				return nil
+			}
+			return setRow(g, "rollback:delete", []gosnmp.SnmpPDU{rowStatusPDU(s.table, s.entry, s.index, RowStatusActive)})
+		}, nil
+	}
+
+	oids := make([]string, len(s.pdus))
+	for i, pdu := range s.pdus {
+		oids[i] = pdu.Name
+	}
+	prior, missing, err := readPrior(g, oids)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no prior value of %s to roll back to", missing[0])
+	}
+	if err := setRow(g, "transaction:set", s.pdus); err != nil {
+		return nil, err
+	}
+	return func() error { return setRow(g, "rollback:set", prior) }, nil
+}
+
+// TransactionHandler - ordered set, create and delete steps with rollback
+//
+// Every step is validated before the first write. Steps run in order and
+// stop at the first failure; steps already applied are then undone in
+// reverse order, best effort, from the values recorded before each step.
+// Responds 200 if every step was applied, 207 otherwise.
+func TransactionHandler(w http.ResponseWriter, r *http.Request) {
+	g := r.Context().Value(SNMPKeyName).(*gosnmp.GoSNMP)
+
+	request := TransactionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid transaction json")
+		return
+	}
+	if len(request.Steps) == 0 {
+		WriteError(w, http.StatusBadRequest, "Nothing to set")
+		return
+	}
+
+	limits := LimitsForTarget(SessionTarget(g))
+	steps := make([]txStep, len(request.Steps))
+	for i, step := range request.Steps {
+		s, err := prepareStep(step)
+		if err == nil {
+			err = limits.Check(g, gosnmp.SetRequest, s.pdus)
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("step %d: %v", i, err))
+			return
+		}
+		steps[i] = s
+	}
+
+	result := RunTransaction(g, steps)
+	status := http.StatusOK
+	if result.Status != TxCommitted {
+		status = http.StatusMultiStatus
+	}
+	WriteJSON(w, status, result)
+}
+
+// RunTransaction - apply steps in order, undoing applied steps after a failure
+func RunTransaction(g *gosnmp.GoSNMP, steps []txStep) TransactionResult {
+	result := TransactionResult{Status: TxCommitted, Steps: make([]TransactionStepResult, len(steps))}
+	undo := make([]func() error, 0, len(steps))
+	failed := false
+	for i, s := range steps {
+		result.Steps[i] = TransactionStepResult{Step: i, Action: s.action, Status: StepSkipped}
+		if failed {
+			continue
+		}
+		rollback, err := s.run(g)
+		if err != nil {
+			result.Steps[i].Status = StepFailed
+			result.Steps[i].Error = err.Error()
+			failed = true
+			continue
+		}
+		result.Steps[i].Status = StepOK
+		undo = append(undo, rollback)
+	}
+	if !failed {
+		stats.Inc("transactions.committed")
+		return result
+	}
+
+	result.Status = TxRolledBack
+	for i := len(undo) - 1; i >= 0; i-- {
+		if err := undo[i](); err != nil {
+			result.Steps[i].Rollback = StepRollbackFailed
+			result.Steps[i].RollbackError = err.Error()
+			result.Status = TxRollbackIncomplete
+			continue
+		}
+		result.Steps[i].Rollback = TxRolledBack
+	}
+	stats.Inc("transactions." + result.Status)
+	return result
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// Transform - named step of the result pipeline applied to every rendered varbind
+//
+// Oids restricts it to varbinds at or below these prefixes, names
+// accepted, and Types to varbinds of these types, e.g. TimeTicks. At most
+// one of Scale, Enum, Regex and Expr is the step:
+//
+//	scale  multiplies numeric values, e.g. 0.01 for hundredths to seconds
+//	enum   replaces integers by their label from the MIB, see LoadMibDir
+//	regex  replaces text by its first group, or a map of the named groups;
+//	       text that does not match is kept
+//	expr   replaces the value by an expression, see exprParser, over value,
+//	       raw, oid, symbol and type
+//
+// Unit annotates the varbinds the transform applies to, alone or next to
+// a step.
+type Transform struct {
+	Name  string   `json:"name"`
+	Oids  []string `json:"oids,omitempty"`
+	Types []string `json:"types,omitempty"`
+	Scale float64  `json:"scale,omitempty"`
+	Enum  bool     `json:"enum,omitempty"`
+	Regex string   `json:"regex,omitempty"`
+	Expr  string   `json:"expr,omitempty"`
+	Unit  string   `json:"unit,omitempty"`
+
+	prefixes []string
+	regex    *regexp.Regexp
+	program  exprNode
+}
+
+// builtinTransforms - transforms available without configuration
+var builtinTransforms = []Transform{
+	{Name: "seconds", Types: []string{"TimeTicks"}, Scale: 0.01, Unit: "s"},
+	{Name: "enum", Enum: true},
+	{Name: "sysdescr_version", Oids: []string{"sysDescr"}, Regex: `(?i)version:?\s+([^\s,]+)`},
+}
+
+// transformTypes - varbind types transforms may be restricted to
+var transformTypes = func() map[string]bool {
+	types := map[string]bool{}
+	for _, t := range []gosnmp.Asn1BER{gosnmp.Integer, gosnmp.OctetString, gosnmp.ObjectIdentifier, gosnmp.IPAddress,
+		gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Opaque, gosnmp.Counter64, gosnmp.Uinteger32, gosnmp.BitString} {
+		types[t.String()] = true
+	}
+	return types
+}()
+
+// validate - check the step and compile oids, regex and expression
+func (t *Transform) validate() error {
+	if t.Name == "" || strings.ContainsAny(t.Name, ", ") || t.Name == "none" {
+		return fmt.Errorf("invalid transform name %q", t.Name)
+	}
+	steps := 0
+	for _, set := range []bool{t.Scale != 0, t.Enum, t.Regex != "", t.Expr != ""} {
+		if set {
+			steps++
+		}
+	}
+	if steps > 1 || (steps == 0 && t.Unit == "") {
+		return fmt.Errorf("transform %s: exactly one of scale, enum, regex and expr, or a unit alone, required", t.Name)
+	}
+	for _, name := range t.Types {
+		if !transformTypes[name] {
+			return fmt.Errorf("transform %s: unknown type %s", t.Name, name)
+		}
+	}
+
+	t.prefixes = make([]string, len(t.Oids))
+	for i, oid := range t.Oids {
+		resolved, err := ResolveOid(oid)
+		if err != nil {
+			return fmt.Errorf("transform %s: %v", t.Name, err)
+		}
+		t.prefixes[i] = normalizeOid(resolved)
+	}
+	var err error
+	if t.Regex != "" {
+		if t.regex, err = regexp.Compile(t.Regex); err != nil {
+			return fmt.Errorf("transform %s: %v", t.Name, err)
+		}
+	}
+	if t.Expr != "" {
+		if t.program, err = CompileExpr(t.Expr); err != nil {
+			return fmt.Errorf("transform %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// applies - whether t transforms pdu, never varbinds without value
+func (t *Transform) applies(pdu gosnmp.SnmpPDU) bool {
+	if pdu.Type == gosnmp.Null || varbindExceptions[pdu.Type] != "" {
+		return false
+	}
+	if len(t.Types) > 0 {
+		matched := false
+		for _, name := range t.Types {
+			if pdu.Type.String() == name {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(t.prefixes) == 0 {
+		return true
+	}
+	oid := normalizeOid(pdu.Name)
+	for _, prefix := range t.prefixes {
+		if oid == prefix || strings.HasPrefix(oid, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// numericValue - value of pdu as float64 for the integer types
+func numericValue(pdu gosnmp.SnmpPDU) (float64, bool) {
+	switch pdu.Type {
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32:
+		f, _ := exprNumber(gosnmp.ToBigInt(pdu.Value))
+		return f, true
+	}
+	return 0, false
+}
+
+// Apply - value rendered for pdu after the step of t
+//
+// Scaling works on the wire value, so it is independent of ?counters and
+// ?timeticks; the other steps see value as rendered so far. Varbinds t
+// does not apply to, and expressions that fail, keep value.
+func (t *Transform) Apply(pdu gosnmp.SnmpPDU, value interface{}) interface{} {
+	if !t.applies(pdu) {
+		return value
+	}
+	switch {
+	case t.Scale != 0:
+		if x, ok := numericValue(pdu); ok {
+			return x * t.Scale
+		}
+	case t.Enum:
+		_, object := TranslateOid(pdu.Name)
+		if n, ok := pdu.Value.(int); ok && object != nil {
+			if label, ok := object.Enums[n]; ok {
+				return label
+			}
+		}
+	case t.regex != nil:
+		m := t.regex.FindStringSubmatch(exprText(value))
+		if m == nil {
+			return value
+		}
+		groups := map[string]string{}
+		for i, name := range t.regex.SubexpNames() {
+			if name != "" {
+				groups[name] = m[i]
+			}
+		}
+		switch {
+		case len(groups) > 0:
+			return groups
+		case len(m) > 1:
+			return m[1]
+		}
+		return m[0]
+	case t.program != nil:
+		raw := pdu.Value
+		if x, ok := numericValue(pdu); ok {
+			raw = x
+		} else if pdu.Type == gosnmp.OctetString {
+			raw = octetString(pdu.Value)
+		}
+		symbol, _ := TranslateOid(pdu.Name)
+		result, err := t.program(map[string]interface{}{
+			"value":  value,
+			"raw":    raw,
+			"oid":    normalizeOid(pdu.Name),
+			"symbol": symbol,
+			"type":   pdu.Type.String(),
+		})
+		if err != nil {
+			stats.Inc("transform.errors")
+			logger.Debug("transform failed", Fields{"transform": t.Name, "oid": pdu.Name, "err": err})
+			return value
+		}
+		return result
+	}
+	return value
+}
+
+// TransformPipeline - transforms applied in order to every rendered varbind
+type TransformPipeline []*Transform
+
+// Apply - value rendered for pdu after every transform
+func (p TransformPipeline) Apply(pdu gosnmp.SnmpPDU, value interface{}) interface{} {
+	for _, t := range p {
+		value = t.Apply(pdu, value)
+	}
+	return value
+}
+
+// Unit - unit of pdu, from the last transform applying to it with one
+func (p TransformPipeline) Unit(pdu gosnmp.SnmpPDU) string {
+	unit := ""
+	for _, t := range p {
+		if t.Unit != "" && t.applies(pdu) {
+			unit = t.Unit
+		}
+	}
+	return unit
+}
+
+// TransformConfig - user defined transforms and the ones routes apply by default
+//
+// Routes are keyed by route template, e.g.
+// /api/v1/snmp/{snmp_version}/{target}/system.
+type TransformConfig struct {
+	Transforms []Transform         `json:"transforms"`
+	Routes     map[string][]string `json:"routes,omitempty"`
+}
+
+// TransformStore - builtin and user defined transforms, persisted to path if not empty
+type TransformStore struct {
+	mu     sync.RWMutex
+	path   string
+	config TransformConfig
+	byName map[string]*Transform
+}
+
+// transforms - result transforms of the gateway, loaded from -transforms
+var transforms = NewTransformStore("")
+
+// NewTransformStore - store with the builtin transforms only
+func NewTransformStore(path string) *TransformStore {
+	s := &TransformStore{path: path, config: TransformConfig{Transforms: []Transform{}}}
+	byName, err := s.index(s.config)
+	if err != nil {
+		panic(err)
+	}
+	s.byName = byName
+	return s
+}
+
+// index - transforms of config and the builtins by name, config validated
+func (s *TransformStore) index(config TransformConfig) (map[string]*Transform, error) {
+	byName := map[string]*Transform{}
+	for i := range builtinTransforms {
+		t := builtinTransforms[i]
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		byName[t.Name] = &t
+	}
+	for i := range config.Transforms {
+		t := &config.Transforms[i]
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := byName[t.Name]; ok {
+			return nil, fmt.Errorf("transform %s defined twice or builtin", t.Name)
+		}
+		byName[t.Name] = t
+	}
+	for route, names := range config.Routes {
+		if !strings.HasPrefix(route, "/api/v1/") {
+			return nil, fmt.Errorf("route %s: not an api route template", route)
+		}
+		for _, name := range names {
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("route %s: unknown transform %s", route, name)
+			}
+		}
+	}
+	return byName, nil
+}
+
+// LoadTransforms - transform store from json file, missing file leaves the builtins only
+func LoadTransforms(path string) (*TransformStore, error) {
+	s := NewTransformStore(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config TransformConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	byName, err := s.index(config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if config.Transforms == nil {
+		config.Transforms = []Transform{}
+	}
+	s.config, s.byName = config, byName
+	return s, nil
+}
+
+// save - persist configuration, caller holds the lock
+func (s *TransformStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+// Pipeline - transforms of request, the defaults of its route followed by ?transform=
+//
+// ?transform= takes comma separated names and may be repeated; none drops
+// the transforms named before it, route defaults included.
+func (s *TransformStore) Pipeline(r *http.Request) (TransformPipeline, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var names []string
+	if current := mux.CurrentRoute(r); current != nil {
+		if route, err := current.GetPathTemplate(); err == nil {
+			names = append(names, s.config.Routes[route]...)
+		}
+	}
+	for _, param := range r.URL.Query()["transform"] {
+		for _, name := range strings.Split(param, ",") {
+			switch name = strings.TrimSpace(name); name {
+			case "":
+			case "none":
+				names = nil
+			default:
+				names = append(names, name)
+			}
+		}
+	}
+
+	pipeline := make(TransformPipeline, 0, len(names))
+	for _, name := range names {
+		t, ok := s.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %s", name)
+		}
+		pipeline = append(pipeline, t)
+	}
+	return pipeline, nil
+}
+
+// GetTransformsHandler - user defined transforms and route defaults
+func (s *TransformStore) GetTransformsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	WriteJSON(w, http.StatusOK, s.config)
+}
+
+// PutTransformsHandler - replace the user defined transforms and route defaults
+func (s *TransformStore) PutTransformsHandler(w http.ResponseWriter, r *http.Request) {
+	var config TransformConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid transforms json")
+		return
+	}
+	byName, err := s.index(config)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if config.Transforms == nil {
+		config.Transforms = []Transform{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config, s.byName = config, byName
+	if err := s.save(); err != nil {
+		RequestLogger(r).Error("saving transforms", Fields{"err": err})
+	}
+	RequestLogger(r).Info("transforms changed", Fields{"transforms": len(config.Transforms), "routes": len(config.Routes), "by": RequestIdentity(r)})
+	WriteJSON(w, http.StatusOK, config)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// snmpTrapOid - snmpTrapOID.0, second varbind of every v2c trap
+const snmpTrapOid = ".1.3.6.1.6.3.1.1.4.1.0"
+
+// Notification kinds
+const (
+	NotificationTrapV1 = "trap-v1"
+	NotificationTrap   = "trap"
+	NotificationInform = "inform"
+)
+
+// Trap - decoded notification held in the trap buffer
+type Trap struct {
+	ID           string           `json:"id"`
+	Seq          uint64           `json:"seq"`
+	Received     time.Time        `json:"received"`
+	Source       string           `json:"source"`
+	Version      string           `json:"snmp_version"`
+	Kind         string           `json:"kind"`
+	TrapOid      string           `json:"trap_oid,omitempty"`
+	Enterprise   string           `json:"enterprise,omitempty"`
+	AgentAddress string           `json:"agent_address,omitempty"`
+	GenericTrap  int              `json:"generic_trap,omitempty"`
+	SpecificTrap int              `json:"specific_trap,omitempty"`
+	Uptime       uint             `json:"uptime,omitempty"`
+	Variables    []gosnmp.SnmpPDU `json:"variables"`
+}
+
+// TrapWebhook - url every received trap is POSTed to
+//
+// With trap_oid set only traps whose trap oid starts with it are sent.
+type TrapWebhook struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	TrapOid  string    `json:"trap_oid,omitempty"`
+	Delivery *Callback `json:"last_delivery,omitempty"`
+}
+
+// TrapReceiver - trap listener with ring buffer and webhook forwarding
+//
+// Informs are acknowledged with a response carrying the same varbinds.
+// Communities, if set, restrict the communities traps are accepted with.
+type TrapReceiver struct {
+	Communities []string
+
+	mu        sync.RWMutex
+	listening bool
+	buf       []Trap
+	next      int
+	seq       uint64
+	webhooks  map[string]*TrapWebhook
+}
+
+// NewTrapReceiver - receiver buffering the last size traps
+func NewTrapReceiver(size int) *TrapReceiver {
+	if size < 1 {
+		size = 1
+	}
+	return &TrapReceiver{buf: make([]Trap, 0, size), webhooks: map[string]*TrapWebhook{}}
+}
+
+// Listen - receive traps on udp addr until stop is closed
+//
+// gosnmp.TrapListener cannot answer informs, so the socket is read here
+// and decoding is left to gosnmp.
+func (t *TrapReceiver) Listen(addr string, stop <-chan struct{}) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	t.setListening(true)
+	defer t.setListening(false)
+	go func() {
+		<-stop
+		conn.Close()
+	}()
+
+	params := &gosnmp.GoSNMP{}
+	buf := make([]byte, 65535)
+	for {
+		n, remote, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-stop:
+				return nil
+			default:
+			}
+			logger.Error("reading trap", Fields{"err": err})
+			continue
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+		inform := markInform(msg)
+		packet := params.UnmarshalTrap(msg)
+		if packet == nil {
+			stats.Inc("traps.invalid")
+			continue
+		}
+		if !t.accepts(packet.Community) {
+			stats.Inc("traps.rejected")
+			continue
+		}
+
+		if inform {
+			if err := acknowledgeInform(conn, remote, packet); err != nil {
+				logger.Error("acknowledging inform", Fields{"remote": remote.String(), "err": err})
+			}
+		}
+		t.add(packet, remote, inform)
+	}
+}
+
+func (t *TrapReceiver) setListening(listening bool) {
+	t.mu.Lock()
+	t.listening = listening
+	t.mu.Unlock()
+}
+
+// Listening - whether the trap socket is bound
+func (t *TrapReceiver) Listening() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.listening
+}
+
+// markInform - rewrite v1/v2c InformRequest tag to SNMPv2Trap in place
+//
+// Both PDUs share the same layout but gosnmp only decodes the latter.
+func markInform(msg []byte) bool {
+	cursor := 0
+	// message sequence, version, community
+	for _, tag := range []byte{byte(gosnmp.Sequence), byte(gosnmp.Integer), byte(gosnmp.OctetString)} {
+		if cursor >= len(msg) || msg[cursor] != tag {
+			return false
+		}
+		length, header, ok := berLength(msg[cursor+1:])
+		if !ok {
+			return false
+		}
+		cursor += 1 + header
+		if tag != byte(gosnmp.Sequence) {
+			cursor += length
+		}
+	}
+	if cursor < len(msg) && msg[cursor] == byte(gosnmp.InformRequest) {
+		msg[cursor] = byte(gosnmp.SNMPv2Trap)
+		return true
+	}
+	return false
+}
+
+// berLength - value length and size of the length field
+func berLength(b []byte) (int, int, bool) {
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+	if b[0] < 0x80 {
+		return int(b[0]), 1, true
+	}
+	n := int(b[0] & 0x7f)
+	if n == 0 || n > 4 || len(b) < 1+n {
+		return 0, 0, false
+	}
+	length := 0
+	for _, c := range b[1 : 1+n] {
+		length = length<<8 | int(c)
+	}
+	return length, 1 + n, true
+}
+
+// acknowledgeInform - send response to inform with its request id and varbinds
+func acknowledgeInform(conn *net.UDPConn, remote *net.UDPAddr, packet *gosnmp.SnmpPacket) error {
+	response := *packet
+	response.PDUType = gosnmp.GetResponse
+	response.Error = gosnmp.NoError
+	response.ErrorIndex = 0
+
+	// Decoded 32 bit values are uint, gosnmp only encodes uint32
+	response.Variables = make([]gosnmp.SnmpPDU, len(packet.Variables))
+	for i, v := range packet.Variables {
+		switch v.Type {
+		case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+			if n, ok := v.Value.(uint); ok {
+				v.Value = uint32(n)
+			}
+		}
+		response.Variables[i] = v
+	}
+	msg, err := response.MarshalMsg()
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteToUDP(msg, remote)
+	return err
+}
+
+func (t *TrapReceiver) accepts(community string) bool {
+	if len(t.Communities) == 0 {
+		return true
+	}
+	for _, c := range t.Communities {
+		if c == community {
+			return true
+		}
+	}
+	return false
+}
+
+// add - decode packet into buffer and forward to webhooks
+func (t *TrapReceiver) add(packet *gosnmp.SnmpPacket, remote *net.UDPAddr, inform bool) {
+	trap := Trap{
+		ID:       NewID(),
+		Received: time.Now(),
+		Source:   remote.IP.String(),
+		Version:  VersionLabel(packet.Version),
+		Kind:     NotificationTrap,
+	}
+	if inform {
+		trap.Kind = NotificationInform
+	}
+	if packet.PDUType == gosnmp.Trap {
+		trap.Kind = NotificationTrapV1
+		trap.Enterprise = packet.Enterprise
+		trap.AgentAddress = packet.AgentAddress
+		trap.GenericTrap = packet.GenericTrap
+		trap.SpecificTrap = packet.SpecificTrap
+		trap.Uptime = packet.Timestamp
+	}
+	trap.Variables = SanitizeResultVariables(&packet.Variables)
+	if packet.PDUType != gosnmp.Trap {
+		for _, v := range trap.Variables {
+			if v.Name == snmpTrapOid {
+				trap.TrapOid, _ = v.Value.(string)
+			}
+		}
+	}
+	stats.Inc("traps.received")
+	metrics.Inc("traps_total", trap.Source, trap.Kind)
+
+	t.mu.Lock()
+	t.seq++
+	trap.Seq = t.seq
+	if len(t.buf) < cap(t.buf) {
+		t.buf = append(t.buf, trap)
+	} else {
+		t.buf[t.next] = trap
+		stats.Inc("traps.evicted")
+	}
+	t.next = (t.next + 1) % cap(t.buf)
+
+	for _, hook := range t.webhooks {
+		if hook.TrapOid != "" && !strings.HasPrefix(trap.TrapOid, hook.TrapOid) {
+			continue
+		}
+		hook := hook
+		NewCallback(hook.URL).Deliver(CallbackEvent{Event: "trap", ID: trap.ID, Time: trap.Received, Data: trap}, func(cb Callback) {
+			t.mu.Lock()
+			hook.Delivery = &cb
+			t.mu.Unlock()
+		})
+	}
+	t.mu.Unlock()
+	events.Publish(ChannelTraps, trap.Source, trap)
+}
+
+// Traps - buffered traps in receive order matching filter
+func (t *TrapReceiver) Traps(match func(Trap) bool) []Trap {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	traps := []Trap{}
+	start := 0
+	if len(t.buf) == cap(t.buf) {
+		start = t.next
+	}
+	for i := 0; i < len(t.buf); i++ {
+		trap := t.buf[(start+i)%len(t.buf)]
+		if match(trap) {
+			traps = append(traps, trap)
+		}
+	}
+	return traps
+}
+
+// ListTrapsHandler - buffered traps, filtered by source, trap_oid, since_seq and limit
+func (t *TrapReceiver) ListTrapsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var sinceSeq uint64
+	if v := q.Get("since_seq"); v != "" {
+		var err error
+		if sinceSeq, err = strconv.ParseUint(v, 10, 64); err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid since_seq")
+			return
+		}
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			WriteError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	traps := t.Traps(func(trap Trap) bool {
+		return trap.Seq > sinceSeq &&
+			(q.Get("source") == "" || q.Get("source") == trap.Source) &&
+			(q.Get("trap_oid") == "" || strings.HasPrefix(trap.TrapOid, q.Get("trap_oid")))
+	})
+	if limit > 0 && len(traps) > limit {
+		traps = traps[len(traps)-limit:]
+	}
+	WriteJSON(w, http.StatusOK, traps)
+}
+
+// ListTrapWebhooksHandler - registered trap webhooks
+func (t *TrapReceiver) ListTrapWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	hooks := make([]TrapWebhook, 0, len(t.webhooks))
+	for _, hook := range t.webhooks {
+		hooks = append(hooks, *hook)
+	}
+	t.mu.RUnlock()
+	WriteJSON(w, http.StatusOK, hooks)
+}
+
+// CreateTrapWebhookHandler - register url receiving every trap
+func (t *TrapReceiver) CreateTrapWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook := &TrapWebhook{}
+	if err := json.NewDecoder(r.Body).Decode(hook); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid webhook json")
+		return
+	}
+	if err := ValidateCallbackURL(hook.URL); err != nil {
+		WriteError(w, http.StatusBadRequest, "url "+err.Error())
+		return
+	}
+	if hook.TrapOid != "" {
+		oid, err := ResolveOid(hook.TrapOid)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		hook.TrapOid = oid
+	}
+	hook.ID = NewID()
+	hook.Delivery = nil
+
+	t.mu.Lock()
+	t.webhooks[hook.ID] = hook
+	t.mu.Unlock()
+	WriteJSON(w, http.StatusCreated, hook)
+}
+
+// DeleteTrapWebhookHandler - remove trap webhook
+func (t *TrapReceiver) DeleteTrapWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	t.mu.Lock()
+	_, ok := t.webhooks[id]
+	delete(t.webhooks, id)
+	t.mu.Unlock()
+
+	if !ok {
+		WriteError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/soniah/gosnmp"
+)
+
+// validateVersions - snmp versions tried for every stored credential
+var validateVersions = []gosnmp.SnmpVersion{gosnmp.Version2c, gosnmp.Version1}
+
+// CredentialCheck - outcome of one credential and version against a target
+type CredentialCheck struct {
+	CredentialIndex int    `json:"credential_index"`
+	Version         string `json:"snmp_version"`
+	OK              bool   `json:"ok"`
+	SysObjectID     string `json:"sys_object_id,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// CredentialValidation - stored credentials of a target checked against it
+type CredentialValidation struct {
+	Target  string            `json:"target"`
+	Profile string            `json:"profile"`
+	Working int               `json:"working"`
+	Checks  []CredentialCheck `json:"checks"`
+}
+
+// ValidateCredentials - read sysObjectID.0 with every credential and version
+//
+// Credentials are reported by their index in the profile, never by value.
+func ValidateCredentials(target string, p *Profile) CredentialValidation {
+	validation := CredentialValidation{Target: target, Profile: p.Name, Checks: []CredentialCheck{}}
+	for i, community := range p.Communities {
+		for _, version := range validateVersions {
+			check := CredentialCheck{CredentialIndex: i, Version: VersionLabel(version)}
+			g, err := sessions.Get(target, version, community)
+			if err != nil {
+				check.Error = err.Error()
+				validation.Checks = append(validation.Checks, check)
+				continue
+			}
+			pdu, err := ProbeSession(g)
+			sessions.Put(g)
+			if err != nil {
+				check.Error = err.Error()
+			} else {
+				check.OK = true
+				if pdu.Type == gosnmp.ObjectIdentifier {
+					check.SysObjectID, _ = pdu.Value.(string)
+				}
+				validation.Working++
+			}
+			validation.Checks = append(validation.Checks, check)
+		}
+	}
+	return validation
+}
+
+// ValidateTargetHandler - check stored credentials of target, or registered target, against the device
+func ValidateTargetHandler(w http.ResponseWriter, r *http.Request) {
+	target := inventory.Resolve(mux.Vars(r)["name"])
+	if _, ok := AuthorizeTargets(r, []string{target}); !ok {
+		WriteError(w, http.StatusForbidden, "target not allowed: "+target)
+		return
+	}
+	p := profiles.ForTarget(target)
+	if p == nil || len(p.Communities) == 0 {
+		WriteError(w, http.StatusNotFound, "no stored credentials for "+target)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, ValidateCredentials(target, p))
+}
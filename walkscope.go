@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Reasons a scoped walk stopped before the end of its subtree
+const (
+	WalkMaxResults = "max_results"
+	WalkTimeBudget = "time_budget"
+)
+
+// WalkScope - guard rails of a walk
+//
+// MaxResults and TimeBudget stop the walk early, with a cursor to resume
+// it from; MaxDepth skips varbinds more than MaxDepth sub-identifiers
+// below the root. After is the cursor of a previous page. Zero values do
+// not limit the walk.
+type WalkScope struct {
+	MaxResults int
+	MaxDepth   int
+	TimeBudget time.Duration
+	After      string
+}
+
+// WalkCursor - where a scoped walk stopped, last line of streamed walks
+type WalkCursor struct {
+	Next      string `json:"next,omitempty"`
+	Truncated string `json:"truncated,omitempty"`
+}
+
+// ParseWalkScope - max_results, max_depth, time_budget and next of request walking rootOid
+func ParseWalkScope(r *http.Request, rootOid string) (WalkScope, error) {
+	q := r.URL.Query()
+	s := WalkScope{}
+	if v := q.Get("max_results"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return s, fmt.Errorf("max_results must be a positive number")
+		}
+		s.MaxResults = n
+	}
+	if v := q.Get("max_depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return s, fmt.Errorf("max_depth must be a positive number")
+		}
+		s.MaxDepth = n
+	}
+	if v := q.Get("time_budget"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return s, fmt.Errorf("time_budget must be a positive duration, e.g. 10s")
+		}
+		s.TimeBudget = d
+	}
+	if v := q.Get("next"); v != "" {
+		next := normalizeOid(v)
+		if _, err := ParseOid(next); err != nil || !strings.HasPrefix(next, normalizeOid(rootOid)+".") {
+			return s, fmt.Errorf("next must be an oid under %s", rootOid)
+		}
+		s.After = next
+	}
+	return s, nil
+}
+
+// Scoped - whether the walk is limited at all
+func (s WalkScope) Scoped() bool {
+	return s != WalkScope{}
+}
+
+// compareOids - -1, 0 or 1 as oid a sorts before, equal to or after b
+func compareOids(a []int, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// ScopedWalk - walk rootOid within scope, calling walkFn with every varbind
+//
+// The walk is driven by GETNEXT, or GETBULK of maxReps if bulk, from the
+// cursor of the scope. An agent returning an oid that does not increase
+// fails the walk instead of looping. Subtrees deeper than MaxDepth are
+// stepped over rather than read. The cursor is set if the walk stopped
+// early; passing its Next as ?next= resumes after the last oid read.
+func ScopedWalk(g *gosnmp.GoSNMP, rootOid string, bulk bool, maxReps int, scope WalkScope, walkFn gosnmp.WalkFunc) (WalkCursor, error) {
+	root := normalizeOid(rootOid)
+	rootIds, err := ParseOid(root)
+	if err != nil {
+		return WalkCursor{}, err
+	}
+	cursor := root
+	if scope.After != "" {
+		cursor = scope.After
+	}
+	cursorIds, _ := ParseOid(cursor)
+
+	var deadline time.Time
+	if scope.TimeBudget > 0 {
+		deadline = time.Now().Add(scope.TimeBudget)
+	}
+	results := 0
+	stats.Inc("walk.scoped")
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			stats.Inc("walk.scoped.truncated")
+			if cursor == root {
+				return WalkCursor{Truncated: WalkTimeBudget}, nil
+			}
+			return WalkCursor{Next: cursor, Truncated: WalkTimeBudget}, nil
+		}
+
+		var packet *gosnmp.SnmpPacket
+		if bulk {
+			packet, err = NegotiatedGetBulk(g, []string{cursor}, 0, maxReps)
+		} else {
+			packet, err = ObservedGetNext(g, []string{cursor})
+		}
+		if err != nil {
+			return WalkCursor{}, err
+		}
+		// v1 agents answer noSuchName past the last oid
+		if packet.Error == gosnmp.NoSuchName && g.Version == gosnmp.Version1 {
+			return WalkCursor{}, nil
+		}
+		if packet.Error != gosnmp.NoError {
+			return WalkCursor{}, fmt.Errorf("walk error: %v, at %s", packet.Error, cursor)
+		}
+		if len(packet.Variables) == 0 {
+			return WalkCursor{}, nil
+		}
+
+		for _, pdu := range packet.Variables {
+			name := normalizeOid(pdu.Name)
+			if pdu.Type == gosnmp.EndOfMibView || pdu.Type == gosnmp.NoSuchObject || pdu.Type == gosnmp.NoSuchInstance ||
+				!strings.HasPrefix(name, root+".") {
+				return WalkCursor{}, nil
+			}
+			ids, err := ParseOid(name)
+			if err != nil {
+				return WalkCursor{}, err
+			}
+			if compareOids(ids, cursorIds) <= 0 {
+				stats.Inc("walk.scoped.not_increasing")
+				return WalkCursor{}, fmt.Errorf("agent returned oid %s not increasing after %s", name, cursor)
+			}
+			cursor, cursorIds = name, ids
+
+			if scope.MaxDepth > 0 && len(ids)-len(rootIds) > scope.MaxDepth {
+				// Continue after the whole subtree at max depth
+				depth := len(rootIds) + scope.MaxDepth
+				cursorIds = append(append([]int(nil), ids[:depth]...), 1<<32-1)
+				cursor = formatOid(cursorIds)
+				break
+			}
+			if err := walkFn(pdu); err != nil {
+				return WalkCursor{}, err
+			}
+			results++
+			if scope.MaxResults > 0 && results >= scope.MaxResults {
+				stats.Inc("walk.scoped.truncated")
+				return WalkCursor{Next: name, Truncated: WalkMaxResults}, nil
+			}
+		}
+	}
+}
+
+// formatOid - dotted oid of sub-identifiers
+func formatOid(ids []int) string {
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString(".")
+		b.WriteString(strconv.Itoa(id))
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Callback delivery states
+const (
+	CallbackPending   = "pending"
+	CallbackDelivered = "delivered"
+	CallbackFailed    = "failed"
+)
+
+// callbackAttempts - deliveries tried before a callback is given up
+const callbackAttempts = 5
+
+// callbackClient - http client used for webhook deliveries
+var callbackClient = &http.Client{Timeout: 10 * time.Second}
+
+// Callback - webhook notified when an asynchronous operation completes
+type Callback struct {
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	Error       string     `json:"error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// CallbackEvent - body POSTed to a callback url
+type CallbackEvent struct {
+	Event string      `json:"event"`
+	ID    string      `json:"id"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// RequestCallbackURL - callback_url from query or top level of json body
+func RequestCallbackURL(r *http.Request, body []byte) (string, error) {
+	raw := r.URL.Query().Get("callback_url")
+	if raw == "" && len(body) > 0 {
+		fields := struct {
+			CallbackURL string `json:"callback_url"`
+		}{}
+		// Malformed bodies are left for the handler to report
+		_ = json.Unmarshal(body, &fields)
+		raw = fields.CallbackURL
+	}
+	if raw == "" {
+		return "", nil
+	}
+	if err := ValidateCallbackURL(raw); err != nil {
+		return "", fmt.Errorf("callback_url %v", err)
+	}
+	return raw, nil
+}
+
+// ValidateCallbackURL - error unless raw is an absolute http(s) url
+func ValidateCallbackURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https url")
+	}
+	return nil
+}
+
+// NewCallback - pending callback to url, nil without url
+func NewCallback(url string) *Callback {
+	if url == "" {
+		return nil
+	}
+	return &Callback{URL: url, Status: CallbackPending}
+}
+
+// Deliver - POST event to callback url in the background
+//
+// Failed deliveries are retried with exponential backoff; update is called
+// with the delivery state after every attempt.
+func (c Callback) Deliver(event CallbackEvent, update func(Callback)) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("encoding callback", Fields{"event": event.Event, "err": err})
+		return
+	}
+
+	go func() {
+		backoff := time.Second
+		for c.Attempts < callbackAttempts {
+			c.Attempts++
+			err := postCallback(c.URL, body)
+			if err == nil {
+				now := time.Now()
+				c.Status = CallbackDelivered
+				c.Error = ""
+				c.DeliveredAt = &now
+				stats.Inc("callbacks.delivered")
+				update(c)
+				return
+			}
+
+			c.Error = err.Error()
+			if c.Attempts == callbackAttempts {
+				c.Status = CallbackFailed
+				stats.Inc("callbacks.failed")
+				logger.Error("callback failed", Fields{"event": event.Event, "id": event.ID, "url": c.URL, "attempts": c.Attempts, "err": err})
+			}
+			update(c)
+			if c.Status == CallbackFailed {
+				return
+			}
+			stats.Inc("callbacks.retries")
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+func postCallback(url string, body []byte) error {
+	resp, err := callbackClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+	return nil
+}
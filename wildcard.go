@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// IsWildcard - oid ends with ".*" and selects the whole subtree
+func IsWildcard(oid string) bool {
+	return strings.HasSuffix(oid, ".*")
+}
+
+// PlainOids - oids that are not wildcards
+func PlainOids(oids []string) []string {
+	plain := make([]string, 0, len(oids))
+	for _, oid := range oids {
+		if !IsWildcard(oid) {
+			plain = append(plain, oid)
+		}
+	}
+	return plain
+}
+
+// VarbindError - oid that could not be read while others were
+type VarbindError struct {
+	Oid   string `json:"oid"`
+	Error string `json:"error"`
+}
+
+// GetWithWildcards - snmpget where wildcard oids are expanded by a walk
+//
+// Plain oids are fetched with GetPartial; every wildcard is replaced,
+// in request order, by the varbinds of a walk scoped to its prefix.
+// Oids the agent rejects and failed walks are returned as errors next to
+// the varbinds that were read; err is only set if the GET itself failed.
+func GetWithWildcards(g *gosnmp.GoSNMP, oids []string) ([]gosnmp.SnmpPDU, []VarbindError, error) {
+	plain := PlainOids(oids)
+	var got []gosnmp.SnmpPDU
+	var failed map[int]string
+	if len(plain) > 0 {
+		var err error
+		if got, failed, err = GetPartial(g, plain); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pdus := make([]gosnmp.SnmpPDU, 0, len(oids))
+	var errs []VarbindError
+	next := 0
+	for _, oid := range oids {
+		if !IsWildcard(oid) {
+			if msg, ok := failed[next]; ok {
+				errs = append(errs, VarbindError{Oid: oid, Error: msg})
+			} else if next < len(got) {
+				pdus = append(pdus, got[next])
+			}
+			next++
+			continue
+		}
+		walked, err := ObservedWalkAll(g, strings.TrimSuffix(oid, ".*"), false)
+		if err != nil {
+			errs = append(errs, VarbindError{Oid: oid, Error: err.Error()})
+			continue
+		}
+		pdus = append(pdus, walked...)
+	}
+	return pdus, errs, nil
+}
+
+// GetPartial - snmpget that drops oids rejected by the agent
+//
+// More oids than fit a GET of the target are read in chunks, one after
+// the other; a chunk that fails, e.g. on a timeout, is recorded as failed
+// oid by oid and the following chunks are still read. err is only set if
+// no chunk could be read. Chunks after the first that are over the rate
+// limit of the target wait for it, up to the session timeout.
+//
+// An error status names the offending varbind by its index; that oid is
+// recorded as failed and the GET is repeated without it. The returned
+// varbinds are aligned with oids, failed ones are left empty.
+func GetPartial(g *gosnmp.GoSNMP, oids []string) ([]gosnmp.SnmpPDU, map[int]string, error) {
+	size := getChunkSize(g, len(oids))
+	if size >= len(oids) {
+		return getChunk(g, oids)
+	}
+
+	pdus := make([]gosnmp.SnmpPDU, len(oids))
+	failed := map[int]string{}
+	chunks := (len(oids) + size - 1) / size
+	var firstErr error
+	read := false
+	stats.Inc("snmp.get.chunked")
+	for c := 0; c < chunks; c++ {
+		start, end := c*size, (c+1)*size
+		if end > len(oids) {
+			end = len(oids)
+		}
+		got, gotFailed, err := getChunk(g, oids[start:end])
+		if rl, ok := err.(*RateLimitError); ok && c > 0 && rl.RetryAfter <= g.Timeout {
+			time.Sleep(rl.RetryAfter)
+			got, gotFailed, err = getChunk(g, oids[start:end])
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			for k := start; k < end; k++ {
+				failed[k] = fmt.Sprintf("chunk %d/%d: %v", c+1, chunks, err)
+			}
+			stats.Inc("snmp.get.chunks_failed")
+			continue
+		}
+		read = true
+		copy(pdus[start:end], got)
+		for k, msg := range gotFailed {
+			failed[start+k] = msg
+		}
+	}
+	if !read {
+		return nil, nil, firstErr
+	}
+	return pdus, failed, nil
+}
+
+// getChunk - GetPartial of oids that fit a single GET
+func getChunk(g *gosnmp.GoSNMP, oids []string) ([]gosnmp.SnmpPDU, map[int]string, error) {
+	pdus := make([]gosnmp.SnmpPDU, len(oids))
+	failed := map[int]string{}
+
+	pending := make([]int, len(oids))
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		request := make([]string, len(pending))
+		for i, k := range pending {
+			request[i] = oids[k]
+		}
+		result, err := NegotiatedGet(g, request)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if result.Error == gosnmp.NoError {
+			for i, k := range pending {
+				if i < len(result.Variables) {
+					pdus[k] = result.Variables[i]
+				}
+			}
+			break
+		}
+
+		bad := int(result.ErrorIndex) - 1
+		if bad < 0 || bad >= len(pending) {
+			for _, k := range pending {
+				failed[k] = fmt.Sprint(result.Error)
+			}
+			break
+		}
+		failed[pending[bad]] = fmt.Sprint(result.Error)
+		pending = append(pending[:bad], pending[bad+1:]...)
+	}
+	return pdus, failed, nil
+}